@@ -0,0 +1,241 @@
+package aof
+
+import (
+	"io"
+	"os"
+	idb "ringodis/interface/database"
+	"ringodis/lib/logger"
+	"ringodis/lib/utils"
+	"ringodis/resp/conn"
+	"ringodis/resp/parser"
+	"ringodis/resp/reply"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CmdLine is alias for [][]byte, represents a command line
+type CmdLine = [][]byte
+
+const (
+	aofQueueSize = 1 << 16
+)
+
+const (
+	// FsyncAlways do fsync for every command
+	FsyncAlways = "always"
+	// FsyncEverySec do fsync every second
+	FsyncEverySec = "everysec"
+	// FsyncNo lets operating system decides when to do fsync
+	FsyncNo = "no"
+)
+
+type payload struct {
+	cmdLine CmdLine
+	dbIndex int
+}
+
+// Persister receives commands from channel and writes them into aof file
+type Persister struct {
+	db          idb.DB
+	aofChan     chan *payload
+	aofFile     *os.File
+	aofFilename string
+	aofFsync    string
+	// aof goroutine will send msg to main goroutine through this channel when aof tasks finished and ready to shut down
+	aofFinished chan struct{}
+	// pause aof for start/finish aof rewrite progress
+	pausingAof sync.Mutex
+	// the db index of the last written command, used to decide whether a SELECT is needed
+	currentDB int
+	closeChan chan struct{}
+
+	// tmpDBMaker creates an empty engine, rewrite replays aof into it and dumps its keyspace
+	tmpDBMaker func() idb.DBEngine
+	// 1 while rewriting, prevents concurrent rewrites
+	rewriting int32
+	// current size of aof file, and its size after last rewrite
+	aofSize  int64
+	baseSize int64
+}
+
+// NewPersister creates a new aof.Persister, replays the existing aof file into db if load is true
+func NewPersister(db idb.DB, filename string, load bool, fsync string,
+	tmpDBMaker func() idb.DBEngine) (*Persister, error) {
+	persister := &Persister{
+		db:          db,
+		aofFilename: filename,
+		aofFsync:    fsync,
+		currentDB:   0,
+		tmpDBMaker:  tmpDBMaker,
+	}
+	if load {
+		persister.LoadAof(0)
+	}
+	aofFile, err := os.OpenFile(persister.aofFilename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	persister.aofFile = aofFile
+	if info, err := aofFile.Stat(); err == nil {
+		persister.aofSize = info.Size()
+		persister.baseSize = info.Size()
+	}
+	persister.aofChan = make(chan *payload, aofQueueSize)
+	persister.aofFinished = make(chan struct{})
+	persister.closeChan = make(chan struct{})
+	go persister.listenCmd()
+	if persister.aofFsync == FsyncEverySec {
+		persister.fsyncEverySecond()
+	}
+	return persister, nil
+}
+
+// SaveCmdLine sends command to aof goroutine through channel
+func (persister *Persister) SaveCmdLine(dbIndex int, cmdLine CmdLine) {
+	if persister.aofChan == nil {
+		return
+	}
+	p := &payload{
+		cmdLine: cmdLine,
+		dbIndex: dbIndex,
+	}
+	if persister.aofFsync == FsyncAlways {
+		persister.writeAof(p)
+		return
+	}
+	persister.aofChan <- p
+}
+
+// listenCmd listens aof channel and writes into file
+func (persister *Persister) listenCmd() {
+	for p := range persister.aofChan {
+		persister.writeAof(p)
+	}
+	persister.aofFinished <- struct{}{}
+}
+
+func (persister *Persister) writeAof(p *payload) {
+	persister.pausingAof.Lock() // prevent other goroutines from pausing aof
+	defer persister.pausingAof.Unlock()
+
+	// ensure aof is in the right database
+	if p.dbIndex != persister.currentDB {
+		n, err := persister.aofFile.Write(makeSelectCmd(p.dbIndex))
+		persister.aofSize += int64(n)
+		if err != nil {
+			logger.Warn(err)
+			return // skip this command
+		}
+		persister.currentDB = p.dbIndex
+	}
+	data := reply.MakeMultiBulkReply(p.cmdLine).ToBytes()
+	n, err := persister.aofFile.Write(data)
+	persister.aofSize += int64(n)
+	if err != nil {
+		logger.Warn(err)
+	}
+	if persister.aofFsync == FsyncAlways {
+		_ = persister.aofFile.Sync()
+	}
+	if persister.needRewrite() {
+		go persister.autoRewrite()
+	}
+}
+
+func makeSelectCmd(dbIndex int) []byte {
+	return reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(dbIndex))).ToBytes()
+}
+
+// LoadAof reads aof file, can only be used before Persister.listenCmd started
+// reads the whole file if maxBytes is not positive
+func (persister *Persister) LoadAof(maxBytes int) {
+	// persister.db.Exec may call persister.SaveCmdLine
+	// delete aofChan to prevent loaded commands back into aofChan
+	aofChan := persister.aofChan
+	persister.aofChan = nil
+	defer func(aofChan chan *payload) {
+		persister.aofChan = aofChan
+	}(aofChan)
+
+	file, err := os.Open(persister.aofFilename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return
+		}
+		logger.Warn(err)
+		return
+	}
+	defer file.Close()
+
+	var reader io.Reader
+	if maxBytes > 0 {
+		reader = io.LimitReader(file, int64(maxBytes))
+	} else {
+		reader = file
+	}
+	ch := parser.ParseStream(reader)
+	fakeConn := conn.NewFakeConn() // only used for save dbIndex
+	for p := range ch {
+		if p.Err != nil {
+			if p.Err == io.EOF {
+				break
+			}
+			logger.Error("parse error: " + p.Err.Error())
+			continue
+		}
+		if p.Data == nil {
+			logger.Error("empty payload")
+			continue
+		}
+		r, ok := p.Data.(*reply.MultiBulkReply)
+		if !ok {
+			logger.Error("require multi bulk reply")
+			continue
+		}
+		res := persister.db.Exec(fakeConn, r.Args)
+		if reply.IsErrorReply(res) {
+			logger.Error("exec err", string(res.ToBytes()))
+		}
+	}
+	persister.currentDB = fakeConn.GetDBIndex()
+}
+
+// Fsync flushes aof file to disk
+func (persister *Persister) Fsync() {
+	persister.pausingAof.Lock()
+	if err := persister.aofFile.Sync(); err != nil {
+		logger.Error("fsync failed: " + err.Error())
+	}
+	persister.pausingAof.Unlock()
+}
+
+func (persister *Persister) fsyncEverySecond() {
+	ticker := time.NewTicker(time.Second)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				persister.Fsync()
+			case <-persister.closeChan:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// Close gracefully stops aof persistence procedure
+func (persister *Persister) Close() {
+	if persister.aofFile != nil {
+		close(persister.aofChan)
+		<-persister.aofFinished // wait for aof finished
+		if err := persister.aofFile.Sync(); err != nil {
+			logger.Warn(err)
+		}
+		if err := persister.aofFile.Close(); err != nil {
+			logger.Warn(err)
+		}
+	}
+	close(persister.closeChan)
+}
@@ -0,0 +1,169 @@
+package aof
+
+import (
+	"ringodis/ds/dict"
+	List "ringodis/ds/list"
+	HashSet "ringodis/ds/set"
+	"ringodis/ds/stream"
+	SortedSet "ringodis/ds/zset"
+	"ringodis/interface/database"
+	"ringodis/resp/reply"
+	"strconv"
+	"time"
+)
+
+var (
+	setCmd       = []byte("SET")
+	hMSetCmd     = []byte("HMSET")
+	rPushAllCmd  = []byte("RPUSH")
+	sAddCmd      = []byte("SADD")
+	zAddCmd      = []byte("ZADD")
+	xAddCmd      = []byte("XADD")
+	xSetIDCmd    = []byte("XSETID")
+	xGroupCmd    = []byte("XGROUP")
+	xClaimCmd    = []byte("XCLAIM")
+	pExpireAtCmd = []byte("PEXPIREAT")
+)
+
+// EntityToCmd serializes data entity to the minimal command line which rebuilds it
+func EntityToCmd(key string, entity *database.DataEntity) *reply.MultiBulkReply {
+	if entity == nil {
+		return nil
+	}
+	var cmd *reply.MultiBulkReply
+	switch val := entity.Data.(type) {
+	case []byte:
+		cmd = stringToCmd(key, val)
+	case dict.Dict:
+		cmd = hashToCmd(key, val)
+	case List.List:
+		cmd = listToCmd(key, val)
+	case *HashSet.Set:
+		cmd = setToCmd(key, val)
+	case *SortedSet.ZSet:
+		cmd = zSetToCmd(key, val)
+	}
+	return cmd
+}
+
+func stringToCmd(key string, bytes []byte) *reply.MultiBulkReply {
+	args := make([][]byte, 3)
+	args[0] = setCmd
+	args[1] = []byte(key)
+	args[2] = bytes
+	return reply.MakeMultiBulkReply(args)
+}
+
+func listToCmd(key string, list List.List) *reply.MultiBulkReply {
+	args := make([][]byte, 2, 2+list.Len())
+	args[0] = rPushAllCmd
+	args[1] = []byte(key)
+	list.ForEach(func(i int, val interface{}) bool {
+		bytes, _ := val.([]byte)
+		args = append(args, bytes)
+		return true
+	})
+	return reply.MakeMultiBulkReply(args)
+}
+
+func setToCmd(key string, set *HashSet.Set) *reply.MultiBulkReply {
+	args := make([][]byte, 2, 2+set.Len())
+	args[0] = sAddCmd
+	args[1] = []byte(key)
+	set.ForEach(func(member string) bool {
+		args = append(args, []byte(member))
+		return true
+	})
+	return reply.MakeMultiBulkReply(args)
+}
+
+func zSetToCmd(key string, zset *SortedSet.ZSet) *reply.MultiBulkReply {
+	args := make([][]byte, 2, 2+zset.Len()*2)
+	args[0] = zAddCmd
+	args[1] = []byte(key)
+	zset.ForEach(0, zset.Len(), false, func(element *SortedSet.Elem) bool {
+		score := strconv.FormatFloat(element.Score, 'f', -1, 64)
+		args = append(args, []byte(score), []byte(element.Member))
+		return true
+	})
+	return reply.MakeMultiBulkReply(args)
+}
+
+func hashToCmd(key string, hash dict.Dict) *reply.MultiBulkReply {
+	args := make([][]byte, 2, 2+hash.Len()*2)
+	args[0] = hMSetCmd
+	args[1] = []byte(key)
+	hash.ForEach(func(field string, val interface{}) bool {
+		bytes, _ := val.([]byte)
+		args = append(args, []byte(field), bytes)
+		return true
+	})
+	return reply.MakeMultiBulkReply(args)
+}
+
+// EntityToCmds serializes data entity to command lines which rebuild it,
+// most types need only one command, while streams need extra commands to restore last id and consumer groups
+func EntityToCmds(key string, entity *database.DataEntity) []*reply.MultiBulkReply {
+	if entity == nil {
+		return nil
+	}
+	if s, ok := entity.Data.(*stream.Stream); ok {
+		return streamToCmds(key, s)
+	}
+	if cmd := EntityToCmd(key, entity); cmd != nil {
+		return []*reply.MultiBulkReply{cmd}
+	}
+	return nil
+}
+
+// streamToCmds rebuilds entries with XADD, restores last id with XSETID,
+// then restores consumer groups with XGROUP and pending entries with XCLAIM
+func streamToCmds(key string, s *stream.Stream) []*reply.MultiBulkReply {
+	cmds := make([]*reply.MultiBulkReply, 0, s.Len()+2)
+	keyBytes := []byte(key)
+	if s.Len() == 0 {
+		// create the stream by adding an entry and trimming it immediately
+		cmds = append(cmds, reply.MakeMultiBulkReply([][]byte{
+			xAddCmd, keyBytes, []byte("MAXLEN"), []byte("0"), []byte("0-1"), []byte(""), []byte(""),
+		}))
+	}
+	s.ForEach(func(entry *stream.Entry) bool {
+		args := make([][]byte, 0, 3+len(entry.Fields))
+		args = append(args, xAddCmd, keyBytes, []byte(entry.ID.String()))
+		args = append(args, entry.Fields...)
+		cmds = append(cmds, reply.MakeMultiBulkReply(args))
+		return true
+	})
+	cmds = append(cmds, reply.MakeMultiBulkReply([][]byte{
+		xSetIDCmd, keyBytes, []byte(s.LastID().String()),
+	}))
+	for _, group := range s.Groups() {
+		groupName := []byte(group.Name)
+		cmds = append(cmds, reply.MakeMultiBulkReply([][]byte{
+			xGroupCmd, []byte("CREATE"), keyBytes, groupName, []byte(group.LastID.String()),
+		}))
+		for _, consumer := range group.SortedConsumers() {
+			cmds = append(cmds, reply.MakeMultiBulkReply([][]byte{
+				xGroupCmd, []byte("CREATECONSUMER"), keyBytes, groupName, []byte(consumer.Name),
+			}))
+			for _, pending := range consumer.PendingEntries() {
+				cmds = append(cmds, reply.MakeMultiBulkReply([][]byte{
+					xClaimCmd, keyBytes, groupName, []byte(consumer.Name), []byte("0"), []byte(pending.ID.String()),
+					[]byte("TIME"), []byte(strconv.FormatInt(pending.DeliveryTime.UnixMilli(), 10)),
+					[]byte("RETRYCOUNT"), []byte(strconv.FormatInt(pending.DeliveryCount, 10)),
+					[]byte("FORCE"), []byte("JUSTID"),
+				}))
+			}
+		}
+	}
+	return cmds
+}
+
+// MakeExpireCmd generates command line to set expiration for the given key
+func MakeExpireCmd(key string, expireAt time.Time) *reply.MultiBulkReply {
+	args := make([][]byte, 3)
+	args[0] = pExpireAtCmd
+	args[1] = []byte(key)
+	args[2] = []byte(strconv.FormatInt(expireAt.UnixMilli(), 10))
+	return reply.MakeMultiBulkReply(args)
+}
@@ -0,0 +1,218 @@
+package aof
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"ringodis/config"
+	"ringodis/interface/database"
+	"ringodis/lib/logger"
+	"sync/atomic"
+	"time"
+)
+
+// ErrRewriting is returned when another rewrite is in progress
+var ErrRewriting = errors.New("ERR Background append only file rewriting already in progress")
+
+// rewriteCtx records the state of aof file when rewrite started
+type rewriteCtx struct {
+	tmpFile  *os.File
+	fileSize int64 // size of aof file when rewrite started
+	dbIdx    int   // selected db index of aof file when rewrite started
+}
+
+// Rewrite compacts aof file into the minimal command set.
+// Commands arriving during the rewrite are still appended to the old file,
+// they are copied into the new file right before swapping, so clients are never blocked.
+func (persister *Persister) Rewrite() error {
+	if !atomic.CompareAndSwapInt32(&persister.rewriting, 0, 1) {
+		return ErrRewriting
+	}
+	defer atomic.StoreInt32(&persister.rewriting, 0)
+	return persister.rewrite()
+}
+
+// BackgroundRewrite starts Rewrite in a new goroutine
+func (persister *Persister) BackgroundRewrite() error {
+	if !atomic.CompareAndSwapInt32(&persister.rewriting, 0, 1) {
+		return ErrRewriting
+	}
+	go func() {
+		defer atomic.StoreInt32(&persister.rewriting, 0)
+		if err := persister.rewrite(); err != nil {
+			logger.Error("aof rewrite failed: " + err.Error())
+		}
+	}()
+	return nil
+}
+
+func (persister *Persister) rewrite() error {
+	ctx, err := persister.startRewrite()
+	if err != nil {
+		return err
+	}
+	if err = persister.doRewrite(ctx); err != nil {
+		_ = ctx.tmpFile.Close()
+		_ = os.Remove(ctx.tmpFile.Name())
+		return err
+	}
+	return persister.finishRewrite(ctx)
+}
+
+// needRewrite checks whether aof file grows enough since last rewrite, must be called while holding pausingAof
+func (persister *Persister) needRewrite() bool {
+	percentage := int64(config.Properties.AutoAofRewritePercentage)
+	if percentage <= 0 || persister.tmpDBMaker == nil || atomic.LoadInt32(&persister.rewriting) == 1 {
+		return false
+	}
+	if persister.aofSize < int64(config.Properties.AutoAofRewriteMinSize) {
+		return false
+	}
+	base := persister.baseSize
+	if base == 0 {
+		base = 1
+	}
+	return (persister.aofSize-persister.baseSize)*100/base >= percentage
+}
+
+func (persister *Persister) autoRewrite() {
+	logger.Info("aof grows too large, start rewriting")
+	if err := persister.Rewrite(); err != nil && err != ErrRewriting {
+		logger.Error("aof rewrite failed: " + err.Error())
+	}
+}
+
+func (persister *Persister) startRewrite() (*rewriteCtx, error) {
+	persister.pausingAof.Lock()
+	defer persister.pausingAof.Unlock()
+
+	if err := persister.aofFile.Sync(); err != nil {
+		return nil, err
+	}
+	info, err := persister.aofFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	// create tmp file in the same directory, so that it could be renamed atomically
+	tmpFile, err := os.CreateTemp(filepath.Dir(persister.aofFilename), "rewrite-*.aof")
+	if err != nil {
+		return nil, err
+	}
+	return &rewriteCtx{
+		tmpFile:  tmpFile,
+		fileSize: info.Size(),
+		dbIdx:    persister.currentDB,
+	}, nil
+}
+
+// doRewrite replays the first fileSize bytes of aof into a temporary engine and dumps its keyspace
+func (persister *Persister) doRewrite(ctx *rewriteCtx) error {
+	tmpDB := persister.tmpDBMaker()
+	defer tmpDB.Close()
+	if ctx.fileSize > 0 {
+		tmpAof := &Persister{
+			db:          tmpDB,
+			aofFilename: persister.aofFilename,
+		}
+		tmpAof.LoadAof(int(ctx.fileSize))
+	}
+	return Dump(tmpDB, ctx.tmpFile)
+}
+
+// Dump writes commands rebuilding all keys of engine into writer, the caller should prevent keys from being modified
+func Dump(engine database.DBEngine, writer io.Writer) error {
+	var err error
+	write := func(data []byte) bool {
+		_, err = writer.Write(data)
+		return err == nil
+	}
+	for i := 0; i < config.Properties.Databases; i++ {
+		selected := false
+		engine.ForEach(i, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+			cmds := EntityToCmds(key, entity)
+			if len(cmds) == 0 {
+				return true
+			}
+			if !selected {
+				if !write(makeSelectCmd(i)) {
+					return false
+				}
+				selected = true
+			}
+			for _, cmd := range cmds {
+				if !write(cmd.ToBytes()) {
+					return false
+				}
+			}
+			if expiration != nil {
+				return write(MakeExpireCmd(key, *expiration).ToBytes())
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finishRewrite copies commands executed during rewriting into tmp file, then replaces aof file with it
+func (persister *Persister) finishRewrite(ctx *rewriteCtx) error {
+	persister.pausingAof.Lock()
+	defer persister.pausingAof.Unlock()
+
+	tmpFile := ctx.tmpFile
+	abort := func(err error) error {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+	src, err := os.Open(persister.aofFilename)
+	if err != nil {
+		return abort(err)
+	}
+	defer src.Close()
+	if _, err = src.Seek(ctx.fileSize, io.SeekStart); err != nil {
+		return abort(err)
+	}
+	// tail of old file is written in the db selected when rewrite started
+	if _, err = tmpFile.Write(makeSelectCmd(ctx.dbIdx)); err != nil {
+		return abort(err)
+	}
+	if _, err = io.Copy(tmpFile, src); err != nil {
+		return abort(err)
+	}
+	if err = tmpFile.Sync(); err != nil {
+		return abort(err)
+	}
+	_ = tmpFile.Close()
+
+	// open the new file before swapping, so that the old handle is kept if anything fails
+	aofFile, err := os.OpenFile(tmpFile.Name(), os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+	if err = os.Rename(tmpFile.Name(), persister.aofFilename); err != nil {
+		_ = aofFile.Close()
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+	// the handle follows the renamed file, writes go to the new aof from now on
+	if err = persister.aofFile.Close(); err != nil {
+		logger.Warn(err)
+	}
+	persister.aofFile = aofFile
+	// the tail may have switched db, select again to resume the current db
+	if _, err = aofFile.Write(makeSelectCmd(persister.currentDB)); err != nil {
+		return err
+	}
+	info, err := aofFile.Stat()
+	if err != nil {
+		return err
+	}
+	persister.aofSize = info.Size()
+	persister.baseSize = info.Size()
+	return nil
+}
@@ -0,0 +1,71 @@
+package cluster
+
+import (
+	"ringodis/config"
+	"ringodis/lib/utils"
+	"ringodis/resp/conn"
+	"ringodis/resp/reply/asserts"
+	"strconv"
+	"testing"
+)
+
+func TestPeerAuth(t *testing.T) {
+	config.Properties.RequirePass = "pass"
+	config.Properties.MasterAuth = "pass"
+	defer func() {
+		config.Properties.RequirePass = ""
+		config.Properties.MasterAuth = ""
+	}()
+	// peers authenticate by masterauth when relaying
+	a, b, keyA, keyB := serveRing(t)
+	c := conn.NewFakeConn()
+	asserts.AssertStatusReply(t, a.Exec(c, utils.ToCmdLine("mset", keyA(0), "1", keyB(0), "2")), "OK")
+	asserts.AssertBulkReply(t, b.Exec(c, utils.ToCmdLine("get", keyA(0))), "1")
+
+	client := conn.NewFakeConn()
+	client.SetAuthenticated(false)
+	asserts.AssertErrReply(t, a.Exec(client, utils.ToCmdLine("get", keyB(0))), "NOAUTH Authentication required.")
+	asserts.AssertErrReply(t, a.Exec(client, utils.ToCmdLine("cluster", "nodes")), "NOAUTH Authentication required.")
+	asserts.AssertStatusReply(t, a.Exec(client, utils.ToCmdLine("auth", "pass")), "OK")
+	asserts.AssertBulkReply(t, a.Exec(client, utils.ToCmdLine("get", keyB(0))), "2")
+}
+
+func TestPeerCommands(t *testing.T) {
+	config.Properties.RequirePass = "pass"
+	config.Properties.MasterUser = "peer"
+	config.Properties.MasterAuth = "peerpass"
+	defer func() {
+		config.Properties.RequirePass = ""
+		config.Properties.MasterUser = ""
+		config.Properties.MasterAuth = ""
+	}()
+	a, b := serveCluster(t), serveCluster(t)
+	fake := conn.NewFakeConn()
+	asserts.AssertStatusReply(t, a.db.Exec(fake, utils.ToCmdLine("acl", "setuser", "peer", "on", ">peerpass", "~*", "+@all")), "OK")
+	defer a.db.Exec(fake, utils.ToCmdLine("acl", "deluser", "peer"))
+	host, port := splitHostPort(b.self)
+	asserts.AssertStatusReply(t, a.Exec(fake, utils.ToCmdLine("cluster", "meet", host, strconv.Itoa(port))), "OK")
+	waitResharding(t, a, b)
+	if nodes := b.getNodes(); len(nodes) != 2 {
+		t.Fatalf("expected 2 nodes, actual %v", nodes)
+	}
+
+	// internal commands are denied to clients even if they are allowed to run all commands
+	client := conn.NewFakeConn()
+	client.SetAuthenticated(false)
+	asserts.AssertStatusReply(t, b.Exec(client, utils.ToCmdLine("auth", "pass")), "OK")
+	asserts.AssertErrReply(t, b.Exec(client, utils.ToCmdLine(relayGossip, "fail", a.self)),
+		"NOPERM User default has no permissions to run the 'gossip_' command")
+	asserts.AssertErrReply(t, b.Exec(client, utils.ToCmdLine(relayReshard, "nodes", "100", b.self)),
+		"NOPERM User default has no permissions to run the 'reshard_' command")
+	asserts.AssertErrReply(t, b.Exec(client, utils.ToCmdLine(relayReshard, "finished", a.self)),
+		"NOPERM User default has no permissions to run the 'reshard_' command")
+	asserts.AssertStatusReply(t, b.Exec(client, utils.ToCmdLine("auth", "peer", "peerpass")), "OK")
+	asserts.AssertStatusReply(t, b.Exec(client, utils.ToCmdLine(relayGossip, "fail", a.self)), "OK")
+
+	asserts.AssertStatusReply(t, a.db.Exec(fake, utils.ToCmdLine("acl", "setuser", "alice", "on", ">alice", "~public:*", "+@all")), "OK")
+	defer a.db.Exec(fake, utils.ToCmdLine("acl", "deluser", "alice"))
+	asserts.AssertStatusReply(t, b.Exec(client, utils.ToCmdLine("auth", "alice", "alice")), "OK")
+	asserts.AssertErrReply(t, b.Exec(client, utils.ToCmdLine(relayTx, "prepare", "t1", "2", "get", "secret")),
+		"NOPERM User alice has no permissions to run the 'tx_' command")
+}
@@ -2,17 +2,33 @@ package cluster
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	pool "github.com/jolestar/go-commons-pool/v2"
+	"ringodis/config"
 	"ringodis/resp/client"
 )
 
+// makePeerPool makes pool of connections to peer, connections are established on demand
+func makePeerPool(peer string) *pool.ObjectPool {
+	return pool.NewObjectPoolWithDefaultConfig(context.Background(), &connFactory{
+		Peer: peer,
+	})
+}
+
 type connFactory struct {
 	Peer string
 }
 
 func (cf *connFactory) MakeObject(ctx context.Context) (*pool.PooledObject, error) {
-	c, err := client.MakeClient(cf.Peer)
+	var tlsConfig *tls.Config
+	if config.Properties.TLSCluster {
+		var err error
+		if tlsConfig, err = config.MakeClientTLSConfig(); err != nil {
+			return nil, err
+		}
+	}
+	c, err := client.MakeTLSClient(cf.Peer, config.Properties.MasterUser, config.Properties.MasterAuth, tlsConfig)
 	if err != nil {
 		return nil, err
 	}
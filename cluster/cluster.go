@@ -1,24 +1,53 @@
 package cluster
 
 import (
-	"context"
 	pool "github.com/jolestar/go-commons-pool/v2"
 	"ringodis/config"
 	"ringodis/database"
+	"ringodis/ds/lock"
 	idb "ringodis/interface/database"
 	"ringodis/interface/resp"
 	"ringodis/lib/consistenthash"
 	"ringodis/lib/logger"
 	"ringodis/resp/reply"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Cluster struct {
-	self       string
-	nodes      []string
+	self string
+	// mu guards nodes, peerPicker, peerConn, resharding and members which change by membership commands and gossip
+	mu    sync.RWMutex
+	nodes []string
+	// peerPicker is the ring of nodes, failed nodes are excluded
 	peerPicker *consistenthash.Map
 	peerConn   map[string]*pool.ObjectPool
 	db         idb.DB
+	// resharding is not nil while keys are being migrated between nodes
+	resharding *resharding
+	// ringEpoch increases when nodes change, nodes of greater epoch are adopted through gossip
+	ringEpoch int64
+	// members records health of other nodes in the ring
+	members     map[string]*member
+	nodeTimeout time.Duration
+	// migrateLocks serializes migration of the same key
+	migrateLocks *lock.Locks
+	// slots is not nil in hash slot mode, clients are redirected instead of proxied
+	slots *slotTable
+	// asking holds clients which sent ASKING for their next command
+	asking sync.Map
+	// transactions holds two-phase commit transactions prepared on this node
+	transactions sync.Map
+	// replicas of nodes in the ring guarded by mu, read-only commands are routed to them by readPolicy
+	replicas         map[string]*replicaSet
+	readPolicy       string
+	replicaMaxLag    int64
+	checkingReplicas int32
+
+	closeChan chan struct{}
+	closeOnce sync.Once
 }
 
 func (cluster *Cluster) Exec(client resp.Connection, cmdLine idb.CmdLine) (res resp.Reply) {
@@ -29,19 +58,41 @@ func (cluster *Cluster) Exec(client resp.Connection, cmdLine idb.CmdLine) (res r
 		}
 	}()
 	cmdName := strings.ToLower(string(cmdLine[0]))
+	// authentication is kept by connection, checked before redirecting or relaying
+	if cmdName == "auth" || cmdName == "hello" {
+		return cluster.db.Exec(client, cmdLine)
+	}
+	if errReply := database.CheckPermission(client, cmdLine); errReply != nil {
+		return errReply
+	}
+	// client in subscribe mode is restricted by local db, as its subscriptions are kept locally
+	if client.InSubscribeMode() && cmdName != "ssubscribe" {
+		return cluster.db.Exec(client, cmdLine)
+	}
+	if cluster.slots != nil {
+		return cluster.execSlotMode(client, cmdName, cmdLine)
+	}
+	if client.InMultiState() && cmdName != "exec" && cmdName != "discard" && cmdName != "multi" &&
+		cmdName != "watch" && cmdName != "unwatch" {
+		return enqueueCmd(cluster, client, cmdLine)
+	}
 	cmdFunc, ok := router[cmdName]
 	if !ok {
-		res = reply.MakeErrReply("not supported command")
+		return reply.MakeErrReply("not supported command")
 	}
 	res = cmdFunc(cluster, client, cmdLine)
 	return
 }
 
 func (cluster *Cluster) Close() {
+	cluster.closeOnce.Do(func() {
+		close(cluster.closeChan)
+	})
 	cluster.db.Close()
 }
 
 func (cluster *Cluster) AfterClientClose(c resp.Connection) {
+	cluster.asking.Delete(c)
 	cluster.db.AfterClientClose(c)
 }
 
@@ -52,17 +103,63 @@ func MakeCluster() *Cluster {
 		peerPicker: consistenthash.New(1, nil),
 		peerConn:   make(map[string]*pool.ObjectPool),
 		db:         database.NewStandaloneServer(),
+		// size must be power of 2
+		migrateLocks:  lock.Make(1024),
+		members:       make(map[string]*member),
+		nodeTimeout:   time.Duration(config.Properties.ClusterNodeTimeout) * time.Millisecond,
+		closeChan:     make(chan struct{}),
+		replicas:      parseReplicas(config.Properties.ClusterReplicas),
+		readPolicy:    parseReadPolicy(config.Properties.ClusterReadPolicy),
+		replicaMaxLag: int64(config.Properties.ClusterReplicaMaxLag),
+	}
+	if cluster.nodeTimeout <= 0 {
+		cluster.nodeTimeout = defaultNodeTimeout
+	}
+	if cluster.replicaMaxLag <= 0 {
+		cluster.replicaMaxLag = defaultReplicaMaxLag
+	}
+	for _, set := range cluster.replicas {
+		for _, addr := range set.addrs {
+			cluster.peerConn[addr] = makePeerPool(addr)
+		}
+	}
+	if config.Properties.ClusterEnabled && cluster.self == "" {
+		port := config.Properties.Port
+		// peers reach each other by tls-port if tls-cluster is enabled
+		if config.Properties.TLSCluster {
+			port = config.Properties.TLSPort
+		}
+		cluster.self = config.Properties.Bind + ":" + strconv.Itoa(port)
 	}
-	ctx := context.Background()
 	for _, peer := range config.Properties.Peers {
 		cluster.nodes = append(cluster.nodes, peer)
-		cluster.peerConn[peer] = pool.NewObjectPoolWithDefaultConfig(ctx, &connFactory{
-			Peer: peer,
-		})
+		cluster.peerConn[peer] = makePeerPool(peer)
 	}
 	cluster.nodes = append(cluster.nodes, cluster.self)
 	cluster.peerPicker.AddNode(cluster.nodes...)
+	if config.Properties.ClusterEnabled {
+		cluster.slots = makeSlotTable(cluster.nodes)
+	} else {
+		cluster.syncMembers()
+		go cluster.cron()
+	}
 	return cluster
 }
 
-type CmdFunc func(cluster *Cluster, c resp.Connection, cmdLine [][]byte) resp.Reply
\ No newline at end of file
+// pickNode returns the node owning key in the current ring
+func (cluster *Cluster) pickNode(key string) string {
+	cluster.mu.RLock()
+	defer cluster.mu.RUnlock()
+	return cluster.peerPicker.PickNode(key)
+}
+
+// getNodes returns a copy of nodes in the current ring
+func (cluster *Cluster) getNodes() []string {
+	cluster.mu.RLock()
+	defer cluster.mu.RUnlock()
+	nodes := make([]string, len(cluster.nodes))
+	copy(nodes, cluster.nodes)
+	return nodes
+}
+
+type CmdFunc func(cluster *Cluster, c resp.Connection, cmdLine [][]byte) resp.Reply
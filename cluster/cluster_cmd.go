@@ -0,0 +1,270 @@
+package cluster
+
+import (
+	"net"
+	idb "ringodis/interface/database"
+	"ringodis/interface/resp"
+	"ringodis/resp/reply"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clusterBusPortOffset is added to client port to get the cluster bus port shown in CLUSTER NODES
+const clusterBusPortOffset = 10000
+
+// execCluster executes CLUSTER subcommands in slot mode
+func execCluster(cluster *Cluster, c resp.Connection, cmdLine [][]byte) resp.Reply {
+	if len(cmdLine) < 2 {
+		return reply.MakeArgNumErrReply(string(cmdLine[0]))
+	}
+	subCmd := strings.ToLower(string(cmdLine[1]))
+	args := cmdLine[2:]
+	switch subCmd {
+	case "info":
+		return execClusterInfo(cluster)
+	case "myid":
+		return reply.MakeBulkReply([]byte(makeNodeID(cluster.self)))
+	case "keyslot":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("cluster|keyslot")
+		}
+		return reply.MakeIntReply(int64(KeySlot(string(args[0]))))
+	case "countkeysinslot":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("cluster|countkeysinslot")
+		}
+		slot, errReply := parseSlot(args[0])
+		if errReply != nil {
+			return errReply
+		}
+		return reply.MakeIntReply(int64(len(cluster.keysInSlot(c.GetDBIndex(), slot, -1))))
+	case "getkeysinslot":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("cluster|getkeysinslot")
+		}
+		slot, errReply := parseSlot(args[0])
+		if errReply != nil {
+			return errReply
+		}
+		count, err := strconv.Atoi(string(args[1]))
+		if err != nil || count < 0 {
+			return reply.MakeErrReply("ERR Invalid number of keys")
+		}
+		keys := cluster.keysInSlot(c.GetDBIndex(), slot, count)
+		result := make([][]byte, len(keys))
+		for i, key := range keys {
+			result[i] = []byte(key)
+		}
+		return reply.MakeMultiBulkReply(result)
+	case "slots":
+		return execClusterSlots(cluster)
+	case "shards":
+		return execClusterShards(cluster)
+	case "nodes":
+		return execClusterNodes(cluster)
+	case "setslot":
+		return execClusterSetSlot(cluster, args)
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try CLUSTER HELP.")
+}
+
+func parseSlot(arg []byte) (int, reply.ErrorReply) {
+	slot, err := strconv.Atoi(string(arg))
+	if err != nil || slot < 0 || slot >= SlotCount {
+		return 0, reply.MakeErrReply("ERR Invalid or out of range slot")
+	}
+	return slot, nil
+}
+
+// keysInSlot scans local keys of the slot, limit < 0 means no limit
+func (cluster *Cluster) keysInSlot(dbIndex int, slot int, limit int) []string {
+	engine, ok := cluster.db.(idb.DBEngine)
+	if !ok {
+		return nil
+	}
+	var keys []string
+	engine.ForEach(dbIndex, func(key string, data *idb.DataEntity, expiration *time.Time) bool {
+		if limit >= 0 && len(keys) >= limit {
+			return false
+		}
+		if expiration != nil && expiration.Before(time.Now()) {
+			return true
+		}
+		if KeySlot(key) == slot {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	return keys
+}
+
+func splitHostPort(node string) (string, int) {
+	host, portStr, _ := net.SplitHostPort(node)
+	port, _ := strconv.Atoi(portStr)
+	return host, port
+}
+
+func execClusterInfo(cluster *Cluster) resp.Reply {
+	assigned := 0
+	owners := make(map[string]struct{})
+	for _, r := range cluster.slots.ranges() {
+		assigned += r.end - r.begin + 1
+		owners[r.node] = struct{}{}
+	}
+	state := "ok"
+	if assigned < SlotCount {
+		state = "fail"
+	}
+	cluster.slots.mu.RLock()
+	epoch := cluster.slots.configEpoch
+	cluster.slots.mu.RUnlock()
+	info := "cluster_enabled:1\r\n" +
+		"cluster_state:" + state + "\r\n" +
+		"cluster_slots_assigned:" + strconv.Itoa(assigned) + "\r\n" +
+		"cluster_slots_ok:" + strconv.Itoa(assigned) + "\r\n" +
+		"cluster_known_nodes:" + strconv.Itoa(len(cluster.nodes)) + "\r\n" +
+		"cluster_size:" + strconv.Itoa(len(owners)) + "\r\n" +
+		"cluster_current_epoch:" + strconv.FormatInt(epoch, 10) + "\r\n"
+	return reply.MakeBulkReply([]byte(info))
+}
+
+// execClusterSlots replies ranges like [begin, end, [ip, port, id]]
+func execClusterSlots(cluster *Cluster) resp.Reply {
+	ranges := cluster.slots.ranges()
+	result := make([]resp.Reply, 0, len(ranges))
+	for _, r := range ranges {
+		host, port := splitHostPort(r.node)
+		result = append(result, reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeIntReply(int64(r.begin)),
+			reply.MakeIntReply(int64(r.end)),
+			reply.MakeMultiRawReply([]resp.Reply{
+				reply.MakeBulkReply([]byte(host)),
+				reply.MakeIntReply(int64(port)),
+				reply.MakeBulkReply([]byte(makeNodeID(r.node))),
+			}),
+		}))
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// execClusterShards replies slots and nodes of every shard
+func execClusterShards(cluster *Cluster) resp.Reply {
+	slotsOfNode := make(map[string][]resp.Reply)
+	for _, r := range cluster.slots.ranges() {
+		slotsOfNode[r.node] = append(slotsOfNode[r.node], reply.MakeIntReply(int64(r.begin)), reply.MakeIntReply(int64(r.end)))
+	}
+	result := make([]resp.Reply, 0, len(cluster.nodes))
+	for _, node := range cluster.nodes {
+		host, port := splitHostPort(node)
+		slots := slotsOfNode[node]
+		if slots == nil {
+			slots = []resp.Reply{}
+		}
+		nodeInfo := reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte("id")), reply.MakeBulkReply([]byte(makeNodeID(node))),
+			reply.MakeBulkReply([]byte("port")), reply.MakeIntReply(int64(port)),
+			reply.MakeBulkReply([]byte("ip")), reply.MakeBulkReply([]byte(host)),
+			reply.MakeBulkReply([]byte("endpoint")), reply.MakeBulkReply([]byte(host)),
+			reply.MakeBulkReply([]byte("role")), reply.MakeBulkReply([]byte("master")),
+			reply.MakeBulkReply([]byte("replication-offset")), reply.MakeIntReply(0),
+			reply.MakeBulkReply([]byte("health")), reply.MakeBulkReply([]byte("online")),
+		})
+		result = append(result, reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte("slots")),
+			reply.MakeMultiRawReply(slots),
+			reply.MakeBulkReply([]byte("nodes")),
+			reply.MakeMultiRawReply([]resp.Reply{nodeInfo}),
+		}))
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// execClusterNodes replies lines like `<id> <ip:port@cport> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> ...`
+func execClusterNodes(cluster *Cluster) resp.Reply {
+	slotsOfNode := make(map[string][]string)
+	for _, r := range cluster.slots.ranges() {
+		if r.begin == r.end {
+			slotsOfNode[r.node] = append(slotsOfNode[r.node], strconv.Itoa(r.begin))
+		} else {
+			slotsOfNode[r.node] = append(slotsOfNode[r.node], strconv.Itoa(r.begin)+"-"+strconv.Itoa(r.end))
+		}
+	}
+	cluster.slots.mu.RLock()
+	for slot, target := range cluster.slots.migrating {
+		slotsOfNode[cluster.self] = append(slotsOfNode[cluster.self],
+			"["+strconv.Itoa(slot)+"->-"+makeNodeID(target)+"]")
+	}
+	for slot, source := range cluster.slots.importing {
+		slotsOfNode[cluster.self] = append(slotsOfNode[cluster.self],
+			"["+strconv.Itoa(slot)+"-<-"+makeNodeID(source)+"]")
+	}
+	epoch := cluster.slots.configEpoch
+	cluster.slots.mu.RUnlock()
+
+	builder := &strings.Builder{}
+	for _, node := range cluster.nodes {
+		host, port := splitHostPort(node)
+		flags := "master"
+		if node == cluster.self {
+			flags = "myself,master"
+		}
+		fields := []string{
+			makeNodeID(node),
+			host + ":" + strconv.Itoa(port) + "@" + strconv.Itoa(port+clusterBusPortOffset),
+			flags,
+			"-",
+			"0",
+			"0",
+			strconv.FormatInt(epoch, 10),
+			"connected",
+		}
+		fields = append(fields, slotsOfNode[node]...)
+		builder.WriteString(strings.Join(fields, " ") + "\n")
+	}
+	return reply.MakeBulkReply([]byte(builder.String()))
+}
+
+// execClusterSetSlot changes state of slot on this node:
+// CLUSTER SETSLOT slot MIGRATING|IMPORTING|NODE node-id or CLUSTER SETSLOT slot STABLE
+// Like redis, NODE should be sent to every node as slot table is not propagated
+func execClusterSetSlot(cluster *Cluster, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("cluster|setslot")
+	}
+	slot, errReply := parseSlot(args[0])
+	if errReply != nil {
+		return errReply
+	}
+	action := strings.ToLower(string(args[1]))
+	if action == "stable" {
+		if len(args) != 2 {
+			return reply.MakeSyntaxErrReply()
+		}
+		cluster.slots.setSlot(slot, action, "")
+		return reply.MakeOkReply()
+	}
+	if len(args) != 3 {
+		return reply.MakeSyntaxErrReply()
+	}
+	node, ok := cluster.nodeByID(string(args[2]))
+	if !ok {
+		return reply.MakeErrReply("ERR I don't know about node " + string(args[2]))
+	}
+	owner := cluster.slots.getOwner(slot)
+	switch action {
+	case "migrating":
+		if owner != cluster.self {
+			return reply.MakeErrReply("ERR I'm not the owner of hash slot " + strconv.Itoa(slot))
+		}
+	case "importing":
+		if owner == cluster.self {
+			return reply.MakeErrReply("ERR I'm already the owner of hash slot " + strconv.Itoa(slot))
+		}
+	case "node":
+	default:
+		return reply.MakeSyntaxErrReply()
+	}
+	cluster.slots.setSlot(slot, action, node)
+	return reply.MakeOkReply()
+}
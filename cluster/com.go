@@ -18,23 +18,29 @@ func (cluster *Cluster) relay(peer string, conn resp.Connection, cmdLine [][]byt
 	if err != nil {
 		return reply.MakeErrReply(err.Error())
 	}
-	defer func() {
-		_ = cluster.returnPeerClient(peer, peerClient)
-	}()
 	peerClient.Send(utils.ToCmdLine("SELECT", strconv.Itoa(conn.GetDBIndex())))
-	return peerClient.Send(cmdLine)
+	res := peerClient.Send(cmdLine)
+	if errReply, ok := res.(reply.ErrorReply); ok && errReply.Error() == "client closed" {
+		// client gave up reconnecting, drop it so that a new connection is made once peer recovers
+		_ = cluster.invalidatePeerClient(peer, peerClient)
+	} else {
+		_ = cluster.returnPeerClient(peer, peerClient)
+	}
+	return res
 }
 
 func (cluster *Cluster) broadcast(conn resp.Connection, cmdLine [][]byte) map[string]resp.Reply {
 	res := make(map[string]resp.Reply)
-	for _, node := range cluster.nodes {
+	for _, node := range cluster.getNodes() {
 		res[node] = cluster.relay(node, conn, cmdLine)
 	}
 	return res
 }
 
 func (cluster *Cluster) getPeerClient(peer string) (*client.Client, error) {
+	cluster.mu.RLock()
 	pool, ok := cluster.peerConn[peer]
+	cluster.mu.RUnlock()
 	if !ok {
 		return nil, errors.New("peer connection not found")
 	}
@@ -50,9 +56,21 @@ func (cluster *Cluster) getPeerClient(peer string) (*client.Client, error) {
 }
 
 func (cluster *Cluster) returnPeerClient(peer string, c *client.Client) error {
+	cluster.mu.RLock()
 	pool, ok := cluster.peerConn[peer]
+	cluster.mu.RUnlock()
 	if !ok {
 		return errors.New("peer connection not found")
 	}
 	return pool.ReturnObject(context.Background(), c)
 }
+
+func (cluster *Cluster) invalidatePeerClient(peer string, c *client.Client) error {
+	cluster.mu.RLock()
+	pool, ok := cluster.peerConn[peer]
+	cluster.mu.RUnlock()
+	if !ok {
+		return errors.New("peer connection not found")
+	}
+	return pool.InvalidateObject(context.Background(), c)
+}
@@ -0,0 +1,372 @@
+package cluster
+
+import (
+	"ringodis/database"
+	"ringodis/interface/resp"
+	"ringodis/lib/consistenthash"
+	"ringodis/lib/logger"
+	"ringodis/resp/conn"
+	"ringodis/resp/reply"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// relayGossip is the internal command of cluster bus, sent through peer connections:
+//
+//	gossip_ ping sender epoch resharding node state [node state ...]
+//	gossip_ fail node
+//
+// ping carries nodes in the ring of sender and their states seen by sender, receiver replies its own view in the same fields.
+// fail is broadcast when majority agrees on failure of node, receiver marks it failed only if failure reports it has seen
+// reach majority as well. Only peers authenticated by masteruser and masterauth are allowed to send it, see database.CheckPermission
+const relayGossip = "gossip_"
+
+const (
+	defaultNodeTimeout = 15 * time.Second
+	// peers are pinged every maxGossipPeriod, or more frequently if node timeout is shorter
+	maxGossipPeriod = time.Second
+
+	stateOK    = "ok"
+	statePFail = "pfail"
+	stateFail  = "fail"
+)
+
+// member is health of another node in the ring, guarded by mu of Cluster
+type member struct {
+	pinging  bool
+	lastPong time.Time
+	// pfail means no pong received within node timeout
+	pfail bool
+	// fail means majority agreed on pfail, failed nodes are excluded from peerPicker until they reply again
+	fail bool
+	// failReports records when other nodes reported this one as pfail
+	failReports map[string]time.Time
+}
+
+func (m *member) state() string {
+	if m.fail {
+		return stateFail
+	}
+	if m.pfail {
+		return statePFail
+	}
+	return stateOK
+}
+
+// syncMembers makes members consistent with nodes, caller must hold the lock
+func (cluster *Cluster) syncMembers() {
+	inRing := make(map[string]struct{}, len(cluster.nodes))
+	for _, node := range cluster.nodes {
+		inRing[node] = struct{}{}
+		if _, ok := cluster.members[node]; !ok && node != cluster.self {
+			cluster.members[node] = &member{
+				lastPong:    time.Now(),
+				failReports: make(map[string]time.Time),
+			}
+		}
+	}
+	for node := range cluster.members {
+		if _, ok := inRing[node]; !ok {
+			delete(cluster.members, node)
+		}
+	}
+}
+
+// makePicker builds ring of nodes not failed, caller must hold the lock
+func (cluster *Cluster) makePicker() *consistenthash.Map {
+	picker := consistenthash.New(1, nil)
+	for _, node := range cluster.nodes {
+		if m := cluster.members[node]; m == nil || !m.fail {
+			picker.AddNode(node)
+		}
+	}
+	return picker
+}
+
+func (cluster *Cluster) cron() {
+	period := cluster.nodeTimeout / 5
+	if period > maxGossipPeriod {
+		period = maxGossipPeriod
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cluster.closeChan:
+			return
+		case <-ticker.C:
+		}
+		cluster.pingPeers()
+		cluster.checkFailures()
+		go cluster.checkReplicas()
+	}
+}
+
+// pingPeers pings every other node in the ring including failed ones, so that their recovery is detected
+func (cluster *Cluster) pingPeers() {
+	cluster.mu.Lock()
+	var peers []string
+	for node, m := range cluster.members {
+		if !m.pinging {
+			m.pinging = true
+			peers = append(peers, node)
+		}
+	}
+	cluster.mu.Unlock()
+	for _, peer := range peers {
+		go cluster.ping(peer)
+	}
+}
+
+func (cluster *Cluster) ping(peer string) {
+	res := cluster.relay(peer, conn.NewFakeConn(), cluster.makePing())
+	cluster.mu.Lock()
+	if m := cluster.members[peer]; m != nil {
+		m.pinging = false
+	}
+	cluster.mu.Unlock()
+	if pong, ok := res.(*reply.MultiBulkReply); ok && len(pong.Args) > 0 && string(pong.Args[0]) == peer {
+		cluster.handleGossip(pong.Args, true)
+	}
+}
+
+// makePing returns gossip_ ping command line describing this node
+func (cluster *Cluster) makePing() [][]byte {
+	return append([][]byte{[]byte(relayGossip), []byte("ping")}, cluster.gossipFields()...)
+}
+
+// gossipFields returns sender, epoch, resharding and states of nodes in the ring
+func (cluster *Cluster) gossipFields() [][]byte {
+	cluster.mu.RLock()
+	defer cluster.mu.RUnlock()
+	resharding := "0"
+	if cluster.resharding != nil {
+		resharding = "1"
+	}
+	fields := [][]byte{[]byte(cluster.self), []byte(strconv.FormatInt(cluster.ringEpoch, 10)), []byte(resharding)}
+	for _, node := range cluster.nodes {
+		state := stateOK
+		if m := cluster.members[node]; m != nil {
+			state = m.state()
+		}
+		fields = append(fields, []byte(node), []byte(state))
+	}
+	return fields
+}
+
+// handleGossip merges view of another node, isPong means the node replied our ping, which proves it is alive
+func (cluster *Cluster) handleGossip(fields [][]byte, isPong bool) bool {
+	if len(fields) < 3 || len(fields)%2 != 1 {
+		return false
+	}
+	sender := string(fields[0])
+	epoch, err := strconv.ParseInt(string(fields[1]), 10, 64)
+	if err != nil {
+		return false
+	}
+	senderResharding := string(fields[2]) == "1"
+	var nodes []string
+	hasSelf := false
+	now := time.Now()
+
+	cluster.mu.Lock()
+	// view of nodes outside the ring is ignored, they are replied with our view only
+	senderMember := cluster.members[sender]
+	if senderMember == nil {
+		cluster.mu.Unlock()
+		return true
+	}
+	recovered := false
+	if isPong {
+		senderMember.lastPong = now
+		senderMember.pfail = false
+		if senderMember.fail {
+			senderMember.fail = false
+			senderMember.failReports = make(map[string]time.Time)
+			recovered = true
+		}
+	}
+	for i := 3; i < len(fields); i += 2 {
+		node, state := string(fields[i]), string(fields[i+1])
+		nodes = append(nodes, node)
+		if node == cluster.self {
+			hasSelf = true
+		}
+		m := cluster.members[node]
+		if m == nil || node == sender {
+			continue
+		}
+		if state == statePFail || state == stateFail {
+			m.failReports[sender] = now
+		} else {
+			delete(m.failReports, sender)
+		}
+	}
+	// sender has finished a resharding this node missed, e.g. this node was down.
+	// a ring without this node is never adopted, removed nodes are told by reshard_ nodes
+	adopted := epoch > cluster.ringEpoch && !senderResharding && cluster.resharding == nil && hasSelf
+	if adopted {
+		cluster.ringEpoch = epoch
+		cluster.nodes = nodes
+		cluster.syncMembers()
+		cluster.ensurePeerConn()
+	}
+	if recovered || adopted {
+		cluster.peerPicker = cluster.makePicker()
+	}
+	cluster.mu.Unlock()
+
+	if recovered {
+		logger.Info("node " + sender + " recovered")
+		// keys written during failure are newer than the ones left on the recovered node
+		go cluster.migrateKeys(true)
+	}
+	if adopted {
+		logger.Info("adopted nodes of epoch " + strconv.FormatInt(epoch, 10) + " from " + sender)
+		go cluster.migrateKeys(false)
+	}
+	return true
+}
+
+// checkFailures marks peers without pong as pfail, and as fail if majority of ring agrees
+func (cluster *Cluster) checkFailures() {
+	now := time.Now()
+	var failed []string
+	cluster.mu.Lock()
+	for node, m := range cluster.members {
+		if now.Sub(m.lastPong) > cluster.nodeTimeout {
+			m.pfail = true
+		}
+		if !m.pfail || m.fail {
+			continue
+		}
+		votes := 1
+		for reporter, reportTime := range m.failReports {
+			if now.Sub(reportTime) > 2*cluster.nodeTimeout {
+				delete(m.failReports, reporter)
+				continue
+			}
+			if r := cluster.members[reporter]; r != nil && !r.fail {
+				votes++
+			}
+		}
+		if votes > len(cluster.nodes)/2 {
+			failed = append(failed, node)
+		}
+	}
+	for _, node := range failed {
+		cluster.markFail(node)
+	}
+	var peers []string
+	for node, m := range cluster.members {
+		if !m.fail {
+			peers = append(peers, node)
+		}
+	}
+	cluster.mu.Unlock()
+
+	c := conn.NewFakeConn()
+	for _, node := range failed {
+		logger.Warn("node " + node + " failed")
+		for _, peer := range peers {
+			cluster.relay(peer, c, [][]byte{[]byte(relayGossip), []byte("fail"), []byte(node)})
+		}
+	}
+}
+
+// markFail excludes node from peerPicker, caller must hold the lock
+func (cluster *Cluster) markFail(node string) {
+	m := cluster.members[node]
+	if m == nil || m.fail {
+		return
+	}
+	m.pfail = true
+	m.fail = true
+	cluster.peerPicker = cluster.makePicker()
+	// keys on failed node can't be migrated
+	cluster.dropPending(node)
+}
+
+// ensurePeerConn makes connection pools for nodes in the ring, caller must hold the lock
+func (cluster *Cluster) ensurePeerConn() {
+	for _, node := range cluster.nodes {
+		if _, ok := cluster.peerConn[node]; !ok && node != cluster.self {
+			cluster.peerConn[node] = makePeerPool(node)
+		}
+	}
+}
+
+func execGossip(cluster *Cluster, c resp.Connection, cmdLine [][]byte) resp.Reply {
+	if len(cmdLine) < 3 {
+		return reply.MakeArgNumErrReply(string(cmdLine[0]))
+	}
+	switch strings.ToLower(string(cmdLine[1])) {
+	case "ping":
+		if !cluster.handleGossip(cmdLine[2:], false) {
+			return reply.MakeSyntaxErrReply()
+		}
+		return reply.MakeMultiBulkReply(cluster.gossipFields())
+	case "fail":
+		// a single message is not trusted, failure is decided by reports of this node
+		go cluster.checkFailures()
+		return reply.MakeOkReply()
+	}
+	return reply.MakeSyntaxErrReply()
+}
+
+// execClusterMeet adds node to the ring of every node: CLUSTER MEET ip port
+func execClusterMeet(cluster *Cluster, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply("cluster|meet")
+	}
+	port, err := strconv.Atoi(string(args[1]))
+	if err != nil || port <= 0 || port > 65535 {
+		return reply.MakeErrReply("ERR Invalid node address specified: " + string(args[0]) + ":" + string(args[1]))
+	}
+	return execRingCluster(cluster, c, [][]byte{[]byte("cluster"), []byte("addnode"),
+		[]byte(string(args[0]) + ":" + strconv.Itoa(port))})
+}
+
+// execRingClusterNodes replies lines like `<id> <ip:port@cport> <flags> - <ping-sent> <pong-recv> <epoch> <link-state>` of nodes in the ring
+func execRingClusterNodes(cluster *Cluster) resp.Reply {
+	cluster.mu.RLock()
+	defer cluster.mu.RUnlock()
+	builder := &strings.Builder{}
+	for _, node := range cluster.nodes {
+		host, port := splitHostPort(node)
+		flags, pongRecv, link := "myself,master", int64(0), "connected"
+		if m := cluster.members[node]; m != nil {
+			flags = "master"
+			if m.fail {
+				flags += ",fail"
+			} else if m.pfail {
+				flags += ",fail?"
+			}
+			pongRecv = m.lastPong.UnixMilli()
+			if m.pfail {
+				link = "disconnected"
+			}
+		}
+		fields := []string{
+			makeNodeID(node),
+			host + ":" + strconv.Itoa(port) + "@" + strconv.Itoa(port+clusterBusPortOffset),
+			flags,
+			"-",
+			"0",
+			strconv.FormatInt(pongRecv, 10),
+			strconv.FormatInt(cluster.ringEpoch, 10),
+			link,
+		}
+		builder.WriteString(strings.Join(fields, " ") + "\n")
+		for _, line := range cluster.ringClusterReplicaLines(node) {
+			builder.WriteString(line + "\n")
+		}
+	}
+	return reply.MakeBulkReply([]byte(builder.String()))
+}
+
+func init() {
+	registerCmd(relayGossip, execGossip)
+	database.RegisterInternalCommand(relayGossip)
+}
@@ -0,0 +1,115 @@
+package cluster
+
+import (
+	"ringodis/config"
+	"ringodis/lib/utils"
+	"ringodis/resp/conn"
+	"ringodis/resp/reply"
+	"ringodis/resp/reply/asserts"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestGossipFailover(t *testing.T) {
+	config.Properties.ClusterNodeTimeout = 1000
+	defer func() {
+		config.Properties.ClusterNodeTimeout = 0
+	}()
+	a, b, c := serveCluster(t), serveCluster(t), serveCluster(t)
+	fake := conn.NewFakeConn()
+	for _, node := range []*testNode{b, c} {
+		host, port := splitHostPort(node.self)
+		asserts.AssertStatusReply(t, a.Exec(fake, utils.ToCmdLine("cluster", "meet", host, strconv.Itoa(port))), "OK")
+		waitResharding(t, a, b, c)
+	}
+	for _, node := range []*testNode{a, b, c} {
+		if nodes := node.getNodes(); len(nodes) != 3 {
+			t.Fatalf("expected 3 nodes, actual %v", nodes)
+		}
+	}
+	var key string
+	for i := 0; a.pickNode(key) != c.self; i++ {
+		key = "k" + strconv.Itoa(i)
+	}
+	asserts.AssertStatusReply(t, a.Exec(fake, utils.ToCmdLine("set", key, "old")), "OK")
+	asserts.AssertBulkReply(t, c.db.Exec(fake, utils.ToCmdLine("get", key)), "old")
+
+	// c is excluded from the ring after a and b agree on its failure
+	c.kill()
+	waitFor(t, 10*time.Second, func() bool {
+		return a.pickNode(key) != c.self && b.pickNode(key) != c.self
+	})
+	nodes := string(a.Exec(fake, utils.ToCmdLine("cluster", "nodes")).ToBytes())
+	if !strings.Contains(nodes, c.self+"@"+strconv.Itoa(c.port()+clusterBusPortOffset)+" master,fail ") {
+		t.Errorf("c should be failed: %s", nodes)
+	}
+	asserts.AssertStatusReply(t, b.Exec(fake, utils.ToCmdLine("set", key, "new")), "OK")
+
+	// keys written during failure are moved back after c recovers
+	c.restart(t)
+	waitFor(t, 10*time.Second, func() bool {
+		res, ok := c.db.Exec(fake, utils.ToCmdLine("get", key)).(*reply.BulkReply)
+		return ok && string(res.Arg) == "new"
+	})
+	asserts.AssertBulkReply(t, a.Exec(fake, utils.ToCmdLine("get", key)), "new")
+	asserts.AssertBulkReply(t, b.Exec(fake, utils.ToCmdLine("get", key)), "new")
+}
+
+func TestGossipAdoptNodes(t *testing.T) {
+	a, b := serveCluster(t), serveCluster(t)
+	fake := conn.NewFakeConn()
+	host, port := splitHostPort(b.self)
+	asserts.AssertStatusReply(t, a.Exec(fake, utils.ToCmdLine("cluster", "meet", host, strconv.Itoa(port))), "OK")
+	waitResharding(t, a, b)
+	getEpoch := func() int64 {
+		b.mu.RLock()
+		defer b.mu.RUnlock()
+		return b.ringEpoch
+	}
+	next := strconv.FormatInt(getEpoch()+1, 10)
+
+	// rings from nodes out of the ring or without b are not adopted
+	ping := utils.ToCmdLine(relayGossip, "ping", "127.0.0.1:1", next, "0", "127.0.0.1:1", stateOK, b.self, stateOK)
+	asserts.AssertMultiBulkReplySize(t, b.Exec(fake, ping), 7)
+	ping = utils.ToCmdLine(relayGossip, "ping", a.self, next, "0", a.self, stateOK)
+	b.Exec(fake, ping)
+	if nodes := b.getNodes(); len(nodes) != 2 || nodes[0] == "127.0.0.1:1" || nodes[1] == "127.0.0.1:1" {
+		t.Errorf("expected a and b, actual %v", nodes)
+	}
+	// nodes in resharding are not adopted
+	ping = utils.ToCmdLine(relayGossip, "ping", a.self, next, "1", a.self, stateOK, b.self, stateOK)
+	b.Exec(fake, ping)
+	if epoch := getEpoch(); strconv.FormatInt(epoch, 10) == next {
+		t.Errorf("epoch %d should not be adopted", epoch)
+	}
+	// b has missed the resharding of the next epoch
+	ping = utils.ToCmdLine(relayGossip, "ping", a.self, next, "0", a.self, stateOK, b.self, stateOK)
+	b.Exec(fake, ping)
+	if epoch := getEpoch(); strconv.FormatInt(epoch, 10) != next {
+		t.Errorf("expected epoch %s, actual %d", next, epoch)
+	}
+	asserts.AssertErrReply(t, b.Exec(fake, utils.ToCmdLine(relayGossip, "ping", a.self, "x", "0")), "Err syntax error")
+
+	// a single fail message is not trusted
+	asserts.AssertStatusReply(t, b.Exec(fake, utils.ToCmdLine(relayGossip, "fail", a.self)), "OK")
+	time.Sleep(100 * time.Millisecond)
+	b.mu.RLock()
+	failed := b.members[a.self].fail
+	b.mu.RUnlock()
+	if failed {
+		t.Error("a should not be failed")
+	}
+}
@@ -0,0 +1,377 @@
+package cluster
+
+import (
+	"bytes"
+	"ringodis/database"
+	idb "ringodis/interface/database"
+	"ringodis/interface/resp"
+	"ringodis/lib/utils"
+	"ringodis/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// groupByNode returns indices of keys grouped by their owners
+func (cluster *Cluster) groupByNode(keys []string) map[string][]int {
+	cluster.mu.RLock()
+	defer cluster.mu.RUnlock()
+	groups := make(map[string][]int)
+	for i, key := range keys {
+		node := cluster.peerPicker.PickNode(key)
+		groups[node] = append(groups[node], i)
+	}
+	return groups
+}
+
+func toKeys(args [][]byte) []string {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = string(arg)
+	}
+	return keys
+}
+
+// mgetFunc reads keys from their owners, reading is not atomic across nodes like redis cluster
+func mgetFunc(cluster *Cluster, c resp.Connection, cmdLine [][]byte) resp.Reply {
+	if len(cmdLine) < 2 {
+		return reply.MakeArgNumErrReply(string(cmdLine[0]))
+	}
+	keys := toKeys(cmdLine[1:])
+	if errReply := cluster.pullKeys(c, keys...); errReply != nil {
+		return errReply
+	}
+	result := make([][]byte, len(keys))
+	for node, indices := range cluster.groupByNode(keys) {
+		args := make([][]byte, 0, len(indices)+1)
+		args = append(args, cmdLine[0])
+		for _, i := range indices {
+			args = append(args, cmdLine[i+1])
+		}
+		res, ok := cluster.relay(cluster.pickReader(node), c, args).(*reply.MultiBulkReply)
+		if !ok || len(res.Args) != len(indices) {
+			return reply.MakeErrReply("ERR unexpected reply of mget from " + node)
+		}
+		for j, i := range indices {
+			result[i] = res.Args[j]
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// existsFunc counts existing keys on their owners
+func existsFunc(cluster *Cluster, c resp.Connection, cmdLine [][]byte) resp.Reply {
+	if len(cmdLine) < 2 {
+		return reply.MakeArgNumErrReply(string(cmdLine[0]))
+	}
+	keys := toKeys(cmdLine[1:])
+	if errReply := cluster.pullKeys(c, keys...); errReply != nil {
+		return errReply
+	}
+	var count int64
+	for node, indices := range cluster.groupByNode(keys) {
+		args := make([][]byte, 0, len(indices)+1)
+		args = append(args, cmdLine[0])
+		for _, i := range indices {
+			args = append(args, cmdLine[i+1])
+		}
+		res, ok := cluster.relay(cluster.pickReader(node), c, args).(*reply.IntReply)
+		if !ok {
+			return reply.MakeErrReply("ERR unexpected reply of exists from " + node)
+		}
+		count += res.Code
+	}
+	return reply.MakeIntReply(count)
+}
+
+// delFunc deletes keys on all owners atomically
+func delFunc(cluster *Cluster, c resp.Connection, cmdLine [][]byte) resp.Reply {
+	if len(cmdLine) < 2 {
+		return reply.MakeArgNumErrReply(string(cmdLine[0]))
+	}
+	keys := toKeys(cmdLine[1:])
+	if errReply := cluster.pullKeys(c, keys...); errReply != nil {
+		return errReply
+	}
+	groups := make(map[string][]idb.CmdLine)
+	for node, indices := range cluster.groupByNode(keys) {
+		args := make([][]byte, 0, len(indices)+1)
+		args = append(args, cmdLine[0])
+		for _, i := range indices {
+			args = append(args, cmdLine[i+1])
+		}
+		groups[node] = []idb.CmdLine{args}
+	}
+	if len(groups) == 1 {
+		for node, cmdLines := range groups {
+			return cluster.relay(node, c, cmdLines[0])
+		}
+	}
+	results, errReply := cluster.execAtomic(c, groups)
+	if errReply != nil {
+		return errReply
+	}
+	var deleted int64
+	for _, res := range results {
+		if intReply, ok := parseResult(res[0]).(*reply.IntReply); ok {
+			deleted += intReply.Code
+		}
+	}
+	return reply.MakeIntReply(deleted)
+}
+
+// msetFunc sets keys on all owners atomically
+func msetFunc(cluster *Cluster, c resp.Connection, cmdLine [][]byte) resp.Reply {
+	if len(cmdLine) < 3 || len(cmdLine)%2 != 1 {
+		return reply.MakeArgNumErrReply(string(cmdLine[0]))
+	}
+	keys := make([]string, 0, len(cmdLine)/2)
+	for i := 1; i < len(cmdLine); i += 2 {
+		keys = append(keys, string(cmdLine[i]))
+	}
+	if errReply := cluster.pullKeys(c, keys...); errReply != nil {
+		return errReply
+	}
+	groups := make(map[string][]idb.CmdLine)
+	for node, indices := range cluster.groupByNode(keys) {
+		args := make([][]byte, 0, 2*len(indices)+1)
+		args = append(args, cmdLine[0])
+		for _, i := range indices {
+			args = append(args, cmdLine[2*i+1], cmdLine[2*i+2])
+		}
+		groups[node] = []idb.CmdLine{args}
+	}
+	if len(groups) == 1 {
+		for node, cmdLines := range groups {
+			return cluster.relay(node, c, cmdLines[0])
+		}
+	}
+	if _, errReply := cluster.execAtomic(c, groups); errReply != nil {
+		return errReply
+	}
+	return reply.MakeOkReply()
+}
+
+// maxRenameRetries limits how many times rename is retried if source key is modified before it is locked
+const maxRenameRetries = 3
+
+// renameSource is the value of source key read by rename
+type renameSource struct {
+	payload []byte
+	pttl    int64
+	// readAt is the time before source is read, used to bound expected change of pttl
+	readAt time.Time
+}
+
+// parseRenameSource parses results of dump and pttl of source key
+func parseRenameSource(dumpRes, pttlRes resp.Reply, readAt time.Time) (*renameSource, reply.ErrorReply) {
+	if errReply, ok := dumpRes.(reply.ErrorReply); ok {
+		return nil, errReply
+	}
+	if errReply, ok := pttlRes.(reply.ErrorReply); ok {
+		return nil, errReply
+	}
+	payload, ok := dumpRes.(*reply.BulkReply)
+	if !ok || payload.Arg == nil {
+		return nil, reply.MakeErrReply("ERR no such key")
+	}
+	pttl, ok := pttlRes.(*reply.IntReply)
+	if !ok {
+		return nil, reply.MakeErrReply("ERR unexpected reply of pttl")
+	}
+	if pttl.Code == -2 {
+		return nil, reply.MakeErrReply("ERR no such key")
+	}
+	return &renameSource{payload: payload.Arg, pttl: pttl.Code, readAt: readAt}, nil
+}
+
+// unchanged returns whether locked is the same value of source, its pttl may only decrease by time elapsed since source was read
+func (source *renameSource) unchanged(locked *renameSource) bool {
+	if !bytes.Equal(source.payload, locked.payload) {
+		return false
+	}
+	if source.pttl < 0 || locked.pttl < 0 {
+		return source.pttl == locked.pttl
+	}
+	elapsed := int64(time.Since(source.readAt) / time.Millisecond)
+	// pttl is truncated to milliseconds
+	return locked.pttl <= source.pttl+1 && locked.pttl >= source.pttl-elapsed-1
+}
+
+// renameFunc moves value with ttl to the owner of new key, source is deleted in the same transaction
+func renameFunc(cluster *Cluster, c resp.Connection, cmdLine [][]byte) resp.Reply {
+	if len(cmdLine) != 3 {
+		return reply.MakeArgNumErrReply(string(cmdLine[0]))
+	}
+	src, dest := string(cmdLine[1]), string(cmdLine[2])
+	if errReply := cluster.pullKeys(c, src, dest); errReply != nil {
+		return errReply
+	}
+	srcNode, destNode := cluster.pickNode(src), cluster.pickNode(dest)
+	if srcNode == destNode {
+		return cluster.relay(srcNode, c, cmdLine)
+	}
+	// source is read without lock, then nodes are prepared in sorted order like execAtomic,
+	// so concurrent renames in opposite directions never wait for each other.
+	// If destination is prepared first and source is modified in the meantime, rename is retried
+	readAt := time.Now()
+	source, errReply := parseRenameSource(
+		cluster.relay(srcNode, c, utils.ToCmdLine("dump", src)),
+		cluster.relay(srcNode, c, utils.ToCmdLine("pttl", src)),
+		readAt,
+	)
+	if errReply != nil {
+		return errReply
+	}
+	nodes := []string{srcNode, destNode}
+	sort.Strings(nodes)
+	for i := 0; i < maxRenameRetries; i++ {
+		co := cluster.newCoordinator(c)
+		var locked *renameSource
+		for _, node := range nodes {
+			if node == srcNode {
+				prepareAt := time.Now()
+				results, errReply := co.prepare(srcNode, []idb.CmdLine{
+					utils.ToCmdLine("dump", src),
+					utils.ToCmdLine("pttl", src),
+					utils.ToCmdLine("del", src),
+				})
+				if errReply == nil {
+					locked, errReply = parseRenameSource(parseResult(results[0]), parseResult(results[1]), prepareAt)
+				}
+				if errReply != nil {
+					co.rollback()
+					return errReply
+				}
+				// destination is not prepared yet, so it can be restored by the locked value
+				source = locked
+				continue
+			}
+			ttl := source.pttl
+			if ttl < 0 {
+				ttl = 0
+			}
+			_, errReply := co.prepare(destNode, []idb.CmdLine{
+				utils.ToCmdLine("restore", dest, strconv.FormatInt(ttl, 10), string(source.payload), "replace"),
+			})
+			if errReply != nil {
+				co.rollback()
+				return errReply
+			}
+		}
+		if source.unchanged(locked) {
+			co.commit()
+			return reply.MakeOkReply()
+		}
+		co.rollback()
+		source = locked
+	}
+	return reply.MakeErrReply("ERR source key is modified concurrently, try again later")
+}
+
+// enqueueCmd validates command in transaction and puts it into queue, it is executed by execFunc
+func enqueueCmd(cluster *Cluster, c resp.Connection, cmdLine [][]byte) resp.Reply {
+	write, read, ok := database.GetRelatedKeys(cmdLine)
+	if !ok {
+		errReply := reply.MakeErrReply("ERR unknown command or wrong number of arguments for '" + string(cmdLine[0]) + "'")
+		c.AddTxError(errReply)
+		return errReply
+	}
+	if _, errReply := cluster.pickTxNode(append(write, read...)); errReply != nil {
+		c.AddTxError(errReply)
+		return errReply
+	}
+	c.EnqueueCmd(cmdLine)
+	return reply.MakeStatusReply("QUEUED")
+}
+
+// pickTxNode returns the node owning all keys of a command in transaction, commands without key are executed locally
+func (cluster *Cluster) pickTxNode(keys []string) (string, reply.ErrorReply) {
+	if len(keys) == 0 {
+		return cluster.self, nil
+	}
+	groups := cluster.groupByNode(keys)
+	if len(groups) > 1 {
+		return "", reply.MakeErrReply("ERR keys of a command in transaction must be located on the same node")
+	}
+	for node := range groups {
+		return node, nil
+	}
+	return "", nil
+}
+
+// execFunc executes queued commands on their owners atomically. Unlike standalone server, which replies errors of
+// commands in place, any error rolls back the whole transaction and is replied as EXECABORT
+func execFunc(cluster *Cluster, c resp.Connection, cmdLine [][]byte) resp.Reply {
+	if len(cmdLine) != 1 {
+		return reply.MakeArgNumErrReply(string(cmdLine[0]))
+	}
+	if !c.InMultiState() {
+		return reply.MakeErrReply("ERR EXEC without MULTI")
+	}
+	defer c.SetMultiState(false)
+	if len(c.GetTxErrors()) > 0 {
+		return reply.MakeErrReply("EXECABORT Transaction discarded because of previous errors.")
+	}
+	cmdLines := c.GetQueuedCmdLine()
+	if len(cmdLines) == 0 {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	type position struct {
+		node  string
+		index int
+	}
+	positions := make([]position, len(cmdLines))
+	groups := make(map[string][]idb.CmdLine)
+	for i, line := range cmdLines {
+		write, read, _ := database.GetRelatedKeys(line)
+		keys := append(write, read...)
+		if errReply := cluster.pullKeys(c, keys...); errReply != nil {
+			return errReply
+		}
+		// ring may have changed since the command was queued
+		node, errReply := cluster.pickTxNode(keys)
+		if errReply != nil {
+			return reply.MakeErrReply("EXECABORT Transaction discarded because of error: " + errReply.Error())
+		}
+		positions[i] = position{node: node, index: len(groups[node])}
+		groups[node] = append(groups[node], line)
+	}
+	results, errReply := cluster.execAtomic(c, groups)
+	if errReply != nil {
+		return reply.MakeErrReply("EXECABORT Transaction rolled back because of error: " +
+			errReply.Error())
+	}
+	replies := make([]resp.Reply, len(cmdLines))
+	for i, pos := range positions {
+		replies[i] = rawReply(results[pos.node][pos.index])
+	}
+	return reply.MakeMultiRawReply(replies)
+}
+
+// watchFunc rejects WATCH and UNWATCH, versions of keys on different nodes can't be checked atomically
+func watchFunc(cluster *Cluster, c resp.Connection, cmdLine [][]byte) resp.Reply {
+	return reply.MakeErrReply("ERR " + strings.ToUpper(string(cmdLine[0])) +
+		" is not supported in cluster, transaction is rolled back if any command fails instead")
+}
+
+// rawReply is a reply already encoded by participant
+type rawReply []byte
+
+func (r rawReply) ToBytes() []byte {
+	return r
+}
+
+func init() {
+	registerCmd("mget", mgetFunc)
+	registerCmd("exists", existsFunc)
+	registerCmd("del", delFunc)
+	registerCmd("mset", msetFunc)
+	registerCmd("rename", renameFunc)
+	registerCmd("multi", localFunc)
+	registerCmd("discard", localFunc)
+	registerCmd("exec", execFunc)
+	registerCmd("watch", watchFunc)
+	registerCmd("unwatch", watchFunc)
+}
@@ -0,0 +1,92 @@
+package cluster
+
+import (
+	"ringodis/database"
+	"ringodis/interface/resp"
+	"ringodis/lib/logger"
+	"ringodis/resp/reply"
+)
+
+// relayPublish is sent to peers when broadcasting PUBLISH,
+// peers deliver it to local subscribers only, so that messages won't be broadcast again
+const relayPublish = "publish_"
+
+// publishFunc delivers message to local subscribers and broadcasts it to every peer, replies total number of receivers
+func publishFunc(cluster *Cluster, c resp.Connection, cmdLine [][]byte) resp.Reply {
+	if len(cmdLine) != 3 {
+		return reply.MakeArgNumErrReply(string(cmdLine[0]))
+	}
+	relayLine := make([][]byte, len(cmdLine))
+	copy(relayLine, cmdLine)
+	relayLine[0] = []byte(relayPublish)
+	var count int64
+	for _, node := range cluster.getNodes() {
+		var res resp.Reply
+		if node == cluster.self {
+			res = cluster.db.Exec(c, cmdLine)
+		} else {
+			res = cluster.relay(node, c, relayLine)
+		}
+		if intReply, ok := res.(*reply.IntReply); ok {
+			count += intReply.Code
+		} else {
+			logger.Warn("publish to " + node + " failed: " + string(res.ToBytes()))
+		}
+	}
+	return reply.MakeIntReply(count)
+}
+
+// relayPublishFunc handles PUBLISH broadcast by other node
+func relayPublishFunc(cluster *Cluster, c resp.Connection, cmdLine [][]byte) resp.Reply {
+	localLine := make([][]byte, len(cmdLine))
+	copy(localLine, cmdLine)
+	localLine[0] = []byte("publish")
+	return cluster.db.Exec(c, localLine)
+}
+
+// localFunc executes commands on the current node, e.g. SUBSCRIBE which keeps states in local connection
+func localFunc(cluster *Cluster, c resp.Connection, cmdLine [][]byte) resp.Reply {
+	return cluster.db.Exec(c, cmdLine)
+}
+
+// ssubscribeFunc subscribes shard channels owned by the current node,
+// client must connect to the owner node because messages can't be pushed through peer connections
+func ssubscribeFunc(cluster *Cluster, c resp.Connection, cmdLine [][]byte) resp.Reply {
+	if len(cmdLine) < 2 {
+		return reply.MakeArgNumErrReply(string(cmdLine[0]))
+	}
+	node := cluster.pickNode(string(cmdLine[1]))
+	for _, channel := range cmdLine[2:] {
+		if cluster.pickNode(string(channel)) != node {
+			return reply.MakeErrReply("ERR shard channels must be located on the same node")
+		}
+	}
+	if node != cluster.self {
+		return reply.MakeErrReply("ERR shard channel is served by node " + node)
+	}
+	return cluster.db.Exec(c, cmdLine)
+}
+
+// spublishFunc relays SPUBLISH to the node owning the shard channel
+func spublishFunc(cluster *Cluster, c resp.Connection, cmdLine [][]byte) resp.Reply {
+	if len(cmdLine) != 3 {
+		return reply.MakeArgNumErrReply(string(cmdLine[0]))
+	}
+	node := cluster.pickNode(string(cmdLine[1]))
+	return cluster.relay(node, c, cmdLine)
+}
+
+func init() {
+	registerCmd("publish", publishFunc)
+	registerCmd(relayPublish, relayPublishFunc)
+	database.RegisterInternalCommand(relayPublish)
+	registerCmd("subscribe", localFunc)
+	registerCmd("unsubscribe", localFunc)
+	registerCmd("psubscribe", localFunc)
+	registerCmd("punsubscribe", localFunc)
+	registerCmd("sunsubscribe", localFunc)
+	registerCmd("pubsub", localFunc)
+	registerCmd("ping", localFunc)
+	registerCmd("ssubscribe", ssubscribeFunc)
+	registerCmd("spublish", spublishFunc)
+}
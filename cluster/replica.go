@@ -0,0 +1,208 @@
+package cluster
+
+import (
+	"ringodis/database"
+	"ringodis/interface/resp"
+	"ringodis/lib/logger"
+	"ringodis/lib/utils"
+	"ringodis/resp/conn"
+	"ringodis/resp/reply"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// policies of routing read-only commands
+const (
+	readPrimaryOnly   = "primary-only"
+	readPreferReplica = "prefer-replica"
+	readRoundRobin    = "round-robin"
+
+	defaultReplicaMaxLag = 1 << 20
+)
+
+// replicaSet is read replicas of a node in the ring, guarded by mu of Cluster
+type replicaSet struct {
+	addrs []string
+	// healthy replicas are online at primary and not lagging too much, refreshed by cron
+	healthy []string
+	// next counts picks to take replicas in turn
+	next uint32
+}
+
+// parseReplicas parses entries like "primary replica [replica ...]"
+func parseReplicas(entries []string) map[string]*replicaSet {
+	replicas := make(map[string]*replicaSet)
+	for _, entry := range entries {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			logger.Warn("no replica of " + fields[0] + " in cluster-replicas")
+			continue
+		}
+		set := replicas[fields[0]]
+		if set == nil {
+			set = &replicaSet{}
+			replicas[fields[0]] = set
+		}
+		set.addrs = append(set.addrs, fields[1:]...)
+	}
+	return replicas
+}
+
+func parseReadPolicy(policy string) string {
+	switch policy = strings.ToLower(policy); policy {
+	case "":
+		return readPrimaryOnly
+	case readPrimaryOnly, readPreferReplica, readRoundRobin:
+		return policy
+	}
+	logger.Warn("unknown cluster-read-policy " + policy + ", use " + readPrimaryOnly)
+	return readPrimaryOnly
+}
+
+// isReadOnly tells whether command only reads keys, which could be executed by replicas
+func isReadOnly(cmdLine [][]byte) bool {
+	writeKeys, readKeys, ok := database.GetRelatedKeys(cmdLine)
+	return ok && len(writeKeys) == 0 && len(readKeys) > 0
+}
+
+// pickReader returns the node to execute read-only commands on keys of primary according to read policy
+func (cluster *Cluster) pickReader(primary string) string {
+	if cluster.readPolicy == readPrimaryOnly {
+		return primary
+	}
+	cluster.mu.RLock()
+	defer cluster.mu.RUnlock()
+	set := cluster.replicas[primary]
+	// replicas may not have received keys being migrated to primary
+	if set == nil || len(set.healthy) == 0 || cluster.resharding != nil {
+		return primary
+	}
+	n := atomic.AddUint32(&set.next, 1)
+	if cluster.readPolicy == readRoundRobin {
+		i := int(n % uint32(len(set.healthy)+1))
+		if i == len(set.healthy) {
+			return primary
+		}
+		return set.healthy[i]
+	}
+	return set.healthy[n%uint32(len(set.healthy))]
+}
+
+// checkReplicas refreshes healthy replicas by replication info of their primaries, runs one at a time
+func (cluster *Cluster) checkReplicas() {
+	if !atomic.CompareAndSwapInt32(&cluster.checkingReplicas, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&cluster.checkingReplicas, 0)
+	cluster.mu.RLock()
+	primaries := make(map[string][]string, len(cluster.replicas))
+	for primary, set := range cluster.replicas {
+		primaries[primary] = set.addrs
+	}
+	maxLag := cluster.replicaMaxLag
+	cluster.mu.RUnlock()
+	c := conn.NewFakeConn()
+	for primary, addrs := range primaries {
+		healthy := cluster.healthyReplicas(c, primary, addrs, maxLag)
+		cluster.mu.Lock()
+		if set := cluster.replicas[primary]; set != nil {
+			set.healthy = healthy
+		}
+		cluster.mu.Unlock()
+	}
+}
+
+// healthyReplicas returns replicas in addrs which are online at primary, and within max lag of offset and ack time
+func (cluster *Cluster) healthyReplicas(c resp.Connection, primary string, addrs []string, maxLag int64) []string {
+	res, ok := cluster.relay(primary, c, utils.ToCmdLine("info", "replication")).(*reply.BulkReply)
+	if !ok {
+		return nil
+	}
+	type replicaInfo struct {
+		offset int64
+		lag    int64
+	}
+	var masterOffset int64
+	online := make(map[string]replicaInfo)
+	for _, line := range strings.Split(string(res.Arg), "\r\n") {
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		if name == "master_repl_offset" {
+			masterOffset, _ = strconv.ParseInt(value, 10, 64)
+			continue
+		}
+		if !strings.HasPrefix(name, "slave") {
+			continue
+		}
+		// slave0:ip=127.0.0.1,port=7391,state=online,offset=100,lag=0
+		fields := make(map[string]string)
+		for _, field := range strings.Split(value, ",") {
+			if k, v, found := strings.Cut(field, "="); found {
+				fields[k] = v
+			}
+		}
+		if fields["state"] != "online" {
+			continue
+		}
+		var info replicaInfo
+		info.offset, _ = strconv.ParseInt(fields["offset"], 10, 64)
+		info.lag, _ = strconv.ParseInt(fields["lag"], 10, 64)
+		online[fields["ip"]+":"+fields["port"]] = info
+	}
+	var healthy []string
+	for _, addr := range addrs {
+		info, ok := online[addr]
+		if !ok || masterOffset-info.offset > maxLag || info.lag*1000 > cluster.nodeTimeout.Milliseconds() {
+			continue
+		}
+		healthy = append(healthy, addr)
+	}
+	return healthy
+}
+
+// ringClusterReplicaLines returns lines of replicas in CLUSTER NODES, caller must hold the lock
+func (cluster *Cluster) ringClusterReplicaLines(primary string) []string {
+	set := cluster.replicas[primary]
+	if set == nil {
+		return nil
+	}
+	healthy := make(map[string]struct{}, len(set.healthy))
+	for _, addr := range set.healthy {
+		healthy[addr] = struct{}{}
+	}
+	var lines []string
+	for _, addr := range set.addrs {
+		host, port := splitHostPort(addr)
+		link := "disconnected"
+		if _, ok := healthy[addr]; ok {
+			link = "connected"
+		}
+		lines = append(lines, strings.Join([]string{
+			makeNodeID(addr),
+			host + ":" + strconv.Itoa(port) + "@" + strconv.Itoa(port+clusterBusPortOffset),
+			"slave",
+			makeNodeID(primary),
+			"0",
+			"0",
+			strconv.FormatInt(cluster.ringEpoch, 10),
+			link,
+		}, " "))
+	}
+	return lines
+}
+
+func init() {
+	// relay selects db before every command, so that peers and replicas execute it in the same db
+	registerCmd("select", localFunc)
+	// nodes in the ring could be primaries of replicas
+	registerCmd("info", localFunc)
+	registerCmd("role", localFunc)
+	registerCmd("psync", localFunc)
+	registerCmd("replconf", localFunc)
+}
@@ -0,0 +1,70 @@
+package cluster
+
+import (
+	"ringodis/config"
+	"ringodis/lib/utils"
+	"ringodis/resp/conn"
+	"ringodis/resp/reply/asserts"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadFromReplica(t *testing.T) {
+	a, r := serveCluster(t), serveCluster(t)
+	a.mu.Lock()
+	a.replicas = parseReplicas([]string{a.self + " " + r.self})
+	a.peerConn[r.self] = makePeerPool(r.self)
+	a.readPolicy = readPreferReplica
+	a.mu.Unlock()
+
+	c := conn.NewFakeConn()
+	config.Properties.SlaveAnnouncePort = r.port()
+	defer func() {
+		config.Properties.SlaveAnnouncePort = 0
+	}()
+	host, port := splitHostPort(a.self)
+	asserts.AssertStatusReply(t, r.db.Exec(c, utils.ToCmdLine("replicaof", host, strconv.Itoa(port))), "OK")
+	// writes are never routed to replica, which is read only
+	asserts.AssertStatusReply(t, a.Exec(c, utils.ToCmdLine("set", "k", "v")), "OK")
+	waitFor(t, 5*time.Second, func() bool {
+		a.checkReplicas()
+		return a.pickReader(a.self) == r.self
+	})
+	waitFor(t, 5*time.Second, func() bool {
+		return string(r.db.Exec(c, utils.ToCmdLine("get", "k")).ToBytes()) == "$1\r\nv\r\n"
+	})
+	asserts.AssertBulkReply(t, a.Exec(c, utils.ToCmdLine("get", "k")), "v")
+	asserts.AssertMultiBulkReply(t, a.Exec(c, utils.ToCmdLine("mget", "k", "none")), []string{"v", ""})
+	nodes := string(a.Exec(c, utils.ToCmdLine("cluster", "nodes")).ToBytes())
+	if !strings.Contains(nodes, makeNodeID(r.self)+" "+r.self+"@"+strconv.Itoa(r.port()+clusterBusPortOffset)+" slave "+makeNodeID(a.self)) {
+		t.Errorf("replica not found: %s", nodes)
+	}
+
+	a.readPolicy = readRoundRobin
+	picked := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		picked[a.pickReader(a.self)]++
+	}
+	if picked[a.self] != 2 || picked[r.self] != 2 {
+		t.Errorf("expected primary and replica in turn, actual %v", picked)
+	}
+	a.readPolicy = readPrimaryOnly
+	if node := a.pickReader(a.self); node != a.self {
+		t.Errorf("expected primary, actual %s", node)
+	}
+
+	// replica lagging beyond limit falls back to primary
+	a.readPolicy = readPreferReplica
+	a.mu.Lock()
+	a.replicaMaxLag = -1
+	a.mu.Unlock()
+	a.checkReplicas()
+	if node := a.pickReader(a.self); node != a.self {
+		t.Errorf("expected primary, actual %s", node)
+	}
+	if isReadOnly(utils.ToCmdLine("set", "k", "v")) || isReadOnly(utils.ToCmdLine("del", "k")) || !isReadOnly(utils.ToCmdLine("get", "k")) {
+		t.Error("isReadOnly is wrong")
+	}
+}
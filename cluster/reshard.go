@@ -0,0 +1,368 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"ringodis/config"
+	"ringodis/database"
+	idb "ringodis/interface/database"
+	"ringodis/interface/resp"
+	"ringodis/lib/consistenthash"
+	"ringodis/lib/logger"
+	"ringodis/lib/utils"
+	"ringodis/resp/conn"
+	"ringodis/resp/reply"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// relayReshard is the internal command coordinating resharding between nodes:
+//
+//	reshard_ nodes epoch node...                switches to the new ring, the old ring is kept for routing until migration finished
+//	reshard_ start                              starts moving local keys to their new owners in background
+//	reshard_ finished node                      announces node has moved all its keys away
+//	reshard_ pull key                           moves key to its new owner at once, sent to the old owner of key
+//	reshard_ restore key ttl payload [REPLACE]  restores key transferred from the old owner
+//
+// like other internal commands, it is allowed only to peers authenticated by masteruser and masterauth
+const relayReshard = "reshard_"
+
+// resharding records the old ring until every node in it has migrated its keys
+type resharding struct {
+	oldPicker *consistenthash.Map
+	// pending holds nodes of the old ring which haven't finished migration
+	pending map[string]struct{}
+	// members are nodes in either the old or the new ring, they are notified when a node finishes migration
+	members []string
+}
+
+// execRingCluster executes CLUSTER subcommands in consistent hash mode,
+// for CLUSTER ADDNODE|DELNODE host:port the node receiving the command changes ring of every node, then keys are migrated in background
+func execRingCluster(cluster *Cluster, c resp.Connection, cmdLine [][]byte) resp.Reply {
+	if len(cmdLine) < 2 {
+		return reply.MakeArgNumErrReply(string(cmdLine[0]))
+	}
+	subCmd := strings.ToLower(string(cmdLine[1]))
+	switch subCmd {
+	case "meet":
+		return execClusterMeet(cluster, c, cmdLine[2:])
+	case "nodes":
+		return execRingClusterNodes(cluster)
+	}
+	if len(cmdLine) != 3 {
+		return reply.MakeArgNumErrReply("cluster|" + subCmd)
+	}
+	node := string(cmdLine[2])
+	nodes := cluster.getNodes()
+	idx := -1
+	for i, n := range nodes {
+		if n == node {
+			idx = i
+		}
+	}
+	switch subCmd {
+	case "addnode":
+		if idx >= 0 {
+			return reply.MakeErrReply("ERR node " + node + " already exists")
+		}
+		nodes = append(nodes, node)
+	case "delnode":
+		if idx < 0 {
+			return reply.MakeErrReply("ERR I don't know about node " + node)
+		}
+		if len(nodes) == 1 {
+			return reply.MakeErrReply("ERR can't remove the last node")
+		}
+		nodes = append(nodes[:idx], nodes[idx+1:]...)
+	default:
+		return reply.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try CLUSTER HELP.")
+	}
+	return cluster.reshard(c, nodes)
+}
+
+// reshard switches every node to the new ring before any migration starts,
+// so that keys are never moved to a node which still routes them elsewhere
+func (cluster *Cluster) reshard(c resp.Connection, nodes []string) resp.Reply {
+	cluster.mu.RLock()
+	epoch := cluster.ringEpoch + 1
+	cluster.mu.RUnlock()
+	args := append([]string{"nodes", strconv.FormatInt(epoch, 10)}, nodes...)
+	// change this node first, it fails if another resharding is in progress
+	if res := cluster.sendReshard(cluster.self, c, args...); reply.IsErrorReply(res) {
+		return res
+	}
+	cluster.mu.RLock()
+	members := cluster.resharding.members
+	cluster.mu.RUnlock()
+	for _, member := range members {
+		if member == cluster.self {
+			continue
+		}
+		if res := cluster.sendReshard(member, c, args...); reply.IsErrorReply(res) {
+			return reply.MakeErrReply("ERR failed to change ring of " + member + ": " + string(res.ToBytes()))
+		}
+	}
+	// new nodes may hold stale keys as well, so every member migrates
+	for _, member := range members {
+		if res := cluster.sendReshard(member, c, "start"); reply.IsErrorReply(res) {
+			return reply.MakeErrReply("ERR failed to start migration on " + member + ": " + string(res.ToBytes()))
+		}
+	}
+	return reply.MakeOkReply()
+}
+
+// sendReshard executes reshard_ command on node
+func (cluster *Cluster) sendReshard(node string, c resp.Connection, args ...string) resp.Reply {
+	cmdLine := utils.ToCmdLine(append([]string{relayReshard}, args...)...)
+	if node == cluster.self {
+		return execReshard(cluster, c, cmdLine)
+	}
+	return cluster.relay(node, c, cmdLine)
+}
+
+func execReshard(cluster *Cluster, c resp.Connection, cmdLine [][]byte) resp.Reply {
+	if len(cmdLine) < 2 {
+		return reply.MakeArgNumErrReply(string(cmdLine[0]))
+	}
+	args := cmdLine[2:]
+	switch strings.ToLower(string(cmdLine[1])) {
+	case "nodes":
+		if len(args) < 2 {
+			return reply.MakeArgNumErrReply(relayReshard)
+		}
+		epoch, err := strconv.ParseInt(string(args[0]), 10, 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		nodes := make([]string, len(args)-1)
+		for i, arg := range args[1:] {
+			nodes[i] = string(arg)
+		}
+		if err = cluster.setNodes(epoch, nodes); err != nil {
+			return reply.MakeErrReply("ERR " + err.Error())
+		}
+		return reply.MakeOkReply()
+	case "start":
+		cluster.mu.RLock()
+		resharding := cluster.resharding
+		cluster.mu.RUnlock()
+		if resharding == nil {
+			return reply.MakeErrReply("ERR no resharding in progress")
+		}
+		go cluster.migrateAll()
+		return reply.MakeOkReply()
+	case "finished":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply(relayReshard)
+		}
+		cluster.finishMigration(string(args[0]))
+		return reply.MakeOkReply()
+	case "pull":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply(relayReshard)
+		}
+		if err := cluster.migrateKey(c.GetDBIndex(), string(args[0]), false); err != nil {
+			return reply.MakeErrReply("ERR " + err.Error())
+		}
+		return reply.MakeOkReply()
+	case "restore":
+		if len(args) != 3 && len(args) != 4 {
+			return reply.MakeArgNumErrReply(relayReshard)
+		}
+		return cluster.db.Exec(c, append([][]byte{[]byte("restore")}, args...))
+	}
+	return reply.MakeSyntaxErrReply()
+}
+
+// setNodes replaces the ring, keys are routed by the old ring as well until all nodes in it finish migration
+func (cluster *Cluster) setNodes(epoch int64, nodes []string) error {
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	if cluster.resharding != nil {
+		return errors.New("resharding is in progress")
+	}
+	pending := make(map[string]struct{}, len(cluster.nodes))
+	members := make([]string, 0, len(cluster.nodes)+len(nodes))
+	for _, node := range cluster.nodes {
+		pending[node] = struct{}{}
+		members = append(members, node)
+	}
+	for _, node := range nodes {
+		if _, ok := pending[node]; !ok {
+			members = append(members, node)
+		}
+	}
+	// nodes leaving the ring are still connected until migration finished
+	for _, member := range members {
+		if _, ok := cluster.peerConn[member]; !ok && member != cluster.self {
+			cluster.peerConn[member] = makePeerPool(member)
+		}
+	}
+	cluster.resharding = &resharding{
+		oldPicker: cluster.peerPicker,
+		pending:   pending,
+		members:   members,
+	}
+	cluster.ringEpoch = epoch
+	cluster.nodes = nodes
+	cluster.syncMembers()
+	cluster.peerPicker = cluster.makePicker()
+	return nil
+}
+
+// finishMigration removes node from pending ones, the old ring is dropped if all nodes finished
+func (cluster *Cluster) finishMigration(node string) {
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	cluster.dropPending(node)
+}
+
+// dropPending removes node from pending ones without lock
+func (cluster *Cluster) dropPending(node string) {
+	if cluster.resharding == nil {
+		return
+	}
+	delete(cluster.resharding.pending, node)
+	if len(cluster.resharding.pending) > 0 {
+		return
+	}
+	cluster.resharding = nil
+	inRing := make(map[string]struct{}, len(cluster.nodes))
+	for _, n := range cluster.nodes {
+		inRing[n] = struct{}{}
+	}
+	for peer, peerPool := range cluster.peerConn {
+		if _, ok := inRing[peer]; !ok {
+			peerPool.Close(context.Background())
+			delete(cluster.peerConn, peer)
+		}
+	}
+	logger.Info("resharding finished")
+}
+
+// migrateAll moves local keys not belonging to this node any more, then announces it to members
+func (cluster *Cluster) migrateAll() {
+	failed := cluster.migrateKeys(false)
+	// keep this node pending, so that other nodes still pull keys left here
+	if failed > 0 {
+		logger.Error("resharding not finished, " + strconv.Itoa(failed) + " keys failed to migrate")
+		return
+	}
+	cluster.mu.RLock()
+	var members []string
+	if cluster.resharding != nil {
+		members = cluster.resharding.members
+	}
+	cluster.mu.RUnlock()
+	c := conn.NewFakeConn()
+	for _, member := range members {
+		if res := cluster.sendReshard(member, c, "finished", cluster.self); reply.IsErrorReply(res) {
+			logger.Warn("notify " + member + " failed: " + string(res.ToBytes()))
+		}
+	}
+}
+
+// migrateKeys moves local keys owned by other nodes in the current ring, returns number of keys failed to migrate
+func (cluster *Cluster) migrateKeys(replace bool) int {
+	engine, ok := cluster.db.(idb.DBEngine)
+	if !ok {
+		return 0
+	}
+	failed := 0
+	for dbIndex := 0; dbIndex < config.Properties.Databases; dbIndex++ {
+		var keys []string
+		engine.ForEach(dbIndex, func(key string, data *idb.DataEntity, expiration *time.Time) bool {
+			if cluster.pickNode(key) != cluster.self {
+				keys = append(keys, key)
+			}
+			return true
+		})
+		for _, key := range keys {
+			if err := cluster.migrateKey(dbIndex, key, replace); err != nil {
+				logger.Warn("migrate " + key + " failed: " + err.Error())
+				failed++
+			}
+		}
+	}
+	return failed
+}
+
+// migrateKey transfers key with its ttl to the owner in the current ring by DUMP and RESTORE, then deletes it locally,
+// key on the owner is replaced if replace is set, otherwise the one on the owner is considered newer
+func (cluster *Cluster) migrateKey(dbIndex int, key string, replace bool) error {
+	lockKey := strconv.Itoa(dbIndex) + " " + key
+	cluster.migrateLocks.Lock(lockKey)
+	defer cluster.migrateLocks.UnLock(lockKey)
+
+	target := cluster.pickNode(key)
+	if target == cluster.self {
+		return nil
+	}
+	c := conn.NewFakeConn()
+	c.SelectDB(dbIndex)
+	payload, ok := cluster.db.Exec(c, utils.ToCmdLine("dump", key)).(*reply.BulkReply)
+	if !ok {
+		// already migrated or deleted
+		return nil
+	}
+	pttl, ok := cluster.db.Exec(c, utils.ToCmdLine("pttl", key)).(*reply.IntReply)
+	if !ok || pttl.Code == -2 {
+		return nil
+	}
+	ttl := pttl.Code
+	if ttl == -1 {
+		ttl = 0
+	} else if ttl == 0 {
+		// 0 means persistent for RESTORE
+		ttl = 1
+	}
+	restoreLine := [][]byte{
+		[]byte(relayReshard), []byte("restore"), []byte(key), []byte(strconv.FormatInt(ttl, 10)), payload.Arg,
+	}
+	if replace {
+		restoreLine = append(restoreLine, []byte("REPLACE"))
+	}
+	res := cluster.relay(target, c, restoreLine)
+	// BUSYKEY means key has been written on the new owner, which is newer than the local one
+	if errReply, ok := res.(reply.ErrorReply); ok && !strings.HasPrefix(errReply.Error(), "BUSYKEY") {
+		return errors.New(errReply.Error())
+	}
+	cluster.db.Exec(c, utils.ToCmdLine("del", key))
+	return nil
+}
+
+// pullKeys asks old owners to migrate keys at once during resharding, so that commands never miss keys not migrated yet
+func (cluster *Cluster) pullKeys(c resp.Connection, keys ...string) resp.Reply {
+	type pull struct {
+		owner string
+		key   string
+	}
+	var pulls []pull
+	cluster.mu.RLock()
+	if cluster.resharding != nil {
+		for _, key := range keys {
+			owner := cluster.resharding.oldPicker.PickNode(key)
+			// keys on failed nodes are unavailable anyway
+			if m := cluster.members[owner]; m != nil && m.fail {
+				continue
+			}
+			if _, pending := cluster.resharding.pending[owner]; pending && owner != cluster.peerPicker.PickNode(key) {
+				pulls = append(pulls, pull{owner: owner, key: key})
+			}
+		}
+	}
+	cluster.mu.RUnlock()
+	for _, p := range pulls {
+		if res := cluster.sendReshard(p.owner, c, "pull", p.key); reply.IsErrorReply(res) {
+			return res
+		}
+	}
+	return nil
+}
+
+func init() {
+	registerCmd("cluster", execRingCluster)
+	registerCmd(relayReshard, execReshard)
+	database.RegisterInternalCommand(relayReshard)
+}
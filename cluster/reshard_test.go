@@ -0,0 +1,172 @@
+package cluster
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"ringodis/config"
+	"ringodis/lib/utils"
+	"ringodis/resp/conn"
+	"ringodis/resp/parser"
+	"ringodis/resp/reply"
+	"ringodis/resp/reply/asserts"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testNode serves cluster in consistent hash mode on a random port, it could be killed to simulate crash
+type testNode struct {
+	*Cluster
+	connMu   sync.Mutex
+	listener net.Listener
+	conns    []net.Conn
+}
+
+func serveCluster(t *testing.T) *testNode {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serveClusterOn(t, listener)
+}
+
+// serveClusterOn serves a node on the given listener, whose address is the node id
+func serveClusterOn(t *testing.T, listener net.Listener) *testNode {
+	config.Properties.RDBFilename = filepath.Join(t.TempDir(), "dump.rdb")
+	config.Properties.Self = listener.Addr().String()
+	node := &testNode{Cluster: MakeCluster()}
+	config.Properties.Self = ""
+	t.Cleanup(func() {
+		node.kill()
+		node.Close()
+	})
+	node.serve(listener)
+	return node
+}
+
+func (node *testNode) serve(listener net.Listener) {
+	node.connMu.Lock()
+	node.listener = listener
+	node.connMu.Unlock()
+	go func() {
+		for {
+			netConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			node.connMu.Lock()
+			node.conns = append(node.conns, netConn)
+			node.connMu.Unlock()
+			go func() {
+				client := conn.NewConn(netConn)
+				defer node.AfterClientClose(client)
+				for payload := range parser.ParseStream(netConn) {
+					if payload.Err != nil {
+						if payload.Err == io.EOF || strings.Contains(payload.Err.Error(), "closed") {
+							return
+						}
+						continue
+					}
+					if cmd, ok := payload.Data.(*reply.MultiBulkReply); ok {
+						_, _ = client.Write(node.Exec(client, cmd.Args).ToBytes())
+					}
+				}
+			}()
+		}
+	}()
+}
+
+// kill stops accepting commands, the cluster keeps running
+func (node *testNode) kill() {
+	node.connMu.Lock()
+	defer node.connMu.Unlock()
+	_ = node.listener.Close()
+	for _, c := range node.conns {
+		_ = c.Close()
+	}
+	node.conns = nil
+}
+
+func (node *testNode) port() int {
+	_, port := splitHostPort(node.self)
+	return port
+}
+
+// restart serves on the same address again
+func (node *testNode) restart(t *testing.T) {
+	listener, err := net.Listen("tcp", node.self)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.serve(listener)
+}
+
+func waitResharding(t *testing.T, nodes ...*testNode) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for _, cluster := range nodes {
+		for {
+			cluster.mu.RLock()
+			done := cluster.resharding == nil
+			cluster.mu.RUnlock()
+			if done {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("timeout")
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+}
+
+func TestReshard(t *testing.T) {
+	a, b := serveCluster(t), serveCluster(t)
+	c := conn.NewFakeConn()
+	keys := make([]string, 20)
+	for i := range keys {
+		keys[i] = "k" + strconv.Itoa(i)
+		asserts.AssertStatusReply(t, a.Exec(c, utils.ToCmdLine("set", keys[i], keys[i], "ex", "1000")), "OK")
+	}
+
+	// keys are pulled by commands before background migration starts
+	nodes := []string{"nodes", "1", a.self, b.self}
+	asserts.AssertStatusReply(t, a.sendReshard(a.self, c, nodes...), "OK")
+	asserts.AssertStatusReply(t, a.sendReshard(b.self, c, nodes...), "OK")
+	var moved string
+	for _, key := range keys {
+		if a.pickNode(key) == b.self {
+			moved = key
+			break
+		}
+	}
+	asserts.AssertBulkReply(t, a.Exec(c, utils.ToCmdLine("get", moved)), moved)
+	asserts.AssertBulkReply(t, b.db.Exec(c, utils.ToCmdLine("get", moved)), moved)
+	asserts.AssertIntReply(t, a.db.Exec(c, utils.ToCmdLine("exists", moved)), 0)
+	asserts.AssertErrReply(t, a.Exec(c, utils.ToCmdLine("cluster", "addnode", b.self)), "ERR node "+b.self+" already exists")
+	asserts.AssertErrReply(t, a.Exec(c, utils.ToCmdLine("cluster", "delnode", b.self)), "ERR resharding is in progress")
+	asserts.AssertStatusReply(t, a.sendReshard(a.self, c, "start"), "OK")
+	asserts.AssertStatusReply(t, a.sendReshard(b.self, c, "start"), "OK")
+	waitResharding(t, a, b)
+	for _, key := range keys {
+		owner, other := a, b
+		if a.pickNode(key) == b.self {
+			owner, other = b, a
+		}
+		asserts.AssertBulkReply(t, owner.db.Exec(c, utils.ToCmdLine("get", key)), key)
+		asserts.AssertIntReply(t, other.db.Exec(c, utils.ToCmdLine("exists", key)), 0)
+		if ttl := b.Exec(c, utils.ToCmdLine("ttl", key)).(*reply.IntReply).Code; ttl <= 0 {
+			t.Errorf("ttl of %s is lost", key)
+		}
+	}
+
+	asserts.AssertStatusReply(t, b.Exec(c, utils.ToCmdLine("cluster", "delnode", b.self)), "OK")
+	waitResharding(t, a, b)
+	asserts.AssertMultiBulkReplySize(t, a.db.Exec(c, utils.ToCmdLine("keys", "*")), len(keys))
+	asserts.AssertMultiBulkReplySize(t, b.db.Exec(c, utils.ToCmdLine("keys", "*")), 0)
+	asserts.AssertBulkReply(t, b.Exec(c, utils.ToCmdLine("get", moved)), moved)
+	asserts.AssertErrReply(t, a.Exec(c, utils.ToCmdLine("cluster", "delnode", a.self)), "ERR can't remove the last node")
+}
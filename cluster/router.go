@@ -2,6 +2,7 @@ package cluster
 
 import (
 	"ringodis/interface/resp"
+	"ringodis/resp/reply"
 	"strings"
 )
 
@@ -18,7 +19,28 @@ func registerDefaultCmd(name string) {
 
 func defaultFunc(cluster *Cluster, c resp.Connection, cmdLine [][]byte) resp.Reply {
 	key := string(cmdLine[1])
-	node := cluster.peerPicker.PickNode(key)
+	if errReply := cluster.pullKeys(c, key); errReply != nil {
+		return errReply
+	}
+	node := cluster.pickNode(key)
+	if isReadOnly(cmdLine) {
+		node = cluster.pickReader(node)
+	}
+	return cluster.relay(node, c, cmdLine)
+}
+
+// sameNodeFunc relays commands moving data between the first two keys, they must be located on the same node
+func sameNodeFunc(cluster *Cluster, c resp.Connection, cmdLine [][]byte) resp.Reply {
+	if len(cmdLine) < 3 {
+		return reply.MakeArgNumErrReply(string(cmdLine[0]))
+	}
+	node := cluster.pickNode(string(cmdLine[1]))
+	if cluster.pickNode(string(cmdLine[2])) != node {
+		return reply.MakeErrReply("ERR source and destination keys must be located on the same node")
+	}
+	if errReply := cluster.pullKeys(c, string(cmdLine[1]), string(cmdLine[2])); errReply != nil {
+		return errReply
+	}
 	return cluster.relay(node, c, cmdLine)
 }
 
@@ -26,14 +48,89 @@ func init() {
 	defaultCmds := []string{
 		"expire",
 		"ttl",
-		"exists",
+		"pttl",
+		"dump",
+		"restore",
 		"type",
 		"set",
 		"setNx",
 		"setEx",
 		"get",
+		"hset",
+		"hmset",
+		"hsetnx",
+		"hget",
+		"hmget",
+		"hexists",
+		"hdel",
+		"hlen",
+		"hstrlen",
+		"hkeys",
+		"hvals",
+		"hgetall",
+		"hincrby",
+		"hincrbyfloat",
+		"hrandfield",
+		"hscan",
+		"lpush",
+		"lpushx",
+		"rpush",
+		"rpushx",
+		"lpop",
+		"rpop",
+		"llen",
+		"lindex",
+		"lset",
+		"lrange",
+		"lrem",
+		"linsert",
+		"ltrim",
+		"lpos",
+		"sadd",
+		"srem",
+		"sismember",
+		"smismember",
+		"scard",
+		"smembers",
+		"spop",
+		"srandmember",
+		"sscan",
+		"zadd",
+		"zincrby",
+		"zrem",
+		"zscore",
+		"zcard",
+		"zcount",
+		"zlexcount",
+		"zrank",
+		"zrevrank",
+		"zrange",
+		"zrevrange",
+		"zrangebyscore",
+		"zrevrangebyscore",
+		"zrangebylex",
+		"zrevrangebylex",
+		"zremrangebyscore",
+		"zremrangebylex",
+		"zremrangebyrank",
+		"zpopmin",
+		"zpopmax",
+		"xadd",
+		"xlen",
+		"xrange",
+		"xrevrange",
+		"xdel",
+		"xtrim",
+		"xsetid",
+		"xack",
+		"xpending",
+		"xclaim",
+		"xautoclaim",
 	}
 	for _, name := range defaultCmds {
 		registerDefaultCmd(name)
 	}
+	registerCmd("lmove", sameNodeFunc)
+	registerCmd("rpoplpush", sameNodeFunc)
+	registerCmd("smove", sameNodeFunc)
 }
@@ -0,0 +1,255 @@
+package cluster
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"ringodis/database"
+	"ringodis/interface/resp"
+	"ringodis/lib/consistenthash"
+	"ringodis/resp/conn"
+	"ringodis/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SlotCount is the number of hash slots in slot mode
+const SlotCount = 16384
+
+var crc16Table [256]uint16
+
+func init() {
+	// CRC16-CCITT (XModem), polynomial 0x1021
+	for i := 0; i < 256; i++ {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		crc16Table[i] = crc
+	}
+}
+
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^b]
+	}
+	return crc
+}
+
+// KeySlot returns hash slot of key, only the hash tag in `{}` is hashed if present
+func KeySlot(key string) int {
+	return int(crc16([]byte(consistenthash.GetPartitionKey(key))) % SlotCount)
+}
+
+// makeNodeID derives a 40 characters node id from address, so that all nodes agree on it without negotiation
+func makeNodeID(addr string) string {
+	sum := sha1.Sum([]byte(addr))
+	return hex.EncodeToString(sum[:])
+}
+
+// slotTable records owner of every slot and slots being migrated
+type slotTable struct {
+	mu     sync.RWMutex
+	owners [SlotCount]string
+	// migrating slots of this node and their target nodes
+	migrating map[int]string
+	// importing slots of this node and their source nodes
+	importing map[int]string
+	// configEpoch increases when any slot changes its owner
+	configEpoch int64
+}
+
+// makeSlotTable assigns slots evenly to nodes in the order of address, so that every node computes the same table
+func makeSlotTable(nodes []string) *slotTable {
+	table := &slotTable{
+		migrating: make(map[int]string),
+		importing: make(map[int]string),
+	}
+	sorted := make([]string, len(nodes))
+	copy(sorted, nodes)
+	sort.Strings(sorted)
+	for i, node := range sorted {
+		begin := i * SlotCount / len(sorted)
+		end := (i + 1) * SlotCount / len(sorted)
+		for slot := begin; slot < end; slot++ {
+			table.owners[slot] = node
+		}
+	}
+	return table
+}
+
+func (table *slotTable) getOwner(slot int) string {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	return table.owners[slot]
+}
+
+// slotRange is a range of continuous slots owned by the same node
+type slotRange struct {
+	begin int
+	end   int // inclusive
+	node  string
+}
+
+// ranges returns continuous slot ranges in order
+func (table *slotTable) ranges() []*slotRange {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	var result []*slotRange
+	for slot := 0; slot < SlotCount; slot++ {
+		node := table.owners[slot]
+		if node == "" {
+			continue
+		}
+		if n := len(result); n > 0 && result[n-1].node == node && result[n-1].end == slot-1 {
+			result[n-1].end = slot
+			continue
+		}
+		result = append(result, &slotRange{begin: slot, end: slot, node: node})
+	}
+	return result
+}
+
+// commandKeys returns keys for routing, channels are treated as keys for sharded pub/sub
+func commandKeys(cmdName string, cmdLine [][]byte) []string {
+	switch cmdName {
+	case "spublish":
+		if len(cmdLine) > 1 {
+			return []string{string(cmdLine[1])}
+		}
+		return nil
+	case "ssubscribe", "sunsubscribe":
+		keys := make([]string, 0, len(cmdLine)-1)
+		for _, channel := range cmdLine[1:] {
+			keys = append(keys, string(channel))
+		}
+		return keys
+	}
+	writeKeys, readKeys, ok := database.GetRelatedKeys(cmdLine)
+	if !ok {
+		return nil
+	}
+	return append(writeKeys, readKeys...)
+}
+
+// execSlotMode executes command on this node if it owns the slot of keys, otherwise redirects client by MOVED or ASK
+func (cluster *Cluster) execSlotMode(c resp.Connection, cmdName string, cmdLine [][]byte) resp.Reply {
+	switch cmdName {
+	case "cluster":
+		return execCluster(cluster, c, cmdLine)
+	case "asking":
+		if len(cmdLine) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		cluster.asking.Store(c, struct{}{})
+		return reply.MakeOkReply()
+	case "publish", relayPublish:
+		return router[cmdName](cluster, c, cmdLine)
+	}
+	_, asking := cluster.asking.LoadAndDelete(c)
+
+	keys := commandKeys(cmdName, cmdLine)
+	if len(keys) == 0 {
+		return cluster.db.Exec(c, cmdLine)
+	}
+	if errReply := cluster.checkSlot(c, keys, asking); errReply != nil {
+		// redirected command is not queued, so EXEC must abort the transaction
+		if c.InMultiState() {
+			c.AddTxError(errReply)
+		}
+		return errReply
+	}
+	return cluster.db.Exec(c, cmdLine)
+}
+
+// checkSlot returns nil if keys can be served by this node, otherwise returns CROSSSLOT, MOVED, ASK, TRYAGAIN or CLUSTERDOWN error
+func (cluster *Cluster) checkSlot(c resp.Connection, keys []string, asking bool) reply.ErrorReply {
+	slot := KeySlot(keys[0])
+	for _, key := range keys[1:] {
+		if KeySlot(key) != slot {
+			return reply.MakeErrReply("CROSSSLOT Keys in request don't hash to the same slot")
+		}
+	}
+
+	table := cluster.slots
+	table.mu.RLock()
+	owner := table.owners[slot]
+	target, migrating := table.migrating[slot]
+	_, importing := table.importing[slot]
+	table.mu.RUnlock()
+
+	if owner != cluster.self {
+		if importing && asking {
+			return nil
+		}
+		if owner == "" {
+			return reply.MakeErrReply("CLUSTERDOWN Hash slot not served")
+		}
+		return reply.MakeErrReply("MOVED " + strconv.Itoa(slot) + " " + owner)
+	}
+	if migrating {
+		// keys not existed may have been migrated to the target node
+		existed := cluster.countExisted(c, keys)
+		if existed == 0 {
+			return reply.MakeErrReply("ASK " + strconv.Itoa(slot) + " " + target)
+		}
+		if existed < len(keys) {
+			return reply.MakeErrReply("TRYAGAIN Multiple keys request during rehashing of slot")
+		}
+	}
+	return nil
+}
+
+// countExisted counts keys existed in the database selected by client
+func (cluster *Cluster) countExisted(c resp.Connection, keys []string) int {
+	fake := conn.NewFakeConn()
+	fake.SelectDB(c.GetDBIndex())
+	args := make([][]byte, 0, len(keys)+1)
+	args = append(args, []byte("exists"))
+	for _, key := range keys {
+		args = append(args, []byte(key))
+	}
+	// duplicated keys are counted repeatedly by EXISTS, as they are in keys
+	if intReply, ok := cluster.db.Exec(fake, args).(*reply.IntReply); ok {
+		return int(intReply.Code)
+	}
+	return 0
+}
+
+// setSlot changes state of slot by CLUSTER SETSLOT
+func (table *slotTable) setSlot(slot int, action string, node string) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	switch action {
+	case "migrating":
+		table.migrating[slot] = node
+	case "importing":
+		table.importing[slot] = node
+	case "stable":
+		delete(table.migrating, slot)
+		delete(table.importing, slot)
+	case "node":
+		delete(table.migrating, slot)
+		delete(table.importing, slot)
+		if table.owners[slot] != node {
+			table.owners[slot] = node
+			table.configEpoch++
+		}
+	}
+}
+
+// nodeByID finds address of node by its id
+func (cluster *Cluster) nodeByID(id string) (string, bool) {
+	for _, node := range cluster.nodes {
+		if makeNodeID(node) == id || strings.EqualFold(node, id) {
+			return node, true
+		}
+	}
+	return "", false
+}
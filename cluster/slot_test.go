@@ -0,0 +1,128 @@
+package cluster
+
+import (
+	"path/filepath"
+	"ringodis/config"
+	"ringodis/lib/utils"
+	"ringodis/resp/conn"
+	"ringodis/resp/reply"
+	"ringodis/resp/reply/asserts"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestKeySlot(t *testing.T) {
+	if crc16([]byte("123456789")) != 0x31C3 {
+		t.Error("wrong crc16")
+	}
+	if slot := KeySlot("foo"); slot != 12182 {
+		t.Errorf("expected slot 12182, actual %d", slot)
+	}
+	if KeySlot("{user1000}.following") != KeySlot("{user1000}.followers") {
+		t.Error("keys with the same hash tag should be in the same slot")
+	}
+}
+
+func makeTestSlotCluster(t *testing.T) *Cluster {
+	config.Properties.RDBFilename = filepath.Join(t.TempDir(), "dump.rdb")
+	config.Properties.Self = "127.0.0.1:7000"
+	config.Properties.Peers = []string{"127.0.0.1:7001"}
+	config.Properties.ClusterEnabled = true
+	defer func() {
+		config.Properties.Self = ""
+		config.Properties.Peers = nil
+		config.Properties.ClusterEnabled = false
+	}()
+	cluster := MakeCluster()
+	t.Cleanup(cluster.Close)
+	return cluster
+}
+
+// keyOwnedBy finds a key whose slot belongs to node
+func keyOwnedBy(cluster *Cluster, node string) string {
+	for i := 0; ; i++ {
+		key := "k" + strconv.Itoa(i)
+		if cluster.slots.getOwner(KeySlot(key)) == node {
+			return key
+		}
+	}
+}
+
+func TestSlotRedirect(t *testing.T) {
+	cluster := makeTestSlotCluster(t)
+	self, peer := "127.0.0.1:7000", "127.0.0.1:7001"
+	c := conn.NewFakeConn()
+	local := keyOwnedBy(cluster, self)
+	remote := keyOwnedBy(cluster, peer)
+
+	asserts.AssertStatusReply(t, cluster.Exec(c, utils.ToCmdLine("set", local, "v")), "OK")
+	asserts.AssertBulkReply(t, cluster.Exec(c, utils.ToCmdLine("get", local)), "v")
+	remoteSlot := strconv.Itoa(KeySlot(remote))
+	asserts.AssertErrReply(t, cluster.Exec(c, utils.ToCmdLine("get", remote)), "MOVED "+remoteSlot+" "+peer)
+	asserts.AssertErrReply(t, cluster.Exec(c, utils.ToCmdLine("sinter", local, remote)),
+		"CROSSSLOT Keys in request don't hash to the same slot")
+	asserts.AssertStatusReply(t, cluster.Exec(c, utils.ToCmdLine("set", "{"+local+"}a", "1")), "OK")
+	asserts.AssertStatusReply(t, cluster.Exec(c, utils.ToCmdLine("rename", "{"+local+"}a", "{"+local+"}b")), "OK")
+
+	// migrating: existing keys are served locally, missing keys are redirected by ASK
+	localSlot := strconv.Itoa(KeySlot(local))
+	asserts.AssertStatusReply(t, cluster.Exec(c, utils.ToCmdLine("cluster", "setslot", localSlot, "migrating", makeNodeID(peer))), "OK")
+	asserts.AssertBulkReply(t, cluster.Exec(c, utils.ToCmdLine("get", local)), "v")
+	asserts.AssertIntReply(t, cluster.Exec(c, utils.ToCmdLine("del", local)), 1)
+	asserts.AssertErrReply(t, cluster.Exec(c, utils.ToCmdLine("get", local)), "ASK "+localSlot+" "+peer)
+	asserts.AssertStatusReply(t, cluster.Exec(c, utils.ToCmdLine("cluster", "setslot", localSlot, "node", makeNodeID(peer))), "OK")
+	asserts.AssertErrReply(t, cluster.Exec(c, utils.ToCmdLine("get", local)), "MOVED "+localSlot+" "+peer)
+
+	// importing: only served after ASKING, and only for the next command
+	asserts.AssertStatusReply(t, cluster.Exec(c, utils.ToCmdLine("cluster", "setslot", remoteSlot, "importing", makeNodeID(peer))), "OK")
+	asserts.AssertErrReply(t, cluster.Exec(c, utils.ToCmdLine("set", remote, "v")), "MOVED "+remoteSlot+" "+peer)
+	asserts.AssertStatusReply(t, cluster.Exec(c, utils.ToCmdLine("asking")), "OK")
+	asserts.AssertStatusReply(t, cluster.Exec(c, utils.ToCmdLine("set", remote, "v")), "OK")
+	asserts.AssertErrReply(t, cluster.Exec(c, utils.ToCmdLine("get", remote)), "MOVED "+remoteSlot+" "+peer)
+	asserts.AssertIntReply(t, cluster.Exec(c, utils.ToCmdLine("cluster", "countkeysinslot", remoteSlot)), 1)
+	asserts.AssertMultiBulkReply(t, cluster.Exec(c, utils.ToCmdLine("cluster", "getkeysinslot", remoteSlot, "10")), []string{remote})
+	asserts.AssertStatusReply(t, cluster.Exec(c, utils.ToCmdLine("cluster", "setslot", remoteSlot, "node", makeNodeID(self))), "OK")
+	asserts.AssertBulkReply(t, cluster.Exec(c, utils.ToCmdLine("get", remote)), "v")
+}
+
+func TestSlotRedirectInMulti(t *testing.T) {
+	cluster := makeTestSlotCluster(t)
+	c := conn.NewFakeConn()
+	local := keyOwnedBy(cluster, "127.0.0.1:7000")
+	remote := keyOwnedBy(cluster, "127.0.0.1:7001")
+
+	asserts.AssertStatusReply(t, cluster.Exec(c, utils.ToCmdLine("multi")), "OK")
+	asserts.AssertStatusReply(t, cluster.Exec(c, utils.ToCmdLine("set", local, "v")), "QUEUED")
+	asserts.AssertErrReply(t, cluster.Exec(c, utils.ToCmdLine("get", remote)),
+		"MOVED "+strconv.Itoa(KeySlot(remote))+" 127.0.0.1:7001")
+	asserts.AssertErrReply(t, cluster.Exec(c, utils.ToCmdLine("exec")),
+		"EXECABORT Transaction discarded because of previous errors.")
+	asserts.AssertNullBulk(t, cluster.Exec(c, utils.ToCmdLine("get", local)))
+}
+
+func TestClusterCommand(t *testing.T) {
+	cluster := makeTestSlotCluster(t)
+	c := conn.NewFakeConn()
+	asserts.AssertIntReply(t, cluster.Exec(c, utils.ToCmdLine("cluster", "keyslot", "foo")), 12182)
+	asserts.AssertBulkReply(t, cluster.Exec(c, utils.ToCmdLine("cluster", "myid")), makeNodeID("127.0.0.1:7000"))
+	slots := cluster.Exec(c, utils.ToCmdLine("cluster", "slots")).(*reply.MultiRawReply)
+	if len(slots.Replies) != 2 {
+		t.Errorf("expected 2 slot ranges, actual %d", len(slots.Replies))
+	}
+	expected := "*3\r\n:0\r\n:8191\r\n*3\r\n$9\r\n127.0.0.1\r\n:7000\r\n$40\r\n" + makeNodeID("127.0.0.1:7000") + "\r\n"
+	if actual := string(slots.Replies[0].ToBytes()); actual != expected {
+		t.Errorf("expected %q, actual %q", expected, actual)
+	}
+	nodes := string(cluster.Exec(c, utils.ToCmdLine("cluster", "nodes")).ToBytes())
+	line := makeNodeID("127.0.0.1:7000") + " 127.0.0.1:7000@17000 myself,master - 0 0 0 connected 0-8191\n"
+	if !strings.Contains(nodes, line) {
+		t.Errorf("expected %q in %q", line, nodes)
+	}
+	shards := cluster.Exec(c, utils.ToCmdLine("cluster", "shards")).(*reply.MultiRawReply)
+	if len(shards.Replies) != 2 {
+		t.Errorf("expected 2 shards, actual %d", len(shards.Replies))
+	}
+	asserts.AssertErrReply(t, cluster.Exec(c, utils.ToCmdLine("cluster", "setslot", "0", "importing", makeNodeID("127.0.0.1:7001"))),
+		"ERR I'm already the owner of hash slot 0")
+}
@@ -0,0 +1,312 @@
+package cluster
+
+import (
+	"ringodis/database"
+	idb "ringodis/interface/database"
+	"ringodis/interface/resp"
+	"ringodis/lib/logger"
+	"ringodis/lib/timewheel"
+	"ringodis/lib/utils"
+	"ringodis/resp/conn"
+	"ringodis/resp/parser"
+	"ringodis/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// relayTx is the internal command of two-phase commit, sent by coordinator to participants:
+//
+//	tx_ prepare id argc cmd arg... [argc cmd arg ...]
+//	tx_ commit id
+//	tx_ rollback id
+//
+// prepare locks keys of the commands and executes them, results are replied as bulk strings encoded in RESP.
+// keys stay locked until commit or rollback, prepared transaction is rolled back automatically after txTimeout.
+// The gate of db is held only while executing, so that other keys and snapshots are not blocked by prepared transactions
+const relayTx = "tx_"
+
+// txTimeout must be longer than max wait of peer client, so that coordinator always gives up first
+const txTimeout = 10 * time.Second
+
+const (
+	txPrepared = iota
+	txCommitted
+	txRolledBack
+)
+
+// transaction is prepared on participant, finished ones are kept for txTimeout to reject late requests
+type transaction struct {
+	id string
+	// mu serializes prepare, commit and rollback of the same transaction
+	mu        sync.Mutex
+	state     int
+	dbIndex   int
+	writeKeys []string
+	readKeys  []string
+	undoLogs  [][]idb.CmdLine
+}
+
+func genTxTask(id string) string {
+	return "tx:" + id
+}
+
+func (cluster *Cluster) getEngine() (idb.DBEngine, reply.ErrorReply) {
+	engine, ok := cluster.db.(idb.DBEngine)
+	if !ok {
+		return nil, reply.MakeErrReply("ERR transaction is not supported by db")
+	}
+	return engine, nil
+}
+
+// prepareTx locks keys and executes cmdLines, they are undone if any command fails
+func (cluster *Cluster) prepareTx(c resp.Connection, id string, cmdLines []idb.CmdLine) resp.Reply {
+	engine, errReply := cluster.getEngine()
+	if errReply != nil {
+		return errReply
+	}
+	tx := &transaction{id: id, dbIndex: c.GetDBIndex()}
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if _, loaded := cluster.transactions.LoadOrStore(id, tx); loaded {
+		return reply.MakeErrReply("ERR transaction " + id + " already exists")
+	}
+	for _, cmdLine := range cmdLines {
+		write, read, ok := database.GetRelatedKeys(cmdLine)
+		if !ok {
+			cluster.finishTx(tx, txRolledBack)
+			return reply.MakeErrReply("ERR unknown command or wrong number of arguments for '" + string(cmdLine[0]) + "'")
+		}
+		tx.writeKeys = append(tx.writeKeys, write...)
+		tx.readKeys = append(tx.readKeys, read...)
+	}
+	engine.RWLocks(tx.dbIndex, tx.writeKeys, tx.readKeys)
+	timewheel.Delay(txTimeout, genTxTask(id), func() {
+		cluster.rollbackTx(id)
+	})
+	results := make([][]byte, 0, len(cmdLines))
+	for _, cmdLine := range cmdLines {
+		tx.undoLogs = append(tx.undoLogs, engine.GetUndoLogs(tx.dbIndex, cmdLine))
+		res := engine.ExecWithLock(c, cmdLine)
+		if reply.IsErrorReply(res) {
+			cluster.undoTx(engine, tx)
+			engine.RWUnLocks(tx.dbIndex, tx.writeKeys, tx.readKeys)
+			cluster.finishTx(tx, txRolledBack)
+			return res
+		}
+		results = append(results, res.ToBytes())
+	}
+	return reply.MakeMultiBulkReply(results)
+}
+
+// undoTx executes undo logs in reverse order, undo commands are persisted like others
+func (cluster *Cluster) undoTx(engine idb.DBEngine, tx *transaction) {
+	c := conn.NewFakeConn()
+	c.SelectDB(tx.dbIndex)
+	for i := len(tx.undoLogs) - 1; i >= 0; i-- {
+		for _, cmdLine := range tx.undoLogs[i] {
+			engine.ExecWithLock(c, cmdLine)
+		}
+	}
+}
+
+// finishTx records state of tx and forgets it after txTimeout, caller must hold tx.mu
+func (cluster *Cluster) finishTx(tx *transaction, state int) {
+	tx.state = state
+	tx.undoLogs = nil
+	taskKey := genTxTask(tx.id)
+	timewheel.Cancel(taskKey)
+	timewheel.Delay(txTimeout, taskKey, func() {
+		cluster.transactions.Delete(tx.id)
+	})
+}
+
+func (cluster *Cluster) commitTx(id string) resp.Reply {
+	raw, ok := cluster.transactions.Load(id)
+	if !ok {
+		return reply.MakeErrReply("ERR transaction " + id + " not found")
+	}
+	tx := raw.(*transaction)
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	switch tx.state {
+	case txCommitted:
+		return reply.MakeOkReply()
+	case txRolledBack:
+		return reply.MakeErrReply("ERR transaction " + id + " has been rolled back")
+	}
+	engine, errReply := cluster.getEngine()
+	if errReply != nil {
+		return errReply
+	}
+	engine.RWUnLocks(tx.dbIndex, tx.writeKeys, tx.readKeys)
+	cluster.finishTx(tx, txCommitted)
+	return reply.MakeOkReply()
+}
+
+// rollbackTx undoes a prepared transaction, unknown transaction is recorded so that late prepare is rejected
+func (cluster *Cluster) rollbackTx(id string) resp.Reply {
+	tombstone := &transaction{id: id}
+	tombstone.mu.Lock()
+	raw, loaded := cluster.transactions.LoadOrStore(id, tombstone)
+	if !loaded {
+		cluster.finishTx(tombstone, txRolledBack)
+		tombstone.mu.Unlock()
+		return reply.MakeOkReply()
+	}
+	tombstone.mu.Unlock()
+	tx := raw.(*transaction)
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	switch tx.state {
+	case txRolledBack:
+		return reply.MakeOkReply()
+	case txCommitted:
+		return reply.MakeErrReply("ERR transaction " + id + " has been committed")
+	}
+	engine, errReply := cluster.getEngine()
+	if errReply != nil {
+		return errReply
+	}
+	cluster.undoTx(engine, tx)
+	engine.RWUnLocks(tx.dbIndex, tx.writeKeys, tx.readKeys)
+	cluster.finishTx(tx, txRolledBack)
+	return reply.MakeOkReply()
+}
+
+func execTx(cluster *Cluster, c resp.Connection, cmdLine [][]byte) resp.Reply {
+	if len(cmdLine) < 3 {
+		return reply.MakeArgNumErrReply(string(cmdLine[0]))
+	}
+	id := string(cmdLine[2])
+	switch strings.ToLower(string(cmdLine[1])) {
+	case "prepare":
+		cmdLines, ok := parseTxCmdLines(cmdLine[3:])
+		if !ok {
+			return reply.MakeSyntaxErrReply()
+		}
+		return cluster.prepareTx(c, id, cmdLines)
+	case "commit":
+		return cluster.commitTx(id)
+	case "rollback":
+		return cluster.rollbackTx(id)
+	}
+	return reply.MakeSyntaxErrReply()
+}
+
+// parseTxCmdLines splits args like `argc cmd arg... [argc cmd arg ...]` into command lines
+func parseTxCmdLines(args [][]byte) ([]idb.CmdLine, bool) {
+	var cmdLines []idb.CmdLine
+	for len(args) > 0 {
+		argc, err := strconv.Atoi(string(args[0]))
+		if err != nil || argc <= 0 || argc > len(args)-1 {
+			return nil, false
+		}
+		cmdLines = append(cmdLines, args[1:1+argc])
+		args = args[1+argc:]
+	}
+	return cmdLines, len(cmdLines) > 0
+}
+
+// sendTx sends relayTx command to node, commands to self are executed directly as relay bypasses router
+func (cluster *Cluster) sendTx(node string, c resp.Connection, cmdLine [][]byte) resp.Reply {
+	if node == cluster.self {
+		return execTx(cluster, c, cmdLine)
+	}
+	return cluster.relay(node, c, cmdLine)
+}
+
+// coordinator drives a two-phase commit across nodes
+type coordinator struct {
+	cluster *Cluster
+	conn    resp.Connection
+	id      string
+	// prepared records nodes which have been sent prepare, they need to be committed or rolled back
+	prepared []string
+}
+
+func (cluster *Cluster) newCoordinator(c resp.Connection) *coordinator {
+	return &coordinator{
+		cluster: cluster,
+		conn:    c,
+		id:      cluster.self + "-" + utils.RandHexString(16),
+	}
+}
+
+// prepare executes cmdLines on node and returns their results encoded in RESP
+func (co *coordinator) prepare(node string, cmdLines []idb.CmdLine) ([][]byte, reply.ErrorReply) {
+	args := [][]byte{[]byte(relayTx), []byte("prepare"), []byte(co.id)}
+	for _, cmdLine := range cmdLines {
+		args = append(args, []byte(strconv.Itoa(len(cmdLine))))
+		args = append(args, cmdLine...)
+	}
+	// node may have locked keys even if its reply is lost
+	co.prepared = append(co.prepared, node)
+	res := co.cluster.sendTx(node, co.conn, args)
+	if errReply, ok := res.(reply.ErrorReply); ok {
+		return nil, errReply
+	}
+	results, ok := res.(*reply.MultiBulkReply)
+	if !ok || len(results.Args) != len(cmdLines) {
+		return nil, reply.MakeErrReply("ERR unexpected reply of prepare from " + node)
+	}
+	return results.Args, nil
+}
+
+func (co *coordinator) commit() {
+	for _, node := range co.prepared {
+		cmdLine := utils.ToCmdLine(relayTx, "commit", co.id)
+		if res := co.cluster.sendTx(node, co.conn, cmdLine); reply.IsErrorReply(res) {
+			// keys are unlocked by timeout anyway, but the node rolls back
+			logger.Warn("commit " + co.id + " on " + node + " failed: " + string(res.ToBytes()))
+		}
+	}
+}
+
+func (co *coordinator) rollback() {
+	for i := len(co.prepared) - 1; i >= 0; i-- {
+		node := co.prepared[i]
+		cmdLine := utils.ToCmdLine(relayTx, "rollback", co.id)
+		if res := co.cluster.sendTx(node, co.conn, cmdLine); reply.IsErrorReply(res) {
+			logger.Warn("rollback " + co.id + " on " + node + " failed: " + string(res.ToBytes()))
+		}
+	}
+}
+
+// execAtomic executes commands grouped by node atomically, results are encoded in RESP in the same order of groups.
+// nodes are prepared in sorted order, so concurrent transactions lock nodes in the same order
+func (cluster *Cluster) execAtomic(c resp.Connection, groups map[string][]idb.CmdLine) (map[string][][]byte, reply.ErrorReply) {
+	nodes := make([]string, 0, len(groups))
+	for node := range groups {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	co := cluster.newCoordinator(c)
+	results := make(map[string][][]byte, len(groups))
+	for _, node := range nodes {
+		res, errReply := co.prepare(node, groups[node])
+		if errReply != nil {
+			co.rollback()
+			return nil, errReply
+		}
+		results[node] = res
+	}
+	co.commit()
+	return results, nil
+}
+
+// parseResult decodes a result returned by prepare
+func parseResult(raw []byte) resp.Reply {
+	res, err := parser.ParseOne(raw)
+	if err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	return res
+}
+
+func init() {
+	registerCmd(relayTx, execTx)
+	database.RegisterInternalCommand(relayTx)
+}
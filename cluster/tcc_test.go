@@ -0,0 +1,170 @@
+package cluster
+
+import (
+	"ringodis/lib/utils"
+	"ringodis/resp/conn"
+	"ringodis/resp/reply"
+	"ringodis/resp/reply/asserts"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serveRing serves two nodes in the same ring, returns keys owned by each of them
+func serveRing(t *testing.T) (a, b *testNode, keyA, keyB func(i int) string) {
+	a, b = serveCluster(t), serveCluster(t)
+	host, port := splitHostPort(b.self)
+	asserts.AssertStatusReply(t, a.Exec(conn.NewFakeConn(), utils.ToCmdLine("cluster", "meet", host, strconv.Itoa(port))), "OK")
+	waitResharding(t, a, b)
+	pick := func(owner string) func(int) string {
+		return func(i int) string {
+			for j := 0; ; j++ {
+				key := "k" + strconv.Itoa(j)
+				if a.pickNode(key) != owner {
+					continue
+				}
+				if i == 0 {
+					return key
+				}
+				i--
+			}
+		}
+	}
+	return a, b, pick(a.self), pick(b.self)
+}
+
+func TestMultiKeyCommands(t *testing.T) {
+	a, b, keyA, keyB := serveRing(t)
+	c := conn.NewFakeConn()
+	asserts.AssertStatusReply(t, a.Exec(c, utils.ToCmdLine("mset", keyA(0), "1", keyB(0), "2")), "OK")
+	asserts.AssertBulkReply(t, a.db.Exec(c, utils.ToCmdLine("get", keyA(0))), "1")
+	asserts.AssertBulkReply(t, b.db.Exec(c, utils.ToCmdLine("get", keyB(0))), "2")
+	res, ok := b.Exec(c, utils.ToCmdLine("mget", keyB(0), keyA(1), keyA(0))).(*reply.MultiBulkReply)
+	if !ok || len(res.Args) != 3 || string(res.Args[0]) != "2" || res.Args[1] != nil || string(res.Args[2]) != "1" {
+		t.Errorf("unexpected mget reply %v", res)
+	}
+	asserts.AssertIntReply(t, b.Exec(c, utils.ToCmdLine("exists", keyA(0), keyB(0), keyB(1))), 2)
+
+	// value and ttl are moved to the owner of new key
+	asserts.AssertIntReply(t, a.Exec(c, utils.ToCmdLine("expire", keyA(0), "1000")), 1)
+	asserts.AssertStatusReply(t, a.Exec(c, utils.ToCmdLine("rename", keyA(0), keyB(1))), "OK")
+	asserts.AssertIntReply(t, a.db.Exec(c, utils.ToCmdLine("exists", keyA(0))), 0)
+	asserts.AssertBulkReply(t, b.db.Exec(c, utils.ToCmdLine("get", keyB(1))), "1")
+	asserts.AssertIntReplyGreaterThan(t, b.db.Exec(c, utils.ToCmdLine("ttl", keyB(1))), 0)
+	asserts.AssertErrReply(t, a.Exec(c, utils.ToCmdLine("rename", keyA(0), keyB(2))), "ERR no such key")
+
+	asserts.AssertIntReply(t, a.Exec(c, utils.ToCmdLine("del", keyA(0), keyB(0), keyB(1))), 2)
+	asserts.AssertMultiBulkReplySize(t, a.db.Exec(c, utils.ToCmdLine("keys", "*")), 0)
+	asserts.AssertMultiBulkReplySize(t, b.db.Exec(c, utils.ToCmdLine("keys", "*")), 0)
+}
+
+func TestRenameOppositeDirections(t *testing.T) {
+	a, b, keyA, keyB := serveRing(t)
+	c := conn.NewFakeConn()
+	asserts.AssertStatusReply(t, a.Exec(c, utils.ToCmdLine("mset", keyA(0), "1", keyB(0), "2")), "OK")
+
+	// renames lock both keys, they must not wait for each other until peer client times out
+	start := time.Now()
+	var wg sync.WaitGroup
+	rename := func(node *testNode, src, dest string) {
+		defer wg.Done()
+		c := conn.NewFakeConn()
+		for i := 0; i < 20; i++ {
+			res := node.Exec(c, utils.ToCmdLine("rename", src, dest))
+			if errReply, ok := res.(reply.ErrorReply); ok && errReply.Error() != "ERR no such key" {
+				t.Error(errReply.Error())
+			}
+		}
+	}
+	wg.Add(2)
+	go rename(a, keyA(0), keyB(0))
+	go rename(b, keyB(0), keyA(0))
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("renames in opposite directions took %s", elapsed)
+	}
+	existed := a.Exec(c, utils.ToCmdLine("exists", keyA(0), keyB(0)))
+	asserts.AssertIntReply(t, existed, 1)
+}
+
+func TestClusterMulti(t *testing.T) {
+	a, b, keyA, keyB := serveRing(t)
+	c := conn.NewFakeConn()
+	asserts.AssertStatusReply(t, a.Exec(c, utils.ToCmdLine("set", keyA(0), "old")), "OK")
+	asserts.AssertIntReply(t, a.Exec(c, utils.ToCmdLine("rpush", keyB(0), "a")), 1)
+
+	// error on b rolls back commands executed on a
+	asserts.AssertStatusReply(t, a.Exec(c, utils.ToCmdLine("multi")), "OK")
+	asserts.AssertStatusReply(t, a.Exec(c, utils.ToCmdLine("set", keyA(0), "new")), "QUEUED")
+	asserts.AssertStatusReply(t, a.Exec(c, utils.ToCmdLine("hset", keyB(0), "f", "v")), "QUEUED")
+	asserts.AssertErrReply(t, a.Exec(c, utils.ToCmdLine("exec")), "EXECABORT Transaction rolled back because of error: "+
+		"WRONG-TYPE Operation against a key holding the wrong kind of value")
+	asserts.AssertBulkReply(t, a.db.Exec(c, utils.ToCmdLine("get", keyA(0))), "old")
+
+	// versions of keys can't be checked across nodes
+	asserts.AssertErrReply(t, a.Exec(c, utils.ToCmdLine("watch", keyA(0))),
+		"ERR WATCH is not supported in cluster, transaction is rolled back if any command fails instead")
+	asserts.AssertStatusReply(t, a.Exec(c, utils.ToCmdLine("multi")), "OK")
+	asserts.AssertErrReply(t, a.Exec(c, utils.ToCmdLine("unwatch")),
+		"ERR UNWATCH is not supported in cluster, transaction is rolled back if any command fails instead")
+	asserts.AssertStatusReply(t, a.Exec(c, utils.ToCmdLine("discard")), "OK")
+
+	asserts.AssertStatusReply(t, a.Exec(c, utils.ToCmdLine("multi")), "OK")
+	asserts.AssertStatusReply(t, a.Exec(c, utils.ToCmdLine("set", keyA(0), "new")), "QUEUED")
+	asserts.AssertStatusReply(t, a.Exec(c, utils.ToCmdLine("rpush", keyB(0), "b")), "QUEUED")
+	asserts.AssertStatusReply(t, a.Exec(c, utils.ToCmdLine("get", keyA(0))), "QUEUED")
+	asserts.AssertErrReply(t, a.Exec(c, utils.ToCmdLine("mset", keyA(0), "1", keyB(0), "2")),
+		"ERR keys of a command in transaction must be located on the same node")
+	asserts.AssertErrReply(t, a.Exec(c, utils.ToCmdLine("exec")), "EXECABORT Transaction discarded because of previous errors.")
+
+	asserts.AssertStatusReply(t, a.Exec(c, utils.ToCmdLine("multi")), "OK")
+	asserts.AssertStatusReply(t, a.Exec(c, utils.ToCmdLine("set", keyA(0), "new")), "QUEUED")
+	asserts.AssertStatusReply(t, a.Exec(c, utils.ToCmdLine("rpush", keyB(0), "b")), "QUEUED")
+	asserts.AssertStatusReply(t, a.Exec(c, utils.ToCmdLine("get", keyA(0))), "QUEUED")
+	res := a.Exec(c, utils.ToCmdLine("exec"))
+	if expected := "*3\r\n+OK\r\n:2\r\n$3\r\nnew\r\n"; string(res.ToBytes()) != expected {
+		t.Errorf("expected %q, actual %q", expected, res.ToBytes())
+	}
+	asserts.AssertIntReply(t, b.db.Exec(c, utils.ToCmdLine("llen", keyB(0))), 2)
+}
+
+func TestTxParticipant(t *testing.T) {
+	a := serveCluster(t)
+	c := conn.NewFakeConn()
+	asserts.AssertStatusReply(t, a.Exec(c, utils.ToCmdLine("set", "k", "old")), "OK")
+	prepare := utils.ToCmdLine(relayTx, "prepare", "tx1", "3", "set", "k", "new", "2", "del", "k2")
+	asserts.AssertMultiBulkReply(t, a.Exec(c, prepare), []string{"+OK\r\n", ":0\r\n"})
+	asserts.AssertErrReply(t, a.Exec(c, prepare), "ERR transaction tx1 already exists")
+	asserts.AssertStatusReply(t, a.Exec(c, utils.ToCmdLine(relayTx, "rollback", "tx1")), "OK")
+	asserts.AssertBulkReply(t, a.db.Exec(c, utils.ToCmdLine("get", "k")), "old")
+	asserts.AssertErrReply(t, a.Exec(c, utils.ToCmdLine(relayTx, "commit", "tx1")), "ERR transaction tx1 has been rolled back")
+
+	// prepare arriving after rollback is rejected
+	asserts.AssertStatusReply(t, a.Exec(c, utils.ToCmdLine(relayTx, "rollback", "tx2")), "OK")
+	asserts.AssertErrReply(t, a.Exec(c, utils.ToCmdLine(relayTx, "prepare", "tx2", "3", "set", "k", "new")),
+		"ERR transaction tx2 already exists")
+
+	asserts.AssertMultiBulkReplySize(t, a.Exec(c, utils.ToCmdLine(relayTx, "prepare", "tx3", "3", "set", "k", "new")), 1)
+	asserts.AssertStatusReply(t, a.Exec(c, utils.ToCmdLine(relayTx, "commit", "tx3")), "OK")
+	asserts.AssertBulkReply(t, a.db.Exec(c, utils.ToCmdLine("get", "k")), "new")
+	asserts.AssertErrReply(t, a.Exec(c, utils.ToCmdLine(relayTx, "rollback", "tx3")), "ERR transaction tx3 has been committed")
+	asserts.AssertErrReply(t, a.Exec(c, utils.ToCmdLine(relayTx, "prepare", "tx4", "5", "set")), "Err syntax error")
+
+	// prepared transaction holds only its own keys, snapshots and other keys are not blocked
+	asserts.AssertMultiBulkReplySize(t, a.Exec(c, utils.ToCmdLine(relayTx, "prepare", "tx5", "3", "set", "k", "tmp")), 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		asserts.AssertStatusReply(t, a.db.Exec(c, utils.ToCmdLine("save")), "OK")
+		asserts.AssertStatusReply(t, a.db.Exec(c, utils.ToCmdLine("set", "k2", "v")), "OK")
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("blocked by prepared transaction")
+	}
+	asserts.AssertStatusReply(t, a.Exec(c, utils.ToCmdLine(relayTx, "rollback", "tx5")), "OK")
+	<-done
+	asserts.AssertBulkReply(t, a.db.Exec(c, utils.ToCmdLine("get", "k")), "new")
+}
@@ -0,0 +1,130 @@
+package cluster
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"ringodis/config"
+	"ringodis/lib/utils"
+	"ringodis/resp/client"
+	"ringodis/resp/conn"
+	"ringodis/resp/reply/asserts"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// makeTestCerts writes a self-signed CA and a certificate of 127.0.0.1 signed by it into dir
+func makeTestCerts(t *testing.T, dir string) (caFile, certFile, keyFile string) {
+	writePEM := func(filename, blockType string, der []byte) string {
+		filename = filepath.Join(dir, filename)
+		if err := os.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ringodis test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "ringodis"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caTemplate, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writePEM("ca.crt", "CERTIFICATE", caDER), writePEM("node.crt", "CERTIFICATE", der),
+		writePEM("node.key", "EC PRIVATE KEY", keyDER)
+}
+
+func TestTLSCluster(t *testing.T) {
+	caFile, certFile, keyFile := makeTestCerts(t, t.TempDir())
+	config.Properties.TLSCACertFile, config.Properties.TLSCertFile, config.Properties.TLSKeyFile = caFile, certFile, keyFile
+	config.Properties.TLSCluster = true
+	defer func() {
+		config.Properties.TLSCACertFile, config.Properties.TLSCertFile, config.Properties.TLSKeyFile = "", "", ""
+		config.Properties.TLSCluster = false
+	}()
+	serverConfig, err := config.MakeServerTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveTLS := func() *testNode {
+		listener, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return serveClusterOn(t, listener)
+	}
+	a, b := serveTLS(), serveTLS()
+	c := conn.NewFakeConn()
+	host, port := splitHostPort(b.self)
+	asserts.AssertStatusReply(t, a.Exec(c, utils.ToCmdLine("cluster", "meet", host, strconv.Itoa(port))), "OK")
+	waitResharding(t, a, b)
+	// relays and transactions between peers go through mutual TLS
+	asserts.AssertStatusReply(t, a.Exec(c, utils.ToCmdLine("mset", "k0", "0", "k1", "1", "k2", "2", "k3", "3")), "OK")
+	asserts.AssertMultiBulkReply(t, b.Exec(c, utils.ToCmdLine("mget", "k0", "k1", "k2", "k3")), []string{"0", "1", "2", "3"})
+
+	clientConfig, err := config.MakeClientTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli, err := client.MakeTLSClient(a.self, "", "", clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli.Start()
+	defer cli.Close()
+	asserts.AssertStatusReply(t, cli.Send(utils.ToCmdLine("ping")), "PONG")
+
+	// clients without certificate are rejected
+	noCert := clientConfig.Clone()
+	noCert.Certificates = nil
+	raw, err := tls.Dial("tcp", a.self, noCert)
+	if err == nil {
+		defer raw.Close()
+		_ = raw.SetDeadline(time.Now().Add(3 * time.Second))
+		if _, err = raw.Write([]byte("*1\r\n$4\r\nPING\r\n")); err == nil {
+			_, err = raw.Read(make([]byte, 16))
+		}
+	}
+	if err == nil {
+		t.Error("expected client without certificate to be rejected")
+	}
+}
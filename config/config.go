@@ -17,17 +17,76 @@ type ServerProperties struct {
 	Port           int    `cfg:"port"`
 	AppendOnly     bool   `cfg:"appendonly"`
 	AppendFilename string `cfg:"appendfilename"`
+	AppendFsync    string `cfg:"appendfsync"`
 	MaxClients     int    `cfg:"maxclients"`
-	RequirePass    string `cfg:"requirepass"`
-	Databases      int    `cfg:"databases"`
-	// RDBFilename       string `cfg:"dbfilename"`
-	// MasterAuth        string `cfg:"masterauth""`
-	// SlaveAnnouncePort int    `cfg:"slave-announce-port"`
-	// SlaveAnnounceIP   string `cfg:"slave-announce-ip"`
-	// ReplTimeout       int    `cfg:"repl-timeout"`
+	// path of unix socket accepting connections alongside tcp, empty disables it
+	UnixSocket string `cfg:"unixsocket"`
+	// permission of unix socket in octal like 700, umask applies if it is empty
+	UnixSocketPerm string `cfg:"unixsocketperm"`
+	// tls-port serves TLS connections alongside plaintext port, 0 disables it
+	TLSPort int `cfg:"tls-port"`
+	// certificate and private key in PEM, used by tls-port and by cluster peers dialing each other
+	TLSCertFile string `cfg:"tls-cert-file"`
+	TLSKeyFile  string `cfg:"tls-key-file"`
+	// CA certificates in PEM verifying clients and peers, system roots are used for peers if it is empty
+	TLSCACertFile string `cfg:"tls-ca-cert-file"`
+	// clients of tls-port must present certificates signed by tls-ca-cert-file, enabled by default
+	TLSAuthClients bool `cfg:"tls-auth-clients"`
+	// cluster peers dial each other by TLS, self and peers must be addresses of their tls-port
+	TLSCluster bool `cfg:"tls-cluster"`
+	// clients must AUTH with requirepass before other commands if it is set, it is the password of default user
+	RequirePass string `cfg:"requirepass"`
+	// ACL users are loaded from aclfile on startup and by ACL LOAD, requirepass is ignored if it is set
+	ACLFile     string `cfg:"aclfile"`
+	Databases   int    `cfg:"databases"`
+	RDBFilename string `cfg:"dbfilename"`
+
+	// replicate from master like "127.0.0.1 6379" on startup
+	ReplicaOf string `cfg:"replicaof"`
+	// user and password sent by replicas to master and by cluster nodes to peers, default user if masteruser is empty
+	MasterUser        string `cfg:"masteruser"`
+	MasterAuth        string `cfg:"masterauth"`
+	SlaveAnnouncePort int    `cfg:"slave-announce-port"`
+	SlaveAnnounceIP   string `cfg:"slave-announce-ip"`
+	// seconds without data from master before replica reconnects, 60 by default
+	ReplTimeout int `cfg:"repl-timeout"`
+	// size of replication backlog in bytes, 1mb by default
+	ReplBacklogSize int `cfg:"repl-backlog-size"`
+	// replicas reject writes from clients, enabled by default
+	ReplicaReadOnly bool `cfg:"replica-read-only"`
+
+	// rewrite aof when it grows by the given percentage since last rewrite, 0 disables auto rewrite
+	AutoAofRewritePercentage int `cfg:"auto-aof-rewrite-percentage"`
+	// minimal aof size in bytes to trigger auto rewrite
+	AutoAofRewriteMinSize int `cfg:"auto-aof-rewrite-min-size"`
+	// save points like "900 1 300 10", save rdb if both seconds elapsed and changes reached
+	Save string `cfg:"save"`
+	// restore all keys if any command in transaction fails, redis doesn't roll back by default
+	MultiRollback bool `cfg:"multi-rollback"`
+
+	// sentinel mode monitors masters and fails over to replicas instead of serving data
+	Sentinel bool `cfg:"sentinel"`
+	// monitored masters separated by comma, each is like "mymaster 127.0.0.1 6379 2" ending with quorum
+	SentinelMonitor []string `cfg:"sentinel-monitor"`
+	// addresses of other sentinels monitoring the same masters
+	SentinelPeers []string `cfg:"sentinel-peers"`
+	// milliseconds without valid reply before an instance is considered down, 30000 by default
+	SentinelDownAfter int `cfg:"sentinel-down-after-milliseconds"`
+	// milliseconds to wait for every step of failover, 180000 by default
+	SentinelFailoverTimeout int `cfg:"sentinel-failover-timeout"`
 
 	Peers []string `cfg:"peers"`
 	Self  string   `cfg:"self"`
+	// cluster-enabled divides keys into 16384 hash slots and redirects clients by MOVED/ASK instead of proxying
+	ClusterEnabled bool `cfg:"cluster-enabled"`
+	// milliseconds without pong before a peer is suspected to fail, 15000 by default
+	ClusterNodeTimeout int `cfg:"cluster-node-timeout"`
+	// read replicas separated by comma, each is like "127.0.0.1:6391 127.0.0.1:7391 127.0.0.1:7392" starting with the primary
+	ClusterReplicas []string `cfg:"cluster-replicas"`
+	// where read-only commands go: primary-only, prefer-replica or round-robin, primary-only by default
+	ClusterReadPolicy string `cfg:"cluster-read-policy"`
+	// bytes of replication offset a replica may fall behind its primary before reads fall back to the primary, 1mb by default
+	ClusterReplicaMaxLag int `cfg:"cluster-replica-max-lag"`
 }
 
 // Properties holds global config properties
@@ -36,14 +95,21 @@ var Properties *ServerProperties
 func init() {
 	// default config
 	Properties = &ServerProperties{
-		Bind:       "127.0.0.1",
-		Port:       6379,
-		AppendOnly: false,
+		Bind:            "127.0.0.1",
+		Port:            6379,
+		AppendOnly:      false,
+		AppendFilename:  "appendonly.aof",
+		AppendFsync:     "everysec",
+		ReplicaReadOnly: true,
+		TLSAuthClients:  true,
 	}
 }
 
 func parse(src io.Reader) *ServerProperties {
-	config := &ServerProperties{}
+	config := &ServerProperties{
+		ReplicaReadOnly: true,
+		TLSAuthClients:  true,
+	}
 
 	// read config file
 	rawMap := make(map[string]string)
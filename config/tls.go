@@ -0,0 +1,66 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+)
+
+// MakeServerTLSConfig loads tls-cert-file and tls-key-file for tls-port,
+// clients are verified by tls-ca-cert-file if tls-auth-clients is enabled
+func MakeServerTLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(Properties.TLSCertFile, Properties.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if Properties.TLSAuthClients {
+		if Properties.TLSCACertFile == "" {
+			return nil, errors.New("tls-ca-cert-file is required to authenticate clients")
+		}
+		if tlsConfig.ClientCAs, err = loadCertPool(Properties.TLSCACertFile); err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// MakeClientTLSConfig makes config of cluster peers dialing each other, the same certificate is presented
+// as client certificate, and peers are verified by tls-ca-cert-file
+func MakeClientTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if Properties.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(Properties.TLSCertFile, Properties.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if Properties.TLSCACertFile != "" {
+		pool, err := loadCertPool(Properties.TLSCACertFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+func loadCertPool(filename string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificate found in " + filename)
+	}
+	return pool, nil
+}
@@ -0,0 +1,713 @@
+package database
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"os"
+	"ringodis/config"
+	"ringodis/interface/resp"
+	"ringodis/lib/wildcard"
+	"ringodis/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultUser is used by connections before AUTH, its password is requirepass unless aclfile is configured
+const defaultUser = "default"
+
+const (
+	aclLogMaxLen = 128
+	// denials of the same reason, object and user within aclLogMergeWindow are counted in one log entry
+	aclLogMergeWindow = time.Minute
+)
+
+// aclUser is immutable after it is stored, SETUSER replaces it by a modified copy
+type aclUser struct {
+	name    string
+	enabled bool
+	nopass  bool
+	// passwords holds sha256 of passwords in hex
+	passwords []string
+	// allCommands allows commands unknown to cmdTable as well, except internal commands of cluster
+	allCommands bool
+	commands    map[string]struct{}
+	// cmdRules are command rules applied since the last +@all or -@all, describing allowed commands
+	cmdRules    []string
+	keyPatterns []string
+	keyMatchers []*wildcard.Pattern
+}
+
+type aclLogEntry struct {
+	count    int
+	reason   string
+	context  string
+	object   string
+	username string
+	created  time.Time
+	updated  time.Time
+}
+
+// aclStore holds users shared by all servers in the process, like cmdTable
+type aclStore struct {
+	mu    sync.RWMutex
+	users map[string]*aclUser
+	// log holds denials, newest first
+	log []*aclLogEntry
+}
+
+var acl = &aclStore{
+	users: map[string]*aclUser{defaultUser: makeDefaultUser()},
+}
+
+// makeUser returns a user without any permission, like a new user of SETUSER or after `reset`
+func makeUser(name string) *aclUser {
+	return &aclUser{
+		name:     name,
+		commands: make(map[string]struct{}),
+		cmdRules: []string{"-@all"},
+	}
+}
+
+// makeDefaultUser returns default user allowed to do anything, protected by requirepass if it is set
+func makeDefaultUser() *aclUser {
+	user := makeUser(defaultUser)
+	rules := []string{"on", "~*", "+@all", "nopass"}
+	if config.Properties.RequirePass != "" {
+		rules[3] = ">" + config.Properties.RequirePass
+	}
+	for _, rule := range rules {
+		_ = user.applyRule(rule)
+	}
+	return user
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func (user *aclUser) clone() *aclUser {
+	c := *user
+	c.passwords = append([]string(nil), user.passwords...)
+	c.commands = make(map[string]struct{}, len(user.commands))
+	for name := range user.commands {
+		c.commands[name] = struct{}{}
+	}
+	c.cmdRules = append([]string(nil), user.cmdRules...)
+	c.keyPatterns = append([]string(nil), user.keyPatterns...)
+	c.keyMatchers = append([]*wildcard.Pattern(nil), user.keyMatchers...)
+	return &c
+}
+
+// forEachCommand calls cb with every known command and its flags
+func forEachCommand(cb func(name string, flags int)) {
+	for name := range cmdTable {
+		flags, _ := getCmdFlags(name)
+		cb(name, flags)
+	}
+	for name, flags := range serverCmdFlags {
+		cb(name, flags)
+	}
+}
+
+// applyRule modifies user by a rule of ACL SETUSER
+func (user *aclUser) applyRule(rule string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		user.enabled = true
+		return nil
+	case "off":
+		user.enabled = false
+		return nil
+	case "nopass":
+		user.nopass = true
+		user.passwords = nil
+		return nil
+	case "resetpass":
+		user.nopass = false
+		user.passwords = nil
+		return nil
+	case "allkeys":
+		return user.applyRule("~*")
+	case "resetkeys":
+		user.keyPatterns = nil
+		user.keyMatchers = nil
+		return nil
+	case "allcommands":
+		return user.applyRule("+@all")
+	case "nocommands":
+		return user.applyRule("-@all")
+	case "reset":
+		*user = *makeUser(user.name)
+		return nil
+	}
+	if rule == "" {
+		return errors.New("Syntax error")
+	}
+	switch arg := rule[1:]; rule[0] {
+	case '>':
+		user.addPassword(hashPassword(arg))
+	case '#':
+		if len(arg) != sha256.Size*2 {
+			return errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		if _, err := hex.DecodeString(arg); err != nil || strings.ToLower(arg) != arg {
+			return errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		user.addPassword(arg)
+	case '<':
+		return user.removePassword(hashPassword(arg))
+	case '!':
+		return user.removePassword(arg)
+	case '~':
+		matcher, err := wildcard.CompilePattern(arg)
+		if err != nil {
+			return errors.New("Syntax error")
+		}
+		user.keyPatterns = append(user.keyPatterns, arg)
+		user.keyMatchers = append(user.keyMatchers, matcher)
+	case '+', '-':
+		return user.applyCommandRule(rule[0] == '+', strings.ToLower(arg))
+	default:
+		return errors.New("Syntax error")
+	}
+	return nil
+}
+
+func (user *aclUser) addPassword(hash string) {
+	user.nopass = false
+	for _, p := range user.passwords {
+		if p == hash {
+			return
+		}
+	}
+	user.passwords = append(user.passwords, hash)
+}
+
+func (user *aclUser) removePassword(hash string) error {
+	for i, p := range user.passwords {
+		if p == hash {
+			user.passwords = append(user.passwords[:i], user.passwords[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("no such password")
+}
+
+// applyCommandRule allows or disallows a command or a category like @write
+func (user *aclUser) applyCommandRule(allow bool, arg string) error {
+	sign := "-"
+	if allow {
+		sign = "+"
+	}
+	if arg == "@all" {
+		user.allCommands = allow
+		user.commands = make(map[string]struct{})
+		if allow {
+			forEachCommand(func(name string, flags int) {
+				user.commands[name] = struct{}{}
+			})
+		}
+		user.cmdRules = []string{sign + arg}
+		return nil
+	}
+	var names []string
+	if strings.HasPrefix(arg, "@") {
+		category, ok := aclCategories[arg[1:]]
+		if !ok {
+			return errors.New("Unknown command or category name in ACL")
+		}
+		forEachCommand(func(name string, flags int) {
+			if flags&category != 0 {
+				names = append(names, name)
+			}
+		})
+	} else {
+		if _, ok := getCmdFlags(arg); !ok {
+			return errors.New("Unknown command or category name in ACL")
+		}
+		names = []string{arg}
+	}
+	for _, name := range names {
+		if allow {
+			user.commands[name] = struct{}{}
+		} else {
+			delete(user.commands, name)
+		}
+	}
+	if !allow {
+		user.allCommands = false
+	}
+	user.cmdRules = append(user.cmdRules, sign+arg)
+	return nil
+}
+
+func (user *aclUser) canRun(name string) bool {
+	if user.allCommands {
+		return true
+	}
+	_, ok := user.commands[name]
+	return ok
+}
+
+func (user *aclUser) canAccess(key string) bool {
+	for _, matcher := range user.keyMatchers {
+		if matcher.IsMatch(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (user *aclUser) checkPassword(password string) bool {
+	if user.nopass {
+		return true
+	}
+	hash := hashPassword(password)
+	for _, p := range user.passwords {
+		if subtle.ConstantTimeCompare([]byte(p), []byte(hash)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// describe returns rules recreating the user, used by ACL LIST and aclfile
+func (user *aclUser) describe() string {
+	fields := []string{"user", user.name}
+	if user.enabled {
+		fields = append(fields, "on")
+	} else {
+		fields = append(fields, "off")
+	}
+	if user.nopass {
+		fields = append(fields, "nopass")
+	}
+	for _, p := range user.passwords {
+		fields = append(fields, "#"+p)
+	}
+	for _, pattern := range user.keyPatterns {
+		fields = append(fields, "~"+pattern)
+	}
+	fields = append(fields, user.cmdRules...)
+	return strings.Join(fields, " ")
+}
+
+// getUser returns user by name, the user must not be modified
+func (store *aclStore) getUser(name string) *aclUser {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return store.users[name]
+}
+
+// addLog records a denial, similar one in recent is counted instead of added
+func (store *aclStore) addLog(reason string, c resp.Connection, object string, username string) {
+	context := "toplevel"
+	if c.InMultiState() {
+		context = "multi"
+	}
+	now := time.Now()
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for _, entry := range store.log {
+		if entry.reason == reason && entry.object == object && entry.username == username &&
+			entry.context == context && now.Sub(entry.updated) < aclLogMergeWindow {
+			entry.count++
+			entry.updated = now
+			return
+		}
+	}
+	store.log = append([]*aclLogEntry{{
+		count:    1,
+		reason:   reason,
+		context:  context,
+		object:   object,
+		username: username,
+		created:  now,
+		updated:  now,
+	}}, store.log...)
+	if len(store.log) > aclLogMaxLen {
+		store.log = store.log[:aclLogMaxLen]
+	}
+}
+
+// setupACL loads users from aclfile, or makes default user protected by requirepass
+func setupACL() error {
+	users := map[string]*aclUser{defaultUser: makeDefaultUser()}
+	if filename := config.Properties.ACLFile; filename != "" {
+		var err error
+		if users, err = loadACLFile(filename); err != nil {
+			return err
+		}
+	}
+	acl.mu.Lock()
+	acl.users = users
+	acl.mu.Unlock()
+	return nil
+}
+
+// loadACLFile parses lines like `user <name> [rule ...]`, default user is added if it is absent
+func loadACLFile(filename string) (map[string]*aclUser, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	users := make(map[string]*aclUser)
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 || fields[0] != "user" {
+			return nil, errors.New(filename + ":" + strconv.Itoa(lineNum) + ": line should start with user keyword")
+		}
+		if _, ok := users[fields[1]]; ok {
+			return nil, errors.New(filename + ":" + strconv.Itoa(lineNum) + ": duplicate user '" + fields[1] + "'")
+		}
+		user := makeUser(fields[1])
+		for _, rule := range fields[2:] {
+			if err := user.applyRule(rule); err != nil {
+				return nil, errors.New(filename + ":" + strconv.Itoa(lineNum) + ": " + err.Error() + ". '" + rule + "'")
+			}
+		}
+		users[user.name] = user
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if _, ok := users[defaultUser]; !ok {
+		users[defaultUser] = makeDefaultUser()
+	}
+	return users, nil
+}
+
+// saveACLFile writes users into aclfile, the file is replaced after fully written
+func saveACLFile(filename string, users []*aclUser) error {
+	tmpFile := filename + ".tmp"
+	file, err := os.Create(tmpFile)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	for _, user := range users {
+		_, _ = writer.WriteString(user.describe() + "\n")
+	}
+	if err = writer.Flush(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, filename)
+}
+
+// internalCommands are sent between nodes of cluster, they are denied to users other than peerUser
+var internalCommands = make(map[string]struct{})
+
+// RegisterInternalCommand marks command as internal, it should be called in init
+func RegisterInternalCommand(name string) {
+	internalCommands[strings.ToLower(name)] = struct{}{}
+}
+
+// peerUser returns the user which peers authenticate as by masteruser and masterauth
+func peerUser() string {
+	if user := config.Properties.MasterUser; user != "" && config.Properties.MasterAuth != "" {
+		return user
+	}
+	return defaultUser
+}
+
+// isPeer tells whether connection of user may run internal commands,
+// peers must have authenticated by masterauth if it is configured
+func isPeer(c resp.Connection, username string) bool {
+	if username != peerUser() {
+		return false
+	}
+	return config.Properties.MasterAuth == "" || c.IsAuthenticated()
+}
+
+// isAuthenticated tells whether client has been authenticated or default user requires no password
+func isAuthenticated(c resp.Connection) bool {
+	if c.IsAuthenticated() {
+		return true
+	}
+	user := acl.getUser(defaultUser)
+	return user != nil && user.enabled && user.nopass
+}
+
+// CheckPermission returns error if client is not authenticated, or its user is not allowed to run the command
+// or access its keys, connections authenticated without user are internal and not restricted
+func CheckPermission(c resp.Connection, cmdLine CmdLine) reply.ErrorReply {
+	username := c.GetUser()
+	if c.IsAuthenticated() && username == "" {
+		return nil
+	}
+	if username == "" {
+		username = defaultUser
+	}
+	user := acl.getUser(username)
+	if user == nil || !user.enabled || (!c.IsAuthenticated() && !user.nopass) {
+		// user of connection has been deleted or disabled
+		c.SetAuthenticated(false)
+		return reply.MakeNoAuthErrReply()
+	}
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	_, internal := internalCommands[cmdName]
+	if (internal && !isPeer(c, username)) || !user.canRun(cmdName) {
+		acl.addLog("command", c, cmdName, username)
+		return reply.MakeErrReply("NOPERM User " + username + " has no permissions to run the '" + cmdName + "' command")
+	}
+	writeKeys, readKeys, _ := GetRelatedKeys(cmdLine)
+	for _, keys := range [][]string{writeKeys, readKeys} {
+		for _, key := range keys {
+			if !user.canAccess(key) {
+				acl.addLog("key", c, key, username)
+				return reply.MakeErrReply("NOPERM No permissions to access a key")
+			}
+		}
+	}
+	return nil
+}
+
+// authenticate checks password of user, failures are recorded in ACL LOG
+func authenticate(c resp.Connection, username string, password string) reply.ErrorReply {
+	user := acl.getUser(username)
+	if user == nil || !user.enabled || !user.checkPassword(password) {
+		acl.addLog("auth", c, "AUTH", username)
+		return reply.MakeErrReply("WRONGPASS invalid username-password pair or user is disabled.")
+	}
+	return nil
+}
+
+// execACL manages users: ACL SETUSER|GETUSER|DELUSER|USERS|LIST|WHOAMI|CAT|LOG|LOAD|SAVE
+func execACL(c resp.Connection, args CmdArgs) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("acl")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	args = args[1:]
+	switch subCmd {
+	case "setuser":
+		if len(args) == 0 {
+			return reply.MakeArgNumErrReply("acl|setuser")
+		}
+		return execACLSetUser(args)
+	case "getuser":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("acl|getuser")
+		}
+		return execACLGetUser(string(args[0]))
+	case "deluser":
+		if len(args) == 0 {
+			return reply.MakeArgNumErrReply("acl|deluser")
+		}
+		return execACLDelUser(args)
+	case "users", "list":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("acl|" + subCmd)
+		}
+		var result [][]byte
+		for _, user := range acl.sortedUsers() {
+			if subCmd == "users" {
+				result = append(result, []byte(user.name))
+			} else {
+				result = append(result, []byte(user.describe()))
+			}
+		}
+		return reply.MakeMultiBulkReply(result)
+	case "whoami":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("acl|whoami")
+		}
+		username := c.GetUser()
+		if username == "" {
+			username = defaultUser
+		}
+		return reply.MakeBulkReply([]byte(username))
+	case "cat":
+		if len(args) > 1 {
+			return reply.MakeArgNumErrReply("acl|cat")
+		}
+		return execACLCat(args)
+	case "log":
+		if len(args) > 1 {
+			return reply.MakeArgNumErrReply("acl|log")
+		}
+		return execACLLog(args)
+	case "load":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("acl|load")
+		}
+		if config.Properties.ACLFile == "" {
+			return reply.MakeErrReply("ERR This Redis instance is not configured to use an ACL file.")
+		}
+		if err := setupACL(); err != nil {
+			return reply.MakeErrReply("ERR " + err.Error())
+		}
+		return reply.MakeOkReply()
+	case "save":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("acl|save")
+		}
+		if config.Properties.ACLFile == "" {
+			return reply.MakeErrReply("ERR This Redis instance is not configured to use an ACL file.")
+		}
+		if err := saveACLFile(config.Properties.ACLFile, acl.sortedUsers()); err != nil {
+			return reply.MakeErrReply("ERR There was an error trying to save the ACLs. " + err.Error())
+		}
+		return reply.MakeOkReply()
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try ACL HELP.")
+}
+
+func (store *aclStore) sortedUsers() []*aclUser {
+	store.mu.RLock()
+	users := make([]*aclUser, 0, len(store.users))
+	for _, user := range store.users {
+		users = append(users, user)
+	}
+	store.mu.RUnlock()
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].name < users[j].name
+	})
+	return users
+}
+
+// execACLSetUser creates or modifies user, nothing changes if any rule is invalid
+func execACLSetUser(args CmdArgs) resp.Reply {
+	name := string(args[0])
+	if name == "" || strings.ContainsAny(name, " \t\r\n") {
+		return reply.MakeErrReply("ERR Usernames can't contain spaces or be empty")
+	}
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+	var user *aclUser
+	if old, ok := acl.users[name]; ok {
+		user = old.clone()
+	} else {
+		user = makeUser(name)
+	}
+	for _, arg := range args[1:] {
+		if err := user.applyRule(string(arg)); err != nil {
+			return reply.MakeErrReply("ERR Error in ACL SETUSER modifier '" + string(arg) + "': " + err.Error())
+		}
+	}
+	acl.users[name] = user
+	return reply.MakeOkReply()
+}
+
+func execACLGetUser(name string) resp.Reply {
+	user := acl.getUser(name)
+	if user == nil {
+		return reply.MakeNullBulkReply()
+	}
+	flags := [][]byte{[]byte("off")}
+	if user.enabled {
+		flags[0] = []byte("on")
+	}
+	if user.nopass {
+		flags = append(flags, []byte("nopass"))
+	}
+	passwords := make([][]byte, len(user.passwords))
+	for i, p := range user.passwords {
+		passwords[i] = []byte(p)
+	}
+	keys := make([]string, len(user.keyPatterns))
+	for i, pattern := range user.keyPatterns {
+		keys[i] = "~" + pattern
+	}
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte("flags")), reply.MakeMultiBulkReply(flags),
+		reply.MakeBulkReply([]byte("passwords")), reply.MakeMultiBulkReply(passwords),
+		reply.MakeBulkReply([]byte("commands")), reply.MakeBulkReply([]byte(strings.Join(user.cmdRules, " "))),
+		reply.MakeBulkReply([]byte("keys")), reply.MakeBulkReply([]byte(strings.Join(keys, " "))),
+	})
+}
+
+func execACLDelUser(args CmdArgs) resp.Reply {
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+	deleted := 0
+	for _, arg := range args {
+		name := string(arg)
+		if name == defaultUser {
+			return reply.MakeErrReply("ERR The 'default' user cannot be removed")
+		}
+		if _, ok := acl.users[name]; ok {
+			delete(acl.users, name)
+			deleted++
+		}
+	}
+	return reply.MakeIntReply(int64(deleted))
+}
+
+// execACLCat lists categories, or commands in the given category
+func execACLCat(args CmdArgs) resp.Reply {
+	var names []string
+	if len(args) == 0 {
+		for category := range aclCategories {
+			names = append(names, category)
+		}
+	} else {
+		category, ok := aclCategories[strings.ToLower(string(args[0]))]
+		if !ok {
+			return reply.MakeErrReply("ERR Unknown category '" + string(args[0]) + "'")
+		}
+		forEachCommand(func(name string, flags int) {
+			if flags&category != 0 {
+				names = append(names, name)
+			}
+		})
+	}
+	sort.Strings(names)
+	result := make([][]byte, len(names))
+	for i, name := range names {
+		result[i] = []byte(name)
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execACLLog shows recent denials: ACL LOG [count | RESET]
+func execACLLog(args CmdArgs) resp.Reply {
+	count := aclLogMaxLen
+	if len(args) == 1 {
+		if strings.ToLower(string(args[0])) == "reset" {
+			acl.mu.Lock()
+			acl.log = nil
+			acl.mu.Unlock()
+			return reply.MakeOkReply()
+		}
+		var err error
+		if count, err = strconv.Atoi(string(args[0])); err != nil || count < 0 {
+			return reply.MakeErrReply("ERR value is out of range, must be positive")
+		}
+	}
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+	if count > len(acl.log) {
+		count = len(acl.log)
+	}
+	now := time.Now()
+	entries := make([]resp.Reply, 0, count)
+	for _, entry := range acl.log[:count] {
+		age := strconv.FormatFloat(now.Sub(entry.created).Seconds(), 'f', 3, 64)
+		entries = append(entries, reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte("count")), reply.MakeIntReply(int64(entry.count)),
+			reply.MakeBulkReply([]byte("reason")), reply.MakeBulkReply([]byte(entry.reason)),
+			reply.MakeBulkReply([]byte("context")), reply.MakeBulkReply([]byte(entry.context)),
+			reply.MakeBulkReply([]byte("object")), reply.MakeBulkReply([]byte(entry.object)),
+			reply.MakeBulkReply([]byte("username")), reply.MakeBulkReply([]byte(entry.username)),
+			reply.MakeBulkReply([]byte("age-seconds")), reply.MakeBulkReply([]byte(age)),
+		}))
+	}
+	return reply.MakeMultiRawReply(entries)
+}
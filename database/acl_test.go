@@ -0,0 +1,130 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"ringodis/config"
+	"ringodis/lib/utils"
+	"ringodis/resp/conn"
+	"ringodis/resp/reply/asserts"
+	"strings"
+	"testing"
+)
+
+func TestACL(t *testing.T) {
+	server := NewStandaloneServer()
+	defer server.Close()
+	defer func() {
+		_ = setupACL()
+	}()
+	admin := conn.NewFakeConn()
+	asserts.AssertStatusReply(t, server.Exec(admin, utils.ToCmdLine("acl", "setuser", "alice", "on", ">p1", "~cache:*", "+@read", "+@transaction", "+set", "-keys")), "OK")
+	asserts.AssertErrReply(t, server.Exec(admin, utils.ToCmdLine("acl", "setuser", "alice", "+nosuchcmd")),
+		"ERR Error in ACL SETUSER modifier '+nosuchcmd': Unknown command or category name in ACL")
+	asserts.AssertErrReply(t, server.Exec(admin, utils.ToCmdLine("acl", "setuser", "alice", "<wrong")),
+		"ERR Error in ACL SETUSER modifier '<wrong': no such password")
+	asserts.AssertMultiBulkReply(t, server.Exec(admin, utils.ToCmdLine("acl", "users")), []string{"alice", "default"})
+	asserts.AssertMultiBulkReply(t, server.Exec(admin, utils.ToCmdLine("acl", "list")), []string{
+		"user alice on #" + hashPassword("p1") + " ~cache:* -@all +@read +@transaction +set -keys",
+		"user default on nopass ~* +@all",
+	})
+
+	c := conn.NewFakeConn()
+	c.SetAuthenticated(false)
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("acl", "whoami")), "default")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("auth", "alice", "wrong")),
+		"WRONGPASS invalid username-password pair or user is disabled.")
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("auth", "alice", "p1")), "OK")
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("set", "cache:1", "v")), "OK")
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("get", "cache:1")), "v")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("get", "secret")), "NOPERM No permissions to access a key")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("del", "cache:1")),
+		"NOPERM User alice has no permissions to run the 'del' command")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("keys", "*")),
+		"NOPERM User alice has no permissions to run the 'keys' command")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("acl", "whoami")),
+		"NOPERM User alice has no permissions to run the 'acl' command")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("mset", "cache:2", "v", "other", "v")),
+		"NOPERM User alice has no permissions to run the 'mset' command")
+
+	// denials in transaction abort it
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("multi")), "OK")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("get", "secret")), "NOPERM No permissions to access a key")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("exec")), "EXECABORT Transaction discarded because of previous errors.")
+
+	asserts.AssertStatusReply(t, server.Exec(admin, utils.ToCmdLine("acl", "setuser", "alice", "allkeys", "+@keyspace")), "OK")
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("del", "cache:1")), 1)
+	res := server.Exec(admin, utils.ToCmdLine("acl", "getuser", "alice"))
+	if !strings.Contains(string(res.ToBytes()), "-@all +@read +@transaction +set -keys +@keyspace") {
+		t.Errorf("unexpected reply %s", res.ToBytes())
+	}
+	asserts.AssertNullBulk(t, server.Exec(admin, utils.ToCmdLine("acl", "getuser", "nobody")))
+
+	res = server.Exec(admin, utils.ToCmdLine("acl", "log", "1"))
+	for _, s := range []string{"*1\r\n", "$6\r\nreason\r\n$3\r\nkey\r\n", "$7\r\ncontext\r\n$5\r\nmulti\r\n", "$6\r\nsecret\r\n"} {
+		if !strings.Contains(string(res.ToBytes()), s) {
+			t.Errorf("expected %q in acl log, actual %s", s, res.ToBytes())
+		}
+	}
+	asserts.AssertStatusReply(t, server.Exec(admin, utils.ToCmdLine("acl", "log", "reset")), "OK")
+	asserts.AssertMultiBulkReplySize(t, server.Exec(admin, utils.ToCmdLine("acl", "log")), 0)
+
+	// disabled or deleted user could not run commands any more
+	asserts.AssertStatusReply(t, server.Exec(admin, utils.ToCmdLine("acl", "setuser", "alice", "off")), "OK")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("get", "cache:1")), "NOAUTH Authentication required.")
+	asserts.AssertErrReply(t, server.Exec(admin, utils.ToCmdLine("acl", "deluser", "default")), "ERR The 'default' user cannot be removed")
+	asserts.AssertIntReply(t, server.Exec(admin, utils.ToCmdLine("acl", "deluser", "alice", "bob")), 1)
+
+	// default user protected by password
+	asserts.AssertStatusReply(t, server.Exec(admin, utils.ToCmdLine("acl", "setuser", "default", "resetpass", ">secret")), "OK")
+	c = conn.NewFakeConn()
+	c.SetAuthenticated(false)
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("get", "k")), "NOAUTH Authentication required.")
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("auth", "secret")), "OK")
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("acl", "whoami")), "default")
+
+	asserts.AssertErrReply(t, server.Exec(admin, utils.ToCmdLine("acl", "cat", "nope")), "ERR Unknown category 'nope'")
+	res = server.Exec(admin, utils.ToCmdLine("acl", "cat", "hash"))
+	if !strings.Contains(string(res.ToBytes()), "$4\r\nhset\r\n") || strings.Contains(string(res.ToBytes()), "$3\r\nset\r\n") {
+		t.Errorf("unexpected commands of hash category %s", res.ToBytes())
+	}
+}
+
+func TestACLFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "users.acl")
+	err := os.WriteFile(filename, []byte("user reader on >pw ~* +get\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Properties.ACLFile = filename
+	defer func() {
+		config.Properties.ACLFile = ""
+		_ = setupACL()
+	}()
+	server := NewStandaloneServer()
+	defer server.Close()
+	admin := conn.NewFakeConn()
+	asserts.AssertMultiBulkReply(t, server.Exec(admin, utils.ToCmdLine("acl", "users")), []string{"default", "reader"})
+	asserts.AssertStatusReply(t, server.Exec(admin, utils.ToCmdLine("acl", "setuser", "writer", "on", "nopass", "~*", "+@write")), "OK")
+	asserts.AssertStatusReply(t, server.Exec(admin, utils.ToCmdLine("acl", "save")), "OK")
+	asserts.AssertIntReply(t, server.Exec(admin, utils.ToCmdLine("acl", "deluser", "writer")), 1)
+	asserts.AssertStatusReply(t, server.Exec(admin, utils.ToCmdLine("acl", "load")), "OK")
+	asserts.AssertMultiBulkReply(t, server.Exec(admin, utils.ToCmdLine("acl", "users")), []string{"default", "reader", "writer"})
+
+	c := conn.NewFakeConn()
+	c.SetAuthenticated(false)
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("auth", "reader", "pw")), "OK")
+	asserts.AssertNullBulk(t, server.Exec(c, utils.ToCmdLine("get", "acl-file")))
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("set", "acl-file", "v")),
+		"NOPERM User reader has no permissions to run the 'set' command")
+
+	err = os.WriteFile(filename, []byte("user reader on +nosuchcmd\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := server.Exec(admin, utils.ToCmdLine("acl", "load"))
+	if !strings.Contains(string(res.ToBytes()), "Unknown command or category name in ACL") {
+		t.Errorf("unexpected reply %s", res.ToBytes())
+	}
+	asserts.AssertMultiBulkReply(t, server.Exec(admin, utils.ToCmdLine("acl", "users")), []string{"default", "reader", "writer"})
+}
@@ -0,0 +1,28 @@
+package database
+
+import (
+	"ringodis/interface/resp"
+	"ringodis/resp/reply"
+)
+
+// execBGRewriteAof asynchronously rewrites aof file
+func execBGRewriteAof(server *Server) resp.Reply {
+	if server.persister == nil {
+		return reply.MakeErrReply("ERR append only file is disabled")
+	}
+	if err := server.persister.BackgroundRewrite(); err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return reply.MakeStatusReply("Background append only file rewriting started")
+}
+
+// execRewriteAof rewrites aof file and returns after finished
+func execRewriteAof(server *Server) resp.Reply {
+	if server.persister == nil {
+		return reply.MakeErrReply("ERR append only file is disabled")
+	}
+	if err := server.persister.Rewrite(); err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return reply.MakeOkReply()
+}
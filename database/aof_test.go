@@ -0,0 +1,130 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"ringodis/config"
+	"ringodis/lib/utils"
+	"ringodis/resp/conn"
+	"ringodis/resp/reply"
+	"ringodis/resp/reply/asserts"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAof(t *testing.T) {
+	config.Properties.AppendOnly = true
+	config.Properties.AppendFilename = filepath.Join(t.TempDir(), "a.aof")
+	config.Properties.AppendFsync = "always"
+	defer func() {
+		config.Properties.AppendOnly = false
+	}()
+
+	server := NewStandaloneServer()
+	c := conn.NewFakeConn()
+	server.Exec(c, utils.ToCmdLine("set", "a", "1"))
+	server.Exec(c, utils.ToCmdLine("set", "b", "", "ex", "1000"))
+	server.Exec(c, utils.ToCmdLine("set", "c", "$3"))
+	server.Exec(c, utils.ToCmdLine("select", "1"))
+	server.Exec(c, utils.ToCmdLine("set", "a", "2"))
+	server.Exec(c, utils.ToCmdLine("get", "not-persisted"))
+	server.Exec(c, utils.ToCmdLine("select", "0"))
+	server.Exec(c, utils.ToCmdLine("del", "c"))
+	server.Close()
+
+	server = NewStandaloneServer()
+	defer server.Close()
+	c = conn.NewFakeConn()
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("get", "a")), "1")
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("get", "b")), "")
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("exists", "c")), 0)
+	result := server.Exec(c, utils.ToCmdLine("ttl", "b"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code <= 0 {
+		t.Errorf("expected ttl more than 0, actual: %s", result.ToBytes())
+	}
+	server.Exec(c, utils.ToCmdLine("select", "1"))
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("get", "a")), "2")
+}
+
+func TestRewriteAof(t *testing.T) {
+	config.Properties.AppendOnly = true
+	config.Properties.AppendFilename = filepath.Join(t.TempDir(), "a.aof")
+	config.Properties.AppendFsync = "always"
+	defer func() {
+		config.Properties.AppendOnly = false
+	}()
+
+	server := NewStandaloneServer()
+	c := conn.NewFakeConn()
+	for i := 0; i < 100; i++ {
+		server.Exec(c, utils.ToCmdLine("set", "a", strconv.Itoa(i)))
+	}
+	server.Exec(c, utils.ToCmdLine("set", "b", "b", "ex", "1000"))
+	server.Exec(c, utils.ToCmdLine("select", "2"))
+	server.Exec(c, utils.ToCmdLine("set", "c", "c"))
+	server.Exec(c, utils.ToCmdLine("zadd", "z", "1.5", "a", "-inf", "b"))
+	server.Exec(c, utils.ToCmdLine("xadd", "x", "1-0", "a", "1"))
+	server.Exec(c, utils.ToCmdLine("xadd", "x", "2-0", "b", "2"))
+	server.Exec(c, utils.ToCmdLine("xgroup", "create", "x", "g", "0"))
+	server.Exec(c, utils.ToCmdLine("xreadgroup", "group", "g", "alice", "count", "1", "streams", "x", ">"))
+	server.Exec(c, utils.ToCmdLine("xdel", "x", "2-0"))
+	server.Exec(c, utils.ToCmdLine("xadd", "e", "MAXLEN", "0", "5-0", "a", "1"))
+	before, _ := os.Stat(config.Properties.AppendFilename)
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("rewriteaof")), "OK")
+	after, _ := os.Stat(config.Properties.AppendFilename)
+	if after.Size() >= before.Size() {
+		t.Errorf("expected aof to shrink, before: %d, after: %d", before.Size(), after.Size())
+	}
+	server.Exec(c, utils.ToCmdLine("set", "d", "d"))
+	server.Close()
+
+	server = NewStandaloneServer()
+	defer server.Close()
+	c = conn.NewFakeConn()
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("get", "a")), "99")
+	result := server.Exec(c, utils.ToCmdLine("ttl", "b"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code <= 0 {
+		t.Errorf("expected ttl more than 0, actual: %s", result.ToBytes())
+	}
+	server.Exec(c, utils.ToCmdLine("select", "2"))
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("get", "c")), "c")
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("get", "d")), "d")
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("zrange", "z", "0", "-1", "withscores")),
+		[]string{"b", "-inf", "a", "1.5"})
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("xlen", "x")), 1)
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("xclaim", "x", "g", "bob", "0", "1-0", "justid")),
+		[]string{"1-0"})
+	// last id is restored even if the last entry has been deleted
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("xadd", "x", "2-0", "c", "3")),
+		"ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("xlen", "e")), 0)
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("xadd", "e", "5-*", "a", "1")), "5-1")
+}
+
+func TestRewriteAofKeepsExpiring(t *testing.T) {
+	config.Properties.AppendOnly = true
+	config.Properties.AppendFilename = filepath.Join(t.TempDir(), "a.aof")
+	config.Properties.AppendFsync = "always"
+	defer func() {
+		config.Properties.AppendOnly = false
+	}()
+
+	server := NewStandaloneServer()
+	defer server.Close()
+	c := conn.NewFakeConn()
+	server.Exec(c, utils.ToCmdLine("set", "k", "v", "ex", "1"))
+	// replaying aof must not replace the task deleting k of the live db
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("rewriteaof")), "OK")
+	db, _ := server.selectDB(0)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, exists := db.data.Get("k"); !exists {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expired key is not deleted actively")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"ringodis/interface/resp"
+	"ringodis/resp/reply"
+	"strconv"
+	"strings"
+)
+
+// execAuth authenticates connection: AUTH [username] password
+func execAuth(c resp.Connection, args CmdArgs) resp.Reply {
+	var username, password string
+	switch len(args) {
+	case 1:
+		if user := acl.getUser(defaultUser); user != nil && user.nopass {
+			return reply.MakeErrReply("ERR AUTH <password> called without any password configured for the default user. " +
+				"Are you sure your configuration is correct?")
+		}
+		username, password = defaultUser, string(args[0])
+	case 2:
+		username, password = string(args[0]), string(args[1])
+	default:
+		return reply.MakeArgNumErrReply("auth")
+	}
+	if errReply := authenticate(c, username, password); errReply != nil {
+		return errReply
+	}
+	c.SetAuthenticated(true)
+	c.SetUser(username)
+	return reply.MakeOkReply()
+}
+
+// execHello handshakes with client: HELLO [protover [AUTH username password] [SETNAME clientname]],
+// only RESP2 is supported and client name is not kept
+func execHello(server *Server, c resp.Connection, args CmdArgs) resp.Reply {
+	if len(args) > 0 {
+		protover, err := strconv.Atoi(string(args[0]))
+		if err != nil {
+			return reply.MakeErrReply("ERR Protocol version is not an integer or out of range")
+		}
+		if protover != 2 {
+			return reply.MakeErrReply("NOPROTO unsupported protocol version")
+		}
+		args = args[1:]
+	}
+	username := ""
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "auth":
+			if i+2 >= len(args) {
+				return reply.MakeErrReply("ERR Syntax error in HELLO option 'auth'")
+			}
+			if errReply := authenticate(c, string(args[i+1]), string(args[i+2])); errReply != nil {
+				return errReply
+			}
+			username = string(args[i+1])
+			i += 2
+		case "setname":
+			if i+1 >= len(args) {
+				return reply.MakeErrReply("ERR Syntax error in HELLO option 'setname'")
+			}
+			i++
+		default:
+			return reply.MakeErrReply("ERR Syntax error in HELLO option '" + string(args[i]) + "'")
+		}
+	}
+	if username != "" {
+		c.SetAuthenticated(true)
+		c.SetUser(username)
+	} else if !isAuthenticated(c) {
+		return reply.MakeErrReply("NOAUTH HELLO must be called with the client already authenticated, " +
+			"otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client " +
+			"and select the RESP protocol version at the same time")
+	}
+	role := "master"
+	if server.repl.isReplica() {
+		role = "replica"
+	}
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte("server")), reply.MakeBulkReply([]byte("redis")),
+		reply.MakeBulkReply([]byte("version")), reply.MakeBulkReply([]byte("7.0.0")),
+		reply.MakeBulkReply([]byte("proto")), reply.MakeIntReply(2),
+		reply.MakeBulkReply([]byte("mode")), reply.MakeBulkReply([]byte("standalone")),
+		reply.MakeBulkReply([]byte("role")), reply.MakeBulkReply([]byte(role)),
+		reply.MakeBulkReply([]byte("modules")), reply.MakeEmptyMultiBulkReply(),
+	})
+}
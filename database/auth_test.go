@@ -0,0 +1,50 @@
+package database
+
+import (
+	"ringodis/config"
+	"ringodis/lib/utils"
+	"ringodis/resp/conn"
+	"ringodis/resp/reply/asserts"
+	"strings"
+	"testing"
+)
+
+func TestAuth(t *testing.T) {
+	server := NewStandaloneServer()
+	defer server.Close()
+	c := conn.NewFakeConn()
+	c.SetAuthenticated(false)
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("auth", "a")),
+		"ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("ping")), "PONG")
+
+	config.Properties.RequirePass = "pass"
+	defer func() {
+		config.Properties.RequirePass = ""
+		_ = setupACL()
+	}()
+	// requirepass is the password of default user made on startup
+	server = NewStandaloneServer()
+	defer server.Close()
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("flushdb")), "NOAUTH Authentication required.")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("auth", "wrong")),
+		"WRONGPASS invalid username-password pair or user is disabled.")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("auth", "user", "pass")),
+		"WRONGPASS invalid username-password pair or user is disabled.")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("auth")), "ERR wrong number of arguments for 'auth' command")
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("auth", "pass")), "OK")
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("set", "k", "v")), "OK")
+
+	c = conn.NewFakeConn()
+	c.SetAuthenticated(false)
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("hello", "3")), "NOPROTO unsupported protocol version")
+	if res := server.Exec(c, utils.ToCmdLine("hello", "2")); !strings.HasPrefix(string(res.ToBytes()), "-NOAUTH") {
+		t.Errorf("expected NOAUTH, actual %s", res.ToBytes())
+	}
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("hello", "2", "auth", "default", "wrong")),
+		"WRONGPASS invalid username-password pair or user is disabled.")
+	if res := server.Exec(c, utils.ToCmdLine("hello", "2", "auth", "default", "pass", "setname", "x")); !strings.HasPrefix(string(res.ToBytes()), "*12\r\n") {
+		t.Errorf("unexpected reply %s", res.ToBytes())
+	}
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("get", "k")), "v")
+}
@@ -0,0 +1,648 @@
+package database
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"ringodis/ds/stream"
+	"ringodis/interface/resp"
+	"ringodis/lib/timewheel"
+	"ringodis/lib/utils"
+	"ringodis/resp/reply"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// commands which block the client until the list, sorted set or stream is ready,
+// inside `multi` they are executed without blocking, see txBlockingCommands
+var blockingCommands = map[string]func(db *DB, c resp.Connection, args CmdArgs) resp.Reply{
+	"blpop":      execBLPop,
+	"brpop":      execBRPop,
+	"blmove":     execBLMove,
+	"brpoplpush": execBRPopLPush,
+	"bzpopmin":   execBZPopMin,
+	"bzpopmax":   execBZPopMax,
+	"bzmpop":     execBZMPop,
+	"xread":      execBlockingXRead,
+	"xreadgroup": execBlockingXReadGroup,
+}
+
+// blockingPrepares find keys of blocking commands which are not registered in cmdTable
+var blockingPrepares = map[string]PreFunc{
+	"blpop":      writeKeysBeforeTimeout,
+	"brpop":      writeKeysBeforeTimeout,
+	"blmove":     writeFirstTwoKeys,
+	"brpoplpush": writeFirstTwoKeys,
+	"bzpopmin":   writeKeysBeforeTimeout,
+	"bzpopmax":   writeKeysBeforeTimeout,
+	"bzmpop":     prepareBZMPop,
+}
+
+// txBlockingCommand executes a blocking command in transaction, like redis it never blocks
+type txBlockingCommand struct {
+	arity int
+	// resolve picks the non-blocking command to execute according to current data, related keys are locked by caller.
+	// It returns the command line with a function converting its reply, the function may be nil if no conversion is needed,
+	// or returns the reply directly if there is nothing to execute, e.g. all lists are empty
+	resolve func(db *DB, args CmdArgs) (CmdLine, func(resp.Reply) resp.Reply, resp.Reply)
+}
+
+var txBlockingCommands = map[string]*txBlockingCommand{
+	"blpop":      {arity: -3, resolve: resolveListPop(true)},
+	"brpop":      {arity: -3, resolve: resolveListPop(false)},
+	"blmove":     {arity: 6, resolve: resolveBLMove},
+	"brpoplpush": {arity: 4, resolve: resolveBRPopLPush},
+	"bzpopmin":   {arity: -3, resolve: resolveSortedSetPop(false)},
+	"bzpopmax":   {arity: -3, resolve: resolveSortedSetPop(true)},
+	"bzmpop":     {arity: -5, resolve: resolveBZMPop},
+}
+
+// writeKeysBeforeTimeout returns keys of commands like `BLPOP key [key ...] timeout`
+func writeKeysBeforeTimeout(args CmdArgs) ([]string, []string) {
+	return writeAllKeys(args[:len(args)-1])
+}
+
+func prepareBZMPop(args CmdArgs) ([]string, []string) {
+	return prepareZMPop(args[1:])
+}
+
+// waiter is a client blocked by list, sorted set or stream commands
+type waiter struct {
+	conn resp.Connection
+	keys []string
+	// position in the waiting queue of every key
+	elements map[string]*list.Element
+	// notified when any of keys may become ready
+	wakeUp chan struct{}
+	// closed when the client disconnected or timeout
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func newWaiter(c resp.Connection, keys []string) *waiter {
+	return &waiter{
+		conn:     c,
+		keys:     keys,
+		elements: make(map[string]*list.Element, len(keys)),
+		wakeUp:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+func (w *waiter) notify() {
+	select {
+	case w.wakeUp <- struct{}{}:
+	default:
+	}
+}
+
+func (w *waiter) finish() {
+	w.doneOnce.Do(func() {
+		close(w.done)
+	})
+}
+
+// blockingRegistry holds clients waiting for lists, waiters of the same key are served in FIFO order
+type blockingRegistry struct {
+	mu sync.Mutex
+	// key -> waiting queue of *waiter
+	queues  map[string]*list.List
+	waiters map[resp.Connection]*waiter
+}
+
+func makeBlockingRegistry() *blockingRegistry {
+	return &blockingRegistry{
+		queues:  make(map[string]*list.List),
+		waiters: make(map[resp.Connection]*waiter),
+	}
+}
+
+// register puts waiter at the end of queues of its keys, returns false if the client has been closed
+func (r *blockingRegistry) register(w *waiter) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// checked under registry lock, so that cancel won't miss a waiter registered concurrently
+	if w.conn.IsClosed() {
+		return false
+	}
+	for _, key := range w.keys {
+		if _, ok := w.elements[key]; ok {
+			continue
+		}
+		queue, ok := r.queues[key]
+		if !ok {
+			queue = list.New()
+			r.queues[key] = queue
+		}
+		w.elements[key] = queue.PushBack(w)
+	}
+	r.waiters[w.conn] = w
+	return true
+}
+
+// unregister removes waiter from queues, and wakes up the next waiter of its keys
+func (r *blockingRegistry) unregister(w *waiter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, element := range w.elements {
+		queue := r.queues[key]
+		queue.Remove(element)
+		if queue.Len() == 0 {
+			delete(r.queues, key)
+		} else {
+			queue.Front().Value.(*waiter).notify()
+		}
+	}
+	w.elements = make(map[string]*list.Element)
+	if r.waiters[w.conn] == w {
+		delete(r.waiters, w.conn)
+	}
+}
+
+// signal wakes up the first waiter of the key
+func (r *blockingRegistry) signal(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if queue, ok := r.queues[key]; ok {
+		queue.Front().Value.(*waiter).notify()
+	}
+}
+
+// broadcast wakes up all waiters of the key, used by streams whose entries are not consumed by readers
+func (r *blockingRegistry) broadcast(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if queue, ok := r.queues[key]; ok {
+		for e := queue.Front(); e != nil; e = e.Next() {
+			e.Value.(*waiter).notify()
+		}
+	}
+}
+
+// signalKey wakes up waiters of the key whose value is replaced as a whole, e.g. by RENAME or RESTORE
+func (db *DB) signalKey(key string) {
+	entity, ok := db.GetEntity(key)
+	if !ok {
+		return
+	}
+	if _, isStream := entity.Data.(*stream.Stream); isStream {
+		db.blocking.broadcast(key)
+		return
+	}
+	db.blocking.signal(key)
+}
+
+// isFirst tells whether the waiter could be served on the key, that is no earlier waiter is waiting for it
+func (r *blockingRegistry) isFirst(key string, w *waiter) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	queue, ok := r.queues[key]
+	return !ok || queue.Front().Value.(*waiter) == w
+}
+
+// cancel stops the waiter of the given client
+func (r *blockingRegistry) cancel(c resp.Connection) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if w, ok := r.waiters[c]; ok {
+		w.finish()
+	}
+}
+
+// parseTimeout parses timeout in seconds, 0 means blocking forever
+func parseTimeout(arg []byte) (time.Duration, reply.ErrorReply) {
+	timeout, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(timeout) || math.IsInf(timeout, 0) {
+		return 0, reply.MakeErrReply("ERR timeout is not a float or out of range")
+	}
+	if timeout < 0 {
+		return 0, reply.MakeErrReply("ERR timeout is negative")
+	}
+	return time.Duration(timeout * float64(time.Second)), nil
+}
+
+// blockUntil repeatedly calls try until it succeeds, or timeout, or the client disconnected
+// try is invoked with the waiter, and returns nil if none of keys is ready
+func (db *DB) blockUntil(c resp.Connection, keys []string, timeout time.Duration, try func(w *waiter) resp.Reply) resp.Reply {
+	w := newWaiter(c, keys)
+	if result := try(w); result != nil {
+		return result
+	}
+	if !db.blocking.register(w) {
+		return nil
+	}
+	defer db.blocking.unregister(w)
+	if timeout > 0 {
+		taskKey := fmt.Sprintf("blocking:%p", w)
+		timewheel.Delay(timeout, taskKey, w.finish)
+		defer timewheel.Cancel(taskKey)
+	}
+	for {
+		// try again after registered, elements may be pushed before registering
+		if result := try(w); result != nil {
+			return result
+		}
+		select {
+		case <-w.wakeUp:
+		case <-w.done:
+			return nil
+		}
+	}
+}
+
+// popFirstReady pops from the first non-empty key that no earlier waiter is waiting for,
+// ready tells whether the key holds a non-empty collection, pop is called with the locks held
+func (db *DB) popFirstReady(w *waiter, ready func(key string) (bool, reply.ErrorReply), pop func(key string) resp.Reply) resp.Reply {
+	db.RWLocks(w.keys, nil)
+	defer db.RWUnLocks(w.keys, nil)
+	for _, key := range w.keys {
+		ok, errReply := ready(key)
+		if errReply != nil {
+			return errReply
+		}
+		if !ok || !db.blocking.isFirst(key, w) {
+			continue
+		}
+		return pop(key)
+	}
+	return nil
+}
+
+func (db *DB) isListReady(key string) (bool, reply.ErrorReply) {
+	list, errReply := db.getAsList(key)
+	return list != nil, errReply
+}
+
+func (db *DB) isSortedSetReady(key string) (bool, reply.ErrorReply) {
+	zs, errReply := db.getAsSortedSet(key)
+	return zs != nil, errReply
+}
+
+// popList pops an element from the list, it is recorded as non-blocking command, so that replaying aof never blocks
+func (db *DB) popList(key string, left bool) resp.Reply {
+	cmdName := "rpop"
+	if left {
+		cmdName = "lpop"
+	}
+	result := db.execWithLock(cmdTable[cmdName], utils.ToCmdLine(cmdName, key))
+	value, ok := result.(*reply.BulkReply)
+	if !ok {
+		return result
+	}
+	return reply.MakeMultiBulkReply([][]byte{[]byte(key), value.Arg})
+}
+
+// popSortedSet pops members from the sorted set as ZPOPMIN or ZPOPMAX does, replies flat list of members and scores
+func (db *DB) popSortedSet(key string, max bool, count int64) resp.Reply {
+	cmdName := "zpopmin"
+	if max {
+		cmdName = "zpopmax"
+	}
+	return db.execWithLock(cmdTable[cmdName], utils.ToCmdLine(cmdName, key, strconv.FormatInt(count, 10)))
+}
+
+func execBlockingPop(db *DB, c resp.Connection, args CmdArgs, left bool) resp.Reply {
+	if len(args) < 2 {
+		if left {
+			return reply.MakeArgNumErrReply("blpop")
+		}
+		return reply.MakeArgNumErrReply("brpop")
+	}
+	timeout, errReply := parseTimeout(args[len(args)-1])
+	if errReply != nil {
+		return errReply
+	}
+	keys := make([]string, len(args)-1)
+	for i, arg := range args[:len(args)-1] {
+		keys[i] = string(arg)
+	}
+	result := db.blockUntil(c, keys, timeout, func(w *waiter) resp.Reply {
+		return db.popFirstReady(w, db.isListReady, func(key string) resp.Reply {
+			return db.popList(key, left)
+		})
+	})
+	if result == nil {
+		return reply.MakeNullMultiBulkReply()
+	}
+	return result
+}
+
+// execBLPop removes and returns the first element of the first non-empty list, blocks if all lists are empty
+// BLPOP key [key ...] timeout
+func execBLPop(db *DB, c resp.Connection, args CmdArgs) resp.Reply {
+	return execBlockingPop(db, c, args, true)
+}
+
+// execBRPop removes and returns the last element of the first non-empty list, blocks if all lists are empty
+// BRPOP key [key ...] timeout
+func execBRPop(db *DB, c resp.Connection, args CmdArgs) resp.Reply {
+	return execBlockingPop(db, c, args, false)
+}
+
+func (db *DB) blockingMove(c resp.Connection, src, dest string, from, to []byte, timeout time.Duration) resp.Reply {
+	writerKeys := []string{src, dest}
+	result := db.blockUntil(c, []string{src}, timeout, func(w *waiter) resp.Reply {
+		db.RWLocks(writerKeys, nil)
+		defer db.RWUnLocks(writerKeys, nil)
+		list, errReply := db.getAsList(src)
+		if errReply != nil {
+			return errReply
+		}
+		if list == nil || !db.blocking.isFirst(src, w) {
+			return nil
+		}
+		return db.execWithLock(cmdTable["lmove"], utils.ToCmdLine3("LMove", []byte(src), []byte(dest), from, to))
+	})
+	if result == nil {
+		return reply.MakeNullBulkReply()
+	}
+	return result
+}
+
+// execBLMove pops an element from source and pushes it to destination, blocks if source is empty
+// BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout
+func execBLMove(db *DB, c resp.Connection, args CmdArgs) resp.Reply {
+	if len(args) != 5 {
+		return reply.MakeArgNumErrReply("blmove")
+	}
+	if _, ok := parseDirection(args[2]); !ok {
+		return reply.MakeSyntaxErrReply()
+	}
+	if _, ok := parseDirection(args[3]); !ok {
+		return reply.MakeSyntaxErrReply()
+	}
+	timeout, errReply := parseTimeout(args[4])
+	if errReply != nil {
+		return errReply
+	}
+	return db.blockingMove(c, string(args[0]), string(args[1]), args[2], args[3], timeout)
+}
+
+// execBRPopLPush pops the last element of source and pushes it to the head of destination, blocks if source is empty
+// BRPOPLPUSH source destination timeout
+func execBRPopLPush(db *DB, c resp.Connection, args CmdArgs) resp.Reply {
+	if len(args) != 3 {
+		return reply.MakeArgNumErrReply("brpoplpush")
+	}
+	timeout, errReply := parseTimeout(args[2])
+	if errReply != nil {
+		return errReply
+	}
+	return db.blockingMove(c, string(args[0]), string(args[1]), []byte("RIGHT"), []byte("LEFT"), timeout)
+}
+
+func execBlockingZPop(db *DB, c resp.Connection, args CmdArgs, max bool) resp.Reply {
+	if len(args) < 2 {
+		if max {
+			return reply.MakeArgNumErrReply("bzpopmax")
+		}
+		return reply.MakeArgNumErrReply("bzpopmin")
+	}
+	timeout, errReply := parseTimeout(args[len(args)-1])
+	if errReply != nil {
+		return errReply
+	}
+	keys := make([]string, len(args)-1)
+	for i, arg := range args[:len(args)-1] {
+		keys[i] = string(arg)
+	}
+	result := db.blockUntil(c, keys, timeout, func(w *waiter) resp.Reply {
+		return db.popFirstReady(w, db.isSortedSetReady, func(key string) resp.Reply {
+			result := db.popSortedSet(key, max, 1)
+			popped, ok := result.(*reply.MultiBulkReply)
+			if !ok {
+				return result
+			}
+			return reply.MakeMultiBulkReply(append([][]byte{[]byte(key)}, popped.Args...))
+		})
+	})
+	if result == nil {
+		return reply.MakeNullMultiBulkReply()
+	}
+	return result
+}
+
+// execBZPopMin removes and returns the member with the lowest score from the first non-empty sorted set,
+// blocks if all sorted sets are empty
+// BZPOPMIN key [key ...] timeout
+func execBZPopMin(db *DB, c resp.Connection, args CmdArgs) resp.Reply {
+	return execBlockingZPop(db, c, args, false)
+}
+
+// execBZPopMax removes and returns the member with the highest score from the first non-empty sorted set,
+// blocks if all sorted sets are empty
+// BZPOPMAX key [key ...] timeout
+func execBZPopMax(db *DB, c resp.Connection, args CmdArgs) resp.Reply {
+	return execBlockingZPop(db, c, args, true)
+}
+
+// execBZMPop is the blocking variant of ZMPOP
+// BZMPOP timeout numkeys key [key ...] MIN|MAX [COUNT count]
+func execBZMPop(db *DB, c resp.Connection, args CmdArgs) resp.Reply {
+	if len(args) < 4 {
+		return reply.MakeArgNumErrReply("bzmpop")
+	}
+	timeout, errReply := parseTimeout(args[0])
+	if errReply != nil {
+		return errReply
+	}
+	keys, max, count, errReply := parseZMPopArgs(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	result := db.blockUntil(c, keys, timeout, func(w *waiter) resp.Reply {
+		return db.popFirstReady(w, db.isSortedSetReady, func(key string) resp.Reply {
+			result := db.popSortedSet(key, max, count)
+			popped, ok := result.(*reply.MultiBulkReply)
+			if !ok {
+				return result
+			}
+			return makeZMPopReply(key, popped.Args)
+		})
+	})
+	if result == nil {
+		return reply.MakeNullMultiBulkReply()
+	}
+	return result
+}
+
+// resolveListPop executes BLPOP or BRPOP as LPOP or RPOP on the first non-empty list
+func resolveListPop(left bool) func(db *DB, args CmdArgs) (CmdLine, func(resp.Reply) resp.Reply, resp.Reply) {
+	cmdName := "rpop"
+	if left {
+		cmdName = "lpop"
+	}
+	return func(db *DB, args CmdArgs) (CmdLine, func(resp.Reply) resp.Reply, resp.Reply) {
+		if _, errReply := parseTimeout(args[len(args)-1]); errReply != nil {
+			return nil, nil, errReply
+		}
+		for _, arg := range args[:len(args)-1] {
+			key := string(arg)
+			ready, errReply := db.isListReady(key)
+			if errReply != nil {
+				return nil, nil, errReply
+			}
+			if !ready {
+				continue
+			}
+			return utils.ToCmdLine(cmdName, key), func(result resp.Reply) resp.Reply {
+				value, ok := result.(*reply.BulkReply)
+				if !ok {
+					return result
+				}
+				return reply.MakeMultiBulkReply([][]byte{[]byte(key), value.Arg})
+			}, nil
+		}
+		return nil, nil, reply.MakeNullMultiBulkReply()
+	}
+}
+
+func resolveListMove(db *DB, src, dest []byte, from, to []byte, timeout []byte) (CmdLine, func(resp.Reply) resp.Reply, resp.Reply) {
+	if _, errReply := parseTimeout(timeout); errReply != nil {
+		return nil, nil, errReply
+	}
+	ready, errReply := db.isListReady(string(src))
+	if errReply != nil {
+		return nil, nil, errReply
+	}
+	if !ready {
+		return nil, nil, reply.MakeNullBulkReply()
+	}
+	return utils.ToCmdLine3("LMove", src, dest, from, to), nil, nil
+}
+
+// resolveBLMove executes BLMOVE as LMOVE if source is not empty
+func resolveBLMove(db *DB, args CmdArgs) (CmdLine, func(resp.Reply) resp.Reply, resp.Reply) {
+	if _, ok := parseDirection(args[2]); !ok {
+		return nil, nil, reply.MakeSyntaxErrReply()
+	}
+	if _, ok := parseDirection(args[3]); !ok {
+		return nil, nil, reply.MakeSyntaxErrReply()
+	}
+	return resolveListMove(db, args[0], args[1], args[2], args[3], args[4])
+}
+
+// resolveBRPopLPush executes BRPOPLPUSH as LMOVE if source is not empty
+func resolveBRPopLPush(db *DB, args CmdArgs) (CmdLine, func(resp.Reply) resp.Reply, resp.Reply) {
+	return resolveListMove(db, args[0], args[1], []byte("RIGHT"), []byte("LEFT"), args[2])
+}
+
+// resolveSortedSetPop executes BZPOPMIN or BZPOPMAX as ZPOPMIN or ZPOPMAX on the first non-empty sorted set
+func resolveSortedSetPop(max bool) func(db *DB, args CmdArgs) (CmdLine, func(resp.Reply) resp.Reply, resp.Reply) {
+	cmdName := "zpopmin"
+	if max {
+		cmdName = "zpopmax"
+	}
+	return func(db *DB, args CmdArgs) (CmdLine, func(resp.Reply) resp.Reply, resp.Reply) {
+		if _, errReply := parseTimeout(args[len(args)-1]); errReply != nil {
+			return nil, nil, errReply
+		}
+		for _, arg := range args[:len(args)-1] {
+			key := string(arg)
+			ready, errReply := db.isSortedSetReady(key)
+			if errReply != nil {
+				return nil, nil, errReply
+			}
+			if !ready {
+				continue
+			}
+			return utils.ToCmdLine(cmdName, key, "1"), func(result resp.Reply) resp.Reply {
+				popped, ok := result.(*reply.MultiBulkReply)
+				if !ok {
+					return result
+				}
+				return reply.MakeMultiBulkReply(append([][]byte{[]byte(key)}, popped.Args...))
+			}, nil
+		}
+		return nil, nil, reply.MakeNullMultiBulkReply()
+	}
+}
+
+// resolveBZMPop executes BZMPOP as ZMPOP
+func resolveBZMPop(db *DB, args CmdArgs) (CmdLine, func(resp.Reply) resp.Reply, resp.Reply) {
+	if _, errReply := parseTimeout(args[0]); errReply != nil {
+		return nil, nil, errReply
+	}
+	return append(utils.ToCmdLine("zmpop"), args[1:]...), nil, nil
+}
+
+// resolveStreamIDs replaces `$` with the last id of stream, so that entries added afterwards are returned,
+// returns false if the command should not block, like reading history of consumer or the group not exists
+func (db *DB) resolveStreamIDs(options *readOptions) ([][]byte, bool) {
+	db.RWLocks(nil, options.keys)
+	defer db.RWUnLocks(nil, options.keys)
+	ids := make([][]byte, len(options.ids))
+	for i, key := range options.keys {
+		ids[i] = options.ids[i]
+		s, errReply := db.getAsStream(key)
+		if errReply != nil {
+			return nil, false
+		}
+		if options.group != "" {
+			if string(ids[i]) != ">" || s == nil || s.GetGroup(options.group) == nil {
+				return nil, false
+			}
+			continue
+		}
+		if string(ids[i]) == "$" {
+			lastID := stream.MinID
+			if s != nil {
+				lastID = s.LastID()
+			}
+			ids[i] = []byte(lastID.String())
+		}
+	}
+	return ids, true
+}
+
+// execStreamRead executes XREAD or XREADGROUP, blocks only if BLOCK option is given
+func execStreamRead(db *DB, c resp.Connection, cmdName string, args CmdArgs) resp.Reply {
+	cmd := cmdTable[cmdName]
+	cmdLine := append(utils.ToCmdLine(cmdName), args...)
+	if !validateArity(cmd.arity, cmdLine) {
+		return reply.MakeArgNumErrReply(cmdName)
+	}
+	options, errReply := parseReadOptions(cmdName, args)
+	if errReply != nil {
+		return errReply
+	}
+	if !options.blocking {
+		return db.execRegularCommand(cmdLine)
+	}
+	ids, blocking := db.resolveStreamIDs(options)
+	if !blocking {
+		return db.execRegularCommand(options.toCmdLine(cmdName, options.ids))
+	}
+	writerKeys, readerKeys := cmd.prepare(args)
+	nonBlockingCmdLine := options.toCmdLine(cmdName, ids)
+	result := db.blockUntil(c, options.keys, options.timeout, func(w *waiter) resp.Reply {
+		db.RWLocks(writerKeys, readerKeys)
+		defer db.RWUnLocks(writerKeys, readerKeys)
+		ready, errReply := db.isStreamReady(options, ids)
+		if errReply != nil {
+			return errReply
+		}
+		if !ready {
+			return nil
+		}
+		// recorded as non-blocking command, so that replaying aof never blocks
+		return db.execWithLock(cmd, nonBlockingCmdLine)
+	})
+	if result == nil {
+		return reply.MakeNullMultiBulkReply()
+	}
+	return result
+}
+
+// execBlockingXRead reads entries from streams, blocks if BLOCK option is given and no entry is available
+// XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+func execBlockingXRead(db *DB, c resp.Connection, args CmdArgs) resp.Reply {
+	return execStreamRead(db, c, "xread", args)
+}
+
+// execBlockingXReadGroup reads entries as a consumer of group, blocks if BLOCK option is given and no new entry is available
+// XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+func execBlockingXReadGroup(db *DB, c resp.Connection, args CmdArgs) resp.Reply {
+	return execStreamRead(db, c, "xreadgroup", args)
+}
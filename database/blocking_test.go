@@ -0,0 +1,188 @@
+package database
+
+import (
+	"ringodis/interface/resp"
+	"ringodis/lib/utils"
+	"ringodis/resp/conn"
+	"ringodis/resp/reply"
+	"ringodis/resp/reply/asserts"
+	"testing"
+	"time"
+)
+
+// waitBlocked waits until the given number of clients are blocked in db
+func waitBlocked(t *testing.T, db *DB, n int) {
+	for i := 0; i < 100; i++ {
+		db.blocking.mu.Lock()
+		blocked := len(db.blocking.waiters)
+		db.blocking.mu.Unlock()
+		if blocked == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected %d blocked clients", n)
+}
+
+func execAsync(server *Server, c resp.Connection, args ...string) <-chan resp.Reply {
+	ch := make(chan resp.Reply, 1)
+	go func() {
+		ch <- server.Exec(c, utils.ToCmdLine(args...))
+	}()
+	return ch
+}
+
+func TestBLPop(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	db, _ := server.selectDB(0)
+	c := conn.NewFakeConn()
+	server.Exec(c, utils.ToCmdLine("rpush", "b", "1"))
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("blpop", "a", "b", "0")), []string{"b", "1"})
+
+	c1 := conn.NewFakeConn()
+	c2 := conn.NewFakeConn()
+	ch1 := execAsync(server, c1, "blpop", "a", "b", "0")
+	waitBlocked(t, db, 1)
+	ch2 := execAsync(server, c2, "brpop", "b", "0")
+	waitBlocked(t, db, 2)
+
+	// waiters are served in FIFO order
+	server.Exec(c, utils.ToCmdLine("rpush", "b", "x"))
+	asserts.AssertMultiBulkReply(t, <-ch1, []string{"b", "x"})
+	server.Exec(c, utils.ToCmdLine("lpush", "b", "y", "z"))
+	asserts.AssertMultiBulkReply(t, <-ch2, []string{"b", "y"})
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("lrange", "b", "0", "-1")), []string{"z"})
+	waitBlocked(t, db, 0)
+}
+
+func TestBlockingTimeout(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	c := conn.NewFakeConn()
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("blpop", "a", "-1")), "ERR timeout is negative")
+	result := server.Exec(c, utils.ToCmdLine("blpop", "a", "0.5"))
+	if _, ok := result.(*reply.NullMultiBulkReply); !ok {
+		t.Errorf("expected null multi bulk, actual: %s", result.ToBytes())
+	}
+	asserts.AssertNullBulk(t, server.Exec(c, utils.ToCmdLine("brpoplpush", "a", "b", "0.5")))
+}
+
+func TestBlockingInMulti(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	c := conn.NewFakeConn()
+	server.Exec(c, utils.ToCmdLine("multi"))
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("blpop", "a", "0")), "QUEUED")
+	server.Exec(c, utils.ToCmdLine("rpush", "a", "1", "2", "3"))
+	server.Exec(c, utils.ToCmdLine("blpop", "a", "0"))
+	server.Exec(c, utils.ToCmdLine("blmove", "a", "b", "right", "left", "0"))
+	server.Exec(c, utils.ToCmdLine("brpoplpush", "none", "b", "0"))
+	server.Exec(c, utils.ToCmdLine("zadd", "z", "1", "x"))
+	server.Exec(c, utils.ToCmdLine("bzpopmin", "z", "0"))
+	server.Exec(c, utils.ToCmdLine("bzmpop", "0", "1", "z", "min"))
+	server.Exec(c, utils.ToCmdLine("blpop", "a", "-1"))
+	result := server.Exec(c, utils.ToCmdLine("exec"))
+	multiRaw, ok := result.(*reply.MultiRawReply)
+	if !ok || len(multiRaw.Replies) != 9 {
+		t.Errorf("expected 9 replies, actual: %s", result.ToBytes())
+		return
+	}
+	// blocking commands return immediately in transaction
+	if _, ok := multiRaw.Replies[0].(*reply.NullMultiBulkReply); !ok {
+		t.Errorf("expected null multi bulk, actual: %s", multiRaw.Replies[0].ToBytes())
+	}
+	asserts.AssertMultiBulkReply(t, multiRaw.Replies[2], []string{"a", "1"})
+	asserts.AssertBulkReply(t, multiRaw.Replies[3], "3")
+	asserts.AssertNullBulk(t, multiRaw.Replies[4])
+	asserts.AssertMultiBulkReply(t, multiRaw.Replies[6], []string{"z", "x", "1"})
+	if _, ok := multiRaw.Replies[7].(*reply.NullMultiBulkReply); !ok {
+		t.Errorf("expected null multi bulk, actual: %s", multiRaw.Replies[7].ToBytes())
+	}
+	asserts.AssertErrReply(t, multiRaw.Replies[8], "ERR timeout is negative")
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("lrange", "a", "0", "-1")), []string{"2"})
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("lrange", "b", "0", "-1")), []string{"3"})
+
+	server.Exec(c, utils.ToCmdLine("multi"))
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("blpop", "a")), "ERR wrong number of arguments for 'blpop' command")
+	server.Exec(c, utils.ToCmdLine("discard"))
+}
+
+func TestBlockingWakeUpByRename(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	db, _ := server.selectDB(0)
+	c := conn.NewFakeConn()
+	c1 := conn.NewFakeConn()
+	ch := execAsync(server, c1, "blpop", "dest", "0")
+	waitBlocked(t, db, 1)
+	server.Exec(c, utils.ToCmdLine("rpush", "src", "a"))
+	server.Exec(c, utils.ToCmdLine("rename", "src", "dest"))
+	asserts.AssertMultiBulkReply(t, <-ch, []string{"dest", "a"})
+
+	server.Exec(c, utils.ToCmdLine("zadd", "src", "1", "x"))
+	payload := server.Exec(c, utils.ToCmdLine("dump", "src")).(*reply.BulkReply).Arg
+	ch = execAsync(server, c1, "bzpopmin", "dest", "0")
+	waitBlocked(t, db, 1)
+	server.Exec(c, utils.ToCmdLine3("restore", []byte("dest"), []byte("0"), payload))
+	asserts.AssertMultiBulkReply(t, <-ch, []string{"dest", "x", "1"})
+	waitBlocked(t, db, 0)
+}
+
+func TestBLMoveAndClose(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	db, _ := server.selectDB(0)
+	c := conn.NewFakeConn()
+	c1 := conn.NewFakeConn()
+	ch := execAsync(server, c1, "blmove", "src", "dest", "right", "left", "0")
+	waitBlocked(t, db, 1)
+	server.Exec(c, utils.ToCmdLine("rpush", "src", "a", "b"))
+	asserts.AssertBulkReply(t, <-ch, "b")
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("lrange", "dest", "0", "-1")), []string{"b"})
+
+	// blocked client is released after it is closed
+	ch = execAsync(server, c1, "blpop", "empty", "0")
+	waitBlocked(t, db, 1)
+	server.AfterClientClose(c1)
+	result := <-ch
+	if _, ok := result.(*reply.NullMultiBulkReply); !ok {
+		t.Errorf("expected null multi bulk, actual: %s", result.ToBytes())
+	}
+	waitBlocked(t, db, 0)
+}
+
+func TestBZPop(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	db, _ := server.selectDB(0)
+	c := conn.NewFakeConn()
+	server.Exec(c, utils.ToCmdLine("zadd", "b", "1", "x", "2", "y"))
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("bzpopmin", "a", "b", "0")), []string{"b", "x", "1"})
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("bzpopmax", "b", "0")), []string{"b", "y", "2"})
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("exists", "b")), 0)
+
+	c1 := conn.NewFakeConn()
+	c2 := conn.NewFakeConn()
+	ch1 := execAsync(server, c1, "bzpopmax", "a", "b", "0")
+	waitBlocked(t, db, 1)
+	ch2 := execAsync(server, c2, "bzmpop", "0", "1", "b", "min", "count", "5")
+	waitBlocked(t, db, 2)
+
+	server.Exec(c, utils.ToCmdLine("zadd", "b", "1", "x", "2", "y"))
+	asserts.AssertMultiBulkReply(t, <-ch1, []string{"b", "y", "2"})
+	result := <-ch2
+	if string(result.ToBytes()) != "*2\r\n$1\r\nb\r\n*1\r\n*2\r\n$1\r\nx\r\n$1\r\n1\r\n" {
+		t.Errorf("unexpected bzmpop reply: %s", result.ToBytes())
+	}
+	waitBlocked(t, db, 0)
+
+	result = server.Exec(c, utils.ToCmdLine("bzpopmin", "a", "0.2"))
+	if _, ok := result.(*reply.NullMultiBulkReply); !ok {
+		t.Errorf("expected null multi bulk, actual: %s", result.ToBytes())
+	}
+
+	// blocked client is released after it is closed
+	ch1 = execAsync(server, c1, "bzpopmin", "empty", "0")
+	waitBlocked(t, db, 1)
+	server.AfterClientClose(c1)
+	result = <-ch1
+	if _, ok := result.(*reply.NullMultiBulkReply); !ok {
+		t.Errorf("expected null multi bulk, actual: %s", result.ToBytes())
+	}
+	waitBlocked(t, db, 0)
+}
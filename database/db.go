@@ -9,6 +9,8 @@ import (
 	"ringodis/lib/timewheel"
 	"ringodis/resp/reply"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -25,11 +27,52 @@ type DB struct {
 	data dict.Dict
 	// key -> expireTime (time.Time)
 	ttlMap dict.Dict
+	// versions of watched keys, increased by every modification
+	watches *watchRegistry
 
 	// use locker for complicated command only, e.g. rpush, incr ...
 	locker *lock.Locks
+	// gate is held in shared mode while commands are executed, it is shared by all dbs of a server,
+	// taking it exclusively pauses all commands, e.g. to take a consistent snapshot for replication.
+	// It is always taken after key locks, so that waiting for keys never holds the gate
+	gate *sync.RWMutex
+
+	// addAof appends executed write commands to the aof file
+	addAof func(CmdLine)
+	// number of write commands executed, used to trigger rdb saving
+	changes int64
+
+	// clients blocked by list commands
+	blocking *blockingRegistry
+
+	// expirer deletes keys when they expire, temporary dbs use noopExpirer to leave the global timewheel alone
+	expirer expirer
+}
+
+// expirer schedules tasks deleting expired keys
+type expirer interface {
+	At(at time.Time, key string, job func())
+	Cancel(key string)
+}
+
+// timewheelExpirer schedules tasks in the global timewheel, task keys are shared by all dbs
+type timewheelExpirer struct{}
+
+func (timewheelExpirer) At(at time.Time, key string, job func()) {
+	timewheel.At(at, key, job)
+}
+
+func (timewheelExpirer) Cancel(key string) {
+	timewheel.Cancel(key)
 }
 
+// noopExpirer never deletes keys actively, expired keys are skipped by reads
+type noopExpirer struct{}
+
+func (noopExpirer) At(at time.Time, key string, job func()) {}
+
+func (noopExpirer) Cancel(key string) {}
+
 // ExecFunc is interface for command executor
 type ExecFunc func(db *DB, args CmdArgs) resp.Reply
 
@@ -45,15 +88,26 @@ type PreFunc func(args CmdArgs) ([]string, []string)
 
 func makeDB() *DB {
 	return &DB{
-		data:   dict.MakeConcurrent(dataDictSize),
-		ttlMap: dict.MakeConcurrent(ttlDictSize),
-		locker: lock.Make(lockerSize),
+		data:     dict.MakeConcurrent(dataDictSize),
+		ttlMap:   dict.MakeConcurrent(ttlDictSize),
+		watches:  makeWatchRegistry(),
+		locker:   lock.Make(lockerSize),
+		gate:     &sync.RWMutex{},
+		addAof:   func(line CmdLine) {},
+		blocking: makeBlockingRegistry(),
+		expirer:  timewheelExpirer{},
 	}
 }
 
 // Exec executes command within one database
 func (db *DB) Exec(c resp.Connection, cmdLine CmdLine) resp.Reply {
-
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	if c != nil && c.InMultiState() {
+		return enqueueCmd(c, cmdLine)
+	}
+	if blockingCmd, ok := blockingCommands[cmdName]; ok && c != nil {
+		return blockingCmd(db, c, cmdLine[1:])
+	}
 	return db.execRegularCommand(cmdLine)
 }
 
@@ -66,11 +120,67 @@ func (db *DB) execRegularCommand(cmdLine CmdLine) resp.Reply {
 	if !validateArity(cmd.arity, cmdLine) {
 		return reply.MakeArgNumErrReply(cmdName)
 	}
-    
-    writerKeys, readerKeys := cmd.prepare(cmdLine[1:])
-    db.RWLocks(writerKeys, readerKeys)
-    defer db.RWUnLocks(writerKeys, readerKeys)
-	return cmd.executor(db, cmdLine[1:])
+
+	writerKeys, readerKeys := cmd.prepare(cmdLine[1:])
+	db.RWLocks(writerKeys, readerKeys)
+	defer db.RWUnLocks(writerKeys, readerKeys)
+	return db.execWithLock(cmd, cmdLine)
+}
+
+// execWithLock executes a validated command, related keys must be locked by caller
+func (db *DB) execWithLock(cmd *command, cmdLine CmdLine) resp.Reply {
+	missing := db.missingWriterKeys(cmd, cmdLine)
+	res := cmd.executor(db, cmdLine[1:])
+	if !reply.IsErrorReply(res) {
+		db.afterExec(cmd, cmdLine, missing)
+	}
+	return res
+}
+
+// missingWriterKeys returns keys written by the command which don't exist before execution,
+// they are not modified by the command if they still don't exist after it
+func (db *DB) missingWriterKeys(cmd *command, cmdLine CmdLine) map[string]struct{} {
+	if cmd.flags&flagWrite == 0 {
+		return nil
+	}
+	writerKeys, _ := cmd.prepare(cmdLine[1:])
+	missing := make(map[string]struct{})
+	for _, key := range writerKeys {
+		if _, exists := db.data.Get(key); !exists {
+			missing[key] = struct{}{}
+		}
+	}
+	return missing
+}
+
+// afterExec records a successfully executed write command,
+// versions of keys are increased unless they are missing both before and after execution, e.g. DEL a missing key
+func (db *DB) afterExec(cmd *command, cmdLine CmdLine, missing map[string]struct{}) {
+	if cmd.flags&flagWrite == 0 {
+		return
+	}
+	writerKeys, _ := cmd.prepare(cmdLine[1:])
+	for _, key := range writerKeys {
+		if _, wasMissing := missing[key]; wasMissing {
+			if _, exists := db.data.Get(key); !exists {
+				continue
+			}
+		}
+		db.addVersion(key)
+	}
+	atomic.AddInt64(&db.changes, 1)
+	db.appendAof(cmdLine, writerKeys)
+}
+
+// appendAof appends a successful write command to aof,
+// relative ttl set by the command is recorded as absolute time, so that replaying won't extend it
+func (db *DB) appendAof(cmdLine CmdLine, writerKeys []string) {
+	db.addAof(cmdLine)
+	for _, key := range writerKeys {
+		if raw, ok := db.ttlMap.Get(key); ok {
+			db.addAof(makeExpireCmd(key, raw.(time.Time)))
+		}
+	}
 }
 
 func validateArity(arity int, cmdLine CmdLine) bool {
@@ -112,7 +222,7 @@ func (db *DB) PutIfAbsent(key string, entity *database.DataEntity) int {
 func (db *DB) Remove(key string) {
 	db.data.Remove(key)
 	db.ttlMap.Remove(key)
-	timewheel.Cancel(genExpireTask(key))
+	db.expirer.Cancel(genExpireTask(key))
 }
 
 // Removes the given keys from db
@@ -127,11 +237,31 @@ func (db *DB) Removes(keys ...string) (deleted int) {
 	return deleted
 }
 
-// Flush clean database
+// Flush clean database, versions of existing watched keys are increased so that watchers will notice
 func (db *DB) Flush() {
+	for _, key := range db.watches.watchedKeys() {
+		if _, exists := db.data.Get(key); exists {
+			db.addVersion(key)
+		}
+	}
 	db.data.Clear()
 	db.ttlMap.Clear()
-	db.locker = lock.Make(lockerSize)
+}
+
+// ForEach traverses all the unexpired keys in db
+func (db *DB) ForEach(cb func(key string, entity *database.DataEntity, expiration *time.Time) bool) {
+	db.data.ForEach(func(key string, raw interface{}) bool {
+		entity, _ := raw.(*database.DataEntity)
+		var expiration *time.Time
+		if rawExpireTime, ok := db.ttlMap.Get(key); ok {
+			expireTime, _ := rawExpireTime.(time.Time)
+			if time.Now().After(expireTime) {
+				return true
+			}
+			expiration = &expireTime
+		}
+		return cb(key, entity, expiration)
+	})
 }
 
 /* ==== Lock Function ==== */
@@ -139,13 +269,105 @@ func (db *DB) Flush() {
 // RWLocks lock keys for writing and reading
 func (db *DB) RWLocks(writerKeys, readerKeys []string) {
 	db.locker.RWLocks(writerKeys, readerKeys)
+	db.gate.RLock()
 }
 
 // RWUnLocks unlock keys for writing and reading
 func (db *DB) RWUnLocks(writerKeys, readerKeys []string) {
+	db.gate.RUnlock()
 	db.locker.RWUnLocks(writerKeys, readerKeys)
 }
 
+/* ==== Version Functions ==== */
+
+// watchedKey is version of a watched key and clients watching it
+type watchedKey struct {
+	version uint32
+	clients map[resp.Connection]struct{}
+}
+
+// watchRegistry keeps versions of watched keys only, a version is dropped once no client watches the key,
+// so that keys never watched cost nothing
+type watchRegistry struct {
+	mu      sync.Mutex
+	watched map[string]*watchedKey
+	// keys maps client to keys it watches
+	keys map[resp.Connection][]string
+}
+
+func makeWatchRegistry() *watchRegistry {
+	return &watchRegistry{
+		watched: make(map[string]*watchedKey),
+		keys:    make(map[resp.Connection][]string),
+	}
+}
+
+// watch returns current version of key and keeps it until client unwatches, returns false if client is closed
+func (r *watchRegistry) watch(c resp.Connection, key string) (uint32, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// checked under registry lock, so that unwatchAll of closed client won't miss a key watched concurrently
+	if c.IsClosed() {
+		return 0, false
+	}
+	w, ok := r.watched[key]
+	if !ok {
+		w = &watchedKey{clients: make(map[resp.Connection]struct{})}
+		r.watched[key] = w
+	}
+	if _, ok := w.clients[c]; !ok {
+		w.clients[c] = struct{}{}
+		r.keys[c] = append(r.keys[c], key)
+	}
+	return w.version, true
+}
+
+// unwatchAll forgets keys watched by client, versions of keys without other watchers are dropped
+func (r *watchRegistry) unwatchAll(c resp.Connection) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, key := range r.keys[c] {
+		w := r.watched[key]
+		delete(w.clients, c)
+		if len(w.clients) == 0 {
+			delete(r.watched, key)
+		}
+	}
+	delete(r.keys, c)
+}
+
+// watchedKeys returns all watched keys
+func (r *watchRegistry) watchedKeys() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := make([]string, 0, len(r.watched))
+	for key := range r.watched {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// addVersion increases versions of the given keys if they are watched, caller should hold their write locks
+func (db *DB) addVersion(keys ...string) {
+	db.watches.mu.Lock()
+	defer db.watches.mu.Unlock()
+	for _, key := range keys {
+		if w, ok := db.watches.watched[key]; ok {
+			w.version++
+		}
+	}
+}
+
+// GetVersion returns version of the given key, 0 if it is not watched
+func (db *DB) GetVersion(key string) uint32 {
+	db.watches.mu.Lock()
+	defer db.watches.mu.Unlock()
+	if w, ok := db.watches.watched[key]; ok {
+		return w.version
+	}
+	return 0
+}
+
 /* ==== TTL Functions ==== */
 
 func genExpireTask(key string) string {
@@ -161,7 +383,7 @@ func (db *DB) Expire(key string, expireTime time.Time) {
 	db.ttlMap.Put(key, expireTime)
 	taskKey := genExpireTask(key)
 	// set cron job using time wheel, key will be deleted when expire
-	timewheel.At(expireTime, taskKey, func() {
+	db.expirer.At(expireTime, taskKey, func() {
 		keys := []string{key}
 		db.RWLocks(keys, nil)
 		defer db.RWUnLocks(keys, nil)
@@ -174,14 +396,18 @@ func (db *DB) Expire(key string, expireTime time.Time) {
 		expireTime, _ := rawExpireTime.(time.Time)
 		if time.Now().After(expireTime) {
 			db.Remove(key)
+			db.addVersion(key)
+			return
 		}
+		// timewheel rounds delay down to its interval, so the task may run a little early
+		db.Expire(key, expireTime)
 	})
 }
 
 // Persist cancel ttlCmd of a key
 func (db *DB) Persist(key string) {
 	db.ttlMap.Remove(key)
-	timewheel.Cancel(genExpireTask(key))
+	db.expirer.Cancel(genExpireTask(key))
 }
 
 // IsExpired check whether a key is expired
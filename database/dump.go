@@ -0,0 +1,180 @@
+package database
+
+import (
+	"bytes"
+	"io"
+	"ringodis/aof"
+	"ringodis/ds/stream"
+	"ringodis/interface/database"
+	"ringodis/interface/resp"
+	"ringodis/lib/rdb"
+	"ringodis/resp/parser"
+	"ringodis/resp/reply"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// streamDumpType is the type code of stream payload, which is specific to ringodis:
+// rdb doesn't support streams yet, so the payload holds command lines rebuilding the stream with an empty key
+const streamDumpType = 0xf0
+
+// execDump serializes value of key in the format of redis DUMP
+func execDump(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return reply.MakeNullBulkReply()
+	}
+	if _, ok := entity.Data.(*stream.Stream); ok {
+		return reply.MakeBulkReply(dumpStream(entity))
+	}
+	obj := entityToObject(key, entity)
+	if obj == nil {
+		return reply.MakeUnknownErrReply()
+	}
+	payload, err := rdb.DumpValue(obj)
+	if err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	return reply.MakeBulkReply(payload)
+}
+
+func dumpStream(entity *database.DataEntity) []byte {
+	return rdb.SealDump(append([]byte{streamDumpType}, streamToCmdBytes(entity)...))
+}
+
+// streamToCmdBytes serializes command lines rebuilding the stream with an empty key
+func streamToCmdBytes(entity *database.DataEntity) []byte {
+	var body []byte
+	for _, cmd := range aof.EntityToCmds("", entity) {
+		body = append(body, cmd.ToBytes()...)
+	}
+	return body
+}
+
+// streamKeyIndex returns position of key in command lines generated by aof.EntityToCmds for streams
+func streamKeyIndex(cmdLine CmdLine) int {
+	if strings.EqualFold(string(cmdLine[0]), "xgroup") {
+		return 2
+	}
+	return 1
+}
+
+// parseStreamDump reads command lines in stream payload and fills in the key
+func parseStreamDump(key string, body []byte) ([]CmdLine, bool) {
+	var cmdLines []CmdLine
+	valid := true
+	// drain the channel even if payload is invalid, parser exits after io.EOF is sent
+	for payload := range parser.ParseStream(bytes.NewReader(body)) {
+		if payload.Err != nil {
+			if payload.Err != io.EOF {
+				valid = false
+			}
+			continue
+		}
+		cmd, ok := payload.Data.(*reply.MultiBulkReply)
+		if !ok || len(cmd.Args) < 3 {
+			valid = false
+			continue
+		}
+		cmd.Args[streamKeyIndex(cmd.Args)] = []byte(key)
+		cmdLines = append(cmdLines, cmd.Args)
+	}
+	return cmdLines, valid && len(cmdLines) > 0
+}
+
+// execRestore creates a key from payload of DUMP: RESTORE key ttl serialized-value [REPLACE] [ABSTTL] [IDLETIME seconds] [FREQ frequency]
+// IDLETIME and FREQ are accepted for compatibility but ignored as there is no eviction
+func execRestore(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	ttl, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if ttl < 0 {
+		return reply.MakeErrReply("ERR Invalid TTL value, must be >= 0")
+	}
+	replace, absTTL := false, false
+	for i := 3; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "replace":
+			replace = true
+		case "absttl":
+			absTTL = true
+		case "idletime", "freq":
+			i++
+			if i >= len(args) {
+				return reply.MakeSyntaxErrReply()
+			}
+			if _, err = strconv.ParseInt(string(args[i]), 10, 64); err != nil {
+				return reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	if _, exists := db.GetEntity(key); exists && !replace {
+		return reply.MakeErrReply("BUSYKEY Target key name already exists.")
+	}
+
+	body, err := rdb.OpenDump(args[2])
+	if err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	var entity *database.DataEntity
+	var cmdLines []CmdLine
+	if body[0] == streamDumpType {
+		var ok bool
+		if cmdLines, ok = parseStreamDump(key, body[1:]); !ok {
+			return reply.MakeErrReply("ERR Bad data format")
+		}
+	} else {
+		obj, err := rdb.RestoreValue(args[2])
+		if err != nil {
+			return reply.MakeErrReply("ERR Bad data format")
+		}
+		if entity = objectToEntity(obj); entity == nil {
+			return reply.MakeErrReply("ERR Bad data format")
+		}
+	}
+
+	db.Remove(key)
+	if entity != nil {
+		db.PutEntity(key, entity)
+	}
+	if errReply := db.replayStream(key, cmdLines); errReply != nil {
+		return errReply
+	}
+	if ttl > 0 {
+		expireAt := time.Now().Add(time.Duration(ttl) * time.Millisecond)
+		if absTTL {
+			expireAt = time.UnixMilli(ttl)
+		}
+		db.Expire(key, expireAt)
+	}
+	db.signalKey(key)
+	return reply.MakeOkReply()
+}
+
+// replayStream rebuilds key by command lines parsed by parseStreamDump,
+// returns nil if succeed, otherwise the key is removed and the error is returned
+func (db *DB) replayStream(key string, cmdLines []CmdLine) reply.ErrorReply {
+	for _, cmdLine := range cmdLines {
+		cmd, ok := cmdTable[strings.ToLower(string(cmdLine[0]))]
+		if !ok {
+			db.Remove(key)
+			return reply.MakeErrReply("ERR Bad data format")
+		}
+		if errReply, ok := cmd.executor(db, cmdLine[1:]).(reply.ErrorReply); ok {
+			db.Remove(key)
+			return errReply
+		}
+	}
+	return nil
+}
+
+func init() {
+	RegisterCommand("Dump", execDump, readFirstKey, nil, 2, flagReadOnly|flagKeyspace)
+	RegisterCommand("Restore", execRestore, writeFirstKey, rollbackFirstKey, -4, flagWrite|flagKeyspace|flagDangerous)
+}
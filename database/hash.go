@@ -0,0 +1,386 @@
+package database
+
+import (
+	"ringodis/ds/dict"
+	"ringodis/interface/database"
+	"ringodis/interface/resp"
+	"ringodis/resp/reply"
+	"strconv"
+	"strings"
+)
+
+func (db *DB) getAsDict(key string) (dict.Dict, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	d, ok := entity.Data.(dict.Dict)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return d, nil
+}
+
+func (db *DB) getOrInitDict(key string) (d dict.Dict, inited bool, errReply reply.ErrorReply) {
+	d, errReply = db.getAsDict(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	inited = false
+	if d == nil {
+		d = dict.MakeSimple()
+		db.PutEntity(key, &database.DataEntity{
+			Data: d,
+		})
+		inited = true
+	}
+	return d, inited, nil
+}
+
+// execHSet sets fields of a hash, returns number of fields added
+// HSET key field value [field value ...]
+func execHSet(db *DB, args CmdArgs) resp.Reply {
+	if len(args)%2 != 1 {
+		return reply.MakeArgNumErrReply("hset")
+	}
+	key := string(args[0])
+	d, _, errReply := db.getOrInitDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	result := 0
+	for i := 1; i < len(args); i += 2 {
+		result += d.Put(string(args[i]), args[i+1])
+	}
+	return reply.MakeIntReply(int64(result))
+}
+
+// execHMSet sets fields of a hash, replies OK
+// HMSET key field value [field value ...]
+func execHMSet(db *DB, args CmdArgs) resp.Reply {
+	if len(args)%2 != 1 {
+		return reply.MakeArgNumErrReply("hmset")
+	}
+	res := execHSet(db, args)
+	if reply.IsErrorReply(res) {
+		return res
+	}
+	return reply.MakeOkReply()
+}
+
+// execHSetNX sets field of a hash only if the field not exists
+func execHSetNX(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+	d, _, errReply := db.getOrInitDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	result := d.PutIfAbsent(field, args[2])
+	return reply.MakeIntReply(int64(result))
+}
+
+// execHGet returns value of the given field
+func execHGet(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+	d, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if d == nil {
+		return reply.MakeNullBulkReply()
+	}
+	raw, exists := d.Get(field)
+	if !exists {
+		return reply.MakeNullBulkReply()
+	}
+	return reply.MakeBulkReply(raw.([]byte))
+}
+
+// execHMGet returns values of the given fields, nil for fields not exist
+func execHMGet(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	d, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	result := make([][]byte, len(args)-1)
+	if d == nil {
+		return reply.MakeMultiBulkReply(result)
+	}
+	for i, field := range args[1:] {
+		if raw, exists := d.Get(string(field)); exists {
+			result[i] = raw.([]byte)
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execHExists checks whether the given field exists
+func execHExists(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+	d, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if d == nil {
+		return reply.MakeIntReply(0)
+	}
+	if _, exists := d.Get(field); exists {
+		return reply.MakeIntReply(1)
+	}
+	return reply.MakeIntReply(0)
+}
+
+// execHDel removes the given fields, the key is removed if the hash becomes empty
+func execHDel(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	d, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if d == nil {
+		return reply.MakeIntReply(0)
+	}
+	deleted := 0
+	for _, field := range args[1:] {
+		deleted += d.Remove(string(field))
+	}
+	if d.Len() == 0 {
+		db.Remove(key)
+	}
+	return reply.MakeIntReply(int64(deleted))
+}
+
+// execHLen returns number of fields in hash
+func execHLen(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	d, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if d == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(int64(d.Len()))
+}
+
+// execHStrLen returns length of the value of the given field
+func execHStrLen(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+	d, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if d == nil {
+		return reply.MakeIntReply(0)
+	}
+	raw, exists := d.Get(field)
+	if !exists {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(int64(len(raw.([]byte))))
+}
+
+// execHKeys returns all fields in hash
+func execHKeys(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	d, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if d == nil {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	fields := make([][]byte, 0, d.Len())
+	d.ForEach(func(field string, val interface{}) bool {
+		fields = append(fields, []byte(field))
+		return true
+	})
+	return reply.MakeMultiBulkReply(fields)
+}
+
+// execHVals returns all values in hash
+func execHVals(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	d, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if d == nil {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	values := make([][]byte, 0, d.Len())
+	d.ForEach(func(field string, val interface{}) bool {
+		values = append(values, val.([]byte))
+		return true
+	})
+	return reply.MakeMultiBulkReply(values)
+}
+
+// execHGetAll returns all fields and values in hash
+func execHGetAll(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	d, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if d == nil {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	result := make([][]byte, 0, 2*d.Len())
+	d.ForEach(func(field string, val interface{}) bool {
+		result = append(result, []byte(field), val.([]byte))
+		return true
+	})
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execHIncrBy increments the integer value of the given field
+func execHIncrBy(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+	delta, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	d, _, errReply := db.getOrInitDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	var value int64
+	if raw, exists := d.Get(field); exists {
+		value, err = strconv.ParseInt(string(raw.([]byte)), 10, 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR hash value is not an integer")
+		}
+	}
+	value += delta
+	d.Put(field, []byte(strconv.FormatInt(value, 10)))
+	return reply.MakeIntReply(value)
+}
+
+// execHIncrByFloat increments the float value of the given field
+func execHIncrByFloat(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+	delta, err := strconv.ParseFloat(string(args[2]), 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not a valid float")
+	}
+	d, _, errReply := db.getOrInitDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	var value float64
+	if raw, exists := d.Get(field); exists {
+		value, err = strconv.ParseFloat(string(raw.([]byte)), 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR hash value is not a float")
+		}
+	}
+	value += delta
+	bytes := []byte(strconv.FormatFloat(value, 'f', -1, 64))
+	d.Put(field, bytes)
+	return reply.MakeBulkReply(bytes)
+}
+
+// execHRandField returns random fields of hash
+// HRANDFIELD key [count [WITHVALUES]]
+func execHRandField(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	count := 1
+	withValues := false
+	if len(args) > 3 {
+		return reply.MakeArgNumErrReply("hrandfield")
+	}
+	if len(args) == 3 {
+		if strings.ToLower(string(args[2])) != "withvalues" {
+			return reply.MakeSyntaxErrReply()
+		}
+		withValues = true
+	}
+	if len(args) >= 2 {
+		count64, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		count = int(count64)
+	}
+	d, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if d == nil {
+		if len(args) == 1 {
+			return reply.MakeNullBulkReply()
+		}
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	if len(args) == 1 {
+		return reply.MakeBulkReply([]byte(d.RandomKeys(1)[0]))
+	}
+
+	// positive count returns distinct fields, negative count allows duplicated fields
+	var fields []string
+	if count >= 0 {
+		fields = d.RandomDistinctKeys(count)
+	} else {
+		fields = d.RandomKeys(-count)
+	}
+	result := make([][]byte, 0, len(fields)*2)
+	for _, field := range fields {
+		result = append(result, []byte(field))
+		if withValues {
+			raw, _ := d.Get(field)
+			result = append(result, raw.([]byte))
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execHScan iterates fields and values of hash
+// HSCAN key cursor [MATCH pattern] [COUNT count]
+func execHScan(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	pattern, errReply := parseScanArgs(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	d, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	result := make([][]byte, 0)
+	if d != nil {
+		d.ForEach(func(field string, val interface{}) bool {
+			if pattern == nil || pattern.IsMatch(field) {
+				result = append(result, []byte(field), val.([]byte))
+			}
+			return true
+		})
+	}
+	return makeScanReply(result)
+}
+
+func init() {
+	RegisterCommand("HSet", execHSet, writeFirstKey, rollbackFirstKey, -4, flagWrite|flagHash)
+	RegisterCommand("HMSet", execHMSet, writeFirstKey, rollbackFirstKey, -4, flagWrite|flagHash)
+	RegisterCommand("HSetNX", execHSetNX, writeFirstKey, rollbackFirstKey, 4, flagWrite|flagHash)
+	RegisterCommand("HGet", execHGet, readFirstKey, nil, 3, flagReadOnly|flagHash)
+	RegisterCommand("HMGet", execHMGet, readFirstKey, nil, -3, flagReadOnly|flagHash)
+	RegisterCommand("HExists", execHExists, readFirstKey, nil, 3, flagReadOnly|flagHash)
+	RegisterCommand("HDel", execHDel, writeFirstKey, rollbackFirstKey, -3, flagWrite|flagHash)
+	RegisterCommand("HLen", execHLen, readFirstKey, nil, 2, flagReadOnly|flagHash)
+	RegisterCommand("HStrLen", execHStrLen, readFirstKey, nil, 3, flagReadOnly|flagHash)
+	RegisterCommand("HKeys", execHKeys, readFirstKey, nil, 2, flagReadOnly|flagHash)
+	RegisterCommand("HVals", execHVals, readFirstKey, nil, 2, flagReadOnly|flagHash)
+	RegisterCommand("HGetAll", execHGetAll, readFirstKey, nil, 2, flagReadOnly|flagHash)
+	RegisterCommand("HIncrBy", execHIncrBy, writeFirstKey, rollbackFirstKey, 4, flagWrite|flagHash)
+	RegisterCommand("HIncrByFloat", execHIncrByFloat, writeFirstKey, rollbackFirstKey, 4, flagWrite|flagHash)
+	RegisterCommand("HRandField", execHRandField, readFirstKey, nil, -2, flagReadOnly|flagHash)
+	RegisterCommand("HScan", execHScan, readFirstKey, nil, -3, flagReadOnly|flagHash)
+}
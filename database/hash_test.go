@@ -0,0 +1,52 @@
+package database
+
+import (
+	"ringodis/lib/utils"
+	"ringodis/resp/conn"
+	"ringodis/resp/reply"
+	"ringodis/resp/reply/asserts"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	c := conn.NewFakeConn()
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("hset", "h", "a", "1", "b", "2")), 2)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("hset", "h", "a", "3")), 0)
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("hset", "h", "a")), "ERR wrong number of arguments for 'hset' command")
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("hsetnx", "h", "a", "4")), 0)
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("hget", "h", "a")), "3")
+	asserts.AssertNullBulk(t, server.Exec(c, utils.ToCmdLine("hget", "h", "x")))
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("hmget", "h", "b", "a")), []string{"2", "3"})
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("hexists", "h", "b")), 1)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("hlen", "h")), 2)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("hstrlen", "h", "a")), 1)
+	asserts.AssertMultiBulkReplySize(t, server.Exec(c, utils.ToCmdLine("hgetall", "h")), 4)
+	asserts.AssertMultiBulkReplySize(t, server.Exec(c, utils.ToCmdLine("hkeys", "h")), 2)
+	asserts.AssertMultiBulkReplySize(t, server.Exec(c, utils.ToCmdLine("hvals", "h")), 2)
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("type", "h")), "hash")
+
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("hincrby", "h", "a", "10")), 13)
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("hincrbyfloat", "h", "f", "1.5")), "1.5")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("hincrby", "h", "f", "1")), "ERR hash value is not an integer")
+
+	asserts.AssertMultiBulkReplySize(t, server.Exec(c, utils.ToCmdLine("hrandfield", "h", "2", "withvalues")), 4)
+	asserts.AssertMultiBulkReplySize(t, server.Exec(c, utils.ToCmdLine("hrandfield", "h", "-5")), 5)
+	asserts.AssertMultiBulkReplySize(t, server.Exec(c, utils.ToCmdLine("hrandfield", "h", "10")), 3)
+
+	result := server.Exec(c, utils.ToCmdLine("hscan", "h", "0", "match", "[ab]"))
+	scanResult, ok := result.(*reply.MultiRawReply)
+	if !ok || len(scanResult.Replies) != 2 {
+		t.Errorf("illegal hscan result: %s", result.ToBytes())
+		return
+	}
+	asserts.AssertBulkReply(t, scanResult.Replies[0], "0")
+	asserts.AssertMultiBulkReplySize(t, scanResult.Replies[1], 4)
+
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("hdel", "h", "a", "b", "f", "x")), 3)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("exists", "h")), 0)
+
+	server.Exec(c, utils.ToCmdLine("set", "s", "s"))
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("hget", "s", "a")), "WRONG-TYPE Operation against a key holding the wrong kind of value")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("hset", "s", "a", "1")), "WRONG-TYPE Operation against a key holding the wrong kind of value")
+}
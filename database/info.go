@@ -0,0 +1,117 @@
+package database
+
+import (
+	"ringodis/config"
+	"ringodis/interface/resp"
+	"ringodis/resp/reply"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// infoSections generate sections of INFO in order
+var infoSections = []struct {
+	name     string
+	generate func(server *Server) string
+}{
+	{"server", serverInfo},
+	{"replication", replicationInfo},
+}
+
+// execInfo returns information of server in text: INFO [section ...]
+func execInfo(server *Server, args CmdArgs) resp.Reply {
+	wanted := make(map[string]bool)
+	for _, arg := range args {
+		wanted[strings.ToLower(string(arg))] = true
+	}
+	all := len(wanted) == 0 || wanted["all"] || wanted["default"] || wanted["everything"]
+	var sections []string
+	for _, section := range infoSections {
+		if all || wanted[section.name] {
+			sections = append(sections, section.generate(server))
+		}
+	}
+	return reply.MakeBulkReply([]byte(strings.Join(sections, "\r\n")))
+}
+
+func serverInfo(server *Server) string {
+	return "# Server\r\n" +
+		"redis_mode:standalone\r\n" +
+		"tcp_port:" + strconv.Itoa(config.Properties.Port) + "\r\n"
+}
+
+func replicationInfo(server *Server) string {
+	repl := server.repl
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	builder := &strings.Builder{}
+	builder.WriteString("# Replication\r\n")
+	if status := repl.slave; status != nil {
+		state := status.getState()
+		linkStatus := "down"
+		if state == replStateConnected {
+			linkStatus = "up"
+		}
+		lastIO := int64(-1)
+		if t := atomic.LoadInt64(&status.lastIO); t > 0 {
+			lastIO = int64(time.Since(time.Unix(0, t)).Seconds())
+		}
+		syncing := 0
+		if state == replStateSync {
+			syncing = 1
+		}
+		readOnly := 0
+		if config.Properties.ReplicaReadOnly {
+			readOnly = 1
+		}
+		builder.WriteString("role:slave\r\n" +
+			"master_host:" + status.host + "\r\n" +
+			"master_port:" + status.port + "\r\n" +
+			"master_link_status:" + linkStatus + "\r\n" +
+			"master_last_io_seconds_ago:" + strconv.FormatInt(lastIO, 10) + "\r\n" +
+			"master_sync_in_progress:" + strconv.Itoa(syncing) + "\r\n" +
+			"slave_repl_offset:" + strconv.FormatInt(repl.offset, 10) + "\r\n" +
+			"slave_read_only:" + strconv.Itoa(readOnly) + "\r\n")
+	} else {
+		builder.WriteString("role:master\r\n")
+	}
+
+	online := 0
+	for _, r := range repl.replicas {
+		if !r.online {
+			continue
+		}
+		builder.WriteString("slave" + strconv.Itoa(online) + ":" +
+			"ip=" + r.ip +
+			",port=" + r.port +
+			",state=online" +
+			",offset=" + strconv.FormatInt(atomic.LoadInt64(&r.ackOffset), 10) +
+			",lag=" + strconv.FormatInt(int64(time.Since(r.ackTime).Seconds()), 10) + "\r\n")
+		online++
+	}
+	secondOffset := int64(-1)
+	if repl.replID2 != "" {
+		secondOffset = repl.secondOffset + 1
+	}
+	replID2 := repl.replID2
+	if replID2 == "" {
+		replID2 = strings.Repeat("0", 40)
+	}
+	backlogActive, backlogSize, backlogFirst, backlogLen := 0, replBacklogSize(), int64(0), int64(0)
+	if repl.backlog != nil {
+		backlogActive = 1
+		backlogFirst = repl.backlog.beginOffset + 1
+		backlogLen = repl.backlog.endOffset - repl.backlog.beginOffset
+	}
+	builder.WriteString("connected_slaves:" + strconv.Itoa(online) + "\r\n" +
+		"master_replid:" + repl.replID + "\r\n" +
+		"master_replid2:" + replID2 + "\r\n" +
+		"master_repl_offset:" + strconv.FormatInt(repl.offset, 10) + "\r\n" +
+		"second_repl_offset:" + strconv.FormatInt(secondOffset, 10) + "\r\n" +
+		"repl_backlog_active:" + strconv.Itoa(backlogActive) + "\r\n" +
+		"repl_backlog_size:" + strconv.Itoa(backlogSize) + "\r\n" +
+		"repl_backlog_first_byte_offset:" + strconv.FormatInt(backlogFirst, 10) + "\r\n" +
+		"repl_backlog_histlen:" + strconv.FormatInt(backlogLen, 10) + "\r\n")
+	return builder.String()
+}
@@ -2,10 +2,17 @@ package database
 
 import (
 	"ringodis/ds/dict"
+	List "ringodis/ds/list"
+	HashSet "ringodis/ds/set"
+	"ringodis/ds/stream"
+	SortedSet "ringodis/ds/zset"
+	"ringodis/interface/database"
 	"ringodis/interface/resp"
+	"ringodis/lib/utils"
 	"ringodis/lib/wildcard"
 	"ringodis/resp/reply"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -36,7 +43,17 @@ func execFlushDB(db *DB, args CmdArgs) resp.Reply {
 	return reply.MakeOkReply()
 }
 
-// execType returns the type of entity, including: string, list, hash, set and zset
+// undoFlushDB snapshots all keys of the current db
+func undoFlushDB(db *DB, args CmdArgs) []CmdLine {
+	var undoCmdLines []CmdLine
+	db.ForEach(func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+		undoCmdLines = append(undoCmdLines, snapshotEntity(db, key, entity)...)
+		return true
+	})
+	return undoCmdLines
+}
+
+// execType returns the type of entity, including: string, list, hash, set, zset and stream
 func execType(db *DB, args CmdArgs) resp.Reply {
 	entity, exists := db.GetEntity(string(args[0]))
 	if !exists {
@@ -47,9 +64,14 @@ func execType(db *DB, args CmdArgs) resp.Reply {
 		return reply.MakeStatusReply("string")
 	case dict.Dict:
 		return reply.MakeStatusReply("hash")
-		// case list
-		// case set
-		// case sortedset
+	case List.List:
+		return reply.MakeStatusReply("list")
+	case *HashSet.Set:
+		return reply.MakeStatusReply("set")
+	case *SortedSet.ZSet:
+		return reply.MakeStatusReply("zset")
+	case *stream.Stream:
+		return reply.MakeStatusReply("stream")
 	}
 	return reply.MakeUnknownErrReply()
 }
@@ -57,7 +79,7 @@ func execType(db *DB, args CmdArgs) resp.Reply {
 func prepareRename(args CmdArgs) ([]string, []string) {
 	src := string(args[0])
 	dst := string(args[1])
-	return []string{dst}, []string{src}
+	return []string{dst, src}, nil
 }
 
 // execRename renames a key and overwrites the destination
@@ -78,6 +100,7 @@ func execRename(db *DB, args CmdArgs) resp.Reply {
 		expireTime, _ := srcTTL.(time.Time)
 		db.Expire(dest, expireTime)
 	}
+	db.signalKey(dest)
 	return reply.MakeOkReply()
 }
 
@@ -102,6 +125,7 @@ func execRenameNx(db *DB, args CmdArgs) resp.Reply {
 		expireTime, _ := srcTTL.(time.Time)
 		db.Expire(dest, expireTime)
 	}
+	db.signalKey(dest)
 	return reply.MakeIntReply(1)
 }
 
@@ -119,6 +143,25 @@ func execExpire(db *DB, args CmdArgs) resp.Reply {
 	return reply.MakeIntReply(1)
 }
 
+// execPExpireAt sets a key's expiration in unix timestamp specified in milliseconds
+func execPExpireAt(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	if _, exists := db.GetEntity(key); !exists {
+		return reply.MakeIntReply(0)
+	}
+	raw, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	db.Expire(key, time.UnixMilli(raw))
+	return reply.MakeIntReply(1)
+}
+
+// makeExpireCmd generates a PEXPIREAT command line which is independent of the current time
+func makeExpireCmd(key string, expireAt time.Time) CmdLine {
+	return utils.ToCmdLine("PExpireAt", key, strconv.FormatInt(expireAt.UnixMilli(), 10))
+}
+
 // execTTL returns a key's time to live in seconds
 func execTTL(db *DB, args CmdArgs) resp.Reply {
 	key := string(args[0])
@@ -134,6 +177,63 @@ func execTTL(db *DB, args CmdArgs) resp.Reply {
 	return reply.MakeIntReply(int64(ttl))
 }
 
+// execPTTL returns a key's time to live in milliseconds
+func execPTTL(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	if _, exists := db.GetEntity(key); !exists {
+		return reply.MakeIntReply(-2)
+	}
+	raw, exists := db.ttlMap.Get(key)
+	if !exists {
+		return reply.MakeIntReply(-1)
+	}
+	expireTime, _ := raw.(time.Time)
+	ttl := expireTime.Sub(time.Now()) / time.Millisecond
+	return reply.MakeIntReply(int64(ttl))
+}
+
+// parseScanArgs parses `cursor [MATCH pattern] [COUNT count]` of *SCAN commands
+// like small collections encoded as listpack in redis, all matched elements are returned in one call,
+// so cursor is always 0 and count is only validated
+func parseScanArgs(args CmdArgs) (*wildcard.Pattern, reply.ErrorReply) {
+	if _, err := strconv.ParseUint(string(args[0]), 10, 64); err != nil {
+		return nil, reply.MakeErrReply("ERR invalid cursor")
+	}
+	var pattern *wildcard.Pattern
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, reply.MakeSyntaxErrReply()
+		}
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			var err error
+			pattern, err = wildcard.CompilePattern(string(args[i+1]))
+			if err != nil {
+				return nil, reply.MakeErrReply("ERR illegal wildcard")
+			}
+		case "count":
+			count, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return nil, reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if count < 1 {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+		default:
+			return nil, reply.MakeSyntaxErrReply()
+		}
+	}
+	return pattern, nil
+}
+
+// makeScanReply makes reply of *SCAN commands, which finishes iteration in one call
+func makeScanReply(elements [][]byte) resp.Reply {
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte("0")),
+		reply.MakeMultiBulkReply(elements),
+	})
+}
+
 // execKeys returns all keys matching the given pattern
 func execKeys(db *DB, args CmdArgs) resp.Reply {
 	pattern, err := wildcard.CompilePattern(string(args[0]))
@@ -151,13 +251,15 @@ func execKeys(db *DB, args CmdArgs) resp.Reply {
 }
 
 func init() {
-	RegisterCommand("Del", execDel, writeAllKeys, -2)
-	RegisterCommand("Exists", execExists, readAllKeys, -2)
-	RegisterCommand("FlushDB", execFlushDB, noPrepare, -1)
-	RegisterCommand("Type", execType, readFirstKey, 2)
-	RegisterCommand("Rename", execRename, prepareRename, 3)
-	RegisterCommand("RenameNx", execRenameNx, prepareRename, 3)
-	RegisterCommand("Keys", execKeys, noPrepare, 2)
-	RegisterCommand("Expire", execExpire, writeFirstKey, 3)
-	RegisterCommand("TTL", execTTL, readFirstKey, 2)
+	RegisterCommand("Del", execDel, writeAllKeys, rollbackAllKeys, -2, flagWrite|flagKeyspace)
+	RegisterCommand("Exists", execExists, readAllKeys, nil, -2, flagReadOnly|flagKeyspace)
+	RegisterCommand("FlushDB", execFlushDB, noPrepare, undoFlushDB, -1, flagWrite|flagKeyspace|flagDangerous)
+	RegisterCommand("Type", execType, readFirstKey, nil, 2, flagReadOnly|flagKeyspace)
+	RegisterCommand("Rename", execRename, prepareRename, rollbackAllKeys, 3, flagWrite|flagKeyspace)
+	RegisterCommand("RenameNx", execRenameNx, prepareRename, rollbackAllKeys, 3, flagWrite|flagKeyspace)
+	RegisterCommand("Keys", execKeys, noPrepare, nil, 2, flagReadOnly|flagKeyspace|flagDangerous)
+	RegisterCommand("Expire", execExpire, writeFirstKey, rollbackFirstKey, 3, flagWrite|flagKeyspace)
+	RegisterCommand("PExpireAt", execPExpireAt, writeFirstKey, rollbackFirstKey, 3, flagWrite|flagKeyspace)
+	RegisterCommand("TTL", execTTL, readFirstKey, nil, 2, flagReadOnly|flagKeyspace)
+	RegisterCommand("PTTL", execPTTL, readFirstKey, nil, 2, flagReadOnly|flagKeyspace)
 }
@@ -30,29 +30,27 @@ func TestType(t *testing.T) {
 	result := testDB.Exec(nil, utils.ToCmdLine("type", key))
 	asserts.AssertStatusReply(t, result, "string")
 
-	/*
-		testDB.Remove(key)
-		result = testDB.Exec(nil, utils.ToCmdLine("type", key))
-		asserts.AssertStatusReply(t, result, "none")
-		execRPush(testDB, utils.ToCmdLine(key, value))
-		result = testDB.Exec(nil, utils.ToCmdLine("type", key))
-		asserts.AssertStatusReply(t, result, "list")
+	testDB.Remove(key)
+	result = testDB.Exec(nil, utils.ToCmdLine("type", key))
+	asserts.AssertStatusReply(t, result, "none")
+	execRPush(testDB, utils.ToCmdLine(key, value))
+	result = testDB.Exec(nil, utils.ToCmdLine("type", key))
+	asserts.AssertStatusReply(t, result, "list")
 
-		testDB.Remove(key)
-		testDB.Exec(nil, utils.ToCmdLine("hset", key, key, value))
-		result = testDB.Exec(nil, utils.ToCmdLine("type", key))
-		asserts.AssertStatusReply(t, result, "hash")
+	testDB.Remove(key)
+	testDB.Exec(nil, utils.ToCmdLine("hset", key, key, value))
+	result = testDB.Exec(nil, utils.ToCmdLine("type", key))
+	asserts.AssertStatusReply(t, result, "hash")
 
-		testDB.Remove(key)
-		testDB.Exec(nil, utils.ToCmdLine("sadd", key, value))
-		result = testDB.Exec(nil, utils.ToCmdLine("type", key))
-		asserts.AssertStatusReply(t, result, "set")
+	testDB.Remove(key)
+	testDB.Exec(nil, utils.ToCmdLine("sadd", key, value))
+	result = testDB.Exec(nil, utils.ToCmdLine("type", key))
+	asserts.AssertStatusReply(t, result, "set")
 
-		testDB.Remove(key)
-		testDB.Exec(nil, utils.ToCmdLine("zadd", key, "1", value))
-		result = testDB.Exec(nil, utils.ToCmdLine("type", key))
-		asserts.AssertStatusReply(t, result, "zset")
-	*/
+	testDB.Remove(key)
+	testDB.Exec(nil, utils.ToCmdLine("zadd", key, "1", value))
+	result = testDB.Exec(nil, utils.ToCmdLine("type", key))
+	asserts.AssertStatusReply(t, result, "zset")
 }
 
 func TestRename(t *testing.T) {
@@ -122,3 +120,31 @@ func TestKeys(t *testing.T) {
 	result = testDB.Exec(nil, utils.ToCmdLine("keys", "?:*"))
 	asserts.AssertMultiBulkReplySize(t, result, 2)
 }
+
+func TestDumpAndRestore(t *testing.T) {
+	testDB.Flush()
+	testDB.Exec(nil, utils.ToCmdLine("set", "str", "v", "ex", "1000"))
+	testDB.Exec(nil, utils.ToCmdLine("hset", "hash", "f", "v"))
+	testDB.Exec(nil, utils.ToCmdLine("xadd", "stream", "1-1", "f", "v"))
+	testDB.Exec(nil, utils.ToCmdLine("xgroup", "create", "stream", "g", "0"))
+	for _, key := range []string{"str", "hash", "stream"} {
+		payload := testDB.Exec(nil, utils.ToCmdLine("dump", key)).(*reply.BulkReply).Arg
+		asserts.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine3("restore", []byte(key), []byte("0"), payload)),
+			"BUSYKEY Target key name already exists.")
+		asserts.AssertStatusReply(t, testDB.Exec(nil, utils.ToCmdLine3("restore", []byte(key+"2"), []byte("5000"), payload)), "OK")
+		asserts.AssertStatusReply(t, testDB.Exec(nil, utils.ToCmdLine3("restore", []byte(key), []byte("0"), payload, []byte("REPLACE"))), "OK")
+		asserts.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("ttl", key)), -1)
+		pttl := testDB.Exec(nil, utils.ToCmdLine("pttl", key+"2")).(*reply.IntReply).Code
+		if pttl <= 4000 || pttl > 5000 {
+			t.Errorf("unexpected pttl %d", pttl)
+		}
+	}
+	asserts.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("get", "str2")), "v")
+	asserts.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("hget", "hash2", "f")), "v")
+	asserts.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("xlen", "stream2")), 1)
+	asserts.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("xgroup", "createconsumer", "stream2", "g", "c")), 1)
+
+	asserts.AssertNullBulk(t, testDB.Exec(nil, utils.ToCmdLine("dump", "none")))
+	asserts.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("restore", "bad", "0", "payload")),
+		"ERR DUMP payload version or checksum are wrong")
+}
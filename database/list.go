@@ -0,0 +1,543 @@
+package database
+
+import (
+	List "ringodis/ds/list"
+	"ringodis/interface/database"
+	"ringodis/interface/resp"
+	"ringodis/lib/utils"
+	"ringodis/resp/reply"
+	"strconv"
+	"strings"
+)
+
+func (db *DB) getAsList(key string) (List.List, reply.ErrorReply) {
+	entity, ok := db.GetEntity(key)
+	if !ok {
+		return nil, nil
+	}
+	list, ok := entity.Data.(List.List)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return list, nil
+}
+
+func (db *DB) getOrInitList(key string) (list List.List, isNew bool, errReply reply.ErrorReply) {
+	list, errReply = db.getAsList(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	isNew = false
+	if list == nil {
+		list = List.NewQuickList()
+		db.PutEntity(key, &database.DataEntity{
+			Data: list,
+		})
+		isNew = true
+	}
+	return list, isNew, nil
+}
+
+// removeIfEmptyList removes key if the list has no elements left
+func (db *DB) removeIfEmptyList(key string, list List.List) {
+	if list.Len() == 0 {
+		db.Remove(key)
+	}
+}
+
+func parseInt(arg []byte) (int64, reply.ErrorReply) {
+	v, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	return v, nil
+}
+
+// execLPush inserts elements at the head of list
+func execLPush(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	list, _, errReply := db.getOrInitList(key)
+	if errReply != nil {
+		return errReply
+	}
+	for _, value := range args[1:] {
+		list.Insert(0, value)
+	}
+	db.blocking.signal(key)
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+// execLPushX inserts elements at the head of list, only if the list exists
+func execLPushX(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeIntReply(0)
+	}
+	for _, value := range args[1:] {
+		list.Insert(0, value)
+	}
+	db.blocking.signal(key)
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+// execRPush inserts elements at the tail of list
+func execRPush(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	list, _, errReply := db.getOrInitList(key)
+	if errReply != nil {
+		return errReply
+	}
+	for _, value := range args[1:] {
+		list.Add(value)
+	}
+	db.blocking.signal(key)
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+// execRPushX inserts elements at the tail of list, only if the list exists
+func execRPushX(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeIntReply(0)
+	}
+	for _, value := range args[1:] {
+		list.Add(value)
+	}
+	db.blocking.signal(key)
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+// execLPop removes and returns the first elements of list
+// LPOP key [count]
+func execLPop(db *DB, args CmdArgs) resp.Reply {
+	return execPop(db, args, true)
+}
+
+// execRPop removes and returns the last elements of list
+// RPOP key [count]
+func execRPop(db *DB, args CmdArgs) resp.Reply {
+	return execPop(db, args, false)
+}
+
+func execPop(db *DB, args CmdArgs, left bool) resp.Reply {
+	key := string(args[0])
+	count := int64(1)
+	if len(args) > 2 {
+		return reply.MakeSyntaxErrReply()
+	}
+	if len(args) == 2 {
+		var errReply reply.ErrorReply
+		count, errReply = parseInt(args[1])
+		if errReply != nil {
+			return errReply
+		}
+		if count < 0 {
+			return reply.MakeErrReply("ERR value is out of range, must be positive")
+		}
+	}
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		if len(args) == 2 {
+			return reply.MakeNullMultiBulkReply()
+		}
+		return reply.MakeNullBulkReply()
+	}
+	values := make([][]byte, 0)
+	for i := int64(0); i < count && list.Len() > 0; i++ {
+		if left {
+			values = append(values, list.Remove(0).([]byte))
+		} else {
+			values = append(values, list.RemoveLast().([]byte))
+		}
+	}
+	db.removeIfEmptyList(key, list)
+	if len(args) == 2 {
+		return reply.MakeMultiBulkReply(values)
+	}
+	return reply.MakeBulkReply(values[0])
+}
+
+// execLLen returns the length of list
+func execLLen(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+// normalizeIndex converts negative index, returns -1 if the index is out of range
+func normalizeIndex(index int64, size int) int {
+	if index < 0 {
+		index += int64(size)
+	}
+	if index < 0 || index >= int64(size) {
+		return -1
+	}
+	return int(index)
+}
+
+// execLIndex returns the element at index
+func execLIndex(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	index64, errReply := parseInt(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeNullBulkReply()
+	}
+	index := normalizeIndex(index64, list.Len())
+	if index < 0 {
+		return reply.MakeNullBulkReply()
+	}
+	return reply.MakeBulkReply(list.Get(index).([]byte))
+}
+
+// execLSet updates the element at index
+func execLSet(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	index64, errReply := parseInt(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeErrReply("ERR no such key")
+	}
+	index := normalizeIndex(index64, list.Len())
+	if index < 0 {
+		return reply.MakeErrReply("ERR index out of range")
+	}
+	list.Set(index, args[2])
+	return reply.MakeOkReply()
+}
+
+// execLRange returns elements within [start, stop]
+func execLRange(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	start, errReply := parseInt(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	stop, errReply := parseInt(args[2])
+	if errReply != nil {
+		return errReply
+	}
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	begin, end := utils.ConvertRange(start, stop, int64(list.Len()))
+	if begin < 0 || begin == end {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	slice := list.Range(begin, end)
+	result := make([][]byte, len(slice))
+	for i, v := range slice {
+		result[i] = v.([]byte)
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execLRem removes elements equal to the given value
+// count > 0 removes from head to tail, count < 0 removes from tail to head, count = 0 removes all
+func execLRem(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	count, errReply := parseInt(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	value := args[2]
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeIntReply(0)
+	}
+	expected := func(a interface{}) bool {
+		return utils.BytesEquals(a.([]byte), value)
+	}
+	var removed int
+	if count == 0 {
+		removed = list.RemoveAllByVal(expected)
+	} else if count > 0 {
+		removed = list.RemoveByVal(expected, int(count))
+	} else {
+		removed = list.ReverseRemoveByVal(expected, int(-count))
+	}
+	db.removeIfEmptyList(key, list)
+	return reply.MakeIntReply(int64(removed))
+}
+
+// execLInsert inserts element before or after the pivot
+// LINSERT key BEFORE|AFTER pivot element
+func execLInsert(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	var after bool
+	switch strings.ToLower(string(args[1])) {
+	case "before":
+		after = false
+	case "after":
+		after = true
+	default:
+		return reply.MakeSyntaxErrReply()
+	}
+	pivot := args[2]
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeIntReply(0)
+	}
+	index := -1
+	list.ForEach(func(i int, v interface{}) bool {
+		if utils.BytesEquals(v.([]byte), pivot) {
+			index = i
+			return false
+		}
+		return true
+	})
+	if index < 0 {
+		return reply.MakeIntReply(-1)
+	}
+	if after {
+		index++
+	}
+	list.Insert(index, args[3])
+	db.blocking.signal(key)
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+// execLTrim keeps only elements within [start, stop]
+func execLTrim(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	start, errReply := parseInt(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	stop, errReply := parseInt(args[2])
+	if errReply != nil {
+		return errReply
+	}
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeOkReply()
+	}
+	begin, end := utils.ConvertRange(start, stop, int64(list.Len()))
+	if begin < 0 || begin == end {
+		db.Remove(key)
+		return reply.MakeOkReply()
+	}
+	for list.Len() > end {
+		list.RemoveLast()
+	}
+	for i := 0; i < begin; i++ {
+		list.Remove(0)
+	}
+	return reply.MakeOkReply()
+}
+
+// execLPos returns indexes of elements equal to the given value
+// LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+func execLPos(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	value := args[1]
+	rank, count, maxLen := int64(1), int64(-1), int64(0)
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return reply.MakeSyntaxErrReply()
+		}
+		v, errReply := parseInt(args[i+1])
+		if errReply != nil {
+			return errReply
+		}
+		switch strings.ToLower(string(args[i])) {
+		case "rank":
+			if v == 0 {
+				return reply.MakeErrReply("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+			}
+			rank = v
+		case "count":
+			if v < 0 {
+				return reply.MakeErrReply("ERR COUNT can't be negative")
+			}
+			count = v
+		case "maxlen":
+			if v < 0 {
+				return reply.MakeErrReply("ERR MAXLEN can't be negative")
+			}
+			maxLen = v
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		if count >= 0 {
+			return reply.MakeEmptyMultiBulkReply()
+		}
+		return reply.MakeNullBulkReply()
+	}
+
+	// the first (rank-1) matches are skipped, then collect at most count matches
+	size := list.Len()
+	scanLen := size
+	if maxLen > 0 && int(maxLen) < size {
+		scanLen = int(maxLen)
+	}
+	var candidates []interface{}
+	if rank > 0 {
+		candidates = list.Range(0, scanLen)
+	} else {
+		candidates = list.Range(size-scanLen, size)
+	}
+	skip := rank - 1
+	if rank < 0 {
+		skip = -rank - 1
+	}
+	limit := count
+	if count < 0 {
+		limit = 1
+	}
+	positions := make([]int64, 0)
+	for i := 0; i < len(candidates) && (limit == 0 || int64(len(positions)) < limit); i++ {
+		index := i
+		if rank < 0 {
+			index = len(candidates) - 1 - i
+		}
+		if !utils.BytesEquals(candidates[index].([]byte), value) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		if rank < 0 {
+			index += size - scanLen
+		}
+		positions = append(positions, int64(index))
+	}
+
+	if count < 0 {
+		if len(positions) == 0 {
+			return reply.MakeNullBulkReply()
+		}
+		return reply.MakeIntReply(positions[0])
+	}
+	replies := make([]resp.Reply, len(positions))
+	for i, pos := range positions {
+		replies[i] = reply.MakeIntReply(pos)
+	}
+	return reply.MakeMultiRawReply(replies)
+}
+
+// execLMove pops an element from source and pushes it to destination
+// LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+func execLMove(db *DB, args CmdArgs) resp.Reply {
+	srcLeft, ok := parseDirection(args[2])
+	if !ok {
+		return reply.MakeSyntaxErrReply()
+	}
+	destLeft, ok := parseDirection(args[3])
+	if !ok {
+		return reply.MakeSyntaxErrReply()
+	}
+	return db.moveElement(string(args[0]), string(args[1]), srcLeft, destLeft)
+}
+
+// execRPopLPush pops the last element of source and pushes it to the head of destination
+func execRPopLPush(db *DB, args CmdArgs) resp.Reply {
+	return db.moveElement(string(args[0]), string(args[1]), false, true)
+}
+
+func parseDirection(arg []byte) (left bool, ok bool) {
+	switch strings.ToLower(string(arg)) {
+	case "left":
+		return true, true
+	case "right":
+		return false, true
+	}
+	return false, false
+}
+
+func (db *DB) moveElement(src, dest string, srcLeft, destLeft bool) resp.Reply {
+	srcList, errReply := db.getAsList(src)
+	if errReply != nil {
+		return errReply
+	}
+	if srcList == nil {
+		return reply.MakeNullBulkReply()
+	}
+	// check type of destination before modifying source
+	if _, errReply = db.getAsList(dest); errReply != nil {
+		return errReply
+	}
+	var value []byte
+	if srcLeft {
+		value = srcList.Remove(0).([]byte)
+	} else {
+		value = srcList.RemoveLast().([]byte)
+	}
+	db.removeIfEmptyList(src, srcList)
+	destList, _, _ := db.getOrInitList(dest)
+	if destLeft {
+		destList.Insert(0, value)
+	} else {
+		destList.Add(value)
+	}
+	db.blocking.signal(dest)
+	return reply.MakeBulkReply(value)
+}
+
+func init() {
+	RegisterCommand("LPush", execLPush, writeFirstKey, rollbackFirstKey, -3, flagWrite|flagList)
+	RegisterCommand("LPushX", execLPushX, writeFirstKey, rollbackFirstKey, -3, flagWrite|flagList)
+	RegisterCommand("RPush", execRPush, writeFirstKey, rollbackFirstKey, -3, flagWrite|flagList)
+	RegisterCommand("RPushX", execRPushX, writeFirstKey, rollbackFirstKey, -3, flagWrite|flagList)
+	RegisterCommand("LPop", execLPop, writeFirstKey, rollbackFirstKey, -2, flagWrite|flagList)
+	RegisterCommand("RPop", execRPop, writeFirstKey, rollbackFirstKey, -2, flagWrite|flagList)
+	RegisterCommand("LLen", execLLen, readFirstKey, nil, 2, flagReadOnly|flagList)
+	RegisterCommand("LIndex", execLIndex, readFirstKey, nil, 3, flagReadOnly|flagList)
+	RegisterCommand("LSet", execLSet, writeFirstKey, rollbackFirstKey, 4, flagWrite|flagList)
+	RegisterCommand("LRange", execLRange, readFirstKey, nil, 4, flagReadOnly|flagList)
+	RegisterCommand("LRem", execLRem, writeFirstKey, rollbackFirstKey, 4, flagWrite|flagList)
+	RegisterCommand("LInsert", execLInsert, writeFirstKey, rollbackFirstKey, 5, flagWrite|flagList)
+	RegisterCommand("LTrim", execLTrim, writeFirstKey, rollbackFirstKey, 4, flagWrite|flagList)
+	RegisterCommand("LPos", execLPos, readFirstKey, nil, -3, flagReadOnly|flagList)
+	RegisterCommand("LMove", execLMove, writeFirstTwoKeys, rollbackFirstTwoKeys, 5, flagWrite|flagList)
+	RegisterCommand("RPopLPush", execRPopLPush, writeFirstTwoKeys, rollbackFirstTwoKeys, 3, flagWrite|flagList)
+}
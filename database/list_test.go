@@ -0,0 +1,93 @@
+package database
+
+import (
+	"ringodis/lib/utils"
+	"ringodis/resp/conn"
+	"ringodis/resp/reply"
+	"ringodis/resp/reply/asserts"
+	"testing"
+)
+
+func TestPushAndPop(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	c := conn.NewFakeConn()
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("rpush", "l", "b", "c")), 2)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("lpush", "l", "a", "0")), 4)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("lpushx", "x", "a")), 0)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("rpushx", "l", "d")), 5)
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("type", "l")), "list")
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("lrange", "l", "0", "-1")), []string{"0", "a", "b", "c", "d"})
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("lrange", "l", "-100", "1")), []string{"0", "a"})
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("lrange", "l", "3", "100")), []string{"c", "d"})
+	asserts.AssertMultiBulkReplySize(t, server.Exec(c, utils.ToCmdLine("lrange", "l", "3", "1")), 0)
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("lpop", "l")), "0")
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("rpop", "l", "2")), []string{"d", "c"})
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("llen", "l")), 2)
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("lpop", "l", "10")), []string{"a", "b"})
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("exists", "l")), 0)
+	asserts.AssertNullBulk(t, server.Exec(c, utils.ToCmdLine("rpop", "l")))
+	result := server.Exec(c, utils.ToCmdLine("rpop", "l", "1"))
+	if _, ok := result.(*reply.NullMultiBulkReply); !ok {
+		t.Errorf("expected null multi bulk, actual: %s", result.ToBytes())
+	}
+
+	server.Exec(c, utils.ToCmdLine("set", "s", "s"))
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("lpush", "s", "a")), "WRONG-TYPE Operation against a key holding the wrong kind of value")
+}
+
+func TestListModify(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	c := conn.NewFakeConn()
+	server.Exec(c, utils.ToCmdLine("rpush", "l", "a", "b", "a", "c", "a"))
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("lindex", "l", "-2")), "c")
+	asserts.AssertNullBulk(t, server.Exec(c, utils.ToCmdLine("lindex", "l", "5")))
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("lset", "l", "1", "B")), "OK")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("lset", "l", "5", "B")), "ERR index out of range")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("lset", "x", "0", "B")), "ERR no such key")
+
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("lpos", "l", "a")), 0)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("lpos", "l", "a", "rank", "-1")), 4)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("lpos", "l", "a", "rank", "2")), 2)
+	asserts.AssertNullBulk(t, server.Exec(c, utils.ToCmdLine("lpos", "l", "a", "rank", "2", "maxlen", "2")))
+	result := server.Exec(c, utils.ToCmdLine("lpos", "l", "a", "count", "0"))
+	if multi, ok := result.(*reply.MultiRawReply); !ok || len(multi.Replies) != 3 {
+		t.Errorf("illegal lpos result: %s", result.ToBytes())
+	}
+	result = server.Exec(c, utils.ToCmdLine("lpos", "l", "a", "rank", "-2", "count", "1"))
+	if multi, ok := result.(*reply.MultiRawReply); !ok || len(multi.Replies) != 1 {
+		t.Errorf("illegal lpos result: %s", result.ToBytes())
+	} else {
+		asserts.AssertIntReply(t, multi.Replies[0], 2)
+	}
+
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("linsert", "l", "before", "c", "x")), 6)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("linsert", "l", "after", "c", "y")), 7)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("linsert", "l", "after", "z", "y")), -1)
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("lrange", "l", "0", "-1")), []string{"a", "B", "a", "x", "c", "y", "a"})
+
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("lrem", "l", "-1", "a")), 1)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("lrem", "l", "0", "a")), 2)
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("lrange", "l", "0", "-1")), []string{"B", "x", "c", "y"})
+
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("ltrim", "l", "1", "-2")), "OK")
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("lrange", "l", "0", "-1")), []string{"x", "c"})
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("ltrim", "l", "2", "1")), "OK")
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("exists", "l")), 0)
+}
+
+func TestLMove(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	c := conn.NewFakeConn()
+	server.Exec(c, utils.ToCmdLine("rpush", "src", "a", "b"))
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("lmove", "src", "dest", "left", "right")), "a")
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("rpoplpush", "src", "dest")), "b")
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("lrange", "dest", "0", "-1")), []string{"b", "a"})
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("exists", "src")), 0)
+	asserts.AssertNullBulk(t, server.Exec(c, utils.ToCmdLine("lmove", "src", "dest", "left", "left")))
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("lmove", "dest", "dest", "left", "right")), "b")
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("lrange", "dest", "0", "-1")), []string{"a", "b"})
+
+	server.Exec(c, utils.ToCmdLine("set", "s", "s"))
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("lmove", "dest", "s", "left", "right")), "WRONG-TYPE Operation against a key holding the wrong kind of value")
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("llen", "dest")), 2)
+}
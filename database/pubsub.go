@@ -0,0 +1,398 @@
+package database
+
+import (
+	"ringodis/interface/resp"
+	"ringodis/lib/wildcard"
+	"ringodis/resp/reply"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	messageBytes      = []byte("message")
+	pmessageBytes     = []byte("pmessage")
+	subscribeBytes    = []byte("subscribe")
+	unsubscribeBytes  = []byte("unsubscribe")
+	psubscribeBytes   = []byte("psubscribe")
+	punsubscribeBytes = []byte("punsubscribe")
+	smessageBytes     = []byte("smessage")
+	ssubscribeBytes   = []byte("ssubscribe")
+	sunsubscribeBytes = []byte("sunsubscribe")
+)
+
+// subscribers maps channel to its subscribers
+type subscribers map[string]map[resp.Connection]struct{}
+
+func (table subscribers) add(channel string, c resp.Connection) {
+	subs, ok := table[channel]
+	if !ok {
+		subs = make(map[resp.Connection]struct{})
+		table[channel] = subs
+	}
+	subs[c] = struct{}{}
+}
+
+func (table subscribers) remove(channel string, c resp.Connection) {
+	subs, ok := table[channel]
+	if !ok {
+		return
+	}
+	delete(subs, c)
+	if len(subs) == 0 {
+		delete(table, channel)
+	}
+}
+
+// patternSubs holds the compiled pattern and its subscribers
+type patternSubs struct {
+	pattern *wildcard.Pattern
+	subs    map[resp.Connection]struct{}
+}
+
+// Hub tracks subscribers of channels, patterns and shard channels, and delivers published messages
+// shard channels are in a separate namespace, messages published by SPUBLISH only reach SSUBSCRIBE subscribers
+type Hub struct {
+	mu            sync.RWMutex
+	channels      subscribers
+	patterns      map[string]*patternSubs
+	shardChannels subscribers
+}
+
+// MakeHub creates an empty pubsub hub
+func MakeHub() *Hub {
+	return &Hub{
+		channels:      make(subscribers),
+		patterns:      make(map[string]*patternSubs),
+		shardChannels: make(subscribers),
+	}
+}
+
+// makeSubsReply makes the confirmation like `subscribe channel count`
+func makeSubsReply(kind []byte, channel string, count int) []byte {
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply(kind),
+		reply.MakeBulkReply([]byte(channel)),
+		reply.MakeIntReply(int64(count)),
+	}).ToBytes()
+}
+
+// makeNoSubsReply confirms unsubscribe of a client without any subscription
+func makeNoSubsReply(kind []byte) []byte {
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply(kind),
+		reply.MakeNullBulkReply(),
+		reply.MakeIntReply(0),
+	}).ToBytes()
+}
+
+// Subscribe subscribes client to the given channels, a confirmation is sent for each channel.
+// Closed client is checked under hub lock, so that UnsubscribeAll in AfterClientClose won't miss its subscriptions
+func (hub *Hub) Subscribe(c resp.Connection, channels []string) resp.Reply {
+	for _, channel := range channels {
+		hub.mu.Lock()
+		if c.IsClosed() {
+			hub.mu.Unlock()
+			break
+		}
+		hub.channels.add(channel, c)
+		c.Subscribe(channel)
+		hub.mu.Unlock()
+		c.Push(makeSubsReply(subscribeBytes, channel, c.SubsCount()))
+	}
+	return &reply.NoReply{}
+}
+
+func (hub *Hub) unsubscribe(c resp.Connection, channel string) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	c.UnSubscribe(channel)
+	hub.channels.remove(channel, c)
+}
+
+// UnSubscribe unsubscribes client from the given channels, or all channels if none is given
+func (hub *Hub) UnSubscribe(c resp.Connection, channels []string) resp.Reply {
+	if len(channels) == 0 {
+		channels = c.GetChannels()
+		if len(channels) == 0 {
+			c.Push(makeNoSubsReply(unsubscribeBytes))
+			return &reply.NoReply{}
+		}
+	}
+	for _, channel := range channels {
+		hub.unsubscribe(c, channel)
+		c.Push(makeSubsReply(unsubscribeBytes, channel, c.SubsCount()))
+	}
+	return &reply.NoReply{}
+}
+
+// PSubscribe subscribes client to the given patterns
+func (hub *Hub) PSubscribe(c resp.Connection, patterns []string) resp.Reply {
+	for _, pattern := range patterns {
+		hub.mu.Lock()
+		if c.IsClosed() {
+			hub.mu.Unlock()
+			break
+		}
+		ps, ok := hub.patterns[pattern]
+		if !ok {
+			compiled, err := wildcard.CompilePattern(pattern)
+			if err != nil {
+				hub.mu.Unlock()
+				c.Push(reply.MakeErrReply("ERR invalid pattern " + pattern).ToBytes())
+				continue
+			}
+			ps = &patternSubs{
+				pattern: compiled,
+				subs:    make(map[resp.Connection]struct{}),
+			}
+			hub.patterns[pattern] = ps
+		}
+		ps.subs[c] = struct{}{}
+		c.PSubscribe(pattern)
+		hub.mu.Unlock()
+		c.Push(makeSubsReply(psubscribeBytes, pattern, c.SubsCount()))
+	}
+	return &reply.NoReply{}
+}
+
+func (hub *Hub) punsubscribe(c resp.Connection, pattern string) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	c.PUnSubscribe(pattern)
+	ps, ok := hub.patterns[pattern]
+	if !ok {
+		return
+	}
+	delete(ps.subs, c)
+	if len(ps.subs) == 0 {
+		delete(hub.patterns, pattern)
+	}
+}
+
+// PUnSubscribe unsubscribes client from the given patterns, or all patterns if none is given
+func (hub *Hub) PUnSubscribe(c resp.Connection, patterns []string) resp.Reply {
+	if len(patterns) == 0 {
+		patterns = c.GetPatterns()
+		if len(patterns) == 0 {
+			c.Push(makeNoSubsReply(punsubscribeBytes))
+			return &reply.NoReply{}
+		}
+	}
+	for _, pattern := range patterns {
+		hub.punsubscribe(c, pattern)
+		c.Push(makeSubsReply(punsubscribeBytes, pattern, c.SubsCount()))
+	}
+	return &reply.NoReply{}
+}
+
+// SSubscribe subscribes client to the given shard channels
+func (hub *Hub) SSubscribe(c resp.Connection, channels []string) resp.Reply {
+	for _, channel := range channels {
+		hub.mu.Lock()
+		if c.IsClosed() {
+			hub.mu.Unlock()
+			break
+		}
+		hub.shardChannels.add(channel, c)
+		c.SSubscribe(channel)
+		hub.mu.Unlock()
+		c.Push(makeSubsReply(ssubscribeBytes, channel, c.ShardSubsCount()))
+	}
+	return &reply.NoReply{}
+}
+
+func (hub *Hub) sunsubscribe(c resp.Connection, channel string) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	c.SUnSubscribe(channel)
+	hub.shardChannels.remove(channel, c)
+}
+
+// SUnSubscribe unsubscribes client from the given shard channels, or all shard channels if none is given
+func (hub *Hub) SUnSubscribe(c resp.Connection, channels []string) resp.Reply {
+	if len(channels) == 0 {
+		channels = c.GetShardChannels()
+		if len(channels) == 0 {
+			c.Push(makeNoSubsReply(sunsubscribeBytes))
+			return &reply.NoReply{}
+		}
+	}
+	for _, channel := range channels {
+		hub.sunsubscribe(c, channel)
+		c.Push(makeSubsReply(sunsubscribeBytes, channel, c.ShardSubsCount()))
+	}
+	return &reply.NoReply{}
+}
+
+// UnsubscribeAll removes all subscriptions of the closed client silently
+func (hub *Hub) UnsubscribeAll(c resp.Connection) {
+	for _, channel := range c.GetChannels() {
+		hub.unsubscribe(c, channel)
+	}
+	for _, pattern := range c.GetPatterns() {
+		hub.punsubscribe(c, pattern)
+	}
+	for _, channel := range c.GetShardChannels() {
+		hub.sunsubscribe(c, channel)
+	}
+}
+
+// Publish sends message to subscribers of channel and matched patterns, returns number of receivers
+func (hub *Hub) Publish(channel string, message []byte) int {
+	channelBytes := []byte(channel)
+	type delivery struct {
+		c       resp.Connection
+		payload []byte
+	}
+	deliveries := make([]delivery, 0)
+	hub.mu.RLock()
+	if subs, ok := hub.channels[channel]; ok {
+		payload := reply.MakeMultiBulkReply([][]byte{messageBytes, channelBytes, message}).ToBytes()
+		for c := range subs {
+			deliveries = append(deliveries, delivery{c: c, payload: payload})
+		}
+	}
+	for pattern, ps := range hub.patterns {
+		if !ps.pattern.IsMatch(channel) {
+			continue
+		}
+		payload := reply.MakeMultiBulkReply([][]byte{pmessageBytes, []byte(pattern), channelBytes, message}).ToBytes()
+		for c := range ps.subs {
+			deliveries = append(deliveries, delivery{c: c, payload: payload})
+		}
+	}
+	hub.mu.RUnlock()
+	// push without holding lock, slow clients are disconnected by their connections instead of blocking publisher
+	for _, d := range deliveries {
+		d.c.Push(d.payload)
+	}
+	return len(deliveries)
+}
+
+// SPublish sends message to subscribers of shard channel, returns number of receivers
+func (hub *Hub) SPublish(channel string, message []byte) int {
+	payload := reply.MakeMultiBulkReply([][]byte{smessageBytes, []byte(channel), message}).ToBytes()
+	hub.mu.RLock()
+	receivers := make([]resp.Connection, 0, len(hub.shardChannels[channel]))
+	for c := range hub.shardChannels[channel] {
+		receivers = append(receivers, c)
+	}
+	hub.mu.RUnlock()
+	for _, c := range receivers {
+		c.Push(payload)
+	}
+	return len(receivers)
+}
+
+// Channels returns active channels matching the pattern, all active channels are returned if pattern is empty
+func (hub *Hub) Channels(pattern string) ([]string, error) {
+	return hub.matchChannels(hub.channels, pattern)
+}
+
+// ShardChannels returns active shard channels matching the pattern
+func (hub *Hub) ShardChannels(pattern string) ([]string, error) {
+	return hub.matchChannels(hub.shardChannels, pattern)
+}
+
+func (hub *Hub) matchChannels(table subscribers, pattern string) ([]string, error) {
+	var compiled *wildcard.Pattern
+	if pattern != "" {
+		var err error
+		if compiled, err = wildcard.CompilePattern(pattern); err != nil {
+			return nil, err
+		}
+	}
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	channels := make([]string, 0, len(table))
+	for channel := range table {
+		if compiled == nil || compiled.IsMatch(channel) {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+	return channels, nil
+}
+
+// NumSub returns number of subscribers of channel, pattern subscribers are not counted
+func (hub *Hub) NumSub(channel string) int {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	return len(hub.channels[channel])
+}
+
+// ShardNumSub returns number of subscribers of shard channel
+func (hub *Hub) ShardNumSub(channel string) int {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	return len(hub.shardChannels[channel])
+}
+
+// NumPat returns number of subscribed patterns
+func (hub *Hub) NumPat() int {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	return len(hub.patterns)
+}
+
+func toStrings(args [][]byte) []string {
+	result := make([]string, len(args))
+	for i, arg := range args {
+		result[i] = string(arg)
+	}
+	return result
+}
+
+// execPublish sends message to channel: PUBLISH channel message
+func execPublish(hub *Hub, args CmdArgs) resp.Reply {
+	return reply.MakeIntReply(int64(hub.Publish(string(args[0]), args[1])))
+}
+
+// execSPublish sends message to shard channel: SPUBLISH shardchannel message
+func execSPublish(hub *Hub, args CmdArgs) resp.Reply {
+	return reply.MakeIntReply(int64(hub.SPublish(string(args[0]), args[1])))
+}
+
+func makeChannelsReply(args CmdArgs, match func(pattern string) ([]string, error)) resp.Reply {
+	pattern := ""
+	if len(args) == 2 {
+		pattern = string(args[1])
+	}
+	channels, err := match(pattern)
+	if err != nil {
+		return reply.MakeErrReply("ERR invalid pattern " + pattern)
+	}
+	result := make([][]byte, len(channels))
+	for i, channel := range channels {
+		result[i] = []byte(channel)
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+func makeNumSubReply(args CmdArgs, numSub func(channel string) int) resp.Reply {
+	result := make([]resp.Reply, 0, 2*(len(args)-1))
+	for _, channel := range args[1:] {
+		result = append(result, reply.MakeBulkReply(channel), reply.MakeIntReply(int64(numSub(string(channel)))))
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// execPubSub inspects pubsub state:
+// PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT | SHARDCHANNELS [pattern] | SHARDNUMSUB [channel ...]
+func execPubSub(hub *Hub, args CmdArgs) resp.Reply {
+	subCmd := string(args[0])
+	switch {
+	case strings.EqualFold(subCmd, "channels") && len(args) <= 2:
+		return makeChannelsReply(args, hub.Channels)
+	case strings.EqualFold(subCmd, "numsub"):
+		return makeNumSubReply(args, hub.NumSub)
+	case strings.EqualFold(subCmd, "shardchannels") && len(args) <= 2:
+		return makeChannelsReply(args, hub.ShardChannels)
+	case strings.EqualFold(subCmd, "shardnumsub"):
+		return makeNumSubReply(args, hub.ShardNumSub)
+	case strings.EqualFold(subCmd, "numpat") && len(args) == 1:
+		return reply.MakeIntReply(int64(hub.NumPat()))
+	}
+	return reply.MakeErrReply("ERR unknown subcommand or wrong number of arguments for '" + subCmd + "'. Try PUBSUB HELP.")
+}
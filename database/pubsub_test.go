@@ -0,0 +1,172 @@
+package database
+
+import (
+	"io"
+	"net"
+	"ringodis/interface/resp"
+	"ringodis/lib/utils"
+	"ringodis/resp/conn"
+	"ringodis/resp/reply"
+	"ringodis/resp/reply/asserts"
+	"testing"
+	"time"
+)
+
+func assertWritten(t *testing.T, c *conn.FakeConn, expected ...[]byte) {
+	t.Helper()
+	var buf []byte
+	for _, b := range expected {
+		buf = append(buf, b...)
+	}
+	if string(c.Bytes()) != string(buf) {
+		t.Errorf("expected %q, actual %q", buf, c.Bytes())
+	}
+	c.Clean()
+}
+
+func TestPublish(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	c := conn.NewFakeConn()
+	sub := conn.NewFakeConn()
+	psub := conn.NewFakeConn()
+	server.Exec(sub, utils.ToCmdLine("subscribe", "news", "sports"))
+	assertWritten(t, sub, makeSubsReply(subscribeBytes, "news", 1), makeSubsReply(subscribeBytes, "sports", 2))
+	server.Exec(psub, utils.ToCmdLine("psubscribe", "n*"))
+	assertWritten(t, psub, makeSubsReply(psubscribeBytes, "n*", 1))
+
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("publish", "news", "hello")), 2)
+	assertWritten(t, sub, reply.MakeMultiBulkReply(utils.ToCmdLine("message", "news", "hello")).ToBytes())
+	assertWritten(t, psub, reply.MakeMultiBulkReply(utils.ToCmdLine("pmessage", "n*", "news", "hello")).ToBytes())
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("publish", "sports", "goal")), 1)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("publish", "weather", "rain")), 0)
+
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("pubsub", "channels")), []string{"news", "sports"})
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("pubsub", "channels", "s*")), []string{"sports"})
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("pubsub", "numpat")), 1)
+	result := server.Exec(c, utils.ToCmdLine("pubsub", "numsub", "news", "weather"))
+	expected := reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte("news")),
+		reply.MakeIntReply(1),
+		reply.MakeBulkReply([]byte("weather")),
+		reply.MakeIntReply(0),
+	})
+	if string(result.ToBytes()) != string(expected.ToBytes()) {
+		t.Errorf("unexpected numsub reply: %q", result.ToBytes())
+	}
+
+	sub.Clean()
+	server.Exec(sub, utils.ToCmdLine("unsubscribe", "news"))
+	assertWritten(t, sub, makeSubsReply(unsubscribeBytes, "news", 1))
+	// remaining subscriptions are removed after client is closed
+	server.AfterClientClose(sub)
+	server.AfterClientClose(psub)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("publish", "sports", "goal")), 0)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("pubsub", "numpat")), 0)
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("pubsub", "channels")), []string{})
+}
+
+func TestSubscribeMode(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	c := conn.NewFakeConn()
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("ping")), "PONG")
+	server.Exec(c, utils.ToCmdLine("subscribe", "a"))
+	server.Exec(c, utils.ToCmdLine("psubscribe", "b*"))
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("get", "k")),
+		"ERR Can't execute 'get': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING are allowed in this context")
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("ping")), []string{"pong", ""})
+
+	c.Clean()
+	server.Exec(c, utils.ToCmdLine("unsubscribe"))
+	server.Exec(c, utils.ToCmdLine("punsubscribe"))
+	assertWritten(t, c, makeSubsReply(unsubscribeBytes, "a", 1), makeSubsReply(punsubscribeBytes, "b*", 0))
+	server.Exec(c, utils.ToCmdLine("unsubscribe"))
+	assertWritten(t, c, makeNoSubsReply(unsubscribeBytes))
+	asserts.AssertNullBulk(t, server.Exec(c, utils.ToCmdLine("get", "k")))
+
+	server.Exec(c, utils.ToCmdLine("multi"))
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("subscribe", "a")), "ERR SUBSCRIBE is not allowed in MULTI")
+	server.Exec(c, utils.ToCmdLine("discard"))
+}
+
+func TestSPublish(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	c := conn.NewFakeConn()
+	sub := conn.NewFakeConn()
+	psub := conn.NewFakeConn()
+	server.Exec(sub, utils.ToCmdLine("ssubscribe", "orders"))
+	assertWritten(t, sub, makeSubsReply(ssubscribeBytes, "orders", 1))
+	server.Exec(psub, utils.ToCmdLine("psubscribe", "*"))
+	psub.Clean()
+
+	// shard channels are not visible to PUBLISH and pattern subscribers
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("publish", "orders", "1")), 1)
+	assertWritten(t, sub)
+	psub.Clean()
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("spublish", "orders", "2")), 1)
+	assertWritten(t, sub, reply.MakeMultiBulkReply(utils.ToCmdLine("smessage", "orders", "2")).ToBytes())
+	assertWritten(t, psub)
+
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("pubsub", "shardchannels")), []string{"orders"})
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("pubsub", "channels")), []string{})
+	result := server.Exec(c, utils.ToCmdLine("pubsub", "shardnumsub", "orders"))
+	expected := reply.MakeMultiRawReply([]resp.Reply{reply.MakeBulkReply([]byte("orders")), reply.MakeIntReply(1)})
+	if string(result.ToBytes()) != string(expected.ToBytes()) {
+		t.Errorf("unexpected shardnumsub reply: %q", result.ToBytes())
+	}
+
+	asserts.AssertErrReply(t, server.Exec(sub, utils.ToCmdLine("get", "k")),
+		"ERR Can't execute 'get': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING are allowed in this context")
+	server.Exec(sub, utils.ToCmdLine("sunsubscribe"))
+	assertWritten(t, sub, makeSubsReply(sunsubscribeBytes, "orders", 0))
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("spublish", "orders", "3")), 0)
+}
+
+func TestPublishSlowClient(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	local, remote := net.Pipe()
+	defer func() {
+		_ = remote.Close()
+	}()
+	// remote never reads, so writes to local block
+	sub := conn.NewConn(local)
+	server.Exec(sub, utils.ToCmdLine("subscribe", "news"))
+	c := conn.NewFakeConn()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10000; i++ {
+			server.Exec(c, utils.ToCmdLine("publish", "news", "hello"))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publisher is blocked by slow client")
+	}
+	// slow client is disconnected after too many pending messages
+	_ = remote.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4096)
+	for {
+		if _, err := remote.Read(buf); err != nil {
+			if err != io.EOF {
+				t.Errorf("expected EOF, actual %v", err)
+			}
+			break
+		}
+	}
+}
+
+func TestSubscribeAfterClose(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	local, remote := net.Pipe()
+	_ = remote.Close()
+	sub := conn.NewConn(local)
+	// AfterClientClose may run before the executing SUBSCRIBE
+	_ = sub.Close()
+	server.AfterClientClose(sub)
+	server.Exec(sub, utils.ToCmdLine("subscribe", "news"))
+	server.Exec(sub, utils.ToCmdLine("psubscribe", "n*"))
+	c := conn.NewFakeConn()
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("publish", "news", "hello")), 0)
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("pubsub", "channels")), []string{})
+}
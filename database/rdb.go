@@ -0,0 +1,351 @@
+package database
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"ringodis/config"
+	"ringodis/ds/dict"
+	List "ringodis/ds/list"
+	HashSet "ringodis/ds/set"
+	"ringodis/ds/stream"
+	"ringodis/ds/zset"
+	"ringodis/interface/database"
+	"ringodis/interface/resp"
+	"ringodis/lib/logger"
+	"ringodis/lib/rdb"
+	"ringodis/resp/reply"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var errSaving = errors.New("ERR Background save already in progress")
+
+// saveParam triggers a background save when both seconds elapsed and changes reached since last save
+type saveParam struct {
+	seconds int64
+	changes int64
+}
+
+// parseSaveParams parses save points like "900 1 300 10"
+func parseSaveParams(value string) []*saveParam {
+	fields := strings.Fields(value)
+	if len(fields)%2 != 0 {
+		logger.Warn("invalid save config: " + value)
+		return nil
+	}
+	params := make([]*saveParam, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err1 := strconv.ParseInt(fields[i], 10, 64)
+		changes, err2 := strconv.ParseInt(fields[i+1], 10, 64)
+		if err1 != nil || err2 != nil || seconds <= 0 || changes < 0 {
+			logger.Warn("invalid save config: " + value)
+			return nil
+		}
+		params = append(params, &saveParam{
+			seconds: seconds,
+			changes: changes,
+		})
+	}
+	return params
+}
+
+// execSave synchronously saves all databases into rdb file
+func execSave(server *Server) resp.Reply {
+	if err := server.SaveRDB(); err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return reply.MakeOkReply()
+}
+
+// execBGSave saves all databases into rdb file in background
+func execBGSave(server *Server) resp.Reply {
+	if err := server.BackgroundSaveRDB(); err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return reply.MakeStatusReply("Background saving started")
+}
+
+// execLastSave returns the unix time of the last successful save
+func execLastSave(server *Server) resp.Reply {
+	return reply.MakeIntReply(atomic.LoadInt64(&server.lastSave))
+}
+
+// SaveRDB dumps all databases into rdb file and returns after finished
+func (server *Server) SaveRDB() error {
+	if !atomic.CompareAndSwapInt32(&server.saving, 0, 1) {
+		return errSaving
+	}
+	defer atomic.StoreInt32(&server.saving, 0)
+	return server.saveRDB()
+}
+
+// BackgroundSaveRDB starts SaveRDB in a new goroutine
+func (server *Server) BackgroundSaveRDB() error {
+	if !atomic.CompareAndSwapInt32(&server.saving, 0, 1) {
+		return errSaving
+	}
+	go func() {
+		defer atomic.StoreInt32(&server.saving, 0)
+		if err := server.saveRDB(); err != nil {
+			logger.Error("background saving failed: " + err.Error())
+		}
+	}()
+	return nil
+}
+
+// saveRDB copies all keys while commands are paused, so that the file is a point-in-time snapshot,
+// then encodes the copies without blocking clients
+func (server *Server) saveRDB() error {
+	changes, snapshots, err := server.snapshot()
+	if err != nil {
+		return err
+	}
+	filename := config.Properties.RDBFilename
+	// write into a temp file in the same directory, so that it could be renamed atomically
+	tmpFile, err := os.CreateTemp(filepath.Dir(filename), "temp-*.rdb")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+	}()
+
+	writer := bufio.NewWriter(tmpFile)
+	enc := rdb.NewEncoder(writer)
+	if err = enc.WriteHeader(); err != nil {
+		return err
+	}
+	for i, objects := range snapshots {
+		if len(objects) == 0 {
+			continue
+		}
+		ttlCount := 0
+		for _, obj := range objects {
+			if obj.Expiration != nil {
+				ttlCount++
+			}
+		}
+		if err = enc.WriteDBHeader(i, len(objects), ttlCount); err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			if err = enc.WriteObject(obj); err != nil {
+				return err
+			}
+		}
+	}
+	if err = enc.WriteEnd(); err != nil {
+		return err
+	}
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+	if err = tmpFile.Sync(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpFile.Name(), filename); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&server.lastSave, time.Now().Unix())
+	atomic.StoreInt64(&server.changesAtSave, changes)
+	logger.Info("db saved on disk")
+	return nil
+}
+
+// snapshot copies every unexpired key of all databases into rdb objects while holding the gate exclusively,
+// it returns the number of changes the copies include and objects grouped by db index.
+// Saving fails if any key could not be copied, rather than dropping it silently
+func (server *Server) snapshot() (int64, [][]*rdb.Object, error) {
+	server.gate.Lock()
+	defer server.gate.Unlock()
+
+	snapshots := make([][]*rdb.Object, len(server.dbSet))
+	var err error
+	for i, holder := range server.dbSet {
+		db := holder.Load().(*DB)
+		objects := make([]*rdb.Object, 0, db.data.Len())
+		db.ForEach(func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+			if _, ok := entity.Data.(*stream.Stream); ok {
+				// rdb streams are encoded as listpacks of a radix tree, which is not supported yet
+				logger.Warn("skip stream key " + key + " when saving rdb")
+				return true
+			}
+			obj := entityToObject(key, entity)
+			if obj == nil {
+				err = errors.New("ERR unsupported type of key " + key)
+				return false
+			}
+			obj.DB = i
+			obj.Expiration = expiration
+			objects = append(objects, obj)
+			return true
+		})
+		if err != nil {
+			return 0, nil, err
+		}
+		snapshots[i] = objects
+	}
+	return server.changes(), snapshots, nil
+}
+
+func entityToObject(key string, entity *database.DataEntity) *rdb.Object {
+	obj := &rdb.Object{
+		Key: key,
+	}
+	switch val := entity.Data.(type) {
+	case []byte:
+		obj.Type = rdb.StringType
+		obj.Value = val
+	case dict.Dict:
+		hash := make(map[string][]byte, val.Len())
+		val.ForEach(func(field string, v interface{}) bool {
+			hash[field], _ = v.([]byte)
+			return true
+		})
+		obj.Type = rdb.HashType
+		obj.Value = hash
+	case List.List:
+		values := make([][]byte, 0, val.Len())
+		val.ForEach(func(i int, v interface{}) bool {
+			bytes, _ := v.([]byte)
+			values = append(values, bytes)
+			return true
+		})
+		obj.Type = rdb.ListType
+		obj.Value = values
+	case *HashSet.Set:
+		members := make([][]byte, 0, val.Len())
+		val.ForEach(func(member string) bool {
+			members = append(members, []byte(member))
+			return true
+		})
+		obj.Type = rdb.SetType
+		obj.Value = members
+	case *zset.ZSet:
+		entries := make([]*rdb.ZSetEntry, 0, val.Len())
+		if val.Len() > 0 {
+			val.ForEach(0, val.Len(), false, func(element *zset.Elem) bool {
+				entries = append(entries, &rdb.ZSetEntry{
+					Member: element.Member,
+					Score:  element.Score,
+				})
+				return true
+			})
+		}
+		obj.Type = rdb.ZSetType
+		obj.Value = entries
+	default:
+		return nil
+	}
+	return obj
+}
+
+func objectToEntity(obj *rdb.Object) *database.DataEntity {
+	switch obj.Type {
+	case rdb.StringType:
+		return &database.DataEntity{Data: obj.Value.([]byte)}
+	case rdb.ListType:
+		list := List.NewQuickList()
+		for _, v := range obj.Value.([][]byte) {
+			list.Add(v)
+		}
+		return &database.DataEntity{Data: list}
+	case rdb.SetType:
+		set := HashSet.Make()
+		for _, member := range obj.Value.([][]byte) {
+			set.Add(string(member))
+		}
+		return &database.DataEntity{Data: set}
+	case rdb.HashType:
+		hash := dict.MakeSimple()
+		for field, v := range obj.Value.(map[string][]byte) {
+			hash.Put(field, v)
+		}
+		return &database.DataEntity{Data: hash}
+	case rdb.ZSetType:
+		zs := zset.Make()
+		for _, e := range obj.Value.([]*rdb.ZSetEntry) {
+			zs.Add(e.Member, e.Score)
+		}
+		return &database.DataEntity{Data: zs}
+	}
+	return nil
+}
+
+// loadRDB reads rdb file into databases, does nothing if file not exists
+func (server *Server) loadRDB(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	now := time.Now()
+	dec := rdb.NewDecoder(file)
+	return dec.Parse(func(obj *rdb.Object) bool {
+		db, errReply := server.selectDB(obj.DB)
+		if errReply != nil {
+			logger.Warn("skip key " + obj.Key + ": " + errReply.Error())
+			return true
+		}
+		if obj.Expiration != nil && now.After(*obj.Expiration) {
+			return true
+		}
+		entity := objectToEntity(obj)
+		if entity == nil {
+			logger.Warn("skip key " + obj.Key + ": unsupported type " + obj.Type)
+			return true
+		}
+		db.PutEntity(obj.Key, entity)
+		if obj.Expiration != nil {
+			db.Expire(obj.Key, *obj.Expiration)
+		}
+		return true
+	})
+}
+
+// saveCron checks save points every second
+func (server *Server) saveCron() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if server.reachSavePoint() {
+				if err := server.BackgroundSaveRDB(); err != nil && err != errSaving {
+					logger.Error(err)
+				}
+			}
+		case <-server.closeChan:
+			return
+		}
+	}
+}
+
+func (server *Server) reachSavePoint() bool {
+	changes := server.changes() - atomic.LoadInt64(&server.changesAtSave)
+	elapsed := time.Now().Unix() - atomic.LoadInt64(&server.lastSave)
+	for _, param := range server.saveParams {
+		if elapsed >= param.seconds && changes >= param.changes {
+			return true
+		}
+	}
+	return false
+}
+
+// changes returns total number of write commands executed
+func (server *Server) changes() int64 {
+	var total int64
+	for _, holder := range server.dbSet {
+		total += atomic.LoadInt64(&holder.Load().(*DB).changes)
+	}
+	return total
+}
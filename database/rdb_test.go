@@ -0,0 +1,97 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"ringodis/config"
+	"ringodis/ds/dict"
+	"ringodis/ds/zset"
+	"ringodis/interface/database"
+	"ringodis/lib/rdb"
+	"ringodis/lib/utils"
+	"ringodis/resp/conn"
+	"ringodis/resp/reply"
+	"ringodis/resp/reply/asserts"
+	"testing"
+)
+
+func TestRDB(t *testing.T) {
+	config.Properties.RDBFilename = filepath.Join(t.TempDir(), "dump.rdb")
+
+	server := NewStandaloneServer()
+	c := conn.NewFakeConn()
+	server.Exec(c, utils.ToCmdLine("set", "a", "1"))
+	server.Exec(c, utils.ToCmdLine("set", "b", "b", "ex", "1000"))
+	server.Exec(c, utils.ToCmdLine("select", "3"))
+	server.Exec(c, utils.ToCmdLine("set", "c", "c"))
+	server.Exec(c, utils.ToCmdLine("rpush", "l", "a", "b"))
+	server.Exec(c, utils.ToCmdLine("sadd", "s", "1", "a"))
+	db, _ := server.selectDB(3)
+	hash := dict.MakeSimple()
+	hash.Put("f", []byte("v"))
+	db.PutEntity("h", &database.DataEntity{Data: hash})
+	zs := zset.Make()
+	zs.Add("m", 1.5)
+	db.PutEntity("z", &database.DataEntity{Data: zs})
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("save")), "OK")
+	asserts.AssertIntReplyGreaterThan(t, server.Exec(c, utils.ToCmdLine("lastsave")), 1)
+	server.Close()
+
+	server = NewStandaloneServer()
+	defer server.Close()
+	c = conn.NewFakeConn()
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("get", "a")), "1")
+	result := server.Exec(c, utils.ToCmdLine("ttl", "b"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code <= 0 {
+		t.Errorf("expected ttl more than 0, actual: %s", result.ToBytes())
+	}
+	server.Exec(c, utils.ToCmdLine("select", "3"))
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("get", "c")), "c")
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("type", "h")), "hash")
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("lrange", "l", "0", "-1")), []string{"a", "b"})
+	assertMembers(t, server.Exec(c, utils.ToCmdLine("smembers", "s")), "1", "a")
+	db, _ = server.selectDB(3)
+	entity, ok := db.GetEntity("z")
+	if !ok {
+		t.Error("expected zset to be loaded")
+		return
+	}
+	element, ok := entity.Data.(*zset.ZSet).Get("m")
+	if !ok || element.Score != 1.5 {
+		t.Error("wrong zset member")
+	}
+}
+
+func TestRDBSkipStream(t *testing.T) {
+	config.Properties.RDBFilename = filepath.Join(t.TempDir(), "dump.rdb")
+
+	server := NewStandaloneServer()
+	c := conn.NewFakeConn()
+	server.Exec(c, utils.ToCmdLine("xadd", "s", "1-1", "a", "1"))
+	server.Exec(c, utils.ToCmdLine("set", "k", "v"))
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("save")), "OK")
+	server.Close()
+
+	file, err := os.Open(config.Properties.RDBFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	keys := make([]string, 0)
+	err = rdb.NewDecoder(file).Parse(func(obj *rdb.Object) bool {
+		keys = append(keys, obj.Key)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "k" {
+		t.Errorf("expected only key k in rdb file, actually %v", keys)
+	}
+
+	server = NewStandaloneServer()
+	defer server.Close()
+	c = conn.NewFakeConn()
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("get", "k")), "v")
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("exists", "s")), 0)
+}
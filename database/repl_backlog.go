@@ -0,0 +1,49 @@
+package database
+
+// replBacklog is a circular buffer keeping the latest part of replication stream,
+// so that a replica reconnected shortly could continue from its offset instead of a full resync
+type replBacklog struct {
+	buf []byte
+	// offset of the first byte kept in buf
+	beginOffset int64
+	// offset of the byte following the last written one, it equals the replication offset
+	endOffset int64
+}
+
+func makeReplBacklog(size int, offset int64) *replBacklog {
+	return &replBacklog{
+		buf:         make([]byte, size),
+		beginOffset: offset,
+		endOffset:   offset,
+	}
+}
+
+func (b *replBacklog) pos(offset int64) int {
+	return int(offset % int64(len(b.buf)))
+}
+
+// write appends data to backlog, the oldest bytes are overwritten if it's full
+func (b *replBacklog) write(data []byte) {
+	size := len(b.buf)
+	if len(data) > size {
+		b.endOffset += int64(len(data) - size)
+		data = data[len(data)-size:]
+	}
+	n := copy(b.buf[b.pos(b.endOffset):], data)
+	copy(b.buf, data[n:])
+	b.endOffset += int64(len(data))
+	if b.endOffset-b.beginOffset > int64(size) {
+		b.beginOffset = b.endOffset - int64(size)
+	}
+}
+
+// readFrom returns a copy of bytes from the given offset to the end, returns false if the offset is not kept
+func (b *replBacklog) readFrom(offset int64) ([]byte, bool) {
+	if offset < b.beginOffset || offset > b.endOffset {
+		return nil, false
+	}
+	result := make([]byte, b.endOffset-offset)
+	n := copy(result, b.buf[b.pos(offset):])
+	copy(result[n:], b.buf)
+	return result, true
+}
@@ -0,0 +1,399 @@
+package database
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net"
+	"ringodis/aof"
+	"ringodis/config"
+	"ringodis/interface/resp"
+	"ringodis/lib/logger"
+	"ringodis/lib/utils"
+	"ringodis/resp/conn"
+	"ringodis/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultReplBacklogSize = 1 << 20
+	// master pings replicas periodically, so that replicas could detect timeout
+	replPingPeriod = 10 * time.Second
+)
+
+var pingCmdBytes = reply.MakeMultiBulkReply(utils.ToCmdLine("PING")).ToBytes()
+
+// replicaInfo is a replica connected to this server
+type replicaInfo struct {
+	conn resp.Connection
+	// ip and listening port announced by replica via REPLCONF
+	ip   string
+	port string
+	// online is true after PSYNC succeeded
+	online bool
+	// offset of the next byte to send, only accessed by the sending goroutine
+	offset int64
+	// offset acknowledged by replica
+	ackOffset int64
+	// time of the last acknowledgement, guarded by mu of replication
+	ackTime time.Time
+
+	notify    chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// replication holds replication states of server.
+// Both master and replica keep a backlog of the replication stream identified by replID and offset,
+// replica feeds its backlog with the stream received from master,
+// so that its own replicas could continue from the same offset after it's promoted.
+type replication struct {
+	mu     sync.Mutex
+	replID string
+	offset int64
+	// replID2 is the id of the previous master, PSYNC with it is accepted up to secondOffset
+	replID2      string
+	secondOffset int64
+	// backlog is created when the first replica connects
+	backlog *replBacklog
+	// db index selected in replication stream, -1 means SELECT is required before the next command
+	streamDB int
+	replicas map[resp.Connection]*replicaInfo
+
+	// slave is the connection state with master, it's nil if this server is a master
+	slave *slaveStatus
+	// masterConn keeps the db index selected by commands from master
+	masterConn *conn.FakeConn
+	// applying is held while applying commands from master or taking snapshot for replicas,
+	// so that a snapshot never contains a command which has not been fed into backlog yet
+	applying sync.Mutex
+
+	// number of full resyncs, accepted and rejected partial resyncs
+	syncFull       int64
+	syncPartialOK  int64
+	syncPartialErr int64
+}
+
+func makeReplication() *replication {
+	return &replication{
+		replID:     newReplID(),
+		streamDB:   -1,
+		replicas:   make(map[resp.Connection]*replicaInfo),
+		masterConn: conn.NewFakeConn(),
+	}
+}
+
+// newReplID generates a random 40 characters replication id
+func newReplID() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return utils.RandHexString(40)
+	}
+	return hex.EncodeToString(b)
+}
+
+func replBacklogSize() int {
+	if config.Properties.ReplBacklogSize > 0 {
+		return config.Properties.ReplBacklogSize
+	}
+	return defaultReplBacklogSize
+}
+
+func makeSelectCmdBytes(dbIndex int) []byte {
+	return reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(dbIndex))).ToBytes()
+}
+
+// appendStream writes data into backlog and wakes up replicas, caller should hold mu
+func (repl *replication) appendStream(data []byte) {
+	if repl.backlog == nil {
+		repl.offset += int64(len(data))
+		return
+	}
+	repl.backlog.write(data)
+	repl.offset += int64(len(data))
+	for _, r := range repl.replicas {
+		if !r.online {
+			continue
+		}
+		select {
+		case r.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// propagate sends write command executed by master to replicas,
+// replica doesn't propagate its own commands, it feeds the stream from master instead
+func (repl *replication) propagate(dbIndex int, cmdLine CmdLine) {
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	if repl.backlog == nil || repl.slave != nil {
+		return
+	}
+	if dbIndex != repl.streamDB {
+		repl.appendStream(makeSelectCmdBytes(dbIndex))
+		repl.streamDB = dbIndex
+	}
+	repl.appendStream(reply.MakeMultiBulkReply(cmdLine).ToBytes())
+}
+
+// pingReplicas sends PING through replication stream
+func (repl *replication) pingReplicas() {
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	if repl.backlog == nil || repl.slave != nil || len(repl.replicas) == 0 {
+		return
+	}
+	repl.appendStream(pingCmdBytes)
+}
+
+// getReplica returns info of connection, creates it if not exists, caller should hold mu
+func (repl *replication) getReplica(c resp.Connection) *replicaInfo {
+	r, ok := repl.replicas[c]
+	if !ok {
+		r = &replicaInfo{
+			conn:   c,
+			notify: make(chan struct{}, 1),
+			closed: make(chan struct{}),
+		}
+		if addr, ok := c.(interface{ RemoteAddr() net.Addr }); ok && addr.RemoteAddr() != nil {
+			r.ip, _, _ = net.SplitHostPort(addr.RemoteAddr().String())
+		}
+		// client closed while the command is executing has been removed by AfterClientClose
+		if c.IsClosed() {
+			close(r.closed)
+			return r
+		}
+		repl.replicas[c] = r
+	}
+	return r
+}
+
+// startReplica starts sending replication stream from offset to replica, caller should hold mu
+func (repl *replication) startReplica(c resp.Connection, offset int64) {
+	r := repl.getReplica(c)
+	r.online = true
+	r.offset = offset
+	atomic.StoreInt64(&r.ackOffset, offset)
+	r.ackTime = time.Now()
+	go repl.serveReplica(r)
+	r.notify <- struct{}{}
+}
+
+// serveReplica sends backlog to replica until it's disconnected
+func (repl *replication) serveReplica(r *replicaInfo) {
+	for {
+		select {
+		case <-r.notify:
+		case <-r.closed:
+			return
+		}
+		repl.mu.Lock()
+		data, ok := repl.backlog.readFrom(r.offset)
+		repl.mu.Unlock()
+		if !ok {
+			logger.Warn("replica lags behind replication backlog, disconnect it")
+			closeConn(r.conn)
+			return
+		}
+		if len(data) == 0 {
+			continue
+		}
+		if _, err := r.conn.Write(data); err != nil {
+			closeConn(r.conn)
+			return
+		}
+		r.offset += int64(len(data))
+	}
+}
+
+func closeConn(c resp.Connection) {
+	if closer, ok := c.(io.Closer); ok {
+		_ = closer.Close()
+	}
+}
+
+// removeReplica is called after connection closed
+func (repl *replication) removeReplica(c resp.Connection) {
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	r, ok := repl.replicas[c]
+	if !ok {
+		return
+	}
+	delete(repl.replicas, c)
+	r.closeOnce.Do(func() {
+		close(r.closed)
+	})
+}
+
+// disconnectReplicas closes all replicas, they will resync as the replication stream is changed
+func (repl *replication) disconnectReplicas() {
+	repl.mu.Lock()
+	replicas := make([]resp.Connection, 0, len(repl.replicas))
+	for c, r := range repl.replicas {
+		if r.online {
+			replicas = append(replicas, c)
+		}
+	}
+	repl.mu.Unlock()
+	for _, c := range replicas {
+		closeConn(c)
+	}
+}
+
+// tryPartialSync continues replication from the offset kept in backlog,
+// offset is the position of the next byte wanted by replica
+func (repl *replication) tryPartialSync(c resp.Connection, replID string, offset int64) bool {
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	if replID == "?" || repl.backlog == nil {
+		return false
+	}
+	valid := (replID == repl.replID && offset <= repl.offset) ||
+		(replID == repl.replID2 && offset <= repl.secondOffset)
+	if !valid {
+		repl.syncPartialErr++
+		return false
+	}
+	if _, ok := repl.backlog.readFrom(offset); !ok {
+		repl.syncPartialErr++
+		return false
+	}
+	if _, err := c.Write([]byte("+CONTINUE " + repl.replID + "\r\n")); err != nil {
+		return true
+	}
+	repl.syncPartialOK++
+	repl.startReplica(c, offset)
+	return true
+}
+
+// fullSync sends snapshot of all databases to replica and starts replication from the snapshot offset.
+// The snapshot is made of commands like aof, so that all data types could be transferred,
+// it is taken while all commands are paused, then sent without blocking others.
+func (server *Server) fullSync(c resp.Connection) error {
+	repl := server.repl
+	buf := &bytes.Buffer{}
+	repl.applying.Lock()
+	server.gate.Lock()
+	err := aof.Dump(server, buf)
+	repl.mu.Lock()
+	if repl.backlog == nil {
+		repl.backlog = makeReplBacklog(replBacklogSize(), repl.offset)
+	}
+	// tell replica which db is selected in the following stream
+	if repl.streamDB >= 0 {
+		buf.Write(makeSelectCmdBytes(repl.streamDB))
+	}
+	replID, offset := repl.replID, repl.offset
+	repl.mu.Unlock()
+	server.gate.Unlock()
+	repl.applying.Unlock()
+	if err != nil {
+		return err
+	}
+
+	header := "+FULLRESYNC " + replID + " " + strconv.FormatInt(offset, 10) + "\r\n" +
+		"$" + strconv.Itoa(buf.Len()) + "\r\n"
+	if _, err = c.Write([]byte(header)); err != nil {
+		return err
+	}
+	if _, err = c.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	repl.syncFull++
+	// replica will be disconnected by the sending goroutine if backlog has been overwritten during transfer
+	repl.startReplica(c, offset)
+	return nil
+}
+
+// execPSync starts replication for replica: PSYNC replicationid offset
+// offset is the position of the next wanted byte counting from 1, it's -1 for a full resync
+func execPSync(server *Server, c resp.Connection, args CmdArgs) resp.Reply {
+	offset, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if server.repl.tryPartialSync(c, string(args[0]), offset-1) {
+		return &reply.NoReply{}
+	}
+	if err = server.fullSync(c); err != nil {
+		logger.Warn("full resync failed: " + err.Error())
+		closeConn(c)
+	}
+	return &reply.NoReply{}
+}
+
+// execReplConf configures replication by replica: REPLCONF option value [option value ...]
+// ACK is sent by replica periodically and is never replied
+func execReplConf(server *Server, c resp.Connection, args CmdArgs) resp.Reply {
+	if len(args)%2 != 0 {
+		return reply.MakeSyntaxErrReply()
+	}
+	repl := server.repl
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	r := repl.getReplica(c)
+	for i := 0; i < len(args); i += 2 {
+		value := string(args[i+1])
+		switch strings.ToLower(string(args[i])) {
+		case "listening-port":
+			r.port = value
+		case "ip-address":
+			r.ip = value
+		case "capa":
+		case "ack":
+			offset, err := strconv.ParseInt(value, 10, 64)
+			if err == nil {
+				atomic.StoreInt64(&r.ackOffset, offset)
+				r.ackTime = time.Now()
+			}
+			return &reply.NoReply{}
+		default:
+			return reply.MakeErrReply("ERR Unrecognized REPLCONF option: " + string(args[i]))
+		}
+	}
+	return reply.MakeOkReply()
+}
+
+// execRole returns replication role of server
+// master replies: master, offset, [ip, port, acknowledged offset] of every replica
+// replica replies: slave, master ip, master port, state, offset
+func execRole(server *Server) resp.Reply {
+	repl := server.repl
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	if repl.slave != nil {
+		port, _ := strconv.Atoi(repl.slave.port)
+		return reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte("slave")),
+			reply.MakeBulkReply([]byte(repl.slave.host)),
+			reply.MakeIntReply(int64(port)),
+			reply.MakeBulkReply([]byte(repl.slave.getState())),
+			reply.MakeIntReply(repl.offset),
+		})
+	}
+	replicas := make([]resp.Reply, 0, len(repl.replicas))
+	for _, r := range repl.replicas {
+		if !r.online {
+			continue
+		}
+		replicas = append(replicas, reply.MakeMultiBulkReply([][]byte{
+			[]byte(r.ip),
+			[]byte(r.port),
+			[]byte(strconv.FormatInt(atomic.LoadInt64(&r.ackOffset), 10)),
+		}))
+	}
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte("master")),
+		reply.MakeIntReply(repl.offset),
+		reply.MakeMultiRawReply(replicas),
+	})
+}
@@ -0,0 +1,471 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"ringodis/config"
+	"ringodis/interface/resp"
+	"ringodis/lib/logger"
+	"ringodis/lib/utils"
+	"ringodis/resp/conn"
+	"ringodis/resp/parser"
+	"ringodis/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultReplTimeout = 60 * time.Second
+	replRetryInterval  = time.Second
+)
+
+// states of replica
+const (
+	replStateConnect    = "connect"
+	replStateConnecting = "connecting"
+	replStateSync       = "sync"
+	replStateConnected  = "connected"
+)
+
+var errReplStopped = errors.New("replication stopped")
+
+// slaveStatus is the connection state with master
+type slaveStatus struct {
+	host string
+	port string
+
+	mu    sync.Mutex
+	state string
+	conn  net.Conn
+	// unix nano time of the last data received from master
+	lastIO   int64
+	stopped  bool
+	stopChan chan struct{}
+}
+
+func (status *slaveStatus) getState() string {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	return status.state
+}
+
+func (status *slaveStatus) setState(state string) {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	status.state = state
+}
+
+// setConn binds the connection with master, returns false if replication has been stopped
+func (status *slaveStatus) setConn(c net.Conn) bool {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	if status.stopped {
+		return false
+	}
+	status.conn = c
+	return true
+}
+
+func (status *slaveStatus) touch() {
+	atomic.StoreInt64(&status.lastIO, time.Now().UnixNano())
+}
+
+// stop disconnects from master, the replication goroutine will exit
+func (status *slaveStatus) stop() {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	if status.stopped {
+		return
+	}
+	status.stopped = true
+	close(status.stopChan)
+	if status.conn != nil {
+		_ = status.conn.Close()
+	}
+}
+
+func replTimeout() time.Duration {
+	if config.Properties.ReplTimeout > 0 {
+		return time.Duration(config.Properties.ReplTimeout) * time.Second
+	}
+	return defaultReplTimeout
+}
+
+// startReplication replicates from the given master, returns false if it's already replicating from it
+func (server *Server) startReplication(host string, port string) bool {
+	repl := server.repl
+	repl.mu.Lock()
+	old := repl.slave
+	if old != nil && old.host == host && old.port == port {
+		repl.mu.Unlock()
+		return false
+	}
+	status := &slaveStatus{
+		host:     host,
+		port:     port,
+		state:    replStateConnect,
+		stopChan: make(chan struct{}),
+	}
+	repl.slave = status
+	repl.mu.Unlock()
+	if old != nil {
+		old.stop()
+	}
+	go server.replicate(status)
+	return true
+}
+
+// promote stops replication and turns this server into master,
+// replicas of the old master could continue from the current offset with the old replication id
+func (server *Server) promote() {
+	repl := server.repl
+	repl.mu.Lock()
+	status := repl.slave
+	if status == nil {
+		repl.mu.Unlock()
+		return
+	}
+	repl.slave = nil
+	repl.replID2 = repl.replID
+	repl.secondOffset = repl.offset
+	repl.replID = newReplID()
+	if repl.backlog == nil {
+		repl.backlog = makeReplBacklog(replBacklogSize(), repl.offset)
+	}
+	repl.mu.Unlock()
+	status.stop()
+}
+
+// replicate keeps syncing with master until stopped
+func (server *Server) replicate(status *slaveStatus) {
+	for {
+		err := server.syncWithMaster(status)
+		select {
+		case <-status.stopChan:
+			return
+		default:
+		}
+		logger.Warn("replication from " + net.JoinHostPort(status.host, status.port) + " interrupted: " + err.Error())
+		status.setState(replStateConnect)
+		select {
+		case <-status.stopChan:
+			return
+		case <-time.After(replRetryInterval):
+		}
+	}
+}
+
+// readReplLine reads a single line reply from master
+func readReplLine(br *bufio.Reader) (string, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readSnapshot reads snapshot like `$<length>\r\n<payload>` sent by master, there is no CRLF after payload
+func readSnapshot(br *bufio.Reader) ([]byte, error) {
+	for {
+		line, err := readReplLine(br)
+		if err != nil {
+			return nil, err
+		}
+		// master may send newlines to keep connection alive while preparing snapshot
+		if line == "" {
+			continue
+		}
+		if line[0] != '$' {
+			return nil, errors.New("unexpected snapshot header: " + line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, errors.New("invalid snapshot size: " + line)
+		}
+		payload := make([]byte, size)
+		if _, err = io.ReadFull(br, payload); err != nil {
+			return nil, err
+		}
+		return payload, nil
+	}
+}
+
+// syncWithMaster does handshake and PSYNC with master, then applies the replication stream until disconnected
+func (server *Server) syncWithMaster(status *slaveStatus) error {
+	timeout := replTimeout()
+	netConn, err := net.DialTimeout("tcp", net.JoinHostPort(status.host, status.port), timeout)
+	if err != nil {
+		return err
+	}
+	if !status.setConn(netConn) {
+		_ = netConn.Close()
+		return errReplStopped
+	}
+	defer func() {
+		_ = netConn.Close()
+	}()
+	status.setState(replStateConnecting)
+	br := bufio.NewReader(netConn)
+	request := func(args ...string) (string, error) {
+		_ = netConn.SetDeadline(time.Now().Add(timeout))
+		if _, err := netConn.Write(reply.MakeMultiBulkReply(utils.ToCmdLine(args...)).ToBytes()); err != nil {
+			return "", err
+		}
+		line, err := readReplLine(br)
+		if err == nil && strings.HasPrefix(line, "-") {
+			err = errors.New(line[1:])
+		}
+		return line, err
+	}
+
+	if auth := config.Properties.MasterAuth; auth != "" {
+		args := []string{"AUTH", auth}
+		if user := config.Properties.MasterUser; user != "" {
+			args = []string{"AUTH", user, auth}
+		}
+		if _, err = request(args...); err != nil {
+			return err
+		}
+	}
+	if _, err = request("PING"); err != nil {
+		return err
+	}
+	port := config.Properties.SlaveAnnouncePort
+	if port == 0 {
+		port = config.Properties.Port
+	}
+	if _, err = request("REPLCONF", "listening-port", strconv.Itoa(port)); err != nil {
+		return err
+	}
+	if ip := config.Properties.SlaveAnnounceIP; ip != "" {
+		if _, err = request("REPLCONF", "ip-address", ip); err != nil {
+			return err
+		}
+	}
+	if _, err = request("REPLCONF", "capa", "psync2"); err != nil {
+		return err
+	}
+
+	replID, offset := server.repl.psyncArgs()
+	line, err := request("PSYNC", replID, strconv.FormatInt(offset, 10))
+	if err != nil {
+		return err
+	}
+	status.setState(replStateSync)
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) == 3 && fields[0] == "+FULLRESYNC":
+		masterOffset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return errors.New("invalid psync reply: " + line)
+		}
+		payload, err := readSnapshot(br)
+		if err != nil {
+			return err
+		}
+		if err = server.loadSnapshot(payload, fields[1], masterOffset); err != nil {
+			return err
+		}
+	case len(fields) > 0 && fields[0] == "+CONTINUE":
+		if len(fields) == 2 {
+			server.repl.switchReplID(fields[1])
+		}
+	default:
+		return errors.New("unexpected psync reply: " + line)
+	}
+	_ = netConn.SetDeadline(time.Time{})
+	status.setState(replStateConnected)
+	logger.Info("replication from " + net.JoinHostPort(status.host, status.port) + " started")
+
+	done := make(chan struct{})
+	defer close(done)
+	go server.replicaCron(status, netConn, done)
+	return server.receiveStream(status, br)
+}
+
+// psyncArgs returns replication id and the next wanted offset counting from 1,
+// a server which never replicated asks for a full resync
+func (repl *replication) psyncArgs() (string, int64) {
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	if repl.backlog == nil && repl.offset == 0 {
+		return "?", -1
+	}
+	return repl.replID, repl.offset + 1
+}
+
+// switchReplID follows the new replication id of master after it has been promoted
+func (repl *replication) switchReplID(replID string) {
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	if replID == repl.replID {
+		return
+	}
+	repl.replID2 = repl.replID
+	repl.secondOffset = repl.offset
+	repl.replID = replID
+}
+
+// loadSnapshot replaces all data with snapshot from master, then continues replication from the offset
+func (server *Server) loadSnapshot(payload []byte, replID string, offset int64) error {
+	repl := server.repl
+	repl.applying.Lock()
+	defer repl.applying.Unlock()
+	masterConn := conn.NewFakeConn()
+	for i := range server.dbSet {
+		masterConn.SelectDB(i)
+		server.execFromMaster(masterConn, utils.ToCmdLine("FlushDB"))
+	}
+	masterConn.SelectDB(0)
+	ch := parser.ParseStream(bytes.NewReader(payload))
+	for p := range ch {
+		if p.Err != nil {
+			if p.Err == io.EOF {
+				break
+			}
+			return p.Err
+		}
+		if cmd, ok := p.Data.(*reply.MultiBulkReply); ok {
+			server.execFromMaster(masterConn, cmd.Args)
+		}
+	}
+	repl.mu.Lock()
+	repl.replID = replID
+	repl.replID2 = ""
+	repl.offset = offset
+	repl.backlog = makeReplBacklog(replBacklogSize(), offset)
+	repl.masterConn = masterConn
+	repl.streamDB = masterConn.GetDBIndex()
+	repl.mu.Unlock()
+	// replication stream has changed, replicas of this server must resync
+	repl.disconnectReplicas()
+	return nil
+}
+
+// receiveStream applies commands from master and feeds them into backlog
+func (server *Server) receiveStream(status *slaveStatus, br *bufio.Reader) error {
+	ch := parser.ParseStream(br)
+	defer func() {
+		// drain remaining payloads so that parser goroutine could exit
+		go func() {
+			for range ch {
+			}
+		}()
+	}()
+	status.touch()
+	for p := range ch {
+		if p.Err != nil {
+			return p.Err
+		}
+		status.touch()
+		cmd, ok := p.Data.(*reply.MultiBulkReply)
+		if !ok {
+			continue
+		}
+		server.applyFromMaster(cmd.Args)
+	}
+	return io.EOF
+}
+
+// applyFromMaster executes a command from replication stream and feeds it into backlog
+func (server *Server) applyFromMaster(cmdLine CmdLine) {
+	repl := server.repl
+	repl.applying.Lock()
+	defer repl.applying.Unlock()
+	server.execFromMaster(repl.masterConn, cmdLine)
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	repl.streamDB = repl.masterConn.GetDBIndex()
+	repl.appendStream(reply.MakeMultiBulkReply(cmdLine).ToBytes())
+}
+
+// execFromMaster executes command from master, which is not restricted by read only
+func (server *Server) execFromMaster(c *conn.FakeConn, cmdLine CmdLine) {
+	switch strings.ToLower(string(cmdLine[0])) {
+	case "select":
+		if len(cmdLine) != 2 {
+			return
+		}
+		dbIndex, err := strconv.Atoi(string(cmdLine[1]))
+		if err == nil && dbIndex >= 0 && dbIndex < len(server.dbSet) {
+			c.SelectDB(dbIndex)
+		}
+	case "ping":
+	default:
+		db, errReply := server.selectDB(c.GetDBIndex())
+		if errReply != nil {
+			return
+		}
+		if res := db.Exec(c, cmdLine); reply.IsErrorReply(res) {
+			logger.Warn("exec command from master failed: " + string(res.ToBytes()))
+		}
+	}
+}
+
+// replicaCron acknowledges offset to master every second, and disconnects if master has been silent too long
+func (server *Server) replicaCron(status *slaveStatus, netConn net.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	timeout := replTimeout()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		if time.Since(time.Unix(0, atomic.LoadInt64(&status.lastIO))) > timeout {
+			logger.Warn("master timeout, reconnecting")
+			_ = netConn.Close()
+			return
+		}
+		server.repl.mu.Lock()
+		offset := server.repl.offset
+		server.repl.mu.Unlock()
+		ack := reply.MakeMultiBulkReply(utils.ToCmdLine("REPLCONF", "ACK", strconv.FormatInt(offset, 10)))
+		_ = netConn.SetWriteDeadline(time.Now().Add(timeout))
+		if _, err := netConn.Write(ack.ToBytes()); err != nil {
+			_ = netConn.Close()
+			return
+		}
+	}
+}
+
+// execReplicaOf replicates from master or turns into master: REPLICAOF host port | REPLICAOF NO ONE
+func execReplicaOf(server *Server, args CmdArgs) resp.Reply {
+	host, port := string(args[0]), string(args[1])
+	if strings.EqualFold(host, "no") && strings.EqualFold(port, "one") {
+		server.promote()
+		return reply.MakeOkReply()
+	}
+	if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+		return reply.MakeErrReply("ERR Invalid master port")
+	}
+	if !server.startReplication(host, port) {
+		return reply.MakeStatusReply("OK Already connected to specified master")
+	}
+	return reply.MakeOkReply()
+}
+
+// isReplica tells whether this server replicates from a master
+func (repl *replication) isReplica() bool {
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	return repl.slave != nil
+}
+
+// stop disconnects from master and replicas while server is closing
+func (repl *replication) stop() {
+	repl.mu.Lock()
+	status := repl.slave
+	repl.mu.Unlock()
+	if status != nil {
+		status.stop()
+	}
+	repl.disconnectReplicas()
+}
@@ -0,0 +1,175 @@
+package database
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"ringodis/config"
+	"ringodis/lib/utils"
+	"ringodis/resp/conn"
+	"ringodis/resp/parser"
+	"ringodis/resp/reply"
+	"ringodis/resp/reply/asserts"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplBacklog(t *testing.T) {
+	backlog := makeReplBacklog(8, 100)
+	if data, ok := backlog.readFrom(100); !ok || len(data) != 0 {
+		t.Errorf("expected empty backlog, actual %q", data)
+	}
+	backlog.write([]byte("abcde"))
+	if data, ok := backlog.readFrom(102); !ok || string(data) != "cde" {
+		t.Errorf("expected cde, actual %q", data)
+	}
+	// overwrite the oldest bytes
+	backlog.write([]byte("fghij"))
+	if _, ok := backlog.readFrom(101); ok {
+		t.Error("expected offset 101 to be overwritten")
+	}
+	if data, ok := backlog.readFrom(102); !ok || string(data) != "cdefghij" {
+		t.Errorf("expected cdefghij, actual %q", data)
+	}
+	backlog.write([]byte("0123456789"))
+	if data, ok := backlog.readFrom(112); !ok || string(data) != "23456789" {
+		t.Errorf("expected 23456789, actual %q", data)
+	}
+	if _, ok := backlog.readFrom(121); ok {
+		t.Error("expected offset beyond end to be rejected")
+	}
+}
+
+// serveForTest accepts connections and executes commands by server, returns the listening address
+func serveForTest(t *testing.T, server *Server) (string, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		for {
+			netConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				client := conn.NewConn(netConn)
+				for payload := range parser.ParseStream(netConn) {
+					if payload.Err != nil {
+						if payload.Err == io.EOF || strings.Contains(payload.Err.Error(), "closed") {
+							_ = client.Close()
+							server.AfterClientClose(client)
+							return
+						}
+						continue
+					}
+					if cmd, ok := payload.Data.(*reply.MultiBulkReply); ok {
+						_, _ = client.Write(server.Exec(client, cmd.Args).ToBytes())
+					}
+				}
+			}()
+		}
+	}()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	return host, port
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReplication(t *testing.T) {
+	config.Properties.RDBFilename = filepath.Join(t.TempDir(), "dump.rdb")
+	master := NewStandaloneServer()
+	defer master.Close()
+	replica := NewStandaloneServer()
+	defer replica.Close()
+	host, port := serveForTest(t, master)
+
+	c := conn.NewFakeConn()
+	master.Exec(c, utils.ToCmdLine("set", "a", "1"))
+	master.Exec(c, utils.ToCmdLine("rpush", "list", "x", "y"))
+	replica.Exec(c, utils.ToCmdLine("set", "stale", "1"))
+
+	rc := conn.NewFakeConn()
+	asserts.AssertStatusReply(t, replica.Exec(rc, utils.ToCmdLine("replicaof", host, port)), "OK")
+	waitFor(t, func() bool {
+		return replica.repl.slave.getState() == replStateConnected
+	})
+	// snapshot replaces data of replica
+	asserts.AssertBulkReply(t, replica.Exec(rc, utils.ToCmdLine("get", "a")), "1")
+	asserts.AssertMultiBulkReply(t, replica.Exec(rc, utils.ToCmdLine("lrange", "list", "0", "-1")), []string{"x", "y"})
+	asserts.AssertNullBulk(t, replica.Exec(rc, utils.ToCmdLine("get", "stale")))
+	asserts.AssertErrReply(t, replica.Exec(rc, utils.ToCmdLine("set", "b", "2")),
+		"READONLY You can't write against a read only replica.")
+
+	// write commands are propagated with the selected db
+	master.Exec(c, utils.ToCmdLine("set", "b", "2"))
+	master.Exec(c, utils.ToCmdLine("select", "1"))
+	master.Exec(c, utils.ToCmdLine("set", "c", "3"))
+	rc1 := conn.NewFakeConn()
+	rc1.SelectDB(1)
+	waitFor(t, func() bool {
+		return string(replica.Exec(rc1, utils.ToCmdLine("exists", "c")).ToBytes()) == ":1\r\n"
+	})
+	asserts.AssertBulkReply(t, replica.Exec(rc, utils.ToCmdLine("get", "b")), "2")
+	asserts.AssertNullBulk(t, replica.Exec(rc, utils.ToCmdLine("get", "c")))
+	waitFor(t, func() bool {
+		master.repl.mu.Lock()
+		defer master.repl.mu.Unlock()
+		replica.repl.mu.Lock()
+		defer replica.repl.mu.Unlock()
+		return master.repl.offset == replica.repl.offset
+	})
+
+	// replica continues from its offset after reconnected
+	replica.repl.slave.mu.Lock()
+	_ = replica.repl.slave.conn.Close()
+	replica.repl.slave.mu.Unlock()
+	master.Exec(c, utils.ToCmdLine("set", "d", "4"))
+	waitFor(t, func() bool {
+		return string(replica.Exec(rc1, utils.ToCmdLine("get", "d")).ToBytes()) == "$1\r\n4\r\n"
+	})
+	master.repl.mu.Lock()
+	if master.repl.syncFull != 1 || master.repl.syncPartialOK != 1 {
+		t.Errorf("expected 1 full resync and 1 partial resync, actual %d and %d",
+			master.repl.syncFull, master.repl.syncPartialOK)
+	}
+	master.repl.mu.Unlock()
+
+	role := string(replica.Exec(rc, utils.ToCmdLine("role")).ToBytes())
+	if !strings.HasPrefix(role, "*5\r\n$5\r\nslave\r\n") {
+		t.Errorf("unexpected role of replica: %q", role)
+	}
+	info := string(master.Exec(c, utils.ToCmdLine("info", "replication")).ToBytes())
+	if !strings.Contains(info, "role:master\r\n") || !strings.Contains(info, "connected_slaves:1\r\n") {
+		t.Errorf("unexpected info of master: %q", info)
+	}
+	info = string(replica.Exec(rc, utils.ToCmdLine("info", "replication")).ToBytes())
+	if !strings.Contains(info, "role:slave\r\n") || !strings.Contains(info, "master_link_status:up\r\n") {
+		t.Errorf("unexpected info of replica: %q", info)
+	}
+	role = string(master.Exec(c, utils.ToCmdLine("role")).ToBytes())
+	if !strings.HasPrefix(role, "*3\r\n$6\r\nmaster\r\n") || !strings.Contains(role, "*1\r\n*3\r\n$9\r\n127.0.0.1\r\n") {
+		t.Errorf("unexpected role of master: %q", role)
+	}
+
+	// promoted replica accepts writes
+	asserts.AssertStatusReply(t, replica.Exec(rc, utils.ToCmdLine("replicaof", "no", "one")), "OK")
+	asserts.AssertStatusReply(t, replica.Exec(rc, utils.ToCmdLine("set", "e", "5")), "OK")
+	role = string(replica.Exec(rc, utils.ToCmdLine("role")).ToBytes())
+	if !strings.HasPrefix(role, "*3\r\n$6\r\nmaster\r\n") {
+		t.Errorf("unexpected role after promotion: %q", role)
+	}
+}
@@ -4,18 +4,144 @@ import "strings"
 
 var cmdTable = make(map[string]*command)
 
+const (
+	flagWrite = 1 << iota
+	flagReadOnly
+	// flags below only mark ACL categories
+	flagKeyspace
+	flagString
+	flagHash
+	flagList
+	flagSet
+	flagSortedSet
+	flagStream
+	flagPubSub
+	flagAdmin
+	flagDangerous
+	flagConnection
+	flagTransaction
+	flagBlocking
+)
+
+// aclCategories maps ACL categories to flags, @read and @write are the same as flagReadOnly and flagWrite
+var aclCategories = map[string]int{
+	"keyspace":    flagKeyspace,
+	"read":        flagReadOnly,
+	"write":       flagWrite,
+	"string":      flagString,
+	"hash":        flagHash,
+	"list":        flagList,
+	"set":         flagSet,
+	"sortedset":   flagSortedSet,
+	"stream":      flagStream,
+	"pubsub":      flagPubSub,
+	"admin":       flagAdmin,
+	"dangerous":   flagDangerous,
+	"connection":  flagConnection,
+	"transaction": flagTransaction,
+	"blocking":    flagBlocking,
+}
+
+// serverCmdFlags are flags of commands not in cmdTable, which are handled by server, transaction or blocking
+var serverCmdFlags = map[string]int{
+	"auth":         flagConnection,
+	"hello":        flagConnection,
+	"ping":         flagConnection,
+	"select":       flagConnection,
+	"subscribe":    flagPubSub,
+	"unsubscribe":  flagPubSub,
+	"psubscribe":   flagPubSub,
+	"punsubscribe": flagPubSub,
+	"ssubscribe":   flagPubSub,
+	"sunsubscribe": flagPubSub,
+	"publish":      flagPubSub,
+	"spublish":     flagPubSub,
+	"pubsub":       flagPubSub,
+	"replicaof":    flagAdmin | flagDangerous,
+	"slaveof":      flagAdmin | flagDangerous,
+	"psync":        flagAdmin | flagDangerous,
+	"replconf":     flagAdmin | flagDangerous,
+	"info":         flagDangerous,
+	"role":         flagDangerous,
+	"bgrewriteaof": flagAdmin | flagDangerous,
+	"rewriteaof":   flagAdmin | flagDangerous,
+	"save":         flagAdmin | flagDangerous,
+	"bgsave":       flagAdmin | flagDangerous,
+	"lastsave":     flagAdmin | flagDangerous,
+	"acl":          flagAdmin | flagDangerous,
+	"cluster":      flagAdmin,
+	"multi":        flagTransaction,
+	"exec":         flagTransaction,
+	"discard":      flagTransaction,
+	"watch":        flagTransaction,
+	"unwatch":      flagTransaction,
+	"blpop":        flagWrite | flagList | flagBlocking,
+	"brpop":        flagWrite | flagList | flagBlocking,
+	"blmove":       flagWrite | flagList | flagBlocking,
+	"brpoplpush":   flagWrite | flagList | flagBlocking,
+	"bzpopmin":     flagWrite | flagSortedSet | flagBlocking,
+	"bzpopmax":     flagWrite | flagSortedSet | flagBlocking,
+	"bzmpop":       flagWrite | flagSortedSet | flagBlocking,
+}
+
 type command struct {
 	executor ExecFunc
 	prepare  PreFunc
+	undo     UndoFunc
 	arity    int // allow number of args, arity < 0 means len(args) >= -arity
+	flags    int
 }
 
 // RegisterCommand registers a new command
-func RegisterCommand(name string, executor ExecFunc, prepare PreFunc, arity int) {
+// flags is a combination of flagWrite or flagReadOnly and ACL categories, write commands are persisted after executed
+// undo generates undo logs for transaction rollback, it's nil for read only commands
+func RegisterCommand(name string, executor ExecFunc, prepare PreFunc, undo UndoFunc, arity int, flags int) {
 	name = strings.ToLower(name)
 	cmdTable[name] = &command{
 		executor: executor,
 		prepare:  prepare,
+		undo:     undo,
 		arity:    arity,
+		flags:    flags,
+	}
+}
+
+// isWriteCommand tells whether the command may modify data, blocking commands like BLPOP are writes
+func isWriteCommand(name string) bool {
+	if cmd, ok := cmdTable[name]; ok {
+		return cmd.flags&flagWrite > 0
+	}
+	_, ok := blockingCommands[name]
+	return ok
+}
+
+// getCmdFlags returns flags of command, ok is false for unknown command
+func getCmdFlags(name string) (flags int, ok bool) {
+	if cmd, exists := cmdTable[name]; exists {
+		flags = cmd.flags
+		// XREAD and XREADGROUP are registered as regular commands and could block
+		if _, blocking := blockingCommands[name]; blocking {
+			flags |= flagBlocking
+		}
+		return flags, true
+	}
+	flags, ok = serverCmdFlags[name]
+	return flags, ok
+}
+
+// GetRelatedKeys returns keys written and read by the command, ok is false if the command is unknown or has wrong arity
+func GetRelatedKeys(cmdLine CmdLine) (writeKeys []string, readKeys []string, ok bool) {
+	name := strings.ToLower(string(cmdLine[0]))
+	if cmd, exists := cmdTable[name]; exists {
+		if !validateArity(cmd.arity, cmdLine) {
+			return nil, nil, false
+		}
+		writeKeys, readKeys = cmd.prepare(cmdLine[1:])
+		return writeKeys, readKeys, true
+	}
+	if prepare, exists := blockingPrepares[name]; exists && len(cmdLine) >= 3 {
+		writeKeys, readKeys = prepare(cmdLine[1:])
+		return writeKeys, readKeys, true
 	}
+	return nil, nil, false
 }
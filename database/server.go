@@ -2,23 +2,110 @@ package database
 
 import (
 	"fmt"
+	"ringodis/aof"
 	"ringodis/config"
+	idb "ringodis/interface/database"
 	"ringodis/interface/resp"
 	"ringodis/lib/logger"
 	"ringodis/resp/reply"
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
+// subscribeModeCmds are commands allowed for client in subscribe mode
+var subscribeModeCmds = map[string]bool{
+	"subscribe":    true,
+	"unsubscribe":  true,
+	"psubscribe":   true,
+	"punsubscribe": true,
+	"ssubscribe":   true,
+	"sunsubscribe": true,
+	"ping":         true,
+}
+
+// pubSubCmds are handled by server directly, they can't be queued in transaction
+var pubSubCmds = map[string]bool{
+	"subscribe":    true,
+	"unsubscribe":  true,
+	"psubscribe":   true,
+	"punsubscribe": true,
+	"ssubscribe":   true,
+	"sunsubscribe": true,
+	"publish":      true,
+	"spublish":     true,
+	"pubsub":       true,
+}
+
 type Server struct {
 	dbSet []*atomic.Value // *DB
+
+	// handle aof persistence
+	persister *aof.Persister
+
+	// rdb persistence
+	saveParams    []*saveParam
+	saving        int32 // 1 while saving rdb
+	lastSave      int64 // unix time of last successful save
+	changesAtSave int64 // total changes when last successful save started
+
+	// publish/subscribe
+	hub *Hub
+
+	// gate is shared by all dbs, it's locked exclusively while taking snapshot for replicas
+	gate *sync.RWMutex
+	// master-replica replication
+	repl *replication
+
+	closeChan chan struct{}
+	closeOnce sync.Once
 }
 
 // NewStandaloneServer creates a standalone redis server, with multi database and all other functions
 func NewStandaloneServer() *Server {
-	server := &Server{}
+	server := MakeAuxiliaryServer()
+	if err := setupACL(); err != nil {
+		panic(err)
+	}
+	if config.Properties.RDBFilename == "" {
+		config.Properties.RDBFilename = "dump.rdb"
+	}
+	// aof is preferred when enabled, as it's usually more complete
+	if config.Properties.AppendOnly {
+		if err := server.initAof(); err != nil {
+			panic(err)
+		}
+	} else if err := server.loadRDB(config.Properties.RDBFilename); err != nil {
+		panic(err)
+	}
+	server.lastSave = time.Now().Unix()
+	server.saveParams = parseSaveParams(config.Properties.Save)
+	if len(server.saveParams) > 0 {
+		go server.saveCron()
+	}
+	server.bindPropagation()
+	go server.replCron()
+	if config.Properties.ReplicaOf != "" {
+		fields := strings.Fields(config.Properties.ReplicaOf)
+		if len(fields) != 2 {
+			panic("invalid replicaof: " + config.Properties.ReplicaOf)
+		}
+		server.startReplication(fields[0], fields[1])
+	}
+	return server
+}
+
+// MakeAuxiliaryServer creates a server only with multi database, without persistence or other functions
+func MakeAuxiliaryServer() *Server {
+	server := &Server{
+		closeChan: make(chan struct{}),
+		hub:       MakeHub(),
+		gate:      &sync.RWMutex{},
+		repl:      makeReplication(),
+	}
 	if config.Properties.Databases == 0 {
 		config.Properties.Databases = 16
 	}
@@ -26,6 +113,7 @@ func NewStandaloneServer() *Server {
 	for i := range server.dbSet {
 		singleDB := makeDB()
 		singleDB.index = i
+		singleDB.gate = server.gate
 		holder := &atomic.Value{}
 		holder.Store(singleDB)
 		server.dbSet[i] = holder
@@ -33,6 +121,73 @@ func NewStandaloneServer() *Server {
 	return server
 }
 
+// makeReplayServer creates an auxiliary server for replaying aof while rewriting,
+// it never touches expiring tasks of the live server, which are keyed by key names in the global timewheel
+func makeReplayServer() *Server {
+	server := MakeAuxiliaryServer()
+	for _, holder := range server.dbSet {
+		holder.Load().(*DB).expirer = noopExpirer{}
+	}
+	return server
+}
+
+func (server *Server) initAof() error {
+	if config.Properties.AppendFilename == "" {
+		config.Properties.AppendFilename = "appendonly.aof"
+	}
+	if config.Properties.AppendFsync == "" {
+		config.Properties.AppendFsync = aof.FsyncEverySec
+	}
+	// replay aof before binding addAof, loaded commands should not be appended again
+	persister, err := aof.NewPersister(server,
+		config.Properties.AppendFilename, true, config.Properties.AppendFsync,
+		func() idb.DBEngine {
+			return makeReplayServer()
+		})
+	if err != nil {
+		return err
+	}
+	server.bindPersister(persister)
+	return nil
+}
+
+func (server *Server) bindPersister(persister *aof.Persister) {
+	server.persister = persister
+	for _, holder := range server.dbSet {
+		singleDB := holder.Load().(*DB)
+		singleDB.addAof = func(line CmdLine) {
+			persister.SaveCmdLine(singleDB.index, line)
+		}
+	}
+}
+
+// bindPropagation sends executed write commands to aof and replicas
+func (server *Server) bindPropagation() {
+	for _, holder := range server.dbSet {
+		singleDB := holder.Load().(*DB)
+		singleDB.addAof = func(line CmdLine) {
+			if server.persister != nil {
+				server.persister.SaveCmdLine(singleDB.index, line)
+			}
+			server.repl.propagate(singleDB.index, line)
+		}
+	}
+}
+
+// replCron pings replicas periodically
+func (server *Server) replCron() {
+	ticker := time.NewTicker(replPingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-server.closeChan:
+			return
+		case <-ticker.C:
+			server.repl.pingReplicas()
+		}
+	}
+}
+
 // Exec executes command
 // parameter `cmdLine` contains command name and its arguments, for example: "set key value"
 func (server *Server) Exec(client resp.Connection, cmdLine CmdLine) (result resp.Reply) {
@@ -44,11 +199,143 @@ func (server *Server) Exec(client resp.Connection, cmdLine CmdLine) (result resp
 	}()
 
 	cmdName := strings.ToLower(string(cmdLine[0]))
-	if cmdName == "select" {
+	switch cmdName {
+	case "auth":
+		return execAuth(client, cmdLine[1:])
+	case "hello":
+		return execHello(server, client, cmdLine[1:])
+	}
+	if errReply := CheckPermission(client, cmdLine); errReply != nil {
+		if client.InMultiState() {
+			client.AddTxError(errReply)
+		}
+		return errReply
+	}
+	// client in subscribe mode can only manage its subscriptions
+	if client.InSubscribeMode() && !subscribeModeCmds[cmdName] {
+		return reply.MakeErrReply("ERR Can't execute '" + cmdName +
+			"': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING are allowed in this context")
+	}
+	if pubSubCmds[cmdName] && client.InMultiState() {
+		errReply := reply.MakeErrReply("ERR " + strings.ToUpper(cmdName) + " is not allowed in MULTI")
+		client.AddTxError(errReply)
+		return errReply
+	}
+	if config.Properties.ReplicaReadOnly && isWriteCommand(cmdName) && server.repl.isReplica() {
+		errReply := reply.MakeErrReply("READONLY You can't write against a read only replica.")
+		if client.InMultiState() {
+			client.AddTxError(errReply)
+		}
+		return errReply
+	}
+	if txCmd, ok := txCommands[cmdName]; ok {
+		return txCmd(server, client, cmdLine[1:])
+	}
+	switch cmdName {
+	case "ping":
+		if len(cmdLine) > 2 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execPing(client, cmdLine[1:])
+	case "subscribe":
+		if len(cmdLine) < 2 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return server.hub.Subscribe(client, toStrings(cmdLine[1:]))
+	case "unsubscribe":
+		return server.hub.UnSubscribe(client, toStrings(cmdLine[1:]))
+	case "psubscribe":
+		if len(cmdLine) < 2 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return server.hub.PSubscribe(client, toStrings(cmdLine[1:]))
+	case "punsubscribe":
+		return server.hub.PUnSubscribe(client, toStrings(cmdLine[1:]))
+	case "ssubscribe":
+		if len(cmdLine) < 2 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return server.hub.SSubscribe(client, toStrings(cmdLine[1:]))
+	case "sunsubscribe":
+		return server.hub.SUnSubscribe(client, toStrings(cmdLine[1:]))
+	case "publish":
+		if len(cmdLine) != 3 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execPublish(server.hub, cmdLine[1:])
+	case "spublish":
+		if len(cmdLine) != 3 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execSPublish(server.hub, cmdLine[1:])
+	case "pubsub":
+		if len(cmdLine) < 2 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execPubSub(server.hub, cmdLine[1:])
+	case "replicaof", "slaveof":
+		if len(cmdLine) != 3 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execReplicaOf(server, cmdLine[1:])
+	case "psync":
+		if len(cmdLine) != 3 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execPSync(server, client, cmdLine[1:])
+	case "replconf":
+		if len(cmdLine) < 3 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execReplConf(server, client, cmdLine[1:])
+	case "info":
+		return execInfo(server, cmdLine[1:])
+	case "role":
+		if len(cmdLine) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execRole(server)
+	case "select":
 		if len(cmdLine) != 2 {
-			return reply.MakeArgNumErrReply("select")
+			errReply := reply.MakeArgNumErrReply(cmdName)
+			if client.InMultiState() {
+				client.AddTxError(errReply)
+			}
+			return errReply
+		}
+		if client.InMultiState() {
+			// like redis, SELECT is queued and switches db of the following queued commands
+			client.EnqueueCmd(cmdLine)
+			return reply.MakeStatusReply("QUEUED")
 		}
 		return execSelect(client, server, cmdLine[1:])
+	case "bgrewriteaof":
+		if len(cmdLine) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execBGRewriteAof(server)
+	case "rewriteaof":
+		if len(cmdLine) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execRewriteAof(server)
+	case "save":
+		if len(cmdLine) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execSave(server)
+	case "bgsave":
+		if len(cmdLine) > 2 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execBGSave(server)
+	case "lastsave":
+		if len(cmdLine) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execLastSave(server)
+	case "acl":
+		return execACL(client, cmdLine[1:])
 	}
 
 	dbIndex := client.GetDBIndex()
@@ -59,28 +346,149 @@ func (server *Server) Exec(client resp.Connection, cmdLine CmdLine) (result resp
 	return selectDB.Exec(client, cmdLine)
 }
 
+// Close gracefully shuts down the server
+// it may be called more than once, only the first call takes effect
 func (server *Server) Close() {
-	//TODO implement me
-	panic("implement me")
+	server.closeOnce.Do(func() {
+		close(server.closeChan)
+		server.repl.stop()
+		if server.persister != nil {
+			server.persister.Close()
+		}
+		// like redis, save on shutdown if save points are configured
+		if len(server.saveParams) > 0 {
+			if err := server.SaveRDB(); err != nil {
+				logger.Error("save on shutdown failed: " + err.Error())
+			}
+		}
+	})
 }
 
+// AfterClientClose releases resources of the closed client, e.g. wakes up its blocked command
 func (server *Server) AfterClientClose(c resp.Connection) {
-	//TODO implement me
-	panic("implement me")
+	server.hub.UnsubscribeAll(c)
+	server.repl.removeReplica(c)
+	server.unwatchAll(c)
+	for _, holder := range server.dbSet {
+		holder.Load().(*DB).blocking.cancel(c)
+	}
 }
 
-func execSelect(c resp.Connection, s *Server, args CmdArgs) resp.Reply {
-	dbIndex, err := strconv.Atoi(string(args[0]))
-	if err != nil {
-		return reply.MakeErrReply("ERR invalid DB index")
+// execPing replies PONG or echoes the message, in subscribe mode the reply is a `pong` frame
+func execPing(c resp.Connection, args CmdArgs) resp.Reply {
+	var message []byte
+	if len(args) == 1 {
+		message = args[0]
+	}
+	if c.InSubscribeMode() {
+		if message == nil {
+			message = []byte{}
+		}
+		return reply.MakeMultiBulkReply([][]byte{[]byte("pong"), message})
+	}
+	if message != nil {
+		return reply.MakeBulkReply(message)
 	}
-	if dbIndex >= len(s.dbSet) || dbIndex < 0 {
-		return reply.MakeErrReply("ERR DB index is out of range")
+	return reply.MakeStatusReply("PONG")
+}
+
+func execSelect(c resp.Connection, s *Server, args CmdArgs) resp.Reply {
+	dbIndex, errReply := s.parseDBIndex(args[0])
+	if errReply != nil {
+		return errReply
 	}
 	c.SelectDB(dbIndex)
 	return reply.MakeOkReply()
 }
 
+// parseDBIndex validates argument of SELECT
+func (server *Server) parseDBIndex(arg []byte) (int, reply.ErrorReply) {
+	dbIndex, err := strconv.Atoi(string(arg))
+	if err != nil {
+		return 0, reply.MakeErrReply("ERR invalid DB index")
+	}
+	if dbIndex >= len(server.dbSet) || dbIndex < 0 {
+		return 0, reply.MakeErrReply("ERR DB index is out of range")
+	}
+	return dbIndex, nil
+}
+
+// ForEach traverses all the keys in the given database
+func (server *Server) ForEach(dbIndex int, cb func(key string, data *idb.DataEntity, expiration *time.Time) bool) {
+	selectDB, errReply := server.selectDB(dbIndex)
+	if errReply != nil {
+		logger.Error("ForEach: " + errReply.Error())
+		return
+	}
+	selectDB.ForEach(cb)
+}
+
+// RWLocks locks keys in the given database, commands could be executed under these locks by ExecWithLock.
+// Gate is not held, so that keys of a prepared distributed transaction could stay locked without pausing snapshots
+func (server *Server) RWLocks(dbIndex int, writeKeys []string, readKeys []string) {
+	selectDB, errReply := server.selectDB(dbIndex)
+	if errReply != nil {
+		logger.Error("RWLocks: " + errReply.Error())
+		return
+	}
+	selectDB.locker.RWLocks(writeKeys, readKeys)
+}
+
+// RWUnLocks unlocks keys locked by RWLocks
+func (server *Server) RWUnLocks(dbIndex int, writeKeys []string, readKeys []string) {
+	selectDB, errReply := server.selectDB(dbIndex)
+	if errReply != nil {
+		logger.Error("RWUnLocks: " + errReply.Error())
+		return
+	}
+	selectDB.locker.RWUnLocks(writeKeys, readKeys)
+}
+
+// ExecWithLock executes command in the database selected by client, related keys must be locked by RWLocks,
+// executed write commands are persisted as usual
+func (server *Server) ExecWithLock(client resp.Connection, cmdLine CmdLine) resp.Reply {
+	selectDB, errReply := server.selectDB(client.GetDBIndex())
+	if errReply != nil {
+		return errReply
+	}
+	server.gate.RLock()
+	defer server.gate.RUnlock()
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	if blockingCmd, ok := txBlockingCommands[cmdName]; ok {
+		// executed without blocking, like blocking commands in transaction
+		if !validateArity(blockingCmd.arity, cmdLine) {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		tc := &txCmd{db: selectDB, cmdLine: cmdLine, blocking: blockingCmd}
+		result, convert := tc.resolve()
+		if result != nil {
+			return result
+		}
+		result = selectDB.execWithLock(tc.cmd, tc.cmdLine)
+		if convert != nil {
+			result = convert(result)
+		}
+		return result
+	}
+	cmd, ok := cmdTable[cmdName]
+	if !ok {
+		return reply.MakeErrReply("ERR unknown command '" + cmdName + "'")
+	}
+	if !validateArity(cmd.arity, cmdLine) {
+		return reply.MakeArgNumErrReply(cmdName)
+	}
+	return selectDB.execWithLock(cmd, cmdLine)
+}
+
+// GetUndoLogs returns command lines restoring keys affected by the command, related keys must be locked
+func (server *Server) GetUndoLogs(dbIndex int, cmdLine CmdLine) []CmdLine {
+	selectDB, errReply := server.selectDB(dbIndex)
+	if errReply != nil {
+		return nil
+	}
+	return selectDB.GetUndoLogs(cmdLine)
+}
+
 func (server *Server) selectDB(dbIndex int) (*DB, *reply.StandardErrReply) {
 	if dbIndex >= len(server.dbSet) || dbIndex < 0 {
 		return nil, reply.MakeErrReply("ERR DB index is out of range")
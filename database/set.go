@@ -0,0 +1,425 @@
+package database
+
+import (
+	HashSet "ringodis/ds/set"
+	"ringodis/interface/database"
+	"ringodis/interface/resp"
+	"ringodis/resp/reply"
+	"strconv"
+	"strings"
+)
+
+func (db *DB) getAsSet(key string) (*HashSet.Set, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	set, ok := entity.Data.(*HashSet.Set)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return set, nil
+}
+
+func (db *DB) getOrInitSet(key string) (set *HashSet.Set, inited bool, errReply reply.ErrorReply) {
+	set, errReply = db.getAsSet(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	inited = false
+	if set == nil {
+		set = HashSet.Make()
+		db.PutEntity(key, &database.DataEntity{
+			Data: set,
+		})
+		inited = true
+	}
+	return set, inited, nil
+}
+
+// getSets returns sets of the given keys, not existed set is nil
+func (db *DB) getSets(keys []string) ([]*HashSet.Set, reply.ErrorReply) {
+	sets := make([]*HashSet.Set, len(keys))
+	for i, key := range keys {
+		set, errReply := db.getAsSet(key)
+		if errReply != nil {
+			return nil, errReply
+		}
+		sets[i] = set
+	}
+	return sets, nil
+}
+
+func toMultiBulk(members []string) resp.Reply {
+	result := make([][]byte, len(members))
+	for i, member := range members {
+		result[i] = []byte(member)
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execSAdd adds members into set
+func execSAdd(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	set, _, errReply := db.getOrInitSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	added := 0
+	for _, member := range args[1:] {
+		added += set.Add(string(member))
+	}
+	return reply.MakeIntReply(int64(added))
+}
+
+// execSRem removes members from set, the key is removed if the set becomes empty
+func execSRem(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if set == nil {
+		return reply.MakeIntReply(0)
+	}
+	removed := 0
+	for _, member := range args[1:] {
+		removed += set.Remove(string(member))
+	}
+	if set.Len() == 0 {
+		db.Remove(key)
+	}
+	return reply.MakeIntReply(int64(removed))
+}
+
+// execSIsMember checks whether the member is in set
+func execSIsMember(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if set.Has(string(args[1])) {
+		return reply.MakeIntReply(1)
+	}
+	return reply.MakeIntReply(0)
+}
+
+// execSMIsMember checks whether each member is in set
+func execSMIsMember(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	result := make([]resp.Reply, len(args)-1)
+	for i, member := range args[1:] {
+		if set.Has(string(member)) {
+			result[i] = reply.MakeIntReply(1)
+		} else {
+			result[i] = reply.MakeIntReply(0)
+		}
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// execSCard returns number of members in set
+func execSCard(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	return reply.MakeIntReply(int64(set.Len()))
+}
+
+// execSMembers returns all members in set
+func execSMembers(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if set == nil {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	return toMultiBulk(set.ToSlice())
+}
+
+// execSPop removes and returns random members
+// SPOP key [count]
+func execSPop(db *DB, args CmdArgs) resp.Reply {
+	if len(args) > 2 {
+		return reply.MakeArgNumErrReply("spop")
+	}
+	key := string(args[0])
+	count := 1
+	if len(args) == 2 {
+		count64, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil || count64 < 0 {
+			return reply.MakeErrReply("ERR value is out of range, must be positive")
+		}
+		count = int(count64)
+	}
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if set == nil {
+		if len(args) == 2 {
+			return reply.MakeEmptyMultiBulkReply()
+		}
+		return reply.MakeNullBulkReply()
+	}
+	members := set.RandomDistinctMembers(count)
+	for _, member := range members {
+		set.Remove(member)
+	}
+	if set.Len() == 0 {
+		db.Remove(key)
+	}
+	if len(args) == 2 {
+		return toMultiBulk(members)
+	}
+	return reply.MakeBulkReply([]byte(members[0]))
+}
+
+// execSRandMember returns random members
+// SRANDMEMBER key [count], negative count allows duplicated members
+func execSRandMember(db *DB, args CmdArgs) resp.Reply {
+	if len(args) > 2 {
+		return reply.MakeArgNumErrReply("srandmember")
+	}
+	key := string(args[0])
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if len(args) == 1 {
+		if set == nil {
+			return reply.MakeNullBulkReply()
+		}
+		return reply.MakeBulkReply([]byte(set.RandomMembers(1)[0]))
+	}
+	count, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if set == nil {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	if count >= 0 {
+		return toMultiBulk(set.RandomDistinctMembers(int(count)))
+	}
+	return toMultiBulk(set.RandomMembers(int(-count)))
+}
+
+// execSMove moves member from source set to destination set
+func execSMove(db *DB, args CmdArgs) resp.Reply {
+	src := string(args[0])
+	dest := string(args[1])
+	member := string(args[2])
+	srcSet, errReply := db.getAsSet(src)
+	if errReply != nil {
+		return errReply
+	}
+	destSet, errReply := db.getAsSet(dest)
+	if errReply != nil {
+		return errReply
+	}
+	if !srcSet.Has(member) {
+		return reply.MakeIntReply(0)
+	}
+	srcSet.Remove(member)
+	if srcSet.Len() == 0 {
+		db.Remove(src)
+	}
+	if destSet == nil {
+		destSet, _, _ = db.getOrInitSet(dest)
+	}
+	destSet.Add(member)
+	return reply.MakeIntReply(1)
+}
+
+// setCalculator computes result of SINTER, SUNION or SDIFF
+type setCalculator func(sets ...*HashSet.Set) *HashSet.Set
+
+func (db *DB) calculateSets(keys []string, calculate setCalculator) (*HashSet.Set, reply.ErrorReply) {
+	sets, errReply := db.getSets(keys)
+	if errReply != nil {
+		return nil, errReply
+	}
+	return calculate(sets...), nil
+}
+
+func execSetCalculate(db *DB, args CmdArgs, calculate setCalculator) resp.Reply {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = string(arg)
+	}
+	result, errReply := db.calculateSets(keys, calculate)
+	if errReply != nil {
+		return errReply
+	}
+	return toMultiBulk(result.ToSlice())
+}
+
+// execSetCalculateStore stores the result into destination, replies number of members in result
+func execSetCalculateStore(db *DB, args CmdArgs, calculate setCalculator) resp.Reply {
+	dest := string(args[0])
+	keys := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		keys[i] = string(arg)
+	}
+	result, errReply := db.calculateSets(keys, calculate)
+	if errReply != nil {
+		return errReply
+	}
+	if result.Len() == 0 {
+		db.Remove(dest)
+		return reply.MakeIntReply(0)
+	}
+	db.PutEntity(dest, &database.DataEntity{
+		Data: result,
+	})
+	db.Persist(dest)
+	return reply.MakeIntReply(int64(result.Len()))
+}
+
+// execSInter returns intersection of the given sets
+func execSInter(db *DB, args CmdArgs) resp.Reply {
+	return execSetCalculate(db, args, HashSet.Intersect)
+}
+
+// execSInterStore stores intersection of the given sets into destination
+func execSInterStore(db *DB, args CmdArgs) resp.Reply {
+	return execSetCalculateStore(db, args, HashSet.Intersect)
+}
+
+// execSUnion returns union of the given sets
+func execSUnion(db *DB, args CmdArgs) resp.Reply {
+	return execSetCalculate(db, args, HashSet.Union)
+}
+
+// execSUnionStore stores union of the given sets into destination
+func execSUnionStore(db *DB, args CmdArgs) resp.Reply {
+	return execSetCalculateStore(db, args, HashSet.Union)
+}
+
+// execSDiff returns members of the first set which don't exist in other sets
+func execSDiff(db *DB, args CmdArgs) resp.Reply {
+	return execSetCalculate(db, args, HashSet.Diff)
+}
+
+// execSDiffStore stores difference of the given sets into destination
+func execSDiffStore(db *DB, args CmdArgs) resp.Reply {
+	return execSetCalculateStore(db, args, HashSet.Diff)
+}
+
+// prepareSetCalculateStore writes destination and reads source sets
+func prepareSetCalculateStore(args CmdArgs) ([]string, []string) {
+	dest := string(args[0])
+	keys := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		keys[i] = string(arg)
+	}
+	return []string{dest}, keys
+}
+
+// parseNumKeys returns keys of commands like `SINTERCARD numkeys key [key ...]`, and the rest arguments
+func parseNumKeys(args CmdArgs) ([]string, CmdArgs, reply.ErrorReply) {
+	numKeys, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil {
+		return nil, nil, reply.MakeErrReply("ERR numkeys should be greater than 0")
+	}
+	if numKeys <= 0 {
+		return nil, nil, reply.MakeErrReply("ERR numkeys should be greater than 0")
+	}
+	if numKeys > int64(len(args)-1) {
+		return nil, nil, reply.MakeErrReply("ERR Number of keys can't be greater than number of args")
+	}
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = string(args[i+1])
+	}
+	return keys, args[1+numKeys:], nil
+}
+
+func prepareSInterCard(args CmdArgs) ([]string, []string) {
+	keys, _, errReply := parseNumKeys(args)
+	if errReply != nil {
+		return nil, nil
+	}
+	return nil, keys
+}
+
+// execSInterCard returns cardinality of intersection of the given sets
+// SINTERCARD numkeys key [key ...] [LIMIT limit]
+func execSInterCard(db *DB, args CmdArgs) resp.Reply {
+	keys, rest, errReply := parseNumKeys(args)
+	if errReply != nil {
+		return errReply
+	}
+	limit := 0
+	if len(rest) > 0 {
+		if len(rest) != 2 || strings.ToLower(string(rest[0])) != "limit" {
+			return reply.MakeSyntaxErrReply()
+		}
+		limit64, err := strconv.ParseInt(string(rest[1]), 10, 64)
+		if err != nil || limit64 < 0 {
+			return reply.MakeErrReply("ERR LIMIT can't be negative")
+		}
+		limit = int(limit64)
+	}
+	result, errReply := db.calculateSets(keys, HashSet.Intersect)
+	if errReply != nil {
+		return errReply
+	}
+	card := result.Len()
+	if limit > 0 && card > limit {
+		card = limit
+	}
+	return reply.MakeIntReply(int64(card))
+}
+
+// execSScan iterates members of set
+// SSCAN key cursor [MATCH pattern] [COUNT count]
+func execSScan(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	pattern, errReply := parseScanArgs(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	result := make([][]byte, 0)
+	set.ForEach(func(member string) bool {
+		if pattern == nil || pattern.IsMatch(member) {
+			result = append(result, []byte(member))
+		}
+		return true
+	})
+	return makeScanReply(result)
+}
+
+func init() {
+	RegisterCommand("SAdd", execSAdd, writeFirstKey, rollbackFirstKey, -3, flagWrite|flagSet)
+	RegisterCommand("SRem", execSRem, writeFirstKey, rollbackFirstKey, -3, flagWrite|flagSet)
+	RegisterCommand("SIsMember", execSIsMember, readFirstKey, nil, 3, flagReadOnly|flagSet)
+	RegisterCommand("SMIsMember", execSMIsMember, readFirstKey, nil, -3, flagReadOnly|flagSet)
+	RegisterCommand("SCard", execSCard, readFirstKey, nil, 2, flagReadOnly|flagSet)
+	RegisterCommand("SMembers", execSMembers, readFirstKey, nil, 2, flagReadOnly|flagSet)
+	RegisterCommand("SPop", execSPop, writeFirstKey, rollbackFirstKey, -2, flagWrite|flagSet)
+	RegisterCommand("SRandMember", execSRandMember, readFirstKey, nil, -2, flagReadOnly|flagSet)
+	RegisterCommand("SMove", execSMove, writeFirstTwoKeys, rollbackFirstTwoKeys, 4, flagWrite|flagSet)
+	RegisterCommand("SInter", execSInter, readAllKeys, nil, -2, flagReadOnly|flagSet)
+	RegisterCommand("SInterStore", execSInterStore, prepareSetCalculateStore, rollbackFirstKey, -3, flagWrite|flagSet)
+	RegisterCommand("SInterCard", execSInterCard, prepareSInterCard, nil, -3, flagReadOnly|flagSet)
+	RegisterCommand("SUnion", execSUnion, readAllKeys, nil, -2, flagReadOnly|flagSet)
+	RegisterCommand("SUnionStore", execSUnionStore, prepareSetCalculateStore, rollbackFirstKey, -3, flagWrite|flagSet)
+	RegisterCommand("SDiff", execSDiff, readAllKeys, nil, -2, flagReadOnly|flagSet)
+	RegisterCommand("SDiffStore", execSDiffStore, prepareSetCalculateStore, rollbackFirstKey, -3, flagWrite|flagSet)
+	RegisterCommand("SScan", execSScan, readFirstKey, nil, -3, flagReadOnly|flagSet)
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"ringodis/interface/resp"
+	"ringodis/lib/utils"
+	"ringodis/resp/conn"
+	"ringodis/resp/reply"
+	"ringodis/resp/reply/asserts"
+	"sort"
+	"testing"
+)
+
+// assertMembers checks members in multi bulk reply regardless of order
+func assertMembers(t *testing.T, actual resp.Reply, expected ...string) {
+	multiBulk, ok := actual.(*reply.MultiBulkReply)
+	if !ok {
+		t.Errorf("expected multi bulk reply, actual: %s", actual.ToBytes())
+		return
+	}
+	members := make([]string, len(multiBulk.Args))
+	for i, arg := range multiBulk.Args {
+		members[i] = string(arg)
+	}
+	sort.Strings(members)
+	sort.Strings(expected)
+	if len(members) != len(expected) {
+		t.Errorf("expected %v, actual %v", expected, members)
+		return
+	}
+	for i := range members {
+		if members[i] != expected[i] {
+			t.Errorf("expected %v, actual %v", expected, members)
+			return
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	c := conn.NewFakeConn()
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("sadd", "s", "1", "2", "a", "1")), 3)
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("type", "s")), "set")
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("scard", "s")), 3)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("sismember", "s", "a")), 1)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("sismember", "x", "a")), 0)
+	asserts.AssertNotError(t, server.Exec(c, utils.ToCmdLine("smismember", "s", "a", "b")))
+	assertMembers(t, server.Exec(c, utils.ToCmdLine("smembers", "s")), "1", "2", "a")
+	assertMembers(t, server.Exec(c, utils.ToCmdLine("srandmember", "s", "10")), "1", "2", "a")
+	asserts.AssertMultiBulkReplySize(t, server.Exec(c, utils.ToCmdLine("srandmember", "s", "-10")), 10)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("srem", "s", "2", "b")), 1)
+
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("smove", "s", "d", "a")), 1)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("smove", "s", "d", "a")), 0)
+	assertMembers(t, server.Exec(c, utils.ToCmdLine("smembers", "d")), "a")
+
+	asserts.AssertMultiBulkReplySize(t, server.Exec(c, utils.ToCmdLine("spop", "s", "5")), 1)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("exists", "s")), 0)
+	asserts.AssertNullBulk(t, server.Exec(c, utils.ToCmdLine("spop", "s")))
+
+	result := server.Exec(c, utils.ToCmdLine("sscan", "d", "0", "match", "*"))
+	if scan, ok := result.(*reply.MultiRawReply); !ok || len(scan.Replies) != 2 {
+		t.Errorf("illegal sscan result: %s", result.ToBytes())
+	} else {
+		assertMembers(t, scan.Replies[1], "a")
+	}
+
+	server.Exec(c, utils.ToCmdLine("set", "str", "s"))
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("sadd", "str", "a")), "WRONG-TYPE Operation against a key holding the wrong kind of value")
+}
+
+func TestSetCalculate(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	c := conn.NewFakeConn()
+	server.Exec(c, utils.ToCmdLine("sadd", "a", "1", "2", "3", "x"))
+	server.Exec(c, utils.ToCmdLine("sadd", "b", "2", "3", "4"))
+	server.Exec(c, utils.ToCmdLine("sadd", "c", "3", "x"))
+
+	assertMembers(t, server.Exec(c, utils.ToCmdLine("sinter", "a", "b")), "2", "3")
+	assertMembers(t, server.Exec(c, utils.ToCmdLine("sinter", "a", "none")))
+	assertMembers(t, server.Exec(c, utils.ToCmdLine("sunion", "b", "c", "none")), "2", "3", "4", "x")
+	assertMembers(t, server.Exec(c, utils.ToCmdLine("sdiff", "a", "b", "c")), "1")
+
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("sinterstore", "dest", "a", "b")), 2)
+	assertMembers(t, server.Exec(c, utils.ToCmdLine("smembers", "dest")), "2", "3")
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("sunionstore", "dest", "dest", "c")), 3)
+	assertMembers(t, server.Exec(c, utils.ToCmdLine("smembers", "dest")), "2", "3", "x")
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("sdiffstore", "dest", "c", "a")), 0)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("exists", "dest")), 0)
+
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("sintercard", "2", "a", "b")), 2)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("sintercard", "2", "a", "b", "limit", "1")), 1)
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("sintercard", "3", "a", "b")), "ERR Number of keys can't be greater than number of args")
+}
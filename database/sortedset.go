@@ -0,0 +1,899 @@
+package database
+
+import (
+	"math"
+	HashSet "ringodis/ds/set"
+	SortedSet "ringodis/ds/zset"
+	"ringodis/interface/database"
+	"ringodis/interface/resp"
+	"ringodis/lib/utils"
+	"ringodis/resp/reply"
+	"strconv"
+	"strings"
+)
+
+func (db *DB) getAsSortedSet(key string) (*SortedSet.ZSet, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	zs, ok := entity.Data.(*SortedSet.ZSet)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return zs, nil
+}
+
+func (db *DB) getOrInitSortedSet(key string) (zs *SortedSet.ZSet, inited bool, errReply reply.ErrorReply) {
+	zs, errReply = db.getAsSortedSet(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	inited = false
+	if zs == nil {
+		zs = SortedSet.Make()
+		db.PutEntity(key, &database.DataEntity{
+			Data: zs,
+		})
+		inited = true
+	}
+	return zs, inited, nil
+}
+
+func parseScore(arg []byte) (float64, reply.ErrorReply) {
+	score, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, reply.MakeErrReply("ERR value is not a valid float")
+	}
+	return score, nil
+}
+
+func formatScore(score float64) []byte {
+	if math.IsInf(score, 1) {
+		return []byte("inf")
+	}
+	if math.IsInf(score, -1) {
+		return []byte("-inf")
+	}
+	return []byte(strconv.FormatFloat(score, 'f', -1, 64))
+}
+
+func elementsToReply(elements []*SortedSet.Elem, withScores bool) resp.Reply {
+	size := len(elements)
+	if withScores {
+		size *= 2
+	}
+	result := make([][]byte, 0, size)
+	for _, element := range elements {
+		result = append(result, []byte(element.Member))
+		if withScores {
+			result = append(result, formatScore(element.Score))
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execZAdd adds members into sorted set, or updates their scores
+// ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+func execZAdd(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	var nx, xx, gt, lt, ch, incr bool
+	i := 1
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "gt":
+			gt = true
+		case "lt":
+			lt = true
+		case "ch":
+			ch = true
+		case "incr":
+			incr = true
+		default:
+			break options
+		}
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return reply.MakeSyntaxErrReply()
+	}
+	if nx && xx {
+		return reply.MakeErrReply("ERR XX and NX options at the same time are not compatible")
+	}
+	if (gt && lt) || (nx && (gt || lt)) {
+		return reply.MakeErrReply("ERR GT, LT, and/or NX options at the same time are not compatible")
+	}
+	if incr && len(pairs) > 2 {
+		return reply.MakeErrReply("ERR INCR option supports a single increment-element pair")
+	}
+	scores := make([]float64, len(pairs)/2)
+	for j := range scores {
+		score, errReply := parseScore(pairs[2*j])
+		if errReply != nil {
+			return errReply
+		}
+		scores[j] = score
+	}
+
+	zs, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if zs == nil {
+		if xx {
+			if incr {
+				return reply.MakeNullBulkReply()
+			}
+			return reply.MakeIntReply(0)
+		}
+		zs, _, _ = db.getOrInitSortedSet(key)
+	}
+	var added, updated int64
+	var lastScore float64
+	aborted := true
+	for j, score := range scores {
+		member := string(pairs[2*j+1])
+		element, exists := zs.Get(member)
+		if (exists && nx) || (!exists && xx) {
+			continue
+		}
+		if exists {
+			if incr {
+				score += element.Score
+				if math.IsNaN(score) {
+					return reply.MakeErrReply("ERR resulting score is not a number (NaN)")
+				}
+			}
+			if (gt && score <= element.Score) || (lt && score >= element.Score) {
+				continue
+			}
+			if score != element.Score {
+				zs.Add(member, score)
+				updated++
+			}
+		} else {
+			zs.Add(member, score)
+			added++
+		}
+		lastScore = score
+		aborted = false
+	}
+	db.blocking.signal(key)
+	if incr {
+		if aborted {
+			return reply.MakeNullBulkReply()
+		}
+		return reply.MakeBulkReply(formatScore(lastScore))
+	}
+	if ch {
+		return reply.MakeIntReply(added + updated)
+	}
+	return reply.MakeIntReply(added)
+}
+
+// execZIncrBy increments score of member, replies the new score
+// ZINCRBY key increment member
+func execZIncrBy(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	delta, errReply := parseScore(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	member := string(args[2])
+	zs, _, errReply := db.getOrInitSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	score := delta
+	if element, exists := zs.Get(member); exists {
+		score += element.Score
+		if math.IsNaN(score) {
+			return reply.MakeErrReply("ERR resulting score is not a number (NaN)")
+		}
+	}
+	zs.Add(member, score)
+	db.blocking.signal(key)
+	return reply.MakeBulkReply(formatScore(score))
+}
+
+// execZRem removes members from sorted set, the key is removed if the sorted set becomes empty
+func execZRem(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	zs, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if zs == nil {
+		return reply.MakeIntReply(0)
+	}
+	var removed int64
+	for _, member := range args[1:] {
+		if zs.Remove(string(member)) {
+			removed++
+		}
+	}
+	if zs.Len() == 0 {
+		db.Remove(key)
+	}
+	return reply.MakeIntReply(removed)
+}
+
+// execZScore returns score of member
+func execZScore(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	zs, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if zs == nil {
+		return reply.MakeNullBulkReply()
+	}
+	element, exists := zs.Get(string(args[1]))
+	if !exists {
+		return reply.MakeNullBulkReply()
+	}
+	return reply.MakeBulkReply(formatScore(element.Score))
+}
+
+// execZCard returns number of members in sorted set
+func execZCard(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	zs, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if zs == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(zs.Len())
+}
+
+func parseScoreBorders(min []byte, max []byte) (SortedSet.Border, SortedSet.Border, reply.ErrorReply) {
+	minBorder, err := SortedSet.ParseScoreBorder(string(min))
+	if err != nil {
+		return nil, nil, reply.MakeErrReply(err.Error())
+	}
+	maxBorder, err := SortedSet.ParseScoreBorder(string(max))
+	if err != nil {
+		return nil, nil, reply.MakeErrReply(err.Error())
+	}
+	return minBorder, maxBorder, nil
+}
+
+func parseLexBorders(min []byte, max []byte) (SortedSet.Border, SortedSet.Border, reply.ErrorReply) {
+	minBorder, err := SortedSet.ParseLexBorder(string(min))
+	if err != nil {
+		return nil, nil, reply.MakeErrReply(err.Error())
+	}
+	maxBorder, err := SortedSet.ParseLexBorder(string(max))
+	if err != nil {
+		return nil, nil, reply.MakeErrReply(err.Error())
+	}
+	return minBorder, maxBorder, nil
+}
+
+func (db *DB) countInRange(key string, min SortedSet.Border, max SortedSet.Border) resp.Reply {
+	zs, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if zs == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(zs.Count(min, max))
+}
+
+// execZCount returns number of members whose score within [min, max]
+// ZCOUNT key min max
+func execZCount(db *DB, args CmdArgs) resp.Reply {
+	min, max, errReply := parseScoreBorders(args[1], args[2])
+	if errReply != nil {
+		return errReply
+	}
+	return db.countInRange(string(args[0]), min, max)
+}
+
+// execZLexCount returns number of members within [min, max] in lexicographical order
+// ZLEXCOUNT key min max
+func execZLexCount(db *DB, args CmdArgs) resp.Reply {
+	min, max, errReply := parseLexBorders(args[1], args[2])
+	if errReply != nil {
+		return errReply
+	}
+	return db.countInRange(string(args[0]), min, max)
+}
+
+func execZSetRank(db *DB, args CmdArgs, desc bool) resp.Reply {
+	key := string(args[0])
+	zs, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if zs == nil {
+		return reply.MakeNullBulkReply()
+	}
+	rank := zs.GetRank(string(args[1]), desc)
+	if rank < 0 {
+		return reply.MakeNullBulkReply()
+	}
+	return reply.MakeIntReply(rank)
+}
+
+// execZRank returns rank of member, ordered from low to high score, rank starts from 0
+func execZRank(db *DB, args CmdArgs) resp.Reply {
+	return execZSetRank(db, args, false)
+}
+
+// execZRevRank returns rank of member, ordered from high to low score
+func execZRevRank(db *DB, args CmdArgs) resp.Reply {
+	return execZSetRank(db, args, true)
+}
+
+const (
+	rangeByRank = iota
+	rangeByScore
+	rangeByLex
+)
+
+// rangeOptions holds options of ZRANGE and the legacy range commands
+type rangeOptions struct {
+	by         int
+	rev        bool
+	withScores bool
+	offset     int64
+	// negative limit means all members
+	limit    int64
+	hasLimit bool
+}
+
+// parseRangeOptions parses options after `key start stop`,
+// BYSCORE, BYLEX and REV are only accepted by ZRANGE, that is when modifiable is true
+func parseRangeOptions(args CmdArgs, options *rangeOptions, modifiable bool) reply.ErrorReply {
+	for i := 0; i < len(args); i++ {
+		option := strings.ToLower(string(args[i]))
+		switch {
+		case option == "byscore" && modifiable:
+			options.by = rangeByScore
+		case option == "bylex" && modifiable:
+			options.by = rangeByLex
+		case option == "rev" && modifiable:
+			options.rev = true
+		case option == "withscores":
+			options.withScores = true
+		case option == "limit" && i+2 < len(args):
+			offset, errReply := parseInt(args[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			limit, errReply := parseInt(args[i+2])
+			if errReply != nil {
+				return errReply
+			}
+			options.offset, options.limit, options.hasLimit = offset, limit, true
+			i += 2
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	if options.hasLimit && options.by == rangeByRank {
+		return reply.MakeErrReply("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if options.withScores && options.by == rangeByLex {
+		return reply.MakeErrReply("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	return nil
+}
+
+// zRange returns members between start and stop, start and stop are ranks, scores or members depending on options,
+// with REV start is the higher border
+func (db *DB) zRange(key string, start []byte, stop []byte, options *rangeOptions) resp.Reply {
+	if options.by == rangeByRank {
+		return db.zRangeByRank(key, start, stop, options)
+	}
+	if options.rev {
+		start, stop = stop, start
+	}
+	var min, max SortedSet.Border
+	var errReply reply.ErrorReply
+	if options.by == rangeByScore {
+		min, max, errReply = parseScoreBorders(start, stop)
+	} else {
+		min, max, errReply = parseLexBorders(start, stop)
+	}
+	if errReply != nil {
+		return errReply
+	}
+	zs, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if zs == nil || options.offset < 0 {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	limit := options.limit
+	if !options.hasLimit {
+		limit = -1
+	}
+	return elementsToReply(zs.Range(min, max, options.offset, limit, options.rev), options.withScores)
+}
+
+func (db *DB) zRangeByRank(key string, start []byte, stop []byte, options *rangeOptions) resp.Reply {
+	startRank, errReply := parseInt(start)
+	if errReply != nil {
+		return errReply
+	}
+	stopRank, errReply := parseInt(stop)
+	if errReply != nil {
+		return errReply
+	}
+	zs, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if zs == nil {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	begin, end := utils.ConvertRange(startRank, stopRank, zs.Len())
+	if begin < 0 || begin == end {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	elements := make([]*SortedSet.Elem, 0, end-begin)
+	zs.ForEach(int64(begin), int64(end), options.rev, func(element *SortedSet.Elem) bool {
+		elements = append(elements, element)
+		return true
+	})
+	return elementsToReply(elements, options.withScores)
+}
+
+func execRangeWithOptions(db *DB, args CmdArgs, options *rangeOptions, modifiable bool) resp.Reply {
+	if errReply := parseRangeOptions(args[3:], options, modifiable); errReply != nil {
+		return errReply
+	}
+	return db.zRange(string(args[0]), args[1], args[2], options)
+}
+
+// execZRange returns members in the given range
+// ZRANGE key start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+func execZRange(db *DB, args CmdArgs) resp.Reply {
+	return execRangeWithOptions(db, args, &rangeOptions{}, true)
+}
+
+// execZRevRange returns members between the given ranks, ordered from high to low score
+// ZREVRANGE key start stop [WITHSCORES]
+func execZRevRange(db *DB, args CmdArgs) resp.Reply {
+	return execRangeWithOptions(db, args, &rangeOptions{rev: true}, false)
+}
+
+// execZRangeByScore returns members whose score within [min, max]
+// ZRANGEBYSCORE key min max [WITHSCORES] [LIMIT offset count]
+func execZRangeByScore(db *DB, args CmdArgs) resp.Reply {
+	return execRangeWithOptions(db, args, &rangeOptions{by: rangeByScore}, false)
+}
+
+// execZRevRangeByScore returns members whose score within [min, max], ordered from high to low score
+// ZREVRANGEBYSCORE key max min [WITHSCORES] [LIMIT offset count]
+func execZRevRangeByScore(db *DB, args CmdArgs) resp.Reply {
+	return execRangeWithOptions(db, args, &rangeOptions{by: rangeByScore, rev: true}, false)
+}
+
+// execZRangeByLex returns members within [min, max] in lexicographical order
+// ZRANGEBYLEX key min max [LIMIT offset count]
+func execZRangeByLex(db *DB, args CmdArgs) resp.Reply {
+	return execRangeWithOptions(db, args, &rangeOptions{by: rangeByLex}, false)
+}
+
+// execZRevRangeByLex returns members within [min, max] in reversed lexicographical order
+// ZREVRANGEBYLEX key max min [LIMIT offset count]
+func execZRevRangeByLex(db *DB, args CmdArgs) resp.Reply {
+	return execRangeWithOptions(db, args, &rangeOptions{by: rangeByLex, rev: true}, false)
+}
+
+func (db *DB) removeInRange(key string, min SortedSet.Border, max SortedSet.Border) resp.Reply {
+	zs, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if zs == nil {
+		return reply.MakeIntReply(0)
+	}
+	removed := zs.RemoveRange(min, max)
+	if zs.Len() == 0 {
+		db.Remove(key)
+	}
+	return reply.MakeIntReply(removed)
+}
+
+// execZRemRangeByScore removes members whose score within [min, max]
+// ZREMRANGEBYSCORE key min max
+func execZRemRangeByScore(db *DB, args CmdArgs) resp.Reply {
+	min, max, errReply := parseScoreBorders(args[1], args[2])
+	if errReply != nil {
+		return errReply
+	}
+	return db.removeInRange(string(args[0]), min, max)
+}
+
+// execZRemRangeByLex removes members within [min, max] in lexicographical order
+// ZREMRANGEBYLEX key min max
+func execZRemRangeByLex(db *DB, args CmdArgs) resp.Reply {
+	min, max, errReply := parseLexBorders(args[1], args[2])
+	if errReply != nil {
+		return errReply
+	}
+	return db.removeInRange(string(args[0]), min, max)
+}
+
+// execZRemRangeByRank removes members between the given ranks, both ends are inclusive
+// ZREMRANGEBYRANK key start stop
+func execZRemRangeByRank(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	start, errReply := parseInt(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	stop, errReply := parseInt(args[2])
+	if errReply != nil {
+		return errReply
+	}
+	zs, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if zs == nil {
+		return reply.MakeIntReply(0)
+	}
+	begin, end := utils.ConvertRange(start, stop, zs.Len())
+	if begin < 0 || begin == end {
+		return reply.MakeIntReply(0)
+	}
+	removed := zs.RemoveByRank(int64(begin), int64(end))
+	if zs.Len() == 0 {
+		db.Remove(key)
+	}
+	return reply.MakeIntReply(removed)
+}
+
+func execZSetPop(db *DB, args CmdArgs, max bool) resp.Reply {
+	if len(args) > 2 {
+		if max {
+			return reply.MakeArgNumErrReply("zpopmax")
+		}
+		return reply.MakeArgNumErrReply("zpopmin")
+	}
+	key := string(args[0])
+	var count int64 = 1
+	if len(args) == 2 {
+		var err error
+		count, err = strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil || count < 0 {
+			return reply.MakeErrReply("ERR value is out of range, must be positive")
+		}
+	}
+	zs, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if zs == nil {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	if count > zs.Len() {
+		count = zs.Len()
+	}
+	var elements []*SortedSet.Elem
+	if max {
+		elements = zs.PopMax(count)
+	} else {
+		elements = zs.PopMin(count)
+	}
+	if zs.Len() == 0 {
+		db.Remove(key)
+	}
+	return elementsToReply(elements, true)
+}
+
+// execZPopMin removes and returns members with the lowest scores
+// ZPOPMIN key [count]
+func execZPopMin(db *DB, args CmdArgs) resp.Reply {
+	return execZSetPop(db, args, false)
+}
+
+// execZPopMax removes and returns members with the highest scores
+// ZPOPMAX key [count]
+func execZPopMax(db *DB, args CmdArgs) resp.Reply {
+	return execZSetPop(db, args, true)
+}
+
+// parseZMPopArgs parses `numkeys key [key ...] MIN|MAX [COUNT count]`
+func parseZMPopArgs(args CmdArgs) (keys []string, max bool, count int64, errReply reply.ErrorReply) {
+	keys, rest, errReply := parseNumKeys(args)
+	if errReply != nil {
+		return nil, false, 0, errReply
+	}
+	if len(rest) == 0 {
+		return nil, false, 0, reply.MakeSyntaxErrReply()
+	}
+	switch strings.ToLower(string(rest[0])) {
+	case "min":
+		max = false
+	case "max":
+		max = true
+	default:
+		return nil, false, 0, reply.MakeSyntaxErrReply()
+	}
+	count = 1
+	if len(rest) > 1 {
+		if len(rest) != 3 || strings.ToLower(string(rest[1])) != "count" {
+			return nil, false, 0, reply.MakeSyntaxErrReply()
+		}
+		var err error
+		count, err = strconv.ParseInt(string(rest[2]), 10, 64)
+		if err != nil || count <= 0 {
+			return nil, false, 0, reply.MakeErrReply("ERR count should be greater than 0")
+		}
+	}
+	return keys, max, count, nil
+}
+
+// makeZMPopReply makes reply of ZMPOP from flat list of popped members and scores, like [key, [[member, score], ...]]
+func makeZMPopReply(key string, popped [][]byte) resp.Reply {
+	elements := make([]resp.Reply, 0, len(popped)/2)
+	for i := 0; i+1 < len(popped); i += 2 {
+		elements = append(elements, reply.MakeMultiBulkReply([][]byte{popped[i], popped[i+1]}))
+	}
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(key)),
+		reply.MakeMultiRawReply(elements),
+	})
+}
+
+// execZMPop pops members from the first non-empty sorted set
+// ZMPOP numkeys key [key ...] MIN|MAX [COUNT count]
+func execZMPop(db *DB, args CmdArgs) resp.Reply {
+	keys, max, count, errReply := parseZMPopArgs(args)
+	if errReply != nil {
+		return errReply
+	}
+	for _, key := range keys {
+		zs, errReply := db.getAsSortedSet(key)
+		if errReply != nil {
+			return errReply
+		}
+		if zs == nil {
+			continue
+		}
+		popped := execZSetPop(db, utils.ToCmdLine(key, strconv.FormatInt(count, 10)), max)
+		if multiBulk, ok := popped.(*reply.MultiBulkReply); ok {
+			return makeZMPopReply(key, multiBulk.Args)
+		}
+		return popped
+	}
+	return reply.MakeNullMultiBulkReply()
+}
+
+func prepareZMPop(args CmdArgs) ([]string, []string) {
+	keys, _, errReply := parseNumKeys(args)
+	if errReply != nil {
+		return nil, nil
+	}
+	return keys, nil
+}
+
+func undoZMPop(db *DB, args CmdArgs) []CmdLine {
+	keys, _, errReply := parseNumKeys(args)
+	if errReply != nil {
+		return nil
+	}
+	return rollbackGivenKeys(db, keys...)
+}
+
+// getAsWeightedSources returns sorted sets of the given keys, sets are treated as sorted sets whose scores are 1,
+// not existed key is nil
+func (db *DB) getAsWeightedSources(keys []string) ([]*SortedSet.ZSet, reply.ErrorReply) {
+	sources := make([]*SortedSet.ZSet, len(keys))
+	for i, key := range keys {
+		entity, exists := db.GetEntity(key)
+		if !exists {
+			continue
+		}
+		switch data := entity.Data.(type) {
+		case *SortedSet.ZSet:
+			sources[i] = data
+		case *HashSet.Set:
+			zs := SortedSet.Make()
+			data.ForEach(func(member string) bool {
+				zs.Add(member, 1)
+				return true
+			})
+			sources[i] = zs
+		default:
+			return nil, &reply.WrongTypeErrReply{}
+		}
+	}
+	return sources, nil
+}
+
+type aggregator func(a float64, b float64) float64
+
+func aggregateSum(a float64, b float64) float64 {
+	sum := a + b
+	if math.IsNaN(sum) {
+		// like redis, inf + -inf is 0
+		return 0
+	}
+	return sum
+}
+
+// parseWeightsAndAggregate parses `[WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]`
+func parseWeightsAndAggregate(args CmdArgs, numKeys int) ([]float64, aggregator, reply.ErrorReply) {
+	weights := make([]float64, numKeys)
+	for i := range weights {
+		weights[i] = 1
+	}
+	aggregate := aggregateSum
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "weights":
+			if i+numKeys >= len(args) {
+				return nil, nil, reply.MakeSyntaxErrReply()
+			}
+			for j := range weights {
+				weight, err := strconv.ParseFloat(string(args[i+1+j]), 64)
+				if err != nil || math.IsNaN(weight) {
+					return nil, nil, reply.MakeErrReply("ERR weight value is not a float")
+				}
+				weights[j] = weight
+			}
+			i += numKeys
+		case "aggregate":
+			if i+1 >= len(args) {
+				return nil, nil, reply.MakeSyntaxErrReply()
+			}
+			switch strings.ToLower(string(args[i+1])) {
+			case "sum":
+				aggregate = aggregateSum
+			case "min":
+				aggregate = math.Min
+			case "max":
+				aggregate = math.Max
+			default:
+				return nil, nil, reply.MakeSyntaxErrReply()
+			}
+			i++
+		default:
+			return nil, nil, reply.MakeSyntaxErrReply()
+		}
+	}
+	return weights, aggregate, nil
+}
+
+func weightedScore(score float64, weight float64) float64 {
+	result := score * weight
+	if math.IsNaN(result) {
+		// inf * 0
+		return 0
+	}
+	return result
+}
+
+func unionSortedSets(sources []*SortedSet.ZSet, weights []float64, aggregate aggregator) *SortedSet.ZSet {
+	result := SortedSet.Make()
+	for i, zs := range sources {
+		if zs == nil {
+			continue
+		}
+		zs.ForEach(0, zs.Len(), false, func(element *SortedSet.Elem) bool {
+			score := weightedScore(element.Score, weights[i])
+			if existed, ok := result.Get(element.Member); ok {
+				score = aggregate(existed.Score, score)
+			}
+			result.Add(element.Member, score)
+			return true
+		})
+	}
+	return result
+}
+
+func interSortedSets(sources []*SortedSet.ZSet, weights []float64, aggregate aggregator) *SortedSet.ZSet {
+	result := SortedSet.Make()
+	for _, zs := range sources {
+		if zs == nil {
+			return result
+		}
+	}
+	first := sources[0]
+	first.ForEach(0, first.Len(), false, func(element *SortedSet.Elem) bool {
+		score := weightedScore(element.Score, weights[0])
+		for i, zs := range sources[1:] {
+			other, ok := zs.Get(element.Member)
+			if !ok {
+				return true
+			}
+			score = aggregate(score, weightedScore(other.Score, weights[i+1]))
+		}
+		result.Add(element.Member, score)
+		return true
+	})
+	return result
+}
+
+type sortedSetCalculator func(sources []*SortedSet.ZSet, weights []float64, aggregate aggregator) *SortedSet.ZSet
+
+// execSortedSetCalculateStore stores result into destination, replies number of members in result
+// ZUNIONSTORE|ZINTERSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+func execSortedSetCalculateStore(db *DB, args CmdArgs, calculate sortedSetCalculator) resp.Reply {
+	dest := string(args[0])
+	keys, rest, errReply := parseNumKeys(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	weights, aggregate, errReply := parseWeightsAndAggregate(rest, len(keys))
+	if errReply != nil {
+		return errReply
+	}
+	sources, errReply := db.getAsWeightedSources(keys)
+	if errReply != nil {
+		return errReply
+	}
+	result := calculate(sources, weights, aggregate)
+	if result.Len() == 0 {
+		db.Remove(dest)
+		return reply.MakeIntReply(0)
+	}
+	db.PutEntity(dest, &database.DataEntity{
+		Data: result,
+	})
+	db.Persist(dest)
+	db.blocking.signal(dest)
+	return reply.MakeIntReply(result.Len())
+}
+
+// execZUnionStore stores union of the given sorted sets into destination
+func execZUnionStore(db *DB, args CmdArgs) resp.Reply {
+	return execSortedSetCalculateStore(db, args, unionSortedSets)
+}
+
+// execZInterStore stores intersection of the given sorted sets into destination
+func execZInterStore(db *DB, args CmdArgs) resp.Reply {
+	return execSortedSetCalculateStore(db, args, interSortedSets)
+}
+
+// prepareSortedSetCalculateStore writes destination and reads source keys
+func prepareSortedSetCalculateStore(args CmdArgs) ([]string, []string) {
+	dest := string(args[0])
+	keys, _, errReply := parseNumKeys(args[1:])
+	if errReply != nil {
+		return []string{dest}, nil
+	}
+	return []string{dest}, keys
+}
+
+func init() {
+	RegisterCommand("ZAdd", execZAdd, writeFirstKey, rollbackFirstKey, -4, flagWrite|flagSortedSet)
+	RegisterCommand("ZIncrBy", execZIncrBy, writeFirstKey, rollbackFirstKey, 4, flagWrite|flagSortedSet)
+	RegisterCommand("ZRem", execZRem, writeFirstKey, rollbackFirstKey, -3, flagWrite|flagSortedSet)
+	RegisterCommand("ZScore", execZScore, readFirstKey, nil, 3, flagReadOnly|flagSortedSet)
+	RegisterCommand("ZCard", execZCard, readFirstKey, nil, 2, flagReadOnly|flagSortedSet)
+	RegisterCommand("ZCount", execZCount, readFirstKey, nil, 4, flagReadOnly|flagSortedSet)
+	RegisterCommand("ZLexCount", execZLexCount, readFirstKey, nil, 4, flagReadOnly|flagSortedSet)
+	RegisterCommand("ZRank", execZRank, readFirstKey, nil, 3, flagReadOnly|flagSortedSet)
+	RegisterCommand("ZRevRank", execZRevRank, readFirstKey, nil, 3, flagReadOnly|flagSortedSet)
+	RegisterCommand("ZRange", execZRange, readFirstKey, nil, -4, flagReadOnly|flagSortedSet)
+	RegisterCommand("ZRevRange", execZRevRange, readFirstKey, nil, -4, flagReadOnly|flagSortedSet)
+	RegisterCommand("ZRangeByScore", execZRangeByScore, readFirstKey, nil, -4, flagReadOnly|flagSortedSet)
+	RegisterCommand("ZRevRangeByScore", execZRevRangeByScore, readFirstKey, nil, -4, flagReadOnly|flagSortedSet)
+	RegisterCommand("ZRangeByLex", execZRangeByLex, readFirstKey, nil, -4, flagReadOnly|flagSortedSet)
+	RegisterCommand("ZRevRangeByLex", execZRevRangeByLex, readFirstKey, nil, -4, flagReadOnly|flagSortedSet)
+	RegisterCommand("ZRemRangeByScore", execZRemRangeByScore, writeFirstKey, rollbackFirstKey, 4, flagWrite|flagSortedSet)
+	RegisterCommand("ZRemRangeByLex", execZRemRangeByLex, writeFirstKey, rollbackFirstKey, 4, flagWrite|flagSortedSet)
+	RegisterCommand("ZRemRangeByRank", execZRemRangeByRank, writeFirstKey, rollbackFirstKey, 4, flagWrite|flagSortedSet)
+	RegisterCommand("ZPopMin", execZPopMin, writeFirstKey, rollbackFirstKey, -2, flagWrite|flagSortedSet)
+	RegisterCommand("ZPopMax", execZPopMax, writeFirstKey, rollbackFirstKey, -2, flagWrite|flagSortedSet)
+	RegisterCommand("ZMPop", execZMPop, prepareZMPop, undoZMPop, -4, flagWrite|flagSortedSet)
+	RegisterCommand("ZUnionStore", execZUnionStore, prepareSortedSetCalculateStore, rollbackFirstKey, -4, flagWrite|flagSortedSet)
+	RegisterCommand("ZInterStore", execZInterStore, prepareSortedSetCalculateStore, rollbackFirstKey, -4, flagWrite|flagSortedSet)
+}
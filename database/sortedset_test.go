@@ -0,0 +1,165 @@
+package database
+
+import (
+	"ringodis/lib/utils"
+	"ringodis/resp/conn"
+	"ringodis/resp/reply/asserts"
+	"testing"
+)
+
+func TestZAdd(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	c := conn.NewFakeConn()
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("zadd", "z", "1", "a", "2", "b", "3", "c")), 3)
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("type", "z")), "zset")
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("zadd", "z", "NX", "10", "a", "4", "d")), 1)
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("zscore", "z", "a")), "1")
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("zadd", "z", "XX", "CH", "10", "a", "5", "e")), 1)
+	asserts.AssertNullBulk(t, server.Exec(c, utils.ToCmdLine("zscore", "z", "e")))
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("zadd", "z", "GT", "CH", "5", "a", "5", "b")), 1)
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("zscore", "z", "a")), "10")
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("zscore", "z", "b")), "5")
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("zadd", "z", "INCR", "1.5", "c")), "4.5")
+	asserts.AssertNullBulk(t, server.Exec(c, utils.ToCmdLine("zadd", "z", "LT", "INCR", "1", "c")))
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("zincrby", "z", "-0.5", "c")), "4")
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("zcard", "z")), 4)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("zadd", "x", "XX", "1", "a")), 0)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("exists", "x")), 0)
+
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("zadd", "z", "NX", "XX", "1", "a")),
+		"ERR XX and NX options at the same time are not compatible")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("zadd", "z", "GT", "LT", "1", "a")),
+		"ERR GT, LT, and/or NX options at the same time are not compatible")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("zadd", "z", "INCR", "1", "a", "2", "b")),
+		"ERR INCR option supports a single increment-element pair")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("zadd", "z", "abc", "a")), "ERR value is not a valid float")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("zadd", "z", "1", "a", "2")), "Err syntax error")
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("zadd", "z", "inf", "a")), 0)
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("zscore", "z", "a")), "inf")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("zincrby", "z", "-inf", "a")),
+		"ERR resulting score is not a number (NaN)")
+
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("zrem", "z", "a", "b", "x")), 2)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("zrem", "z", "c", "d")), 2)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("exists", "z")), 0)
+
+	server.Exec(c, utils.ToCmdLine("set", "s", "v"))
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("zadd", "s", "1", "a")),
+		"WRONG-TYPE Operation against a key holding the wrong kind of value")
+}
+
+func TestZRange(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	c := conn.NewFakeConn()
+	server.Exec(c, utils.ToCmdLine("zadd", "z", "1", "a", "2", "b", "3", "c", "4", "d", "5", "e"))
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("zrank", "z", "c")), 2)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("zrevrank", "z", "c")), 2)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("zrevrank", "z", "e")), 0)
+	asserts.AssertNullBulk(t, server.Exec(c, utils.ToCmdLine("zrank", "z", "x")))
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("zcount", "z", "(1", "3")), 2)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("zcount", "z", "-inf", "+inf")), 5)
+
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("zrange", "z", "1", "-2")),
+		[]string{"b", "c", "d"})
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("zrange", "z", "0", "1", "REV", "WITHSCORES")),
+		[]string{"e", "5", "d", "4"})
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("zrevrange", "z", "-2", "-1")),
+		[]string{"b", "a"})
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("zrange", "z", "(1", "4", "BYSCORE", "LIMIT", "1", "2")),
+		[]string{"c", "d"})
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("zrange", "z", "+inf", "(3", "BYSCORE", "REV")),
+		[]string{"e", "d"})
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("zrangebyscore", "z", "2", "3", "WITHSCORES")),
+		[]string{"b", "2", "c", "3"})
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("zrevrangebyscore", "z", "3", "-inf", "LIMIT", "0", "1")),
+		[]string{"c"})
+	asserts.AssertMultiBulkReplySize(t, server.Exec(c, utils.ToCmdLine("zrange", "z", "10", "20")), 0)
+	asserts.AssertMultiBulkReplySize(t, server.Exec(c, utils.ToCmdLine("zrange", "x", "0", "-1")), 0)
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("zrange", "z", "0", "1", "LIMIT", "0", "1")),
+		"ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("zrangebyscore", "z", "a", "1")),
+		"ERR min or max is not a float")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("zrangebyscore", "z", "0", "1", "REV")),
+		"Err syntax error")
+
+	server.Exec(c, utils.ToCmdLine("zadd", "lex", "0", "a", "0", "b", "0", "c", "0", "d"))
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("zlexcount", "lex", "[b", "+")), 3)
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("zrange", "lex", "[b", "(d", "BYLEX")),
+		[]string{"b", "c"})
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("zrevrangebylex", "lex", "+", "-", "LIMIT", "1", "2")),
+		[]string{"c", "b"})
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("zrangebylex", "lex", "-", "[a")),
+		[]string{"a"})
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("zrangebylex", "lex", "a", "+")),
+		"ERR min or max not valid string range item")
+}
+
+func TestZRemRange(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	c := conn.NewFakeConn()
+	server.Exec(c, utils.ToCmdLine("zadd", "z", "1", "a", "2", "b", "3", "c", "4", "d", "5", "e"))
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("zremrangebyscore", "z", "(4", "+inf")), 1)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("zremrangebyrank", "z", "0", "1")), 2)
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("zrange", "z", "0", "-1")),
+		[]string{"c", "d"})
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("zremrangebyrank", "z", "5", "10")), 0)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("zremrangebyrank", "z", "0", "-1")), 2)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("exists", "z")), 0)
+
+	server.Exec(c, utils.ToCmdLine("zadd", "lex", "0", "a", "0", "b", "0", "c"))
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("zremrangebylex", "lex", "(a", "+")), 2)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("zcard", "lex")), 1)
+}
+
+func TestZPop(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	c := conn.NewFakeConn()
+	server.Exec(c, utils.ToCmdLine("zadd", "z", "1", "a", "2", "b", "3", "c"))
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("zpopmin", "z")), []string{"a", "1"})
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("zpopmax", "z", "5")),
+		[]string{"c", "3", "b", "2"})
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("exists", "z")), 0)
+	asserts.AssertMultiBulkReplySize(t, server.Exec(c, utils.ToCmdLine("zpopmin", "z")), 0)
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("zpopmin", "z", "-1")),
+		"ERR value is out of range, must be positive")
+}
+
+func TestZStore(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	c := conn.NewFakeConn()
+	server.Exec(c, utils.ToCmdLine("zadd", "z1", "1", "a", "2", "b"))
+	server.Exec(c, utils.ToCmdLine("zadd", "z2", "10", "b", "20", "c"))
+	server.Exec(c, utils.ToCmdLine("sadd", "s", "b", "d"))
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("zunionstore", "u", "2", "z1", "z2", "WEIGHTS", "2", "1")), 3)
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("zrange", "u", "0", "-1", "WITHSCORES")),
+		[]string{"a", "2", "b", "14", "c", "20"})
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("zinterstore", "i", "3", "z1", "z2", "s", "AGGREGATE", "MAX")), 1)
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("zrange", "i", "0", "-1", "WITHSCORES")),
+		[]string{"b", "10"})
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("zinterstore", "i", "2", "z1", "x")), 0)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("exists", "i")), 0)
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("zunionstore", "u", "2", "z1", "z2", "WEIGHTS", "1")),
+		"Err syntax error")
+
+	server.Exec(c, utils.ToCmdLine("set", "str", "v"))
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("zunionstore", "u", "2", "z1", "str")),
+		"WRONG-TYPE Operation against a key holding the wrong kind of value")
+}
+
+func TestZMPop(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	c := conn.NewFakeConn()
+	server.Exec(c, utils.ToCmdLine("zadd", "z", "1", "a", "2", "b", "3", "c"))
+	result := server.Exec(c, utils.ToCmdLine("zmpop", "2", "x", "z", "max", "count", "2"))
+	if string(result.ToBytes()) != "*2\r\n$1\r\nz\r\n*2\r\n*2\r\n$1\r\nc\r\n$1\r\n3\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n" {
+		t.Errorf("unexpected zmpop reply: %s", result.ToBytes())
+	}
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("zcard", "z")), 1)
+	result = server.Exec(c, utils.ToCmdLine("zmpop", "1", "x", "min"))
+	if string(result.ToBytes()) != "*-1\r\n" {
+		t.Errorf("expected null multi bulk, actual: %s", result.ToBytes())
+	}
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("zmpop", "1", "z", "min", "count", "0")),
+		"ERR count should be greater than 0")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("zmpop", "1", "z", "middle")), "Err syntax error")
+}
@@ -0,0 +1,1151 @@
+package database
+
+import (
+	"ringodis/ds/stream"
+	"ringodis/interface/database"
+	"ringodis/interface/resp"
+	"ringodis/lib/utils"
+	"ringodis/resp/reply"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (db *DB) getAsStream(key string) (*stream.Stream, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	s, ok := entity.Data.(*stream.Stream)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return s, nil
+}
+
+func (db *DB) getOrInitStream(key string) (s *stream.Stream, inited bool, errReply reply.ErrorReply) {
+	s, errReply = db.getAsStream(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	inited = false
+	if s == nil {
+		s = stream.Make()
+		db.PutEntity(key, &database.DataEntity{
+			Data: s,
+		})
+		inited = true
+	}
+	return s, inited, nil
+}
+
+// parseStreamID parses id like 1526919030474-55, sequence is 0 if omitted
+func parseStreamID(arg []byte) (stream.ID, reply.ErrorReply) {
+	id, err := stream.ParseID(string(arg), 0)
+	if err != nil {
+		return stream.ID{}, reply.MakeErrReply(err.Error())
+	}
+	return id, nil
+}
+
+// parseRangeStart parses start of range, which may be `-`, exclusive like `(1-1`, or incomplete like `1`
+func parseRangeStart(arg []byte) (stream.ID, reply.ErrorReply) {
+	s := string(arg)
+	if s == "-" {
+		return stream.MinID, nil
+	}
+	exclusive := strings.HasPrefix(s, "(")
+	id, err := stream.ParseID(strings.TrimPrefix(s, "("), 0)
+	if err != nil {
+		return stream.ID{}, reply.MakeErrReply(err.Error())
+	}
+	if exclusive {
+		var ok bool
+		if id, ok = id.Incr(); !ok {
+			return stream.ID{}, reply.MakeErrReply("ERR invalid start ID for the interval")
+		}
+	}
+	return id, nil
+}
+
+// parseRangeEnd parses end of range, which may be `+`, exclusive like `(1-1`, or incomplete like `1`
+func parseRangeEnd(arg []byte) (stream.ID, reply.ErrorReply) {
+	s := string(arg)
+	if s == "+" {
+		return stream.MaxID, nil
+	}
+	exclusive := strings.HasPrefix(s, "(")
+	id, err := stream.ParseID(strings.TrimPrefix(s, "("), stream.MaxID.Seq)
+	if err != nil {
+		return stream.ID{}, reply.MakeErrReply(err.Error())
+	}
+	if exclusive {
+		var ok bool
+		if id, ok = id.Decr(); !ok {
+			return stream.ID{}, reply.MakeErrReply("ERR invalid end ID for the interval")
+		}
+	}
+	return id, nil
+}
+
+func entryToReply(entry *stream.Entry) resp.Reply {
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(entry.ID.String())),
+		reply.MakeMultiBulkReply(entry.Fields),
+	})
+}
+
+func entriesToReply(entries []*stream.Entry) resp.Reply {
+	result := make([]resp.Reply, len(entries))
+	for i, entry := range entries {
+		result[i] = entryToReply(entry)
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+func idsToReply(ids []stream.ID) resp.Reply {
+	result := make([][]byte, len(ids))
+	for i, id := range ids {
+		result[i] = []byte(id.String())
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// nextStreamID returns id of the new entry, arg may be `*`, `ms-*` or an explicit id
+func nextStreamID(s *stream.Stream, arg []byte) (stream.ID, reply.ErrorReply) {
+	lastID := stream.MinID
+	if s != nil {
+		lastID = s.LastID()
+	}
+	errSmaller := reply.MakeErrReply("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	raw := string(arg)
+	if raw == "*" {
+		if ms := uint64(time.Now().UnixMilli()); ms > lastID.Ms {
+			return stream.ID{Ms: ms}, nil
+		}
+		id, ok := lastID.Incr()
+		if !ok {
+			return stream.ID{}, reply.MakeErrReply("ERR The stream has exhausted the last possible ID, unable to add more items")
+		}
+		return id, nil
+	}
+	if strings.HasSuffix(raw, "-*") {
+		ms, err := strconv.ParseUint(strings.TrimSuffix(raw, "-*"), 10, 64)
+		if err != nil {
+			return stream.ID{}, reply.MakeErrReply("ERR Invalid stream ID specified as stream command argument")
+		}
+		if ms > lastID.Ms {
+			return stream.ID{Ms: ms}, nil
+		}
+		if ms < lastID.Ms || lastID.Seq == stream.MaxID.Seq {
+			return stream.ID{}, errSmaller
+		}
+		return stream.ID{Ms: ms, Seq: lastID.Seq + 1}, nil
+	}
+	id, errReply := parseStreamID(arg)
+	if errReply != nil {
+		return stream.ID{}, errReply
+	}
+	if id == stream.MinID {
+		return stream.ID{}, reply.MakeErrReply("ERR The ID specified in XADD must be greater than 0-0")
+	}
+	if !lastID.Less(id) {
+		return stream.ID{}, errSmaller
+	}
+	return id, nil
+}
+
+// trimOptions holds `MAXLEN|MINID [=|~] threshold [LIMIT count]`
+type trimOptions struct {
+	byMinID bool
+	approx  bool
+	maxLen  int64
+	minID   stream.ID
+	limit   int64
+}
+
+// parseTrimOptions parses trim options starting from args[i], returns index of the last consumed argument
+func parseTrimOptions(args CmdArgs, i int) (*trimOptions, int, reply.ErrorReply) {
+	options := &trimOptions{
+		byMinID: strings.ToLower(string(args[i])) == "minid",
+	}
+	i++
+	if i < len(args) && (string(args[i]) == "=" || string(args[i]) == "~") {
+		options.approx = string(args[i]) == "~"
+		i++
+	}
+	if i >= len(args) {
+		return nil, 0, reply.MakeSyntaxErrReply()
+	}
+	if options.byMinID {
+		minID, errReply := parseStreamID(args[i])
+		if errReply != nil {
+			return nil, 0, errReply
+		}
+		options.minID = minID
+	} else {
+		maxLen, errReply := parseInt(args[i])
+		if errReply != nil {
+			return nil, 0, errReply
+		}
+		if maxLen < 0 {
+			return nil, 0, reply.MakeErrReply("ERR The MAXLEN argument must be >= 0.")
+		}
+		options.maxLen = maxLen
+	}
+	if i+1 < len(args) && strings.ToLower(string(args[i+1])) == "limit" {
+		if i+2 >= len(args) {
+			return nil, 0, reply.MakeSyntaxErrReply()
+		}
+		if !options.approx {
+			return nil, 0, reply.MakeErrReply("ERR syntax error, LIMIT cannot be used without the special ~ option")
+		}
+		limit, errReply := parseInt(args[i+2])
+		if errReply != nil {
+			return nil, 0, errReply
+		}
+		if limit < 0 {
+			return nil, 0, reply.MakeErrReply("ERR The LIMIT argument must be >= 0.")
+		}
+		options.limit = limit
+		i += 2
+	}
+	return options, i, nil
+}
+
+func (options *trimOptions) trim(s *stream.Stream) int64 {
+	if options.byMinID {
+		return s.TrimMinID(options.minID, options.approx, options.limit)
+	}
+	return s.TrimMaxLen(options.maxLen, options.approx, options.limit)
+}
+
+// execXAdd appends an entry to stream, replies id of the entry
+// XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] *|id field value [field value ...]
+func execXAdd(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	noMkStream := false
+	var trim *trimOptions
+	i := 1
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "nomkstream":
+			noMkStream = true
+		case "maxlen", "minid":
+			var errReply reply.ErrorReply
+			trim, i, errReply = parseTrimOptions(args, i)
+			if errReply != nil {
+				return errReply
+			}
+		default:
+			break options
+		}
+	}
+	if i >= len(args) || (len(args)-i-1) == 0 || (len(args)-i-1)%2 != 0 {
+		return reply.MakeArgNumErrReply("xadd")
+	}
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil && noMkStream {
+		return reply.MakeNullBulkReply()
+	}
+	id, errReply := nextStreamID(s, args[i])
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		s, _, _ = db.getOrInitStream(key)
+	}
+	fields := make([][]byte, len(args)-i-1)
+	copy(fields, args[i+1:])
+	s.Add(id, fields)
+	if trim != nil {
+		trim.trim(s)
+	}
+	db.blocking.broadcast(key)
+	return reply.MakeBulkReply([]byte(id.String()))
+}
+
+// execXLen returns number of entries in stream
+func execXLen(db *DB, args CmdArgs) resp.Reply {
+	s, errReply := db.getAsStream(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(s.Len())
+}
+
+func execXRangeGeneric(db *DB, args CmdArgs, desc bool) resp.Reply {
+	startArg, endArg := args[1], args[2]
+	if desc {
+		startArg, endArg = endArg, startArg
+	}
+	start, errReply := parseRangeStart(startArg)
+	if errReply != nil {
+		return errReply
+	}
+	end, errReply := parseRangeEnd(endArg)
+	if errReply != nil {
+		return errReply
+	}
+	var count int64 = -1
+	if len(args) > 3 {
+		if len(args) != 5 || strings.ToLower(string(args[3])) != "count" {
+			return reply.MakeSyntaxErrReply()
+		}
+		count, errReply = parseInt(args[4])
+		if errReply != nil {
+			return errReply
+		}
+		if count < 0 {
+			count = 0
+		}
+	}
+	s, errReply := db.getAsStream(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	return entriesToReply(s.Range(start, end, count, desc))
+}
+
+// execXRange returns entries within the given range of ids
+// XRANGE key start end [COUNT count]
+func execXRange(db *DB, args CmdArgs) resp.Reply {
+	return execXRangeGeneric(db, args, false)
+}
+
+// execXRevRange returns entries within the given range of ids in reversed order
+// XREVRANGE key end start [COUNT count]
+func execXRevRange(db *DB, args CmdArgs) resp.Reply {
+	return execXRangeGeneric(db, args, true)
+}
+
+// execXDel removes entries from stream, the stream is kept even if it becomes empty
+// XDEL key id [id ...]
+func execXDel(db *DB, args CmdArgs) resp.Reply {
+	ids := make([]stream.ID, len(args)-1)
+	for i, arg := range args[1:] {
+		id, errReply := parseStreamID(arg)
+		if errReply != nil {
+			return errReply
+		}
+		ids[i] = id
+	}
+	s, errReply := db.getAsStream(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return reply.MakeIntReply(0)
+	}
+	var deleted int64
+	for _, id := range ids {
+		if s.Delete(id) {
+			deleted++
+		}
+	}
+	return reply.MakeIntReply(deleted)
+}
+
+// execXTrim trims stream, replies number of removed entries
+// XTRIM key MAXLEN|MINID [=|~] threshold [LIMIT count]
+func execXTrim(db *DB, args CmdArgs) resp.Reply {
+	strategy := strings.ToLower(string(args[1]))
+	if strategy != "maxlen" && strategy != "minid" {
+		return reply.MakeSyntaxErrReply()
+	}
+	trim, i, errReply := parseTrimOptions(args, 1)
+	if errReply != nil {
+		return errReply
+	}
+	if i != len(args)-1 {
+		return reply.MakeSyntaxErrReply()
+	}
+	s, errReply := db.getAsStream(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(trim.trim(s))
+}
+
+// execXSetID sets the last id of stream
+// XSETID key last-id
+func execXSetID(db *DB, args CmdArgs) resp.Reply {
+	id, errReply := parseStreamID(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	s, errReply := db.getAsStream(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return reply.MakeErrReply("ERR no such key")
+	}
+	if last := s.Range(stream.MinID, stream.MaxID, 1, true); len(last) > 0 && id.Less(last[0].ID) {
+		return reply.MakeErrReply("ERR The ID specified in XSETID is smaller than the target stream top item")
+	}
+	s.SetLastID(id)
+	return reply.MakeOkReply()
+}
+
+// readOptions holds options of XREAD and XREADGROUP
+type readOptions struct {
+	// negative count means no limit
+	count    int64
+	blocking bool
+	timeout  time.Duration
+	noAck    bool
+	group    string
+	consumer string
+	keys     []string
+	ids      [][]byte
+}
+
+// parseReadOptions parses
+// XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+// XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+func parseReadOptions(cmdName string, args CmdArgs) (*readOptions, reply.ErrorReply) {
+	isGroup := cmdName == "xreadgroup"
+	options := &readOptions{count: -1}
+	hasGroup := false
+	for i := 0; i < len(args); i++ {
+		option := strings.ToLower(string(args[i]))
+		switch {
+		case option == "count" && i+1 < len(args):
+			count, errReply := parseInt(args[i+1])
+			if errReply != nil {
+				return nil, errReply
+			}
+			if count > 0 {
+				options.count = count
+			}
+			i++
+		case option == "block" && i+1 < len(args):
+			timeout, errReply := parseInt(args[i+1])
+			if errReply != nil {
+				return nil, reply.MakeErrReply("ERR timeout is not an integer or out of range")
+			}
+			if timeout < 0 {
+				return nil, reply.MakeErrReply("ERR timeout is negative")
+			}
+			options.blocking = true
+			options.timeout = time.Duration(timeout) * time.Millisecond
+			i++
+		case option == "noack" && isGroup:
+			options.noAck = true
+		case option == "group" && isGroup && i+2 < len(args):
+			options.group = string(args[i+1])
+			options.consumer = string(args[i+2])
+			hasGroup = true
+			i += 2
+		case option == "streams":
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return nil, reply.MakeErrReply("ERR Unbalanced '" + cmdName +
+					"' list of streams: for each stream key an ID or '$' must be specified.")
+			}
+			half := len(rest) / 2
+			options.keys = make([]string, half)
+			for j, key := range rest[:half] {
+				options.keys[j] = string(key)
+			}
+			options.ids = rest[half:]
+			if isGroup && !hasGroup {
+				return nil, reply.MakeErrReply("ERR Missing GROUP option for XREADGROUP")
+			}
+			return options, nil
+		default:
+			return nil, reply.MakeSyntaxErrReply()
+		}
+	}
+	return nil, reply.MakeSyntaxErrReply()
+}
+
+// toCmdLine rebuilds command line without BLOCK option, ids are replaced by the given ones
+func (options *readOptions) toCmdLine(cmdName string, ids [][]byte) CmdLine {
+	cmdLine := utils.ToCmdLine(cmdName)
+	if options.group != "" {
+		cmdLine = append(cmdLine, []byte("GROUP"), []byte(options.group), []byte(options.consumer))
+	}
+	if options.count > 0 {
+		cmdLine = append(cmdLine, []byte("COUNT"), []byte(strconv.FormatInt(options.count, 10)))
+	}
+	if options.noAck {
+		cmdLine = append(cmdLine, []byte("NOACK"))
+	}
+	cmdLine = append(cmdLine, []byte("STREAMS"))
+	for _, key := range options.keys {
+		cmdLine = append(cmdLine, []byte(key))
+	}
+	return append(cmdLine, ids...)
+}
+
+func prepareXRead(args CmdArgs) ([]string, []string) {
+	options, errReply := parseReadOptions("xread", args)
+	if errReply != nil {
+		return nil, nil
+	}
+	return nil, options.keys
+}
+
+func prepareXReadGroup(args CmdArgs) ([]string, []string) {
+	options, errReply := parseReadOptions("xreadgroup", args)
+	if errReply != nil {
+		return nil, nil
+	}
+	return options.keys, nil
+}
+
+func undoXReadGroup(db *DB, args CmdArgs) []CmdLine {
+	writerKeys, _ := prepareXReadGroup(args)
+	return rollbackGivenKeys(db, writerKeys...)
+}
+
+// execXRead reads entries whose id is greater than the given ones, never blocks,
+// blocking is implemented in execBlockingXRead
+func execXRead(db *DB, args CmdArgs) resp.Reply {
+	options, errReply := parseReadOptions("xread", args)
+	if errReply != nil {
+		return errReply
+	}
+	result := make([]resp.Reply, 0, len(options.keys))
+	for i, key := range options.keys {
+		s, errReply := db.getAsStream(key)
+		if errReply != nil {
+			return errReply
+		}
+		var after stream.ID
+		if string(options.ids[i]) == "$" {
+			if s != nil {
+				after = s.LastID()
+			}
+		} else if after, errReply = parseStreamID(options.ids[i]); errReply != nil {
+			return errReply
+		}
+		if s == nil {
+			continue
+		}
+		start, ok := after.Incr()
+		if !ok {
+			continue
+		}
+		entries := s.Range(start, stream.MaxID, options.count, false)
+		if len(entries) == 0 {
+			continue
+		}
+		result = append(result, reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte(key)),
+			entriesToReply(entries),
+		}))
+	}
+	if len(result) == 0 {
+		return reply.MakeNullMultiBulkReply()
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+func makeNoGroupErr(key string, group string) reply.ErrorReply {
+	return reply.MakeErrReply("NOGROUP No such key '" + key + "' or consumer group '" + group + "'")
+}
+
+// execXReadGroup reads entries as a consumer of group, never blocks
+// id `>` means entries never delivered to any consumer, other ids mean history pending entries of the consumer
+func execXReadGroup(db *DB, args CmdArgs) resp.Reply {
+	options, errReply := parseReadOptions("xreadgroup", args)
+	if errReply != nil {
+		return errReply
+	}
+	streams := make([]*stream.Stream, len(options.keys))
+	groups := make([]*stream.Group, len(options.keys))
+	for i, key := range options.keys {
+		if string(options.ids[i]) == "$" {
+			return reply.MakeErrReply("ERR The $ ID is meaningless in the context of XREADGROUP: " +
+				"you want to read the history of this consumer by specifying a proper ID, " +
+				"or use the > ID to get new messages. The $ ID would just return an empty result set.")
+		}
+		s, errReply := db.getAsStream(key)
+		if errReply != nil {
+			return errReply
+		}
+		if s != nil {
+			groups[i] = s.GetGroup(options.group)
+		}
+		if groups[i] == nil {
+			return reply.MakeErrReply("NOGROUP No such key '" + key + "' or consumer group '" +
+				options.group + "' in XREADGROUP with GROUP option")
+		}
+		streams[i] = s
+	}
+	now := time.Now()
+	result := make([]resp.Reply, 0, len(options.keys))
+	for i, key := range options.keys {
+		s, group := streams[i], groups[i]
+		consumer, _ := group.GetOrCreateConsumer(options.consumer, now)
+		consumer.SeenTime = now
+		var entries []resp.Reply
+		if string(options.ids[i]) == ">" {
+			start, _ := group.LastID.Incr()
+			delivered := s.Range(start, stream.MaxID, options.count, false)
+			if len(delivered) == 0 {
+				continue
+			}
+			group.LastID = delivered[len(delivered)-1].ID
+			for _, entry := range delivered {
+				if !options.noAck {
+					group.Deliver(entry.ID, consumer, now)
+				}
+				entries = append(entries, entryToReply(entry))
+			}
+		} else {
+			after, errReply := parseStreamID(options.ids[i])
+			if errReply != nil {
+				return errReply
+			}
+			entries = make([]resp.Reply, 0)
+			for _, pending := range consumer.PendingEntries() {
+				if !after.Less(pending.ID) {
+					continue
+				}
+				if options.count > 0 && int64(len(entries)) >= options.count {
+					break
+				}
+				if entry, ok := s.Get(pending.ID); ok {
+					entries = append(entries, entryToReply(entry))
+				} else {
+					// deleted entries are replied with nil fields
+					entries = append(entries, reply.MakeMultiRawReply([]resp.Reply{
+						reply.MakeBulkReply([]byte(pending.ID.String())),
+						reply.MakeNullMultiBulkReply(),
+					}))
+				}
+			}
+		}
+		result = append(result, reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte(key)),
+			reply.MakeMultiRawReply(entries),
+		}))
+	}
+	if len(result) == 0 {
+		return reply.MakeNullMultiBulkReply()
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// isStreamReady tells whether any of streams has entries to read for the given options,
+// ids must have been resolved, that is `$` is not allowed
+func (db *DB) isStreamReady(options *readOptions, ids [][]byte) (bool, reply.ErrorReply) {
+	for i, key := range options.keys {
+		s, errReply := db.getAsStream(key)
+		if errReply != nil {
+			return false, errReply
+		}
+		if s == nil {
+			continue
+		}
+		var after stream.ID
+		if options.group != "" {
+			group := s.GetGroup(options.group)
+			if group == nil {
+				// let the command report error
+				return true, nil
+			}
+			after = group.LastID
+		} else if after, errReply = parseStreamID(ids[i]); errReply != nil {
+			return false, errReply
+		}
+		if start, ok := after.Incr(); ok && len(s.Range(start, stream.MaxID, 1, false)) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// execXGroup manages consumer groups
+// XGROUP CREATE key group id|$ [MKSTREAM]
+// XGROUP SETID key group id|$
+// XGROUP DESTROY key group
+// XGROUP CREATECONSUMER key group consumer
+// XGROUP DELCONSUMER key group consumer
+func execXGroup(db *DB, args CmdArgs) resp.Reply {
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "create":
+		if len(args) != 4 && len(args) != 5 {
+			return reply.MakeArgNumErrReply("xgroup|create")
+		}
+	case "setid", "createconsumer", "delconsumer":
+		if len(args) != 4 {
+			return reply.MakeArgNumErrReply("xgroup|" + subCmd)
+		}
+	case "destroy":
+		if len(args) != 3 {
+			return reply.MakeArgNumErrReply("xgroup|destroy")
+		}
+	default:
+		return reply.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try XGROUP HELP.")
+	}
+	key := string(args[1])
+	groupName := string(args[2])
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if subCmd == "create" {
+		return db.createGroup(key, s, args[2:])
+	}
+	if s == nil {
+		return reply.MakeErrReply("ERR The XGROUP subcommand requires the key to exist. " +
+			"Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+	}
+	if subCmd == "destroy" {
+		if s.DestroyGroup(groupName) {
+			return reply.MakeIntReply(1)
+		}
+		return reply.MakeIntReply(0)
+	}
+	group := s.GetGroup(groupName)
+	if group == nil {
+		return reply.MakeErrReply("NOGROUP No such consumer group '" + groupName + "' for key name '" + key + "'")
+	}
+	switch subCmd {
+	case "setid":
+		id, errReply := parseGroupID(s, args[3])
+		if errReply != nil {
+			return errReply
+		}
+		group.LastID = id
+		return reply.MakeOkReply()
+	case "createconsumer":
+		if _, created := group.GetOrCreateConsumer(string(args[3]), time.Now()); created {
+			return reply.MakeIntReply(1)
+		}
+		return reply.MakeIntReply(0)
+	default:
+		pending, _ := group.DeleteConsumer(string(args[3]))
+		return reply.MakeIntReply(pending)
+	}
+}
+
+// parseGroupID parses last delivered id of group, `$` means the last id of stream
+func parseGroupID(s *stream.Stream, arg []byte) (stream.ID, reply.ErrorReply) {
+	if string(arg) == "$" {
+		if s == nil {
+			return stream.MinID, nil
+		}
+		return s.LastID(), nil
+	}
+	return parseStreamID(arg)
+}
+
+// createGroup executes `XGROUP CREATE key group id|$ [MKSTREAM]`, args starts from group
+func (db *DB) createGroup(key string, s *stream.Stream, args CmdArgs) resp.Reply {
+	mkStream := false
+	if len(args) == 3 {
+		if strings.ToLower(string(args[2])) != "mkstream" {
+			return reply.MakeSyntaxErrReply()
+		}
+		mkStream = true
+	}
+	id, errReply := parseGroupID(s, args[1])
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		if !mkStream {
+			return reply.MakeErrReply("ERR The XGROUP subcommand requires the key to exist. " +
+				"Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+		}
+		s, _, _ = db.getOrInitStream(key)
+	}
+	if _, ok := s.CreateGroup(string(args[0]), id); !ok {
+		return reply.MakeErrReply("BUSYGROUP Consumer Group name already exists")
+	}
+	return reply.MakeOkReply()
+}
+
+func prepareXGroup(args CmdArgs) ([]string, []string) {
+	if len(args) < 2 {
+		return nil, nil
+	}
+	return []string{string(args[1])}, nil
+}
+
+func undoXGroup(db *DB, args CmdArgs) []CmdLine {
+	if len(args) < 2 {
+		return nil
+	}
+	return rollbackGivenKeys(db, string(args[1]))
+}
+
+// getGroup returns stream and consumer group of the given key, replies NOGROUP error if not exists
+func (db *DB) getGroup(key string, groupName string) (*stream.Stream, *stream.Group, reply.ErrorReply) {
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return nil, nil, errReply
+	}
+	if s == nil || s.GetGroup(groupName) == nil {
+		return nil, nil, makeNoGroupErr(key, groupName)
+	}
+	return s, s.GetGroup(groupName), nil
+}
+
+// execXAck acknowledges pending entries, replies number of acknowledged entries
+// XACK key group id [id ...]
+func execXAck(db *DB, args CmdArgs) resp.Reply {
+	ids := make([]stream.ID, len(args)-2)
+	for i, arg := range args[2:] {
+		id, errReply := parseStreamID(arg)
+		if errReply != nil {
+			return errReply
+		}
+		ids[i] = id
+	}
+	s, errReply := db.getAsStream(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return reply.MakeIntReply(0)
+	}
+	group := s.GetGroup(string(args[1]))
+	if group == nil {
+		return reply.MakeIntReply(0)
+	}
+	var acked int64
+	for _, id := range ids {
+		if group.Ack(id) {
+			acked++
+		}
+	}
+	return reply.MakeIntReply(acked)
+}
+
+// execXPending inspects pending entries of group
+// XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+func execXPending(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	_, group, errReply := db.getGroup(key, string(args[1]))
+	if errReply != nil {
+		return errReply
+	}
+	if len(args) == 2 {
+		return makePendingSummary(group)
+	}
+	rest := args[2:]
+	var minIdle int64
+	if strings.ToLower(string(rest[0])) == "idle" {
+		if len(rest) < 2 {
+			return reply.MakeSyntaxErrReply()
+		}
+		minIdle, errReply = parseInt(rest[1])
+		if errReply != nil {
+			return errReply
+		}
+		rest = rest[2:]
+	}
+	if len(rest) != 3 && len(rest) != 4 {
+		return reply.MakeSyntaxErrReply()
+	}
+	start, errReply := parseRangeStart(rest[0])
+	if errReply != nil {
+		return errReply
+	}
+	end, errReply := parseRangeEnd(rest[1])
+	if errReply != nil {
+		return errReply
+	}
+	count, errReply := parseInt(rest[2])
+	if errReply != nil {
+		return errReply
+	}
+	pendingEntries := group.PendingEntries()
+	if len(rest) == 4 {
+		consumer := group.GetConsumer(string(rest[3]))
+		if consumer == nil {
+			return reply.MakeEmptyMultiBulkReply()
+		}
+		pendingEntries = consumer.PendingEntries()
+	}
+	now := time.Now()
+	result := make([]resp.Reply, 0)
+	for _, pending := range pendingEntries {
+		if int64(len(result)) >= count {
+			break
+		}
+		if pending.ID.Less(start) || end.Less(pending.ID) {
+			continue
+		}
+		idle := now.Sub(pending.DeliveryTime).Milliseconds()
+		if idle < minIdle {
+			continue
+		}
+		result = append(result, reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte(pending.ID.String())),
+			reply.MakeBulkReply([]byte(pending.Consumer)),
+			reply.MakeIntReply(idle),
+			reply.MakeIntReply(pending.DeliveryCount),
+		}))
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// makePendingSummary replies number of pending entries, the smallest and the greatest id, and pending count of every consumer
+func makePendingSummary(group *stream.Group) resp.Reply {
+	pendingEntries := group.PendingEntries()
+	if len(pendingEntries) == 0 {
+		return reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeIntReply(0),
+			reply.MakeNullBulkReply(),
+			reply.MakeNullBulkReply(),
+			reply.MakeNullMultiBulkReply(),
+		})
+	}
+	consumers := make([]resp.Reply, 0)
+	for _, consumer := range group.SortedConsumers() {
+		if len(consumer.Pending) == 0 {
+			continue
+		}
+		consumers = append(consumers, reply.MakeMultiBulkReply([][]byte{
+			[]byte(consumer.Name),
+			[]byte(strconv.Itoa(len(consumer.Pending))),
+		}))
+	}
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeIntReply(int64(len(pendingEntries))),
+		reply.MakeBulkReply([]byte(pendingEntries[0].ID.String())),
+		reply.MakeBulkReply([]byte(pendingEntries[len(pendingEntries)-1].ID.String())),
+		reply.MakeMultiRawReply(consumers),
+	})
+}
+
+// claimOptions holds options of XCLAIM
+type claimOptions struct {
+	deliveryTime  time.Time
+	retryCount    int64
+	hasRetryCount bool
+	force         bool
+	justID        bool
+	lastID        *stream.ID
+}
+
+// claim transfers pending entry to consumer if it has been idle for at least minIdle,
+// returns the claimed entry, deleted is true if the entry no longer exists in stream and it's removed from PEL
+func claim(s *stream.Stream, group *stream.Group, consumer *stream.Consumer, id stream.ID,
+	minIdle time.Duration, now time.Time, options *claimOptions) (claimed *stream.Entry, deleted bool) {
+	entry, exists := s.Get(id)
+	pending, isPending := group.Pending[id]
+	if !isPending {
+		if !options.force || !exists {
+			return nil, false
+		}
+	} else {
+		if !exists {
+			group.Ack(id)
+			return nil, true
+		}
+		if now.Sub(pending.DeliveryTime) < minIdle {
+			return nil, false
+		}
+	}
+	pending = group.Claim(id, consumer)
+	pending.DeliveryTime = options.deliveryTime
+	if options.hasRetryCount {
+		pending.DeliveryCount = options.retryCount
+	} else if !options.justID {
+		pending.DeliveryCount++
+	}
+	return entry, false
+}
+
+func parseMinIdle(arg []byte) (time.Duration, reply.ErrorReply) {
+	minIdle, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, reply.MakeErrReply("ERR Invalid min-idle-time argument for XCLAIM")
+	}
+	if minIdle < 0 {
+		minIdle = 0
+	}
+	return time.Duration(minIdle) * time.Millisecond, nil
+}
+
+// execXClaim changes ownership of pending entries
+// XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms] [TIME unix-time-milliseconds] [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID lastid]
+func execXClaim(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	minIdle, errReply := parseMinIdle(args[3])
+	if errReply != nil {
+		return errReply
+	}
+	now := time.Now()
+	options := &claimOptions{deliveryTime: now}
+	ids := make([]stream.ID, 0)
+	i := 4
+	for ; i < len(args); i++ {
+		id, err := stream.ParseID(string(args[i]), 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return reply.MakeErrReply("ERR Invalid stream ID specified as stream command argument")
+	}
+	for ; i < len(args); i++ {
+		option := strings.ToLower(string(args[i]))
+		switch {
+		case option == "force":
+			options.force = true
+		case option == "justid":
+			options.justID = true
+		case (option == "idle" || option == "time" || option == "retrycount") && i+1 < len(args):
+			value, errReply := parseInt(args[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			switch option {
+			case "idle":
+				options.deliveryTime = now.Add(-time.Duration(value) * time.Millisecond)
+			case "time":
+				options.deliveryTime = time.UnixMilli(value)
+			default:
+				options.retryCount, options.hasRetryCount = value, true
+			}
+			i++
+		case option == "lastid" && i+1 < len(args):
+			lastID, errReply := parseStreamID(args[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			options.lastID = &lastID
+			i++
+		default:
+			return reply.MakeErrReply("ERR Unrecognized XCLAIM option '" + string(args[i]) + "'")
+		}
+	}
+	s, group, errReply := db.getGroup(key, string(args[1]))
+	if errReply != nil {
+		return errReply
+	}
+	if options.lastID != nil && group.LastID.Less(*options.lastID) {
+		group.LastID = *options.lastID
+	}
+	consumer, _ := group.GetOrCreateConsumer(string(args[2]), now)
+	consumer.SeenTime = now
+	claimed := make([]*stream.Entry, 0, len(ids))
+	for _, id := range ids {
+		if entry, _ := claim(s, group, consumer, id, minIdle, now, options); entry != nil {
+			claimed = append(claimed, entry)
+		}
+	}
+	if options.justID {
+		claimedIDs := make([]stream.ID, len(claimed))
+		for j, entry := range claimed {
+			claimedIDs[j] = entry.ID
+		}
+		return idsToReply(claimedIDs)
+	}
+	return entriesToReply(claimed)
+}
+
+// execXAutoClaim claims pending entries idle for at least min-idle-time, scanning PEL from start,
+// replies the cursor of next scanning, claimed entries and ids of deleted entries
+// XAUTOCLAIM key group consumer min-idle-time start [COUNT count] [JUSTID]
+func execXAutoClaim(db *DB, args CmdArgs) resp.Reply {
+	key := string(args[0])
+	minIdle, errReply := parseMinIdle(args[3])
+	if errReply != nil {
+		return errReply
+	}
+	start, errReply := parseRangeStart(args[4])
+	if errReply != nil {
+		return errReply
+	}
+	now := time.Now()
+	options := &claimOptions{deliveryTime: now}
+	var count int64 = 100
+	for i := 5; i < len(args); i++ {
+		option := strings.ToLower(string(args[i]))
+		switch {
+		case option == "justid":
+			options.justID = true
+		case option == "count" && i+1 < len(args):
+			count, errReply = parseInt(args[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			if count <= 0 {
+				return reply.MakeErrReply("ERR COUNT must be > 0")
+			}
+			i++
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	s, group, errReply := db.getGroup(key, string(args[1]))
+	if errReply != nil {
+		return errReply
+	}
+	consumer, _ := group.GetOrCreateConsumer(string(args[2]), now)
+	consumer.SeenTime = now
+	claimed := make([]*stream.Entry, 0)
+	claimedIDs := make([]stream.ID, 0)
+	deletedIDs := make([]stream.ID, 0)
+	next := stream.MinID
+	var scanned int64
+	for _, pending := range group.PendingEntries() {
+		if pending.ID.Less(start) {
+			continue
+		}
+		if scanned >= count {
+			next = pending.ID
+			break
+		}
+		scanned++
+		entry, deleted := claim(s, group, consumer, pending.ID, minIdle, now, options)
+		if deleted {
+			deletedIDs = append(deletedIDs, pending.ID)
+		} else if entry != nil {
+			claimed = append(claimed, entry)
+			claimedIDs = append(claimedIDs, entry.ID)
+		}
+	}
+	claimedReply := entriesToReply(claimed)
+	if options.justID {
+		claimedReply = idsToReply(claimedIDs)
+	}
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(next.String())),
+		claimedReply,
+		idsToReply(deletedIDs),
+	})
+}
+
+func init() {
+	RegisterCommand("XAdd", execXAdd, writeFirstKey, rollbackFirstKey, -5, flagWrite|flagStream)
+	RegisterCommand("XLen", execXLen, readFirstKey, nil, 2, flagReadOnly|flagStream)
+	RegisterCommand("XRange", execXRange, readFirstKey, nil, -4, flagReadOnly|flagStream)
+	RegisterCommand("XRevRange", execXRevRange, readFirstKey, nil, -4, flagReadOnly|flagStream)
+	RegisterCommand("XDel", execXDel, writeFirstKey, rollbackFirstKey, -3, flagWrite|flagStream)
+	RegisterCommand("XTrim", execXTrim, writeFirstKey, rollbackFirstKey, -4, flagWrite|flagStream)
+	RegisterCommand("XSetID", execXSetID, writeFirstKey, rollbackFirstKey, 3, flagWrite|flagStream)
+	RegisterCommand("XRead", execXRead, prepareXRead, nil, -4, flagReadOnly|flagStream)
+	RegisterCommand("XGroup", execXGroup, prepareXGroup, undoXGroup, -2, flagWrite|flagStream)
+	RegisterCommand("XReadGroup", execXReadGroup, prepareXReadGroup, undoXReadGroup, -7, flagWrite|flagStream)
+	RegisterCommand("XAck", execXAck, writeFirstKey, rollbackFirstKey, -4, flagWrite|flagStream)
+	RegisterCommand("XPending", execXPending, readFirstKey, nil, -3, flagReadOnly|flagStream)
+	RegisterCommand("XClaim", execXClaim, writeFirstKey, rollbackFirstKey, -6, flagWrite|flagStream)
+	RegisterCommand("XAutoClaim", execXAutoClaim, writeFirstKey, rollbackFirstKey, -6, flagWrite|flagStream)
+}
@@ -0,0 +1,192 @@
+package database
+
+import (
+	"ringodis/ds/stream"
+	"ringodis/interface/resp"
+	"ringodis/lib/utils"
+	"ringodis/resp/conn"
+	"ringodis/resp/reply"
+	"ringodis/resp/reply/asserts"
+	"testing"
+)
+
+// makeTestEntry makes entry with id like "1-0" and a single field
+func makeTestEntry(id string, field string, value string) *stream.Entry {
+	streamID, _ := stream.ParseID(id, 0)
+	return &stream.Entry{
+		ID:     streamID,
+		Fields: [][]byte{[]byte(field), []byte(value)},
+	}
+}
+
+func assertSameReply(t *testing.T, actual resp.Reply, expected resp.Reply) {
+	t.Helper()
+	if string(actual.ToBytes()) != string(expected.ToBytes()) {
+		t.Errorf("expected %q, actual %q", expected.ToBytes(), actual.ToBytes())
+	}
+}
+
+func TestXAdd(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	c := conn.NewFakeConn()
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("xadd", "s", "1-1", "a", "1")), "1-1")
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("type", "s")), "stream")
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("xadd", "s", "1-*", "b", "2")), "1-2")
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("xadd", "s", "3", "c", "3")), "3-0")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("xadd", "s", "2-0", "d", "4")),
+		"ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("xadd", "x", "0-0", "d", "4")),
+		"ERR The ID specified in XADD must be greater than 0-0")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("xadd", "s", "4-0", "d")),
+		"ERR wrong number of arguments for 'xadd' command")
+	asserts.AssertNullBulk(t, server.Exec(c, utils.ToCmdLine("xadd", "x", "NOMKSTREAM", "*", "a", "1")))
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("exists", "x")), 0)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("xlen", "s")), 3)
+
+	assertSameReply(t, server.Exec(c, utils.ToCmdLine("xrange", "s", "-", "+", "COUNT", "2")), entriesToReply([]*stream.Entry{
+		makeTestEntry("1-1", "a", "1"),
+		makeTestEntry("1-2", "b", "2"),
+	}))
+	assertSameReply(t, server.Exec(c, utils.ToCmdLine("xrange", "s", "(1-1", "1")), entriesToReply([]*stream.Entry{
+		makeTestEntry("1-2", "b", "2"),
+	}))
+	assertSameReply(t, server.Exec(c, utils.ToCmdLine("xrevrange", "s", "+", "-", "COUNT", "1")), entriesToReply([]*stream.Entry{
+		makeTestEntry("3-0", "c", "3"),
+	}))
+
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("xdel", "s", "1-2", "9-9")), 1)
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("xadd", "s", "MAXLEN", "1", "4-0", "d", "4")), "4-0")
+	assertSameReply(t, server.Exec(c, utils.ToCmdLine("xrange", "s", "-", "+")), entriesToReply([]*stream.Entry{
+		makeTestEntry("4-0", "d", "4"),
+	}))
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("xsetid", "s", "3-0")),
+		"ERR The ID specified in XSETID is smaller than the target stream top item")
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("xtrim", "s", "MINID", "5")), 1)
+	// stream is not removed when it becomes empty
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("xlen", "s")), 0)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("exists", "s")), 1)
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("xsetid", "s", "10-0")), "OK")
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("xadd", "s", "10-*", "e", "5")), "10-1")
+
+	server.Exec(c, utils.ToCmdLine("set", "str", "v"))
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("xadd", "str", "*", "a", "1")),
+		"WRONG-TYPE Operation against a key holding the wrong kind of value")
+}
+
+func TestXRead(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	db, _ := server.selectDB(0)
+	c := conn.NewFakeConn()
+	server.Exec(c, utils.ToCmdLine("xadd", "s", "1-0", "a", "1"))
+	server.Exec(c, utils.ToCmdLine("xadd", "s", "2-0", "b", "2"))
+	assertSameReply(t, server.Exec(c, utils.ToCmdLine("xread", "COUNT", "1", "STREAMS", "s", "x", "0", "0")),
+		reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeMultiRawReply([]resp.Reply{
+				reply.MakeBulkReply([]byte("s")),
+				entriesToReply([]*stream.Entry{makeTestEntry("1-0", "a", "1")}),
+			}),
+		}))
+	result := server.Exec(c, utils.ToCmdLine("xread", "STREAMS", "s", "$"))
+	if _, ok := result.(*reply.NullMultiBulkReply); !ok {
+		t.Errorf("expected null multi bulk, actual: %s", result.ToBytes())
+	}
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("xread", "STREAMS", "s", "x", "0")),
+		"ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.")
+
+	// `$` is resolved before blocking, so the entry added later will be delivered
+	c1 := conn.NewFakeConn()
+	ch := execAsync(server, c1, "xread", "BLOCK", "0", "STREAMS", "s", "$")
+	waitBlocked(t, db, 1)
+	server.Exec(c, utils.ToCmdLine("xadd", "s", "3-0", "c", "3"))
+	assertSameReply(t, <-ch, reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte("s")),
+			entriesToReply([]*stream.Entry{makeTestEntry("3-0", "c", "3")}),
+		}),
+	}))
+	waitBlocked(t, db, 0)
+
+	result = server.Exec(c, utils.ToCmdLine("xread", "BLOCK", "200", "STREAMS", "s", "$"))
+	if _, ok := result.(*reply.NullMultiBulkReply); !ok {
+		t.Errorf("expected null multi bulk, actual: %s", result.ToBytes())
+	}
+
+	// XREAD never blocks inside MULTI
+	server.Exec(c, utils.ToCmdLine("multi"))
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("xread", "BLOCK", "0", "STREAMS", "s", "2-0")), "QUEUED")
+	result = server.Exec(c, utils.ToCmdLine("exec"))
+	if multiRaw, ok := result.(*reply.MultiRawReply); !ok || len(multiRaw.Replies) != 1 {
+		t.Errorf("unexpected exec reply: %s", result.ToBytes())
+	}
+}
+
+func TestXReadGroup(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	db, _ := server.selectDB(0)
+	c := conn.NewFakeConn()
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("xgroup", "create", "s", "g", "$")),
+		"ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("xgroup", "create", "s", "g", "$", "MKSTREAM")), "OK")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("xgroup", "create", "s", "g", "$")),
+		"BUSYGROUP Consumer Group name already exists")
+	server.Exec(c, utils.ToCmdLine("xadd", "s", "1-0", "a", "1"))
+	server.Exec(c, utils.ToCmdLine("xadd", "s", "2-0", "b", "2"))
+
+	assertSameReply(t, server.Exec(c, utils.ToCmdLine("xreadgroup", "GROUP", "g", "alice", "COUNT", "1", "STREAMS", "s", ">")),
+		reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeMultiRawReply([]resp.Reply{
+				reply.MakeBulkReply([]byte("s")),
+				entriesToReply([]*stream.Entry{makeTestEntry("1-0", "a", "1")}),
+			}),
+		}))
+	server.Exec(c, utils.ToCmdLine("xreadgroup", "GROUP", "g", "bob", "STREAMS", "s", ">"))
+	// history of consumer is read from its pending entries
+	assertSameReply(t, server.Exec(c, utils.ToCmdLine("xreadgroup", "GROUP", "g", "alice", "STREAMS", "s", "0")),
+		reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeMultiRawReply([]resp.Reply{
+				reply.MakeBulkReply([]byte("s")),
+				entriesToReply([]*stream.Entry{makeTestEntry("1-0", "a", "1")}),
+			}),
+		}))
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("xreadgroup", "GROUP", "x", "alice", "STREAMS", "s", ">")),
+		"NOGROUP No such key 's' or consumer group 'x' in XREADGROUP with GROUP option")
+
+	assertSameReply(t, server.Exec(c, utils.ToCmdLine("xpending", "s", "g")), reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeIntReply(2),
+		reply.MakeBulkReply([]byte("1-0")),
+		reply.MakeBulkReply([]byte("2-0")),
+		reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeMultiBulkReply(utils.ToCmdLine("alice", "1")),
+			reply.MakeMultiBulkReply(utils.ToCmdLine("bob", "1")),
+		}),
+	}))
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("xack", "s", "g", "1-0", "1-0")), 1)
+
+	// claimed entry moves to alice
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("xclaim", "s", "g", "alice", "0", "2-0", "JUSTID")),
+		[]string{"2-0"})
+	asserts.AssertMultiBulkReply(t, server.Exec(c, utils.ToCmdLine("xclaim", "s", "g", "bob", "3600000", "2-0", "JUSTID")),
+		[]string{})
+	server.Exec(c, utils.ToCmdLine("xdel", "s", "2-0"))
+	assertSameReply(t, server.Exec(c, utils.ToCmdLine("xautoclaim", "s", "g", "bob", "0", "0")),
+		reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte("0-0")),
+			reply.MakeMultiRawReply([]resp.Reply{}),
+			reply.MakeMultiBulkReply(utils.ToCmdLine("2-0")),
+		}))
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("xgroup", "delconsumer", "s", "g", "bob")), 0)
+
+	// blocked consumer is woken up by new entries
+	c1 := conn.NewFakeConn()
+	ch := execAsync(server, c1, "xreadgroup", "GROUP", "g", "alice", "BLOCK", "0", "STREAMS", "s", ">")
+	waitBlocked(t, db, 1)
+	server.Exec(c, utils.ToCmdLine("xadd", "s", "3-0", "c", "3"))
+	assertSameReply(t, <-ch, reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte("s")),
+			entriesToReply([]*stream.Entry{makeTestEntry("3-0", "c", "3")}),
+		}),
+	}))
+	waitBlocked(t, db, 0)
+	asserts.AssertIntReply(t, server.Exec(c, utils.ToCmdLine("xgroup", "destroy", "s", "g")), 1)
+}
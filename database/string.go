@@ -135,11 +135,52 @@ func execStrLen(db *DB, args CmdArgs) resp.Reply {
 	return reply.MakeIntReply(int64(len(bytes)))
 }
 
+// execMSet sets values of multiple keys, existing ttl is removed like SET
+// MSET key value [key value ...]
+func execMSet(db *DB, args CmdArgs) resp.Reply {
+	if len(args)%2 != 0 {
+		return reply.MakeArgNumErrReply("mset")
+	}
+	for i := 0; i < len(args); i += 2 {
+		key := string(args[i])
+		db.PutEntity(key, &database.DataEntity{Data: args[i+1]})
+		db.Persist(key)
+	}
+	return reply.MakeOkReply()
+}
+
+func prepareMSet(args CmdArgs) ([]string, []string) {
+	keys := make([]string, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		keys = append(keys, string(args[i]))
+	}
+	return keys, nil
+}
+
+func undoMSet(db *DB, args CmdArgs) []CmdLine {
+	writeKeys, _ := prepareMSet(args)
+	return rollbackGivenKeys(db, writeKeys...)
+}
+
+// execMGet returns values of multiple keys, nil for keys not existed or not holding a string
+func execMGet(db *DB, args CmdArgs) resp.Reply {
+	result := make([][]byte, len(args))
+	for i, arg := range args {
+		bytes, err := db.getAsString(string(arg))
+		if err == nil {
+			result[i] = bytes
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
 func init() {
-	RegisterCommand("Get", execGet, readFirstKey, 2)
-	RegisterCommand("Set", execSet, writeFirstKey, -3)
-	RegisterCommand("SetNX", execSetNX, writeFirstKey, 3)
-	RegisterCommand("GetSet", execGetSet, writeFirstKey, 3)
-	RegisterCommand("StrLen", execStrLen, readFirstKey, 2)
-	RegisterCommand("SetEX", execSetEX, writeFirstKey, 4)
+	RegisterCommand("Get", execGet, readFirstKey, nil, 2, flagReadOnly|flagString)
+	RegisterCommand("Set", execSet, writeFirstKey, rollbackFirstKey, -3, flagWrite|flagString)
+	RegisterCommand("SetNX", execSetNX, writeFirstKey, rollbackFirstKey, 3, flagWrite|flagString)
+	RegisterCommand("GetSet", execGetSet, writeFirstKey, rollbackFirstKey, 3, flagWrite|flagString)
+	RegisterCommand("StrLen", execStrLen, readFirstKey, nil, 2, flagReadOnly|flagString)
+	RegisterCommand("SetEX", execSetEX, writeFirstKey, rollbackFirstKey, 4, flagWrite|flagString)
+	RegisterCommand("MSet", execMSet, prepareMSet, undoMSet, -3, flagWrite|flagString)
+	RegisterCommand("MGet", execMGet, readAllKeys, nil, -2, flagReadOnly|flagString)
 }
@@ -0,0 +1,37 @@
+package database
+
+import (
+	"ringodis/lib/utils"
+	"ringodis/resp/reply"
+	"ringodis/resp/reply/asserts"
+	"testing"
+)
+
+func TestMSet(t *testing.T) {
+	testDB.Flush()
+	keys := []string{utils.RandString(10), utils.RandString(10)}
+	testDB.Exec(nil, utils.ToCmdLine("set", keys[0], "old", "ex", "1000"))
+	result := testDB.Exec(nil, utils.ToCmdLine("mset", keys[0], "a", keys[1], "b"))
+	asserts.AssertStatusReply(t, result, "OK")
+	asserts.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("ttl", keys[0])), -1)
+
+	testDB.Exec(nil, utils.ToCmdLine("rpush", "list", "a"))
+	result = testDB.Exec(nil, utils.ToCmdLine("mget", keys[0], "none", "list", keys[1]))
+	mget, ok := result.(*reply.MultiBulkReply)
+	if !ok || len(mget.Args) != 4 {
+		t.Fatalf("unexpected reply %s", result.ToBytes())
+	}
+	if string(mget.Args[0]) != "a" || mget.Args[1] != nil || mget.Args[2] != nil || string(mget.Args[3]) != "b" {
+		t.Errorf("unexpected reply %s", result.ToBytes())
+	}
+	asserts.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("mset", keys[0], "a", keys[1])),
+		"ERR wrong number of arguments for 'mset' command")
+
+	undoLogs := testDB.GetUndoLogs(utils.ToCmdLine("mset", keys[0], "c", "new", "d"))
+	testDB.Exec(nil, utils.ToCmdLine("mset", keys[0], "c", "new", "d"))
+	for _, cmdLine := range undoLogs {
+		testDB.Exec(nil, cmdLine)
+	}
+	asserts.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("get", keys[0])), "a")
+	asserts.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("exists", "new")), 0)
+}
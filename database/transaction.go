@@ -0,0 +1,307 @@
+package database
+
+import (
+	"ringodis/config"
+	"ringodis/interface/resp"
+	"ringodis/resp/reply"
+	"strings"
+)
+
+// commands controlling transaction, they are never queued.
+// They are handled by server, as SELECT could be queued and watched keys may be in different dbs
+var txCommands = map[string]func(server *Server, c resp.Connection, args CmdArgs) resp.Reply{
+	"multi":   execMulti,
+	"discard": execDiscard,
+	"watch":   execWatch,
+	"unwatch": execUnwatch,
+	"exec":    execExec,
+}
+
+// execMulti starts a transaction
+func execMulti(server *Server, c resp.Connection, args CmdArgs) resp.Reply {
+	if len(args) != 0 {
+		return reply.MakeArgNumErrReply("multi")
+	}
+	if c.InMultiState() {
+		return reply.MakeErrReply("ERR MULTI calls can not be nested")
+	}
+	c.SetMultiState(true)
+	return reply.MakeOkReply()
+}
+
+// execDiscard drops all queued commands and watched keys
+func execDiscard(server *Server, c resp.Connection, args CmdArgs) resp.Reply {
+	if len(args) != 0 {
+		return reply.MakeArgNumErrReply("discard")
+	}
+	if !c.InMultiState() {
+		return reply.MakeErrReply("ERR DISCARD without MULTI")
+	}
+	server.unwatchAll(c)
+	c.SetMultiState(false)
+	return reply.MakeOkReply()
+}
+
+// execWatch marks the given keys to be watched for conditional execution of a transaction
+func execWatch(server *Server, c resp.Connection, args CmdArgs) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("watch")
+	}
+	if c.InMultiState() {
+		return reply.MakeErrReply("ERR WATCH inside MULTI is not allowed")
+	}
+	db, errReply := server.selectDB(c.GetDBIndex())
+	if errReply != nil {
+		return errReply
+	}
+	watching := c.GetWatching()
+	for _, arg := range args {
+		key := string(arg)
+		version, ok := db.watches.watch(c, key)
+		if !ok {
+			break
+		}
+		watching[resp.WatchedKey{DBIndex: db.index, Key: key}] = version
+	}
+	return reply.MakeOkReply()
+}
+
+// execUnwatch forgets all watched keys
+func execUnwatch(server *Server, c resp.Connection, args CmdArgs) resp.Reply {
+	if len(args) != 0 {
+		return reply.MakeArgNumErrReply("unwatch")
+	}
+	if c.InMultiState() {
+		// like redis, unwatch inside multi is queued but does nothing
+		return reply.MakeStatusReply("QUEUED")
+	}
+	server.unwatchAll(c)
+	watching := c.GetWatching()
+	for key := range watching {
+		delete(watching, key)
+	}
+	return reply.MakeOkReply()
+}
+
+// unwatchAll forgets keys watched by client in all dbs
+func (server *Server) unwatchAll(c resp.Connection) {
+	for _, holder := range server.dbSet {
+		holder.Load().(*DB).watches.unwatchAll(c)
+	}
+}
+
+// enqueueCmd validates command and puts it into the queue of transaction,
+// errors are recorded and make the transaction aborted when exec
+func enqueueCmd(c resp.Connection, cmdLine CmdLine) resp.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	var arity int
+	if cmd, ok := cmdTable[cmdName]; ok {
+		arity = cmd.arity
+	} else if blockingCmd, ok := txBlockingCommands[cmdName]; ok {
+		// blocking commands are executed without blocking
+		arity = blockingCmd.arity
+	} else {
+		errReply := reply.MakeErrReply("ERR unknown command '" + cmdName + "'")
+		c.AddTxError(errReply)
+		return errReply
+	}
+	if !validateArity(arity, cmdLine) {
+		errReply := reply.MakeArgNumErrReply(cmdName)
+		c.AddTxError(errReply)
+		return errReply
+	}
+	c.EnqueueCmd(cmdLine)
+	return reply.MakeStatusReply("QUEUED")
+}
+
+// execExec executes all queued commands atomically, unless any watched key has been modified
+func execExec(server *Server, c resp.Connection, args CmdArgs) resp.Reply {
+	if len(args) != 0 {
+		return reply.MakeArgNumErrReply("exec")
+	}
+	if !c.InMultiState() {
+		return reply.MakeErrReply("ERR EXEC without MULTI")
+	}
+	defer func() {
+		server.unwatchAll(c)
+		c.SetMultiState(false)
+	}()
+	if len(c.GetTxErrors()) > 0 {
+		return reply.MakeErrReply("EXECABORT Transaction discarded because of previous errors.")
+	}
+	return server.ExecMulti(c, c.GetWatching(), c.GetQueuedCmdLine())
+}
+
+// txCmd is a queued command with the db it's executed in,
+// cmd is nil for SELECT, whose result is decided when queued commands are analysed,
+// and for blocking commands until they are resolved
+type txCmd struct {
+	db       *DB
+	cmd      *command
+	cmdLine  CmdLine
+	blocking *txBlockingCommand
+	// dbIndex is the db selected by SELECT
+	dbIndex      int
+	selectResult resp.Reply
+}
+
+// resolve decides the command to execute, blocking command is replaced by its non-blocking variant according to current data.
+// It returns the reply directly if there is nothing to execute, otherwise a function converting reply of the command, which may be nil
+func (tc *txCmd) resolve() (resp.Reply, func(resp.Reply) resp.Reply) {
+	if tc.selectResult != nil {
+		return tc.selectResult, nil
+	}
+	if tc.blocking == nil {
+		return nil, nil
+	}
+	cmdLine, convert, result := tc.blocking.resolve(tc.db, tc.cmdLine[1:])
+	if result != nil {
+		return result, nil
+	}
+	tc.cmd = cmdTable[strings.ToLower(string(cmdLine[0]))]
+	tc.cmdLine = cmdLine
+	return nil, convert
+}
+
+// txKeys collects keys to lock in one db
+type txKeys struct {
+	writerKeys []string
+	readerKeys []string
+}
+
+// ExecMulti locks all keys related to the given commands, then executes them one by one.
+// Like redis, queued SELECT switches db of the following commands and of the client after execution.
+// Watched keys are checked in the dbs they were watched in, returns null multi bulk reply if any of them changed
+func (server *Server) ExecMulti(c resp.Connection, watching map[resp.WatchedKey]uint32, cmdLines []CmdLine) resp.Reply {
+	startDB, errReply := server.selectDB(c.GetDBIndex())
+	if errReply != nil {
+		return errReply
+	}
+	keys := make(map[*DB]*txKeys)
+	getKeys := func(db *DB) *txKeys {
+		if keys[db] == nil {
+			keys[db] = &txKeys{}
+		}
+		return keys[db]
+	}
+	cmds := make([]*txCmd, 0, len(cmdLines))
+	db := startDB
+	for _, cmdLine := range cmdLines {
+		cmdName := strings.ToLower(string(cmdLine[0]))
+		if cmdName == "select" {
+			tc := &txCmd{cmdLine: cmdLine, selectResult: reply.MakeOkReply()}
+			if dbIndex, errReply := server.parseDBIndex(cmdLine[1]); errReply != nil {
+				tc.selectResult = errReply
+			} else {
+				tc.dbIndex = dbIndex
+				db, _ = server.selectDB(dbIndex)
+			}
+			cmds = append(cmds, tc)
+			continue
+		}
+		tc := &txCmd{db: db, cmdLine: cmdLine}
+		var write, read []string
+		if cmd, ok := cmdTable[cmdName]; ok {
+			tc.cmd = cmd
+			write, read = cmd.prepare(cmdLine[1:])
+		} else if blockingCmd, ok := txBlockingCommands[cmdName]; ok {
+			tc.blocking = blockingCmd
+			write, read = blockingPrepares[cmdName](cmdLine[1:])
+		} else {
+			return reply.MakeErrReply("ERR unknown command '" + cmdName + "'")
+		}
+		dbKeys := getKeys(db)
+		dbKeys.writerKeys = append(dbKeys.writerKeys, write...)
+		dbKeys.readerKeys = append(dbKeys.readerKeys, read...)
+		cmds = append(cmds, tc)
+	}
+	for watched := range watching {
+		watchedDB, errReply := server.selectDB(watched.DBIndex)
+		if errReply != nil {
+			return errReply
+		}
+		dbKeys := getKeys(watchedDB)
+		dbKeys.readerKeys = append(dbKeys.readerKeys, watched.Key)
+	}
+	// lock dbs in ascending order, then gate is taken only once since recursive read locking may deadlock
+	for _, holder := range server.dbSet {
+		lockDB := holder.Load().(*DB)
+		if dbKeys, ok := keys[lockDB]; ok {
+			lockDB.locker.RWLocks(dbKeys.writerKeys, dbKeys.readerKeys)
+			defer lockDB.locker.RWUnLocks(dbKeys.writerKeys, dbKeys.readerKeys)
+		}
+	}
+	server.gate.RLock()
+	defer server.gate.RUnlock()
+
+	if server.isWatchingChanged(watching) {
+		return reply.MakeNullMultiBulkReply()
+	}
+	var result resp.Reply
+	if config.Properties.MultiRollback {
+		result = execMultiWithRollback(cmds)
+	} else {
+		results := make([]resp.Reply, 0, len(cmds))
+		for _, tc := range cmds {
+			result, convert := tc.resolve()
+			if result == nil {
+				result = tc.db.execWithLock(tc.cmd, tc.cmdLine)
+				if convert != nil {
+					result = convert(result)
+				}
+			}
+			results = append(results, result)
+		}
+		result = reply.MakeMultiRawReply(results)
+	}
+	// rolled back transaction doesn't switch db
+	if _, aborted := result.(reply.ErrorReply); !aborted {
+		for _, tc := range cmds {
+			if tc.selectResult != nil && !reply.IsErrorReply(tc.selectResult) {
+				c.SelectDB(tc.dbIndex)
+			}
+		}
+	}
+	return result
+}
+
+// execMultiWithRollback restores all keys if any command returns error,
+// commands are persisted and counted only after all of them succeeded
+func execMultiWithRollback(cmds []*txCmd) resp.Reply {
+	results := make([]resp.Reply, 0, len(cmds))
+	undoLogs := make([]*txUndoLog, 0, len(cmds))
+	missing := make([]map[string]struct{}, len(cmds))
+	for i, tc := range cmds {
+		result, convert := tc.resolve()
+		if result == nil {
+			undoLogs = append(undoLogs, &txUndoLog{db: tc.db, cmdLines: tc.db.GetUndoLogs(tc.cmdLine)})
+			missing[i] = tc.db.missingWriterKeys(tc.cmd, tc.cmdLine)
+			result = tc.cmd.executor(tc.db, tc.cmdLine[1:])
+			if convert != nil {
+				result = convert(result)
+			}
+		}
+		if reply.IsErrorReply(result) {
+			execUndo(undoLogs)
+			return reply.MakeErrReply("EXECABORT Transaction rolled back because of error: " +
+				strings.TrimSpace(strings.TrimPrefix(string(result.ToBytes()), "-")))
+		}
+		results = append(results, result)
+	}
+	for i, tc := range cmds {
+		if tc.cmd != nil {
+			tc.db.afterExec(tc.cmd, tc.cmdLine, missing[i])
+		}
+	}
+	return reply.MakeMultiRawReply(results)
+}
+
+func (server *Server) isWatchingChanged(watching map[resp.WatchedKey]uint32) bool {
+	for watched, ver := range watching {
+		db, _ := server.selectDB(watched.DBIndex)
+		if db.GetVersion(watched.Key) != ver {
+			return true
+		}
+	}
+	return false
+}
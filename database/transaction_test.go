@@ -0,0 +1,218 @@
+package database
+
+import (
+	"ringodis/config"
+	"ringodis/lib/utils"
+	"ringodis/resp/conn"
+	"ringodis/resp/reply"
+	"ringodis/resp/reply/asserts"
+	"testing"
+)
+
+func TestMulti(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	c := conn.NewFakeConn()
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("multi")), "OK")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("multi")), "ERR MULTI calls can not be nested")
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("set", "a", "1")), "QUEUED")
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("get", "a")), "QUEUED")
+	result := server.Exec(c, utils.ToCmdLine("exec"))
+	multiRaw, ok := result.(*reply.MultiRawReply)
+	if !ok || len(multiRaw.Replies) != 2 {
+		t.Errorf("expected 2 replies, actual: %s", result.ToBytes())
+		return
+	}
+	asserts.AssertStatusReply(t, multiRaw.Replies[0], "OK")
+	asserts.AssertBulkReply(t, multiRaw.Replies[1], "1")
+	if c.InMultiState() {
+		t.Error("expected leaving multi state after exec")
+	}
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("exec")), "ERR EXEC without MULTI")
+}
+
+func TestDiscardAndAbort(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	c := conn.NewFakeConn()
+	server.Exec(c, utils.ToCmdLine("multi"))
+	server.Exec(c, utils.ToCmdLine("set", "a", "1"))
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("discard")), "OK")
+	asserts.AssertNullBulk(t, server.Exec(c, utils.ToCmdLine("get", "a")))
+
+	server.Exec(c, utils.ToCmdLine("multi"))
+	server.Exec(c, utils.ToCmdLine("set", "a", "1"))
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("get")), "ERR wrong number of arguments for 'get' command")
+	asserts.AssertErrReply(t, server.Exec(c, utils.ToCmdLine("exec")), "EXECABORT Transaction discarded because of previous errors.")
+	asserts.AssertNullBulk(t, server.Exec(c, utils.ToCmdLine("get", "a")))
+}
+
+func TestWatch(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	c1 := conn.NewFakeConn()
+	c2 := conn.NewFakeConn()
+	server.Exec(c1, utils.ToCmdLine("set", "a", "1"))
+	asserts.AssertStatusReply(t, server.Exec(c1, utils.ToCmdLine("watch", "a")), "OK")
+	server.Exec(c1, utils.ToCmdLine("multi"))
+	server.Exec(c1, utils.ToCmdLine("set", "a", "2"))
+	server.Exec(c2, utils.ToCmdLine("set", "a", "3"))
+	result := server.Exec(c1, utils.ToCmdLine("exec"))
+	if _, ok := result.(*reply.NullMultiBulkReply); !ok {
+		t.Errorf("expected null multi bulk, actual: %s", result.ToBytes())
+	}
+	asserts.AssertBulkReply(t, server.Exec(c1, utils.ToCmdLine("get", "a")), "3")
+
+	// watched key untouched
+	server.Exec(c1, utils.ToCmdLine("watch", "a"))
+	server.Exec(c1, utils.ToCmdLine("multi"))
+	server.Exec(c1, utils.ToCmdLine("set", "a", "2"))
+	asserts.AssertNotError(t, server.Exec(c1, utils.ToCmdLine("exec")))
+	asserts.AssertBulkReply(t, server.Exec(c1, utils.ToCmdLine("get", "a")), "2")
+
+	// unwatch forgets the key
+	server.Exec(c1, utils.ToCmdLine("watch", "a"))
+	server.Exec(c2, utils.ToCmdLine("set", "a", "4"))
+	server.Exec(c1, utils.ToCmdLine("unwatch"))
+	server.Exec(c1, utils.ToCmdLine("multi"))
+	server.Exec(c1, utils.ToCmdLine("set", "a", "5"))
+	asserts.AssertNotError(t, server.Exec(c1, utils.ToCmdLine("exec")))
+	asserts.AssertBulkReply(t, server.Exec(c1, utils.ToCmdLine("get", "a")), "5")
+}
+
+func TestRollback(t *testing.T) {
+	config.Properties.MultiRollback = true
+	defer func() {
+		config.Properties.MultiRollback = false
+	}()
+	server := MakeAuxiliaryServer()
+	c := conn.NewFakeConn()
+	server.Exec(c, utils.ToCmdLine("set", "a", "1", "ex", "1000"))
+	server.Exec(c, utils.ToCmdLine("set", "b", "2"))
+	server.Exec(c, utils.ToCmdLine("multi"))
+	server.Exec(c, utils.ToCmdLine("set", "a", "3"))
+	server.Exec(c, utils.ToCmdLine("del", "b"))
+	server.Exec(c, utils.ToCmdLine("rename", "a", "c"))
+	server.Exec(c, utils.ToCmdLine("set", "d", "4"))
+	server.Exec(c, utils.ToCmdLine("expire", "d", "not-a-number"))
+	result := server.Exec(c, utils.ToCmdLine("exec"))
+	asserts.AssertErrReply(t, result, "EXECABORT Transaction rolled back because of error: ERR value is not an integer or out of range")
+
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("get", "a")), "1")
+	asserts.AssertIntReplyGreaterThan(t, server.Exec(c, utils.ToCmdLine("ttl", "a")), 0)
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("get", "b")), "2")
+	asserts.AssertNullBulk(t, server.Exec(c, utils.ToCmdLine("get", "c")))
+	asserts.AssertNullBulk(t, server.Exec(c, utils.ToCmdLine("get", "d")))
+
+	server.Exec(c, utils.ToCmdLine("multi"))
+	server.Exec(c, utils.ToCmdLine("set", "a", "3"))
+	server.Exec(c, utils.ToCmdLine("flushdb"))
+	server.Exec(c, utils.ToCmdLine("rename", "a", "c"))
+	server.Exec(c, utils.ToCmdLine("exec"))
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("get", "a")), "1")
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("get", "b")), "2")
+}
+
+func TestMultiSelect(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	c := conn.NewFakeConn()
+	server.Exec(c, utils.ToCmdLine("multi"))
+	server.Exec(c, utils.ToCmdLine("set", "a", "0"))
+	asserts.AssertStatusReply(t, server.Exec(c, utils.ToCmdLine("select", "1")), "QUEUED")
+	server.Exec(c, utils.ToCmdLine("set", "a", "1"))
+	server.Exec(c, utils.ToCmdLine("select", "100"))
+	server.Exec(c, utils.ToCmdLine("get", "a"))
+	result := server.Exec(c, utils.ToCmdLine("exec"))
+	multiRaw, ok := result.(*reply.MultiRawReply)
+	if !ok || len(multiRaw.Replies) != 5 {
+		t.Errorf("expected 5 replies, actual: %s", result.ToBytes())
+		return
+	}
+	asserts.AssertStatusReply(t, multiRaw.Replies[1], "OK")
+	asserts.AssertErrReply(t, multiRaw.Replies[3], "ERR DB index is out of range")
+	asserts.AssertBulkReply(t, multiRaw.Replies[4], "1")
+	// client stays in the db selected in transaction
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("get", "a")), "1")
+	server.Exec(c, utils.ToCmdLine("select", "0"))
+	asserts.AssertBulkReply(t, server.Exec(c, utils.ToCmdLine("get", "a")), "0")
+}
+
+func TestWatchUnchangedKey(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	c1 := conn.NewFakeConn()
+	c2 := conn.NewFakeConn()
+	server.Exec(c1, utils.ToCmdLine("watch", "a"))
+	// deleting a missing key changes nothing
+	server.Exec(c2, utils.ToCmdLine("del", "a"))
+	server.Exec(c1, utils.ToCmdLine("multi"))
+	server.Exec(c1, utils.ToCmdLine("set", "a", "1"))
+	asserts.AssertNotError(t, server.Exec(c1, utils.ToCmdLine("exec")))
+	asserts.AssertBulkReply(t, server.Exec(c1, utils.ToCmdLine("get", "a")), "1")
+
+	server.Exec(c1, utils.ToCmdLine("watch", "a"))
+	server.Exec(c2, utils.ToCmdLine("del", "a"))
+	server.Exec(c1, utils.ToCmdLine("multi"))
+	server.Exec(c1, utils.ToCmdLine("set", "a", "2"))
+	result := server.Exec(c1, utils.ToCmdLine("exec"))
+	if _, ok := result.(*reply.NullMultiBulkReply); !ok {
+		t.Errorf("expected null multi bulk, actual: %s", result.ToBytes())
+	}
+}
+
+func TestWatchOtherDB(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	c1 := conn.NewFakeConn()
+	c2 := conn.NewFakeConn()
+	// key is watched in the db selected when WATCH is called
+	server.Exec(c1, utils.ToCmdLine("watch", "a"))
+	server.Exec(c1, utils.ToCmdLine("select", "1"))
+	server.Exec(c2, utils.ToCmdLine("select", "1"))
+	server.Exec(c2, utils.ToCmdLine("set", "a", "1"))
+	server.Exec(c1, utils.ToCmdLine("multi"))
+	server.Exec(c1, utils.ToCmdLine("set", "b", "1"))
+	asserts.AssertNotError(t, server.Exec(c1, utils.ToCmdLine("exec")))
+
+	server.Exec(c1, utils.ToCmdLine("select", "0"))
+	server.Exec(c1, utils.ToCmdLine("watch", "a"))
+	server.Exec(c1, utils.ToCmdLine("select", "1"))
+	server.Exec(c2, utils.ToCmdLine("select", "0"))
+	server.Exec(c2, utils.ToCmdLine("set", "a", "2"))
+	server.Exec(c1, utils.ToCmdLine("multi"))
+	server.Exec(c1, utils.ToCmdLine("set", "b", "2"))
+	result := server.Exec(c1, utils.ToCmdLine("exec"))
+	if _, ok := result.(*reply.NullMultiBulkReply); !ok {
+		t.Errorf("expected null multi bulk, actual: %s", result.ToBytes())
+	}
+	asserts.AssertBulkReply(t, server.Exec(c1, utils.ToCmdLine("get", "b")), "1")
+}
+
+func TestWatchReleased(t *testing.T) {
+	server := MakeAuxiliaryServer()
+	c := conn.NewFakeConn()
+	db, _ := server.selectDB(0)
+	countWatched := func() int {
+		return len(db.watches.watchedKeys())
+	}
+	// versions are kept for watched keys only
+	server.Exec(c, utils.ToCmdLine("set", "a", "1"))
+	if n := countWatched(); n != 0 {
+		t.Errorf("expected no watched key, actual %d", n)
+	}
+	server.Exec(c, utils.ToCmdLine("watch", "a", "b"))
+	if n := countWatched(); n != 2 {
+		t.Errorf("expected 2 watched keys, actual %d", n)
+	}
+	server.Exec(c, utils.ToCmdLine("multi"))
+	server.Exec(c, utils.ToCmdLine("exec"))
+	if n := countWatched(); n != 0 {
+		t.Errorf("expected no watched key after exec, actual %d", n)
+	}
+
+	server.Exec(c, utils.ToCmdLine("watch", "a"))
+	server.Exec(c, utils.ToCmdLine("unwatch"))
+	server.Exec(c, utils.ToCmdLine("watch", "a"))
+	server.Exec(c, utils.ToCmdLine("multi"))
+	server.Exec(c, utils.ToCmdLine("discard"))
+	server.Exec(c, utils.ToCmdLine("watch", "a"))
+	server.AfterClientClose(c)
+	if n := countWatched(); n != 0 {
+		t.Errorf("expected no watched key, actual %d", n)
+	}
+}
@@ -26,6 +26,10 @@ func writeAllKeys(args CmdArgs) ([]string, []string) {
 	return keys, nil
 }
 
+func writeFirstTwoKeys(args CmdArgs) ([]string, []string) {
+	return []string{string(args[0]), string(args[1])}, nil
+}
+
 func noPrepare(args CmdArgs) ([]string, []string) {
 	return nil, nil
 }
\ No newline at end of file
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"ringodis/aof"
+	"ringodis/interface/database"
+	"ringodis/lib/utils"
+	"strings"
+	"time"
+)
+
+// UndoFunc returns undo logs of a command, which are command lines restoring
+// the affected keys to the state before the command is executed
+// it's invoked before execution, and related keys must be locked by caller
+type UndoFunc func(db *DB, args CmdArgs) []CmdLine
+
+// rollbackGivenKeys snapshots entities and ttl of the given keys
+func rollbackGivenKeys(db *DB, keys ...string) []CmdLine {
+	var undoCmdLines []CmdLine
+	for _, key := range keys {
+		entity, ok := db.GetEntity(key)
+		// the key will be deleted first, then rebuilt if it exists
+		undoCmdLines = append(undoCmdLines, utils.ToCmdLine("DEL", key))
+		if !ok {
+			continue
+		}
+		undoCmdLines = append(undoCmdLines, snapshotEntity(db, key, entity)...)
+	}
+	return undoCmdLines
+}
+
+// snapshotEntity generates command lines rebuilding the given entity and its ttl
+func snapshotEntity(db *DB, key string, entity *database.DataEntity) []CmdLine {
+	var cmdLines []CmdLine
+	for _, cmd := range aof.EntityToCmds(key, entity) {
+		cmdLines = append(cmdLines, cmd.Args)
+	}
+	if raw, ok := db.ttlMap.Get(key); ok {
+		expireTime, _ := raw.(time.Time)
+		cmdLines = append(cmdLines, aof.MakeExpireCmd(key, expireTime).Args)
+	}
+	return cmdLines
+}
+
+func rollbackFirstKey(db *DB, args CmdArgs) []CmdLine {
+	key := string(args[0])
+	return rollbackGivenKeys(db, key)
+}
+
+func rollbackAllKeys(db *DB, args CmdArgs) []CmdLine {
+	keys := make([]string, len(args))
+	for i, v := range args {
+		keys[i] = string(v)
+	}
+	return rollbackGivenKeys(db, keys...)
+}
+
+func rollbackFirstTwoKeys(db *DB, args CmdArgs) []CmdLine {
+	return rollbackGivenKeys(db, string(args[0]), string(args[1]))
+}
+
+// GetUndoLogs returns undo logs of the given command line, nil if the command doesn't modify data
+func (db *DB) GetUndoLogs(cmdLine CmdLine) []CmdLine {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	// blocking commands are executed without blocking in transaction, see txBlockingCommands
+	if blockingCmd, ok := txBlockingCommands[cmdName]; ok {
+		if !validateArity(blockingCmd.arity, cmdLine) {
+			return nil
+		}
+		writerKeys, _ := blockingPrepares[cmdName](cmdLine[1:])
+		return rollbackGivenKeys(db, writerKeys...)
+	}
+	cmd, ok := cmdTable[cmdName]
+	if !ok || cmd.undo == nil {
+		return nil
+	}
+	return cmd.undo(db, cmdLine[1:])
+}
+
+// txUndoLog is undo logs of a command in transaction with the db it's executed in
+type txUndoLog struct {
+	db       *DB
+	cmdLines []CmdLine
+}
+
+// execUndo executes undo logs in reverse order of commands,
+// undo logs bypass aof and versions since related commands are not committed
+func execUndo(undoLogs []*txUndoLog) {
+	for i := len(undoLogs) - 1; i >= 0; i-- {
+		for _, cmdLine := range undoLogs[i].cmdLines {
+			cmd := cmdTable[strings.ToLower(string(cmdLine[0]))]
+			cmd.executor(undoLogs[i].db, cmdLine[1:])
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package dict
+
+// SimpleDict wraps a map, it is not thread safe
+// used for values of hash keys, which are protected by key locks of db
+type SimpleDict struct {
+	m map[string]interface{}
+}
+
+func MakeSimple() *SimpleDict {
+	return &SimpleDict{
+		m: make(map[string]interface{}),
+	}
+}
+
+func (d *SimpleDict) Get(key string) (val interface{}, exists bool) {
+	val, exists = d.m[key]
+	return
+}
+
+func (d *SimpleDict) Len() int {
+	if d.m == nil {
+		panic("m is nil")
+	}
+	return len(d.m)
+}
+
+func (d *SimpleDict) Put(key string, val interface{}) (result int) {
+	_, existed := d.m[key]
+	d.m[key] = val
+	if existed {
+		return 0
+	}
+	return 1
+}
+
+func (d *SimpleDict) PutIfAbsent(key string, val interface{}) (result int) {
+	if _, existed := d.m[key]; existed {
+		return 0
+	}
+	d.m[key] = val
+	return 1
+}
+
+func (d *SimpleDict) PutIfExists(key string, val interface{}) (result int) {
+	if _, existed := d.m[key]; existed {
+		d.m[key] = val
+		return 1
+	}
+	return 0
+}
+
+func (d *SimpleDict) Remove(key string) (result int) {
+	if _, existed := d.m[key]; existed {
+		delete(d.m, key)
+		return 1
+	}
+	return 0
+}
+
+func (d *SimpleDict) ForEach(consumer Consumer) {
+	for k, v := range d.m {
+		if !consumer(k, v) {
+			break
+		}
+	}
+}
+
+func (d *SimpleDict) Keys() []string {
+	keys := make([]string, 0, len(d.m))
+	for k := range d.m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// RandomKeys randomly returns keys of the given number, may contain duplicated key
+func (d *SimpleDict) RandomKeys(limit int) []string {
+	if limit <= 0 || len(d.m) == 0 {
+		return nil
+	}
+	keys := make([]string, limit)
+	for i := 0; i < limit; i++ {
+		// iteration order of map is random
+		for k := range d.m {
+			keys[i] = k
+			break
+		}
+	}
+	return keys
+}
+
+// RandomDistinctKeys randomly returns keys of the given number, won't contain duplicated key
+func (d *SimpleDict) RandomDistinctKeys(limit int) []string {
+	size := limit
+	if size > len(d.m) {
+		size = len(d.m)
+	}
+	keys := make([]string, 0, size)
+	for k := range d.m {
+		if len(keys) == size {
+			break
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func (d *SimpleDict) Clear() {
+	*d = *MakeSimple()
+}
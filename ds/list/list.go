@@ -0,0 +1,24 @@
+package list
+
+// Expected checks whether the given item is equal to expected value
+type Expected func(a interface{}) bool
+
+// Consumer traverses list, it receives index and value, and returns true to continue traversal
+type Consumer func(i int, v interface{}) bool
+
+// List is interface of list data structure
+type List interface {
+	Add(val interface{})
+	Get(index int) (val interface{})
+	Set(index int, val interface{})
+	Insert(index int, val interface{})
+	Remove(index int) (val interface{})
+	RemoveLast() (val interface{})
+	RemoveAllByVal(expected Expected) int
+	RemoveByVal(expected Expected, count int) int
+	ReverseRemoveByVal(expected Expected, count int) int
+	Len() int
+	ForEach(consumer Consumer)
+	Contains(expected Expected) bool
+	Range(start int, stop int) []interface{}
+}
@@ -0,0 +1,346 @@
+package list
+
+import "container/list"
+
+// pageSize must be even
+const pageSize = 1024
+
+// QuickList is a linked list of pages, every page is an array of at most pageSize values
+// it costs less memory than a linked list of values, and inserting is cheaper than a single array
+type QuickList struct {
+	data *list.List // every element is a page: []interface{}
+	size int
+}
+
+// iterator points to an element of QuickList
+type iterator struct {
+	node   *list.Element
+	offset int
+	ql     *QuickList
+}
+
+func NewQuickList() *QuickList {
+	return &QuickList{
+		data: list.New(),
+	}
+}
+
+func newPage() []interface{} {
+	return make([]interface{}, 0, pageSize)
+}
+
+// Add adds value to the tail
+func (ql *QuickList) Add(val interface{}) {
+	ql.size++
+	if ql.data.Len() == 0 {
+		ql.data.PushBack(append(newPage(), val))
+		return
+	}
+	backNode := ql.data.Back()
+	backPage := backNode.Value.([]interface{})
+	if len(backPage) == cap(backPage) {
+		ql.data.PushBack(append(newPage(), val))
+		return
+	}
+	backNode.Value = append(backPage, val)
+}
+
+// find returns iterator points to the element of the given index
+func (ql *QuickList) find(index int) *iterator {
+	if index < 0 || index >= ql.size {
+		panic("index out of bound")
+	}
+	var n *list.Element
+	var page []interface{}
+	var pageBeg int
+	if index < ql.size/2 {
+		// search from front
+		n = ql.data.Front()
+		pageBeg = 0
+		for {
+			page = n.Value.([]interface{})
+			if pageBeg+len(page) > index {
+				break
+			}
+			pageBeg += len(page)
+			n = n.Next()
+		}
+	} else {
+		// search from back
+		n = ql.data.Back()
+		pageBeg = ql.size
+		for {
+			page = n.Value.([]interface{})
+			pageBeg -= len(page)
+			if pageBeg <= index {
+				break
+			}
+			n = n.Prev()
+		}
+	}
+	return &iterator{
+		node:   n,
+		offset: index - pageBeg,
+		ql:     ql,
+	}
+}
+
+func (iter *iterator) page() []interface{} {
+	return iter.node.Value.([]interface{})
+}
+
+func (iter *iterator) get() interface{} {
+	return iter.page()[iter.offset]
+}
+
+func (iter *iterator) set(val interface{}) {
+	iter.page()[iter.offset] = val
+}
+
+// next moves iterator to the next element, returns false if it reaches the end
+func (iter *iterator) next() bool {
+	page := iter.page()
+	if iter.offset < len(page)-1 {
+		iter.offset++
+		return true
+	}
+	if iter.node == iter.ql.data.Back() {
+		// point to the position after the last element
+		iter.offset = len(page)
+		return false
+	}
+	iter.node = iter.node.Next()
+	iter.offset = 0
+	return true
+}
+
+// prev moves iterator to the previous element, returns false if it reaches the beginning
+func (iter *iterator) prev() bool {
+	if iter.offset > 0 {
+		iter.offset--
+		return true
+	}
+	if iter.node == iter.ql.data.Front() {
+		// point to the position before the first element
+		iter.offset = -1
+		return false
+	}
+	iter.node = iter.node.Prev()
+	iter.offset = len(iter.page()) - 1
+	return true
+}
+
+func (iter *iterator) atEnd() bool {
+	if iter.ql.data.Len() == 0 {
+		return true
+	}
+	if iter.node != iter.ql.data.Back() {
+		return false
+	}
+	return iter.offset == len(iter.page())
+}
+
+func (iter *iterator) atBegin() bool {
+	if iter.ql.data.Len() == 0 {
+		return true
+	}
+	if iter.node != iter.ql.data.Front() {
+		return false
+	}
+	return iter.offset == -1
+}
+
+// remove removes the current element and moves iterator to the next one
+func (iter *iterator) remove() interface{} {
+	page := iter.page()
+	val := page[iter.offset]
+	copy(page[iter.offset:], page[iter.offset+1:])
+	page[len(page)-1] = nil // release reference of the moved element
+	page = page[:len(page)-1]
+	iter.ql.size--
+	if len(page) > 0 {
+		iter.node.Value = page
+		if iter.offset == len(page) && iter.node != iter.ql.data.Back() {
+			// removed the last element of page, move to the next page
+			iter.node = iter.node.Next()
+			iter.offset = 0
+		}
+		return val
+	}
+	// page is empty, remove it
+	next := iter.node.Next()
+	iter.ql.data.Remove(iter.node)
+	if next != nil {
+		iter.node = next
+		iter.offset = 0
+	} else if back := iter.ql.data.Back(); back != nil {
+		// removed the last page, point to the end
+		iter.node = back
+		iter.offset = len(iter.page())
+	} else {
+		iter.node = nil
+		iter.offset = 0
+	}
+	return val
+}
+
+// Get returns value of the given index
+func (ql *QuickList) Get(index int) (val interface{}) {
+	return ql.find(index).get()
+}
+
+// Set updates value of the given index
+func (ql *QuickList) Set(index int, val interface{}) {
+	ql.find(index).set(val)
+}
+
+// Insert inserts value at the given index, the original element at the index will move backward
+func (ql *QuickList) Insert(index int, val interface{}) {
+	if index == ql.size {
+		ql.Add(val)
+		return
+	}
+	iter := ql.find(index)
+	page := iter.page()
+	if len(page) < pageSize {
+		iter.node.Value = insertIntoPage(page, iter.offset, val)
+		ql.size++
+		return
+	}
+	if index == 0 {
+		// pushing to head, start a new page instead of splitting a full one
+		ql.data.PushFront(append(newPage(), val))
+		ql.size++
+		return
+	}
+	// split the full page into two pages
+	nextPage := append(newPage(), page[pageSize/2:]...)
+	for i := pageSize / 2; i < pageSize; i++ {
+		page[i] = nil
+	}
+	page = page[:pageSize/2]
+	if iter.offset < len(page) {
+		page = insertIntoPage(page, iter.offset, val)
+	} else {
+		nextPage = insertIntoPage(nextPage, iter.offset-pageSize/2, val)
+	}
+	iter.node.Value = page
+	ql.data.InsertAfter(nextPage, iter.node)
+	ql.size++
+}
+
+func insertIntoPage(page []interface{}, offset int, val interface{}) []interface{} {
+	page = append(page, nil)
+	copy(page[offset+1:], page[offset:])
+	page[offset] = val
+	return page
+}
+
+// Remove removes value of the given index and returns it
+func (ql *QuickList) Remove(index int) interface{} {
+	return ql.find(index).remove()
+}
+
+// RemoveLast removes the last element and returns its value, returns nil if list is empty
+func (ql *QuickList) RemoveLast() interface{} {
+	if ql.size == 0 {
+		return nil
+	}
+	return ql.find(ql.size - 1).remove()
+}
+
+// RemoveAllByVal removes all elements matching expected, returns the number of removed elements
+func (ql *QuickList) RemoveAllByVal(expected Expected) int {
+	return ql.RemoveByVal(expected, 0)
+}
+
+// RemoveByVal removes at most count elements matching expected from head to tail,
+// count <= 0 means no limit
+func (ql *QuickList) RemoveByVal(expected Expected, count int) int {
+	if ql.size == 0 {
+		return 0
+	}
+	iter := ql.find(0)
+	removed := 0
+	for !iter.atEnd() {
+		if !expected(iter.get()) {
+			iter.next()
+			continue
+		}
+		iter.remove()
+		removed++
+		if removed == count {
+			break
+		}
+	}
+	return removed
+}
+
+// ReverseRemoveByVal removes at most count elements matching expected from tail to head,
+// count <= 0 means no limit
+func (ql *QuickList) ReverseRemoveByVal(expected Expected, count int) int {
+	if ql.size == 0 {
+		return 0
+	}
+	iter := ql.find(ql.size - 1)
+	removed := 0
+	for !iter.atBegin() {
+		if expected(iter.get()) {
+			iter.remove()
+			removed++
+			if removed == count || ql.size == 0 {
+				break
+			}
+		}
+		iter.prev()
+	}
+	return removed
+}
+
+// Len returns number of elements
+func (ql *QuickList) Len() int {
+	return ql.size
+}
+
+// ForEach visits each element in the list from head to tail
+func (ql *QuickList) ForEach(consumer Consumer) {
+	i := 0
+	for n := ql.data.Front(); n != nil; n = n.Next() {
+		for _, v := range n.Value.([]interface{}) {
+			if !consumer(i, v) {
+				return
+			}
+			i++
+		}
+	}
+}
+
+// Contains returns whether any element matches expected
+func (ql *QuickList) Contains(expected Expected) bool {
+	contains := false
+	ql.ForEach(func(i int, v interface{}) bool {
+		if expected(v) {
+			contains = true
+			return false
+		}
+		return true
+	})
+	return contains
+}
+
+// Range returns elements whose index within [start, stop)
+func (ql *QuickList) Range(start int, stop int) []interface{} {
+	if start < 0 || start >= ql.size {
+		panic("`start` out of range")
+	}
+	if stop < start || stop > ql.size {
+		panic("`stop` out of range")
+	}
+	result := make([]interface{}, 0, stop-start)
+	iter := ql.find(start)
+	for i := start; i < stop; i++ {
+		result = append(result, iter.get())
+		iter.next()
+	}
+	return result
+}
@@ -0,0 +1,95 @@
+package list
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// toSlice collects all elements of list for comparison
+func toSlice(ql *QuickList) []interface{} {
+	result := make([]interface{}, 0, ql.Len())
+	ql.ForEach(func(i int, v interface{}) bool {
+		result = append(result, v)
+		return true
+	})
+	return result
+}
+
+func TestQuickList(t *testing.T) {
+	ql := NewQuickList()
+	expected := make([]interface{}, 0)
+	for i := 0; i < 20000; i++ {
+		switch op := rand.Intn(10); {
+		case op < 3:
+			ql.Add(i)
+			expected = append(expected, i)
+		case op < 6:
+			index := rand.Intn(len(expected) + 1)
+			ql.Insert(index, i)
+			expected = append(expected, nil)
+			copy(expected[index+1:], expected[index:])
+			expected[index] = i
+		case op < 8 && len(expected) > 0:
+			index := rand.Intn(len(expected))
+			assert.Equal(t, expected[index], ql.Remove(index))
+			expected = append(expected[:index], expected[index+1:]...)
+		case op < 9 && len(expected) > 0:
+			index := rand.Intn(len(expected))
+			ql.Set(index, -i)
+			expected[index] = -i
+		case len(expected) > 0:
+			assert.Equal(t, expected[len(expected)-1], ql.RemoveLast())
+			expected = expected[:len(expected)-1]
+		}
+		if ql.Len() != len(expected) {
+			t.Fatalf("expected len %d, actual %d", len(expected), ql.Len())
+		}
+	}
+	assert.Equal(t, expected, toSlice(ql))
+	for i := 0; i < 100; i++ {
+		start := rand.Intn(len(expected))
+		stop := start + rand.Intn(len(expected)-start+1)
+		assert.Equal(t, expected[start:stop], ql.Range(start, stop))
+		index := rand.Intn(len(expected))
+		assert.Equal(t, expected[index], ql.Get(index))
+	}
+}
+
+func TestPushFront(t *testing.T) {
+	ql := NewQuickList()
+	for i := 0; i < pageSize*3; i++ {
+		ql.Insert(0, i)
+	}
+	assert.Equal(t, 3, ql.data.Len())
+	assert.Equal(t, pageSize*3-1, ql.Get(0))
+	assert.Equal(t, 0, ql.Get(pageSize*3-1))
+}
+
+func TestRemoveByVal(t *testing.T) {
+	ql := NewQuickList()
+	for i := 0; i < pageSize*3; i++ {
+		ql.Add(i % 4)
+	}
+	isZero := func(a interface{}) bool {
+		return a == 0
+	}
+	assert.Equal(t, 10, ql.RemoveByVal(isZero, 10))
+	assert.Equal(t, 0, ql.Get(10*3))
+	assert.Equal(t, 1, ql.Get(0))
+
+	assert.Equal(t, 10, ql.ReverseRemoveByVal(isZero, 10))
+	assert.Equal(t, 3, ql.Get(ql.Len()-1))
+	assert.Equal(t, 0, ql.Get(ql.Len()-34))
+	assert.Equal(t, pageSize*3/4-20, ql.RemoveAllByVal(isZero))
+	assert.False(t, ql.Contains(isZero))
+
+	isAny := func(a interface{}) bool {
+		return true
+	}
+	assert.Equal(t, ql.Len(), ql.ReverseRemoveByVal(isAny, 0))
+	assert.Equal(t, 0, ql.Len())
+	assert.Equal(t, 0, ql.data.Len())
+	assert.Nil(t, ql.RemoveLast())
+}
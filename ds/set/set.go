@@ -0,0 +1,241 @@
+package set
+
+import (
+	"math/rand"
+	"ringodis/ds/dict"
+	"sort"
+	"strconv"
+)
+
+// maxIntSetSize is the max number of members encoded as intset, like set-max-intset-entries of redis
+const maxIntSetSize = 512
+
+// Set is a set of strings
+// members are stored in a sorted integer array while all of them are integers and the set is small,
+// it upgrades to a hash set when a non-integer member arrives or the set grows too large
+type Set struct {
+	intSet []int64
+	dict   dict.Dict
+}
+
+// Make creates a new set with the given members
+func Make(members ...string) *Set {
+	set := &Set{}
+	for _, member := range members {
+		set.Add(member)
+	}
+	return set
+}
+
+// toInt returns the integer value of member, only if member is the canonical representation of an integer
+func toInt(member string) (int64, bool) {
+	v, err := strconv.ParseInt(member, 10, 64)
+	if err != nil || strconv.FormatInt(v, 10) != member {
+		return 0, false
+	}
+	return v, true
+}
+
+// IsIntSet tells whether the set is encoded as intset
+func (set *Set) IsIntSet() bool {
+	return set.dict == nil
+}
+
+// search returns the position of v in intset, and whether v exists
+func (set *Set) search(v int64) (int, bool) {
+	i := sort.Search(len(set.intSet), func(i int) bool {
+		return set.intSet[i] >= v
+	})
+	return i, i < len(set.intSet) && set.intSet[i] == v
+}
+
+// upgrade converts intset to hash set
+func (set *Set) upgrade() {
+	set.dict = dict.MakeSimple()
+	for _, v := range set.intSet {
+		set.dict.Put(strconv.FormatInt(v, 10), nil)
+	}
+	set.intSet = nil
+}
+
+// Add adds member into set, returns 1 if it's a new member
+func (set *Set) Add(member string) int {
+	if set.IsIntSet() {
+		v, ok := toInt(member)
+		if ok {
+			i, exists := set.search(v)
+			if exists {
+				return 0
+			}
+			if len(set.intSet) < maxIntSetSize {
+				set.intSet = append(set.intSet, 0)
+				copy(set.intSet[i+1:], set.intSet[i:])
+				set.intSet[i] = v
+				return 1
+			}
+		}
+		set.upgrade()
+	}
+	return set.dict.Put(member, nil)
+}
+
+// Remove removes member from set, returns 1 if it existed
+func (set *Set) Remove(member string) int {
+	if set.IsIntSet() {
+		v, ok := toInt(member)
+		if !ok {
+			return 0
+		}
+		i, exists := set.search(v)
+		if !exists {
+			return 0
+		}
+		set.intSet = append(set.intSet[:i], set.intSet[i+1:]...)
+		return 1
+	}
+	return set.dict.Remove(member)
+}
+
+// Has tells whether member is in set
+func (set *Set) Has(member string) bool {
+	if set == nil {
+		return false
+	}
+	if set.IsIntSet() {
+		v, ok := toInt(member)
+		if !ok {
+			return false
+		}
+		_, exists := set.search(v)
+		return exists
+	}
+	_, exists := set.dict.Get(member)
+	return exists
+}
+
+// Len returns number of members
+func (set *Set) Len() int {
+	if set == nil {
+		return 0
+	}
+	if set.IsIntSet() {
+		return len(set.intSet)
+	}
+	return set.dict.Len()
+}
+
+// ForEach visits each member, stops if consumer returns false
+func (set *Set) ForEach(consumer func(member string) bool) {
+	if set == nil {
+		return
+	}
+	if set.IsIntSet() {
+		for _, v := range set.intSet {
+			if !consumer(strconv.FormatInt(v, 10)) {
+				return
+			}
+		}
+		return
+	}
+	set.dict.ForEach(func(key string, val interface{}) bool {
+		return consumer(key)
+	})
+}
+
+// ToSlice returns all members
+func (set *Set) ToSlice() []string {
+	members := make([]string, 0, set.Len())
+	set.ForEach(func(member string) bool {
+		members = append(members, member)
+		return true
+	})
+	return members
+}
+
+// RandomMembers randomly returns members of the given number, may contain duplicated members
+func (set *Set) RandomMembers(limit int) []string {
+	if set.IsIntSet() {
+		if limit <= 0 || len(set.intSet) == 0 {
+			return nil
+		}
+		members := make([]string, limit)
+		for i := range members {
+			members[i] = strconv.FormatInt(set.intSet[rand.Intn(len(set.intSet))], 10)
+		}
+		return members
+	}
+	return set.dict.RandomKeys(limit)
+}
+
+// RandomDistinctMembers randomly returns members of the given number, won't contain duplicated members
+func (set *Set) RandomDistinctMembers(limit int) []string {
+	if set.IsIntSet() {
+		if limit > len(set.intSet) {
+			limit = len(set.intSet)
+		}
+		if limit <= 0 {
+			return nil
+		}
+		members := make([]string, limit)
+		for i, pos := range rand.Perm(len(set.intSet))[:limit] {
+			members[i] = strconv.FormatInt(set.intSet[pos], 10)
+		}
+		return members
+	}
+	return set.dict.RandomDistinctKeys(limit)
+}
+
+// Intersect returns members existing in all the given sets, nil set is treated as empty
+func Intersect(sets ...*Set) *Set {
+	result := Make()
+	if len(sets) == 0 {
+		return result
+	}
+	// iterate the smallest set
+	smallest := sets[0]
+	for _, set := range sets[1:] {
+		if set.Len() < smallest.Len() {
+			smallest = set
+		}
+	}
+	smallest.ForEach(func(member string) bool {
+		for _, set := range sets {
+			if set != smallest && !set.Has(member) {
+				return true
+			}
+		}
+		result.Add(member)
+		return true
+	})
+	return result
+}
+
+// Union returns members existing in any of the given sets
+func Union(sets ...*Set) *Set {
+	result := Make()
+	for _, set := range sets {
+		set.ForEach(func(member string) bool {
+			result.Add(member)
+			return true
+		})
+	}
+	return result
+}
+
+// Diff returns members of the first set which don't exist in other sets
+func Diff(sets ...*Set) *Set {
+	result := Make()
+	if len(sets) == 0 {
+		return result
+	}
+	sets[0].ForEach(func(member string) bool {
+		for _, set := range sets[1:] {
+			if set.Has(member) {
+				return true
+			}
+		}
+		result.Add(member)
+		return true
+	})
+	return result
+}
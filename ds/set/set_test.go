@@ -0,0 +1,56 @@
+package set
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIntSet(t *testing.T) {
+	set := Make("3", "1", "2", "1")
+	assert.True(t, set.IsIntSet())
+	assert.Equal(t, 3, set.Len())
+	assert.Equal(t, []string{"1", "2", "3"}, set.ToSlice())
+	assert.True(t, set.Has("2"))
+	assert.False(t, set.Has("02"))
+	assert.Equal(t, 0, set.Remove("4"))
+	assert.Equal(t, 1, set.Remove("2"))
+	assert.Equal(t, 2, len(set.RandomDistinctMembers(5)))
+	assert.Equal(t, 5, len(set.RandomMembers(5)))
+
+	// non-canonical integer upgrades set
+	set.Add("02")
+	assert.False(t, set.IsIntSet())
+	assert.True(t, set.Has("1"))
+	assert.True(t, set.Has("02"))
+	assert.Equal(t, 3, set.Len())
+}
+
+func TestUpgradeBySize(t *testing.T) {
+	set := Make()
+	for i := 0; i < maxIntSetSize; i++ {
+		set.Add(strconv.Itoa(i))
+	}
+	assert.True(t, set.IsIntSet())
+	set.Add(strconv.Itoa(maxIntSetSize))
+	assert.False(t, set.IsIntSet())
+	assert.Equal(t, maxIntSetSize+1, set.Len())
+	assert.True(t, set.Has("0"))
+}
+
+func TestSetOperations(t *testing.T) {
+	a := Make("1", "2", "a", "b")
+	b := Make("2", "b", "c")
+	sorted := func(set *Set) []string {
+		members := set.ToSlice()
+		sort.Strings(members)
+		return members
+	}
+	assert.Equal(t, []string{"2", "b"}, sorted(Intersect(a, b)))
+	assert.Equal(t, []string{"1", "2", "a", "b", "c"}, sorted(Union(a, b)))
+	assert.Equal(t, []string{"1", "a"}, sorted(Diff(a, b)))
+	assert.Equal(t, 0, Intersect(a, nil).Len())
+	assert.Equal(t, 4, Diff(a, nil).Len())
+}
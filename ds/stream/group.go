@@ -0,0 +1,179 @@
+package stream
+
+import (
+	"sort"
+	"time"
+)
+
+// PendingEntry is an entry delivered to consumer but not acknowledged yet
+type PendingEntry struct {
+	ID            ID
+	Consumer      string
+	DeliveryTime  time.Time
+	DeliveryCount int64
+}
+
+// Consumer is a member of consumer group
+type Consumer struct {
+	Name     string
+	SeenTime time.Time
+	// pending entries owned by the consumer
+	Pending map[ID]*PendingEntry
+}
+
+// Group is a consumer group of stream
+type Group struct {
+	Name string
+	// LastID is the id of last entry delivered to consumers of group
+	LastID ID
+	// Pending is the pending entries list (PEL) of group
+	Pending   map[ID]*PendingEntry
+	Consumers map[string]*Consumer
+}
+
+// CreateGroup creates a consumer group, returns false if it already exists
+func (s *Stream) CreateGroup(name string, lastID ID) (*Group, bool) {
+	if _, ok := s.groups[name]; ok {
+		return nil, false
+	}
+	group := &Group{
+		Name:      name,
+		LastID:    lastID,
+		Pending:   make(map[ID]*PendingEntry),
+		Consumers: make(map[string]*Consumer),
+	}
+	s.groups[name] = group
+	return group, true
+}
+
+// GetGroup returns consumer group of the given name, nil if not exists
+func (s *Stream) GetGroup(name string) *Group {
+	return s.groups[name]
+}
+
+// DestroyGroup removes the consumer group and its pending entries
+func (s *Stream) DestroyGroup(name string) bool {
+	if _, ok := s.groups[name]; !ok {
+		return false
+	}
+	delete(s.groups, name)
+	return true
+}
+
+// Groups returns all consumer groups sorted by name
+func (s *Stream) Groups() []*Group {
+	groups := make([]*Group, 0, len(s.groups))
+	for _, group := range s.groups {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
+
+// GetConsumer returns consumer of the given name, nil if not exists
+func (g *Group) GetConsumer(name string) *Consumer {
+	return g.Consumers[name]
+}
+
+// GetOrCreateConsumer returns consumer of the given name, creates it if not exists
+func (g *Group) GetOrCreateConsumer(name string, now time.Time) (consumer *Consumer, created bool) {
+	if consumer, ok := g.Consumers[name]; ok {
+		return consumer, false
+	}
+	consumer = &Consumer{
+		Name:     name,
+		SeenTime: now,
+		Pending:  make(map[ID]*PendingEntry),
+	}
+	g.Consumers[name] = consumer
+	return consumer, true
+}
+
+// DeleteConsumer removes consumer and its pending entries, returns number of removed pending entries
+func (g *Group) DeleteConsumer(name string) (int64, bool) {
+	consumer, ok := g.Consumers[name]
+	if !ok {
+		return 0, false
+	}
+	for id := range consumer.Pending {
+		delete(g.Pending, id)
+	}
+	delete(g.Consumers, name)
+	return int64(len(consumer.Pending)), true
+}
+
+// Deliver records that entry has been delivered to consumer
+func (g *Group) Deliver(id ID, consumer *Consumer, now time.Time) {
+	pending := g.Claim(id, consumer)
+	pending.DeliveryTime = now
+	pending.DeliveryCount++
+}
+
+// assign moves pending entry to consumer
+func (g *Group) assign(pending *PendingEntry, consumer *Consumer) {
+	if owner, ok := g.Consumers[pending.Consumer]; ok && owner != consumer {
+		delete(owner.Pending, pending.ID)
+	}
+	pending.Consumer = consumer.Name
+	consumer.Pending[pending.ID] = pending
+}
+
+// Claim moves pending entry to consumer, creates the pending entry if not exists
+func (g *Group) Claim(id ID, consumer *Consumer) *PendingEntry {
+	pending, ok := g.Pending[id]
+	if !ok {
+		pending = &PendingEntry{ID: id}
+		g.Pending[id] = pending
+	}
+	g.assign(pending, consumer)
+	return pending
+}
+
+// Ack removes pending entry, returns false if it's not pending
+func (g *Group) Ack(id ID) bool {
+	pending, ok := g.Pending[id]
+	if !ok {
+		return false
+	}
+	delete(g.Pending, id)
+	if owner, ok := g.Consumers[pending.Consumer]; ok {
+		delete(owner.Pending, id)
+	}
+	return true
+}
+
+// sortPending returns pending entries sorted by id
+func sortPending(pel map[ID]*PendingEntry) []*PendingEntry {
+	result := make([]*PendingEntry, 0, len(pel))
+	for _, pending := range pel {
+		result = append(result, pending)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID.Less(result[j].ID)
+	})
+	return result
+}
+
+// PendingEntries returns pending entries of group sorted by id
+func (g *Group) PendingEntries() []*PendingEntry {
+	return sortPending(g.Pending)
+}
+
+// PendingEntries returns pending entries of consumer sorted by id
+func (c *Consumer) PendingEntries() []*PendingEntry {
+	return sortPending(c.Pending)
+}
+
+// SortedConsumers returns consumers sorted by name
+func (g *Group) SortedConsumers() []*Consumer {
+	consumers := make([]*Consumer, 0, len(g.Consumers))
+	for _, consumer := range g.Consumers {
+		consumers = append(consumers, consumer)
+	}
+	sort.Slice(consumers, func(i, j int) bool {
+		return consumers[i].Name < consumers[j].Name
+	})
+	return consumers
+}
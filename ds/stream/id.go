@@ -0,0 +1,71 @@
+package stream
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ID identifies an entry of stream, it consists of milliseconds time and sequence number
+type ID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+var (
+	// MinID is the smallest possible id, it's never used by entries
+	MinID = ID{}
+	// MaxID is the largest possible id
+	MaxID = ID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+
+	errInvalidID = errors.New("ERR Invalid stream ID specified as stream command argument")
+)
+
+func (id ID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Less tells whether id is smaller than other
+func (id ID) Less(other ID) bool {
+	return id.Ms < other.Ms || (id.Ms == other.Ms && id.Seq < other.Seq)
+}
+
+// Incr returns the next id, returns false if id is MaxID
+func (id ID) Incr() (ID, bool) {
+	if id.Seq < math.MaxUint64 {
+		return ID{Ms: id.Ms, Seq: id.Seq + 1}, true
+	}
+	if id.Ms < math.MaxUint64 {
+		return ID{Ms: id.Ms + 1}, true
+	}
+	return id, false
+}
+
+// Decr returns the previous id, returns false if id is MinID
+func (id ID) Decr() (ID, bool) {
+	if id.Seq > 0 {
+		return ID{Ms: id.Ms, Seq: id.Seq - 1}, true
+	}
+	if id.Ms > 0 {
+		return ID{Ms: id.Ms - 1, Seq: math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// ParseID parses id like 1526919030474-55, sequence is missingSeq if omitted
+func ParseID(s string, missingSeq uint64) (ID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return ID{}, errInvalidID
+	}
+	if !hasSeq {
+		return ID{Ms: ms, Seq: missingSeq}, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return ID{}, errInvalidID
+	}
+	return ID{Ms: ms, Seq: seq}, nil
+}
@@ -0,0 +1,228 @@
+package stream
+
+import "sort"
+
+// chunkSize is the max number of entries in a chunk
+const chunkSize = 128
+
+// Entry is an element of stream, Fields holds field-value pairs in order
+type Entry struct {
+	ID     ID
+	Fields [][]byte
+}
+
+// chunk packs consecutive entries like listpack, entries are ordered by id
+type chunk struct {
+	entries []*Entry
+}
+
+func (c *chunk) first() *Entry {
+	return c.entries[0]
+}
+
+func (c *chunk) last() *Entry {
+	return c.entries[len(c.entries)-1]
+}
+
+// Stream is an append-only log of entries, entries are packed into chunks which are indexed by their first id
+type Stream struct {
+	chunks []*chunk
+	length int64
+	// lastID is the largest id ever added, it may have been deleted
+	lastID ID
+	groups map[string]*Group
+}
+
+// Make creates an empty stream
+func Make() *Stream {
+	return &Stream{
+		groups: make(map[string]*Group),
+	}
+}
+
+// Len returns number of entries in stream
+func (s *Stream) Len() int64 {
+	return s.length
+}
+
+// LastID returns the largest id ever added to stream
+func (s *Stream) LastID() ID {
+	return s.lastID
+}
+
+// SetLastID sets the last id, caller should make sure it is not smaller than any entry
+func (s *Stream) SetLastID(id ID) {
+	s.lastID = id
+}
+
+// Add appends entry with the given id, id must be greater than last id
+func (s *Stream) Add(id ID, fields [][]byte) *Entry {
+	entry := &Entry{
+		ID:     id,
+		Fields: fields,
+	}
+	if len(s.chunks) == 0 || len(s.chunks[len(s.chunks)-1].entries) >= chunkSize {
+		s.chunks = append(s.chunks, &chunk{
+			entries: make([]*Entry, 0, chunkSize),
+		})
+	}
+	tail := s.chunks[len(s.chunks)-1]
+	tail.entries = append(tail.entries, entry)
+	s.length++
+	s.lastID = id
+	return entry
+}
+
+// locate returns index of chunk and index of entry in chunk which is the first entry not less than id
+func (s *Stream) locate(id ID) (int, int) {
+	i := sort.Search(len(s.chunks), func(i int) bool {
+		return !s.chunks[i].last().ID.Less(id)
+	})
+	if i == len(s.chunks) {
+		return i, 0
+	}
+	entries := s.chunks[i].entries
+	j := sort.Search(len(entries), func(j int) bool {
+		return !entries[j].ID.Less(id)
+	})
+	return i, j
+}
+
+// Get returns entry of the given id
+func (s *Stream) Get(id ID) (*Entry, bool) {
+	i, j := s.locate(id)
+	if i == len(s.chunks) {
+		return nil, false
+	}
+	entry := s.chunks[i].entries[j]
+	if entry.ID != id {
+		return nil, false
+	}
+	return entry, true
+}
+
+// Delete removes entry of the given id, the last id is not changed
+func (s *Stream) Delete(id ID) bool {
+	i, j := s.locate(id)
+	if i == len(s.chunks) || s.chunks[i].entries[j].ID != id {
+		return false
+	}
+	c := s.chunks[i]
+	c.entries = append(c.entries[:j], c.entries[j+1:]...)
+	if len(c.entries) == 0 {
+		s.chunks = append(s.chunks[:i], s.chunks[i+1:]...)
+	}
+	s.length--
+	return true
+}
+
+// Range returns entries whose id within [start, end], in ascending order unless desc
+// at most count entries are returned, negative count means no limit
+func (s *Stream) Range(start ID, end ID, count int64, desc bool) []*Entry {
+	result := make([]*Entry, 0)
+	if end.Less(start) || count == 0 {
+		return result
+	}
+	if desc {
+		i, j := s.locate(end)
+		// locate finds the first entry >= end, step back if it is beyond end
+		if i == len(s.chunks) || end.Less(s.chunks[i].entries[j].ID) {
+			i, j = s.prev(i, j)
+		}
+		for ; i >= 0; i, j = s.prev(i, j) {
+			entry := s.chunks[i].entries[j]
+			if entry.ID.Less(start) || int64(len(result)) == count {
+				break
+			}
+			result = append(result, entry)
+		}
+		return result
+	}
+	for i, j := s.locate(start); i < len(s.chunks); i, j = s.next(i, j) {
+		entry := s.chunks[i].entries[j]
+		if end.Less(entry.ID) || int64(len(result)) == count {
+			break
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+func (s *Stream) next(i int, j int) (int, int) {
+	if j+1 < len(s.chunks[i].entries) {
+		return i, j + 1
+	}
+	return i + 1, 0
+}
+
+func (s *Stream) prev(i int, j int) (int, int) {
+	if j > 0 {
+		return i, j - 1
+	}
+	if i == 0 {
+		return -1, 0
+	}
+	return i - 1, len(s.chunks[i-1].entries) - 1
+}
+
+// trim removes entries from head while evict returns true,
+// approximate trimming only removes whole chunks, and at most limit entries are removed if limit > 0
+func (s *Stream) trim(approx bool, limit int64, evict func(entry *Entry) bool) int64 {
+	var removed int64
+	for len(s.chunks) > 0 {
+		head := s.chunks[0]
+		if approx {
+			size := int64(len(head.entries))
+			if !evict(head.last()) || (limit > 0 && removed+size > limit) {
+				break
+			}
+			s.chunks = s.chunks[1:]
+			s.length -= size
+			removed += size
+			continue
+		}
+		if !evict(head.first()) {
+			break
+		}
+		head.entries = head.entries[1:]
+		if len(head.entries) == 0 {
+			s.chunks = s.chunks[1:]
+		}
+		s.length--
+		removed++
+	}
+	return removed
+}
+
+// TrimMaxLen removes the oldest entries until the length is not greater than maxLen, returns number of removed entries
+// approximate trimming may keep a few more entries
+func (s *Stream) TrimMaxLen(maxLen int64, approx bool, limit int64) int64 {
+	if approx {
+		// a whole chunk is removed only if the rest entries are enough
+		return s.trim(true, limit, func(last *Entry) bool {
+			return s.length-int64(len(s.chunks[0].entries)) >= maxLen
+		})
+	}
+	return s.trim(false, 0, func(entry *Entry) bool {
+		return s.length > maxLen
+	})
+}
+
+// TrimMinID removes entries whose id is less than minID, returns number of removed entries
+// approximate trimming may keep a few more entries
+func (s *Stream) TrimMinID(minID ID, approx bool, limit int64) int64 {
+	return s.trim(approx, limit, func(entry *Entry) bool {
+		return entry.ID.Less(minID)
+	})
+}
+
+// ForEach visits all entries in ascending order, traversal stops if consumer returns false
+func (s *Stream) ForEach(consumer func(entry *Entry) bool) {
+	for _, c := range s.chunks {
+		for _, entry := range c.entries {
+			if !consumer(entry) {
+				return
+			}
+		}
+	}
+}
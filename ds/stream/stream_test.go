@@ -0,0 +1,119 @@
+package stream
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func makeTestStream(size int) *Stream {
+	s := Make()
+	for i := 1; i <= size; i++ {
+		s.Add(ID{Ms: uint64(i)}, [][]byte{[]byte("f"), []byte("v")})
+	}
+	return s
+}
+
+func ids(entries []*Entry) []uint64 {
+	result := make([]uint64, len(entries))
+	for i, entry := range entries {
+		result[i] = entry.ID.Ms
+	}
+	return result
+}
+
+func TestParseID(t *testing.T) {
+	id, err := ParseID("1526919030474-55", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, ID{Ms: 1526919030474, Seq: 55}, id)
+	id, err = ParseID("10", 7)
+	assert.Nil(t, err)
+	assert.Equal(t, ID{Ms: 10, Seq: 7}, id)
+	_, err = ParseID("1-a", 0)
+	assert.NotNil(t, err)
+	_, err = ParseID("-1", 0)
+	assert.NotNil(t, err)
+
+	next, ok := ID{Ms: 1, Seq: MaxID.Seq}.Incr()
+	assert.True(t, ok)
+	assert.Equal(t, ID{Ms: 2}, next)
+	_, ok = MaxID.Incr()
+	assert.False(t, ok)
+	prev, ok := ID{Ms: 2}.Decr()
+	assert.True(t, ok)
+	assert.Equal(t, ID{Ms: 1, Seq: MaxID.Seq}, prev)
+}
+
+func TestRange(t *testing.T) {
+	s := makeTestStream(1000)
+	assert.Equal(t, int64(1000), s.Len())
+	assert.Equal(t, []uint64{200, 201, 202}, ids(s.Range(ID{Ms: 200}, ID{Ms: 202}, -1, false)))
+	assert.Equal(t, []uint64{1000, 999}, ids(s.Range(MinID, MaxID, 2, true)))
+	assert.Equal(t, []uint64{129, 128, 127}, ids(s.Range(ID{Ms: 127}, ID{Ms: 129, Seq: 1}, -1, true)))
+	assert.Empty(t, s.Range(ID{Ms: 5}, ID{Ms: 4}, -1, false))
+
+	for i := 1; i <= 1000; i += 2 {
+		assert.True(t, s.Delete(ID{Ms: uint64(i)}))
+	}
+	assert.False(t, s.Delete(ID{Ms: 1}))
+	assert.Equal(t, int64(500), s.Len())
+	assert.Equal(t, ID{Ms: 1000}, s.LastID())
+	assert.Equal(t, []uint64{128, 130}, ids(s.Range(ID{Ms: 127}, ID{Ms: 131}, -1, false)))
+	_, ok := s.Get(ID{Ms: 3})
+	assert.False(t, ok)
+	entry, ok := s.Get(ID{Ms: 4})
+	assert.True(t, ok)
+	assert.Equal(t, ID{Ms: 4}, entry.ID)
+}
+
+func TestTrim(t *testing.T) {
+	s := makeTestStream(1000)
+	assert.Equal(t, int64(900), s.TrimMaxLen(100, false, 0))
+	assert.Equal(t, []uint64{901}, ids(s.Range(MinID, MaxID, 1, false)))
+
+	s = makeTestStream(1000)
+	removed := s.TrimMaxLen(100, true, 0)
+	// approximate trimming removes whole chunks only
+	assert.Equal(t, int64(0), removed%chunkSize)
+	assert.True(t, s.Len() >= 100 && s.Len() < 100+chunkSize)
+
+	s = makeTestStream(1000)
+	// the limit stops approximate trimming before the second chunk
+	assert.Equal(t, int64(chunkSize), s.TrimMinID(ID{Ms: 300}, true, chunkSize))
+
+	s = makeTestStream(1000)
+	assert.Equal(t, int64(499), s.TrimMinID(ID{Ms: 500}, false, 0))
+	assert.Equal(t, []uint64{500}, ids(s.Range(MinID, MaxID, 1, false)))
+}
+
+func TestGroup(t *testing.T) {
+	s := makeTestStream(10)
+	group, ok := s.CreateGroup("g", MinID)
+	assert.True(t, ok)
+	_, ok = s.CreateGroup("g", MinID)
+	assert.False(t, ok)
+
+	now := time.Now()
+	alice, created := group.GetOrCreateConsumer("alice", now)
+	assert.True(t, created)
+	bob, _ := group.GetOrCreateConsumer("bob", now)
+	group.Deliver(ID{Ms: 1}, alice, now)
+	group.Deliver(ID{Ms: 2}, alice, now)
+	group.Deliver(ID{Ms: 1}, alice, now)
+	assert.Equal(t, int64(2), group.Pending[ID{Ms: 1}].DeliveryCount)
+	assert.Len(t, alice.PendingEntries(), 2)
+
+	group.Claim(ID{Ms: 2}, bob)
+	assert.Len(t, alice.PendingEntries(), 1)
+	assert.Equal(t, "bob", group.Pending[ID{Ms: 2}].Consumer)
+	assert.True(t, group.Ack(ID{Ms: 1}))
+	assert.False(t, group.Ack(ID{Ms: 1}))
+	assert.Empty(t, alice.Pending)
+
+	deleted, ok := group.DeleteConsumer("bob")
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), deleted)
+	assert.Empty(t, group.Pending)
+	assert.True(t, s.DestroyGroup("g"))
+	assert.Nil(t, s.GetGroup("g"))
+}
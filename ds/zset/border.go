@@ -0,0 +1,143 @@
+package zset
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+// Border is a boundary of range query, compares elements either by score or by member in lexicographical order
+type Border interface {
+	// greater tells whether element is greater than border, that is element satisfies the border as min
+	greater(element *Elem) bool
+	// less tells whether element is less than border, that is element satisfies the border as max
+	less(element *Elem) bool
+	// isIntersected tells whether range from the border to max may contain any element
+	isIntersected(max Border) bool
+}
+
+// ScoreBorder is border of score, Value may be positive or negative infinity
+type ScoreBorder struct {
+	Value   float64
+	Exclude bool
+}
+
+var (
+	errScoreBorder = errors.New("ERR min or max is not a float")
+	errLexBorder   = errors.New("ERR min or max not valid string range item")
+)
+
+func (border *ScoreBorder) greater(element *Elem) bool {
+	if border.Exclude {
+		return element.Score > border.Value
+	}
+	return element.Score >= border.Value
+}
+
+func (border *ScoreBorder) less(element *Elem) bool {
+	if border.Exclude {
+		return element.Score < border.Value
+	}
+	return element.Score <= border.Value
+}
+
+func (border *ScoreBorder) isIntersected(max Border) bool {
+	maxBorder, ok := max.(*ScoreBorder)
+	if !ok {
+		return false
+	}
+	if border.Value > maxBorder.Value {
+		return false
+	}
+	return border.Value != maxBorder.Value || (!border.Exclude && !maxBorder.Exclude)
+}
+
+// ParseScoreBorder parses border like 1.5, (1.5, -inf and +inf
+func ParseScoreBorder(s string) (*ScoreBorder, error) {
+	border := &ScoreBorder{}
+	if len(s) > 0 && s[0] == '(' {
+		border.Exclude = true
+		s = s[1:]
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(value) {
+		return nil, errScoreBorder
+	}
+	border.Value = value
+	return border, nil
+}
+
+const (
+	lexNegativeInf int8 = -1
+	lexPositiveInf int8 = 1
+)
+
+// LexBorder is border of member, only meaningful when all members have the same score
+type LexBorder struct {
+	Inf     int8
+	Value   string
+	Exclude bool
+}
+
+func (border *LexBorder) greater(element *Elem) bool {
+	switch border.Inf {
+	case lexNegativeInf:
+		return true
+	case lexPositiveInf:
+		return false
+	}
+	if border.Exclude {
+		return element.Member > border.Value
+	}
+	return element.Member >= border.Value
+}
+
+func (border *LexBorder) less(element *Elem) bool {
+	switch border.Inf {
+	case lexNegativeInf:
+		return false
+	case lexPositiveInf:
+		return true
+	}
+	if border.Exclude {
+		return element.Member < border.Value
+	}
+	return element.Member <= border.Value
+}
+
+func (border *LexBorder) isIntersected(max Border) bool {
+	maxBorder, ok := max.(*LexBorder)
+	if !ok {
+		return false
+	}
+	if border.Inf == lexPositiveInf || maxBorder.Inf == lexNegativeInf {
+		return false
+	}
+	if border.Inf == lexNegativeInf || maxBorder.Inf == lexPositiveInf {
+		return true
+	}
+	if border.Value > maxBorder.Value {
+		return false
+	}
+	return border.Value != maxBorder.Value || (!border.Exclude && !maxBorder.Exclude)
+}
+
+// ParseLexBorder parses border like [a, (a, - and +
+func ParseLexBorder(s string) (*LexBorder, error) {
+	if s == "-" {
+		return &LexBorder{Inf: lexNegativeInf}, nil
+	}
+	if s == "+" {
+		return &LexBorder{Inf: lexPositiveInf}, nil
+	}
+	if len(s) == 0 {
+		return nil, errLexBorder
+	}
+	switch s[0] {
+	case '[':
+		return &LexBorder{Value: s[1:]}, nil
+	case '(':
+		return &LexBorder{Value: s[1:], Exclude: true}, nil
+	}
+	return nil, errLexBorder
+}
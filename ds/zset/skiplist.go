@@ -148,7 +148,7 @@ func (sl *skiplist) removeNode(node *node, update []*node) {
 	for i := int16(0); i < sl.lv; i++ {
 		if update[i].level[i].next == node {
 			update[i].level[i].next = node.level[i].next
-			update[i].level[i].span += node.level[i].span + 1
+			update[i].level[i].span += node.level[i].span - 1
 		} else {
 			update[i].level[i].span--
 		}
@@ -158,7 +158,7 @@ func (sl *skiplist) removeNode(node *node, update []*node) {
 	} else {
 		sl.tail = node.prev
 	}
-	if sl.lv > 1 && sl.head.level[sl.lv-1].next == nil {
+	for sl.lv > 1 && sl.head.level[sl.lv-1].next == nil {
 		sl.lv--
 	}
 	sl.length--
@@ -193,9 +193,105 @@ func (sl *skiplist) getRank(member string, score float64) int64 {
 			cur = cur.level[i].next
 		}
 
-		if cur.Member == member {
+		if cur != sl.head && cur.Member == member {
 			return rank
 		}
 	}
 	return 0
 }
+
+// hasInRange tells whether any element is in range [min, max]
+func (sl *skiplist) hasInRange(min Border, max Border) bool {
+	if !min.isIntersected(max) {
+		return false
+	}
+	if sl.tail == nil || !min.greater(&sl.tail.Elem) {
+		return false
+	}
+	first := sl.head.level[0].next
+	return first != nil && max.less(&first.Elem)
+}
+
+// getFirstInRange returns the first node in range [min, max], or nil if none
+func (sl *skiplist) getFirstInRange(min Border, max Border) *node {
+	if !sl.hasInRange(min, max) {
+		return nil
+	}
+	cur := sl.head
+	for i := sl.lv - 1; i >= 0; i-- {
+		for cur.level[i].next != nil && !min.greater(&cur.level[i].next.Elem) {
+			cur = cur.level[i].next
+		}
+	}
+	cur = cur.level[0].next
+	if cur == nil || !max.less(&cur.Elem) {
+		return nil
+	}
+	return cur
+}
+
+// getLastInRange returns the last node in range [min, max], or nil if none
+func (sl *skiplist) getLastInRange(min Border, max Border) *node {
+	if !sl.hasInRange(min, max) {
+		return nil
+	}
+	cur := sl.head
+	for i := sl.lv - 1; i >= 0; i-- {
+		for cur.level[i].next != nil && max.less(&cur.level[i].next.Elem) {
+			cur = cur.level[i].next
+		}
+	}
+	if cur == sl.head || !min.greater(&cur.Elem) {
+		return nil
+	}
+	return cur
+}
+
+// removeRange removes elements in range [min, max], returns removed elements
+func (sl *skiplist) removeRange(min Border, max Border) []*Elem {
+	removed := make([]*Elem, 0)
+	if !min.isIntersected(max) {
+		return removed
+	}
+	update := make([]*node, maxLevel)
+	cur := sl.head
+	for i := sl.lv - 1; i >= 0; i-- {
+		for cur.level[i].next != nil && !min.greater(&cur.level[i].next.Elem) {
+			cur = cur.level[i].next
+		}
+		update[i] = cur
+	}
+	cur = cur.level[0].next
+	for cur != nil && max.less(&cur.Elem) {
+		next := cur.level[0].next
+		removed = append(removed, &cur.Elem)
+		sl.removeNode(cur, update)
+		cur = next
+	}
+	return removed
+}
+
+// removeRangeByRank removes elements whose rank within [start, stop), rank starts from 1
+func (sl *skiplist) removeRangeByRank(start int64, stop int64) []*Elem {
+	removed := make([]*Elem, 0)
+	var rank int64 = 0
+	update := make([]*node, maxLevel)
+	cur := sl.head
+	for i := sl.lv - 1; i >= 0; i-- {
+		for cur.level[i].next != nil && rank+cur.level[i].span < start {
+			rank += cur.level[i].span
+			cur = cur.level[i].next
+		}
+		update[i] = cur
+	}
+	rank++
+	cur = cur.level[0].next
+	for cur != nil && rank < stop {
+		next := cur.level[0].next
+		removed = append(removed, &cur.Elem)
+		sl.removeNode(cur, update)
+		cur = next
+		rank++
+	}
+	return removed
+}
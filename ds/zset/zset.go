@@ -1,5 +1,7 @@
 package zset
 
+import "strconv"
+
 type ZSet struct {
 	dict map[string]*Elem
 	sl   *skiplist
@@ -60,3 +62,127 @@ func (zs *ZSet) GetRank(member string, desc bool) (rank int64) {
 	}
 	return r
 }
+
+// Len returns number of members in the sorted set
+func (zs *ZSet) Len() int64 {
+	return int64(len(zs.dict))
+}
+
+// ForEach visits members whose rank within [start, stop), sorted by ascending order unless desc
+// rank starts from 0, traversal stops if consumer returns false
+func (zs *ZSet) ForEach(start int64, stop int64, desc bool, consumer func(element *Elem) bool) {
+	size := zs.Len()
+	if start < 0 || start >= size {
+		panic("illegal start " + strconv.FormatInt(start, 10))
+	}
+	if stop < start || stop > size {
+		panic("illegal end " + strconv.FormatInt(stop, 10))
+	}
+
+	// find start node
+	var n *node
+	if desc {
+		n = zs.sl.getByRank(size - start)
+	} else {
+		n = zs.sl.getByRank(start + 1)
+	}
+
+	for i := start; i < stop && n != nil; i++ {
+		if !consumer(&n.Elem) {
+			break
+		}
+		if desc {
+			n = n.prev
+		} else {
+			n = n.level[0].next
+		}
+	}
+}
+
+// Count returns number of members in range [min, max]
+func (zs *ZSet) Count(min Border, max Border) int64 {
+	first := zs.sl.getFirstInRange(min, max)
+	if first == nil {
+		return 0
+	}
+	last := zs.sl.getLastInRange(min, max)
+	return zs.sl.getRank(last.Member, last.Score) - zs.sl.getRank(first.Member, first.Score) + 1
+}
+
+// ForEachInRange visits members in range [min, max], sorted by ascending order unless desc
+// offset members are skipped first, and at most limit members are visited, negative limit means no limit
+func (zs *ZSet) ForEachInRange(min Border, max Border, offset int64, limit int64, desc bool, consumer func(element *Elem) bool) {
+	var n *node
+	if desc {
+		n = zs.sl.getLastInRange(min, max)
+	} else {
+		n = zs.sl.getFirstInRange(min, max)
+	}
+	for ; n != nil && offset > 0; offset-- {
+		if desc {
+			n = n.prev
+		} else {
+			n = n.level[0].next
+		}
+	}
+	for ; n != nil && limit != 0; limit-- {
+		if desc && !min.greater(&n.Elem) || !desc && !max.less(&n.Elem) {
+			break
+		}
+		if !consumer(&n.Elem) {
+			break
+		}
+		if desc {
+			n = n.prev
+		} else {
+			n = n.level[0].next
+		}
+	}
+}
+
+// Range returns members in range [min, max], see ForEachInRange
+func (zs *ZSet) Range(min Border, max Border, offset int64, limit int64, desc bool) []*Elem {
+	elements := make([]*Elem, 0)
+	zs.ForEachInRange(min, max, offset, limit, desc, func(element *Elem) bool {
+		elements = append(elements, element)
+		return true
+	})
+	return elements
+}
+
+// RemoveRange removes members in range [min, max], returns number of removed members
+func (zs *ZSet) RemoveRange(min Border, max Border) int64 {
+	removed := zs.sl.removeRange(min, max)
+	for _, element := range removed {
+		delete(zs.dict, element.Member)
+	}
+	return int64(len(removed))
+}
+
+// RemoveByRank removes members whose rank within [start, stop), rank starts from 0
+func (zs *ZSet) RemoveByRank(start int64, stop int64) int64 {
+	removed := zs.sl.removeRangeByRank(start+1, stop+1)
+	for _, element := range removed {
+		delete(zs.dict, element.Member)
+	}
+	return int64(len(removed))
+}
+
+// PopMin removes and returns at most count members with the lowest scores
+func (zs *ZSet) PopMin(count int64) []*Elem {
+	removed := zs.sl.removeRangeByRank(1, count+1)
+	for _, element := range removed {
+		delete(zs.dict, element.Member)
+	}
+	return removed
+}
+
+// PopMax removes and returns at most count members with the highest scores
+func (zs *ZSet) PopMax(count int64) []*Elem {
+	removed := make([]*Elem, 0)
+	for n := zs.sl.tail; n != nil && int64(len(removed)) < count; n = zs.sl.tail {
+		removed = append(removed, &Elem{Member: n.Member, Score: n.Score})
+		zs.Remove(n.Member)
+	}
+	return removed
+}
@@ -0,0 +1,111 @@
+package zset
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func makeTestZSet(size int) *ZSet {
+	zs := Make()
+	for i := 0; i < size; i++ {
+		zs.Add("m"+strconv.Itoa(i), float64(i))
+	}
+	return zs
+}
+
+func members(elements []*Elem) []string {
+	result := make([]string, len(elements))
+	for i, element := range elements {
+		result[i] = element.Member
+	}
+	return result
+}
+
+func TestRankAfterRemove(t *testing.T) {
+	zs := makeTestZSet(100)
+	for i := 0; i < 100; i += 3 {
+		zs.Remove("m" + strconv.Itoa(i))
+	}
+	var rank int64 = 0
+	zs.ForEach(0, zs.Len(), false, func(element *Elem) bool {
+		assert.Equal(t, rank, zs.GetRank(element.Member, false))
+		rank++
+		return true
+	})
+	assert.Equal(t, zs.Len(), rank)
+}
+
+func TestRangeByScore(t *testing.T) {
+	zs := makeTestZSet(10)
+	min, _ := ParseScoreBorder("(2")
+	max, _ := ParseScoreBorder("5")
+	assert.Equal(t, []string{"m3", "m4", "m5"}, members(zs.Range(min, max, 0, -1, false)))
+	assert.Equal(t, []string{"m5", "m4", "m3"}, members(zs.Range(min, max, 0, -1, true)))
+	assert.Equal(t, []string{"m4"}, members(zs.Range(min, max, 1, 1, false)))
+	assert.Equal(t, []string{"m4", "m3"}, members(zs.Range(min, max, 1, 5, true)))
+	assert.Equal(t, int64(3), zs.Count(min, max))
+
+	min, _ = ParseScoreBorder("-inf")
+	max, _ = ParseScoreBorder("+inf")
+	assert.Equal(t, int64(10), zs.Count(min, max))
+	assert.Equal(t, math.Inf(1), max.Value)
+
+	min, _ = ParseScoreBorder("(5")
+	max, _ = ParseScoreBorder("5")
+	assert.Equal(t, int64(0), zs.Count(min, max))
+	assert.Empty(t, zs.Range(min, max, 0, -1, false))
+
+	_, err := ParseScoreBorder("abc")
+	assert.NotNil(t, err)
+}
+
+func TestRangeByLex(t *testing.T) {
+	zs := Make()
+	for _, member := range []string{"a", "b", "c", "d", "e"} {
+		zs.Add(member, 0)
+	}
+	min, _ := ParseLexBorder("[b")
+	max, _ := ParseLexBorder("(d")
+	assert.Equal(t, []string{"b", "c"}, members(zs.Range(min, max, 0, -1, false)))
+	min, _ = ParseLexBorder("-")
+	max, _ = ParseLexBorder("+")
+	assert.Equal(t, []string{"e", "d", "c", "b", "a"}, members(zs.Range(min, max, 0, -1, true)))
+	assert.Equal(t, int64(5), zs.Count(min, max))
+	assert.Equal(t, int64(0), zs.Count(max, min))
+
+	min, _ = ParseLexBorder("(a")
+	max, _ = ParseLexBorder("[c")
+	assert.Equal(t, int64(2), zs.RemoveRange(min, max))
+	assert.Equal(t, int64(3), zs.Len())
+	_, ok := zs.Get("b")
+	assert.False(t, ok)
+
+	_, err := ParseLexBorder("b")
+	assert.NotNil(t, err)
+}
+
+func TestRemoveRange(t *testing.T) {
+	zs := makeTestZSet(10)
+	min, _ := ParseScoreBorder("3")
+	max, _ := ParseScoreBorder("(6")
+	assert.Equal(t, int64(3), zs.RemoveRange(min, max))
+	assert.Equal(t, int64(7), zs.Len())
+	assert.Equal(t, int64(3), zs.GetRank("m6", false))
+
+	assert.Equal(t, int64(2), zs.RemoveByRank(1, 3))
+	assert.Equal(t, int64(5), zs.Len())
+	assert.Equal(t, int64(1), zs.GetRank("m6", false))
+	_, ok := zs.Get("m1")
+	assert.False(t, ok)
+}
+
+func TestPop(t *testing.T) {
+	zs := makeTestZSet(5)
+	assert.Equal(t, []string{"m0", "m1"}, members(zs.PopMin(2)))
+	assert.Equal(t, []string{"m4", "m3"}, members(zs.PopMax(2)))
+	assert.Equal(t, []string{"m2"}, members(zs.PopMax(10)))
+	assert.Equal(t, int64(0), zs.Len())
+	assert.Empty(t, zs.PopMin(1))
+}
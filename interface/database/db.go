@@ -1,6 +1,9 @@
 package database
 
-import "ringodis/interface/resp"
+import (
+	"ringodis/interface/resp"
+	"time"
+)
 
 // CmdLine is alias for [][]byte, represents a command line
 type CmdLine = [][]byte
@@ -12,6 +15,16 @@ type DB interface {
 	AfterClientClose(c resp.Connection)
 }
 
+// DBEngine is the storage engine exposing keyspace traversal and key locks, used by persistence and distributed transactions
+type DBEngine interface {
+	DB
+	ForEach(dbIndex int, cb func(key string, data *DataEntity, expiration *time.Time) bool)
+	RWLocks(dbIndex int, writeKeys []string, readKeys []string)
+	RWUnLocks(dbIndex int, writeKeys []string, readKeys []string)
+	ExecWithLock(client resp.Connection, cmdLine CmdLine) resp.Reply
+	GetUndoLogs(dbIndex int, cmdLine CmdLine) []CmdLine
+}
+
 // DataEntity stores data bound to a key, including a string, list, hash, set, etc.
 type DataEntity struct {
 	Data interface{}
@@ -6,4 +6,44 @@ type Connection interface {
 
 	GetDBIndex() int
 	SelectDB(int)
+	// IsClosed tells whether the connection has been closed, blocked commands check it before waiting
+	IsClosed() bool
+
+	// used for `Auth` command, connections must be authenticated if default user requires password
+	SetAuthenticated(bool)
+	IsAuthenticated() bool
+	// used for ACL, user is empty for internal connections or before authenticated
+	SetUser(string)
+	GetUser() string
+
+	// used for `Multi` command
+	InMultiState() bool
+	SetMultiState(bool)
+	GetQueuedCmdLine() [][][]byte
+	EnqueueCmd([][]byte)
+	ClearQueuedCmds()
+	GetWatching() map[WatchedKey]uint32
+	AddTxError(err error)
+	GetTxErrors() []error
+
+	// used for publish/subscribe, Push sends message asynchronously so that publisher is never blocked by the client
+	Push([]byte)
+	Subscribe(channel string)
+	UnSubscribe(channel string)
+	PSubscribe(pattern string)
+	PUnSubscribe(pattern string)
+	SSubscribe(channel string)
+	SUnSubscribe(channel string)
+	SubsCount() int
+	ShardSubsCount() int
+	InSubscribeMode() bool
+	GetChannels() []string
+	GetPatterns() []string
+	GetShardChannels() []string
+}
+
+// WatchedKey is a key watched by client, keys of the same name in different dbs are watched separately
+type WatchedKey struct {
+	DBIndex int
+	Key     string
 }
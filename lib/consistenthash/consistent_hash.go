@@ -46,16 +46,35 @@ func (m *Map) AddNode(keys ...string) {
 	sort.Ints(m.keys)
 }
 
-func getPartitionKey(key string) string {
+// RemoveNode removes physical nodes together with their virtual nodes
+func (m *Map) RemoveNode(keys ...string) {
+	removed := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		removed[key] = struct{}{}
+	}
+	hashes := m.keys[:0]
+	for _, hash := range m.keys {
+		if _, ok := removed[m.hashMap[hash]]; ok {
+			delete(m.hashMap, hash)
+			continue
+		}
+		hashes = append(hashes, hash)
+	}
+	m.keys = hashes
+}
+
+// GetPartitionKey returns the hash tag between the first `{` and the following `}`,
+// keys with the same tag are always located together, the whole key is returned if there is no tag
+func GetPartitionKey(key string) string {
 	beg := strings.Index(key, "{")
 	if beg == -1 {
 		return key
 	}
-	end := strings.Index(key, "}")
-	if end == -1 || end == beg+1 {
+	end := strings.Index(key[beg+1:], "}")
+	if end <= 0 {
 		return key
 	}
-	return key[beg+1 : end]
+	return key[beg+1 : beg+1+end]
 }
 
 func (m *Map) PickNode(key string) string {
@@ -63,7 +82,7 @@ func (m *Map) PickNode(key string) string {
 		return ""
 	}
 
-	partitionKey := getPartitionKey(key)
+	partitionKey := GetPartitionKey(key)
 	hash := int(m.hashFunc([]byte(partitionKey)))
 
 	// binary search for appropriate replica
@@ -15,3 +15,35 @@ func TestHash(t *testing.T) {
 		t.Error("wrong answer")
 	}
 }
+
+func TestGetPartitionKey(t *testing.T) {
+	cases := map[string]string{
+		"abc":            "abc",
+		"{user1}.follow": "user1",
+		"a{b}{c}":        "b",
+		"{}abc":          "{}abc",
+		"abc{":           "abc{",
+		"}a{b}":          "b",
+	}
+	for key, expected := range cases {
+		if actual := GetPartitionKey(key); actual != expected {
+			t.Errorf("key %s: expected %s, actual %s", key, expected, actual)
+		}
+	}
+}
+
+func TestRemoveNode(t *testing.T) {
+	m := New(3, nil)
+	m.AddNode("a", "b", "c", "d")
+	m.RemoveNode("b")
+	if node := m.PickNode("abc"); node == "b" || node == "" {
+		t.Errorf("unexpected node %s", node)
+	}
+	if m.PickNode("zxc") != "a" {
+		t.Error("keys of other nodes should not be moved")
+	}
+	m.RemoveNode("a", "c", "d")
+	if !m.IsEmpty() {
+		t.Error("map should be empty")
+	}
+}
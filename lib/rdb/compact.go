@@ -0,0 +1,315 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var errCorrupted = errors.New("corrupted compact encoding")
+
+// lzfDecompress decompresses data compressed by lzf
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, 0, outLen)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 1<<5 {
+			// literal run of ctrl+1 bytes
+			ctrl++
+			if i+ctrl > len(in) {
+				return nil, errCorrupted
+			}
+			out = append(out, in[i:i+ctrl]...)
+			i += ctrl
+			continue
+		}
+		// back reference
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(in) {
+				return nil, errCorrupted
+			}
+			length += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errCorrupted
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, errCorrupted
+		}
+		for j := 0; j < length+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != outLen {
+		return nil, fmt.Errorf("lzf: expect %d bytes, actually %d", outLen, len(out))
+	}
+	return out, nil
+}
+
+// parseZipList reads all entries of a ziplist
+func parseZipList(buf []byte) ([][]byte, error) {
+	if len(buf) < 11 {
+		return nil, errCorrupted
+	}
+	size := int(binary.LittleEndian.Uint16(buf[8:10]))
+	values := make([][]byte, 0, size)
+	pos := 10
+	for {
+		if pos >= len(buf) {
+			return nil, errCorrupted
+		}
+		if buf[pos] == 0xff {
+			return values, nil
+		}
+		// skip prevlen
+		if buf[pos] < 254 {
+			pos++
+		} else {
+			pos += 5
+		}
+		if pos >= len(buf) {
+			return nil, errCorrupted
+		}
+		value, n, err := readZipListEntry(buf[pos:])
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		pos += n
+	}
+}
+
+func readZipListEntry(buf []byte) ([]byte, int, error) {
+	header := buf[0]
+	var length, n int
+	switch header >> 6 {
+	case 0:
+		length, n = int(header&0x3f), 1
+	case 1:
+		if len(buf) < 2 {
+			return nil, 0, errCorrupted
+		}
+		length, n = int(header&0x3f)<<8|int(buf[1]), 2
+	case 2:
+		if len(buf) < 5 {
+			return nil, 0, errCorrupted
+		}
+		length, n = int(binary.BigEndian.Uint32(buf[1:5])), 5
+	default:
+		return readZipListInt(buf)
+	}
+	if n+length > len(buf) {
+		return nil, 0, errCorrupted
+	}
+	return buf[n : n+length], n + length, nil
+}
+
+func readZipListInt(buf []byte) ([]byte, int, error) {
+	header := buf[0]
+	var v int64
+	var size int
+	switch header {
+	case 0xc0:
+		size = 2
+	case 0xd0:
+		size = 4
+	case 0xe0:
+		size = 8
+	case 0xf0:
+		size = 3
+	case 0xfe:
+		size = 1
+	default:
+		if header >= 0xf1 && header <= 0xfd {
+			return []byte(strconv.Itoa(int(header&0x0f) - 1)), 1, nil
+		}
+		return nil, 0, errCorrupted
+	}
+	if len(buf) < 1+size {
+		return nil, 0, errCorrupted
+	}
+	v = readIntLE(buf[1 : 1+size])
+	return []byte(strconv.FormatInt(v, 10)), 1 + size, nil
+}
+
+// readIntLE reads a signed little endian integer of len(buf) bytes
+func readIntLE(buf []byte) int64 {
+	var u uint64
+	for i := len(buf) - 1; i >= 0; i-- {
+		u = u<<8 | uint64(buf[i])
+	}
+	shift := 64 - 8*uint(len(buf))
+	return int64(u<<shift) >> shift
+}
+
+// readIntBE reads a signed big endian integer of the given bits
+func readIntBE(buf []byte, bits uint) int64 {
+	var u uint64
+	for _, b := range buf {
+		u = u<<8 | uint64(b)
+	}
+	shift := 64 - bits
+	return int64(u<<shift) >> shift
+}
+
+// parseListPack reads all entries of a listpack
+func parseListPack(buf []byte) ([][]byte, error) {
+	if len(buf) < 7 {
+		return nil, errCorrupted
+	}
+	size := int(binary.LittleEndian.Uint16(buf[4:6]))
+	values := make([][]byte, 0, size)
+	pos := 6
+	for {
+		if pos >= len(buf) {
+			return nil, errCorrupted
+		}
+		if buf[pos] == 0xff {
+			return values, nil
+		}
+		value, n, err := readListPackEntry(buf[pos:])
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		pos += n + listPackBackLen(n)
+	}
+}
+
+// readListPackEntry returns the value and the size of encoding and data, excluding backlen
+func readListPackEntry(buf []byte) ([]byte, int, error) {
+	header := buf[0]
+	var length, n int
+	switch {
+	case header&0x80 == 0: // 7 bit uint
+		return []byte(strconv.Itoa(int(header & 0x7f))), 1, nil
+	case header&0xc0 == 0x80: // 6 bit str len
+		length, n = int(header&0x3f), 1
+	case header&0xe0 == 0xc0: // 13 bit int
+		if len(buf) < 2 {
+			return nil, 0, errCorrupted
+		}
+		v := readIntBE([]byte{header & 0x1f, buf[1]}, 13)
+		return []byte(strconv.FormatInt(v, 10)), 2, nil
+	case header&0xf0 == 0xe0: // 12 bit str len
+		if len(buf) < 2 {
+			return nil, 0, errCorrupted
+		}
+		length, n = int(header&0x0f)<<8|int(buf[1]), 2
+	case header == 0xf0: // 32 bit str len
+		if len(buf) < 5 {
+			return nil, 0, errCorrupted
+		}
+		length, n = int(binary.LittleEndian.Uint32(buf[1:5])), 5
+	default:
+		var size int
+		switch header {
+		case 0xf1:
+			size = 2
+		case 0xf2:
+			size = 3
+		case 0xf3:
+			size = 4
+		case 0xf4:
+			size = 8
+		default:
+			return nil, 0, errCorrupted
+		}
+		if len(buf) < 1+size {
+			return nil, 0, errCorrupted
+		}
+		v := readIntLE(buf[1 : 1+size])
+		return []byte(strconv.FormatInt(v, 10)), 1 + size, nil
+	}
+	if n+length > len(buf) {
+		return nil, 0, errCorrupted
+	}
+	return buf[n : n+length], n + length, nil
+}
+
+// listPackBackLen returns bytes used by backlen of an entry whose encoding and data take n bytes
+func listPackBackLen(n int) int {
+	switch {
+	case n <= 127:
+		return 1
+	case n < 16383:
+		return 2
+	case n < 2097151:
+		return 3
+	case n < 268435455:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// parseIntSet reads all members of an intset
+func parseIntSet(buf []byte) ([][]byte, error) {
+	if len(buf) < 8 {
+		return nil, errCorrupted
+	}
+	width := int(binary.LittleEndian.Uint32(buf[0:4]))
+	size := int(binary.LittleEndian.Uint32(buf[4:8]))
+	if width != 2 && width != 4 && width != 8 || len(buf) < 8+width*size {
+		return nil, errCorrupted
+	}
+	values := make([][]byte, 0, size)
+	for i := 0; i < size; i++ {
+		pos := 8 + i*width
+		v := readIntLE(buf[pos : pos+width])
+		values = append(values, []byte(strconv.FormatInt(v, 10)))
+	}
+	return values, nil
+}
+
+// parseZipMap reads all fields and values of a zipmap
+func parseZipMap(buf []byte) ([][]byte, error) {
+	if len(buf) < 1 {
+		return nil, errCorrupted
+	}
+	var values [][]byte
+	pos := 1
+	readLen := func() (int, bool) {
+		if pos >= len(buf) || buf[pos] == 0xff {
+			return 0, false
+		}
+		if buf[pos] < 254 {
+			pos++
+			return int(buf[pos-1]), true
+		}
+		if pos+5 > len(buf) {
+			return 0, false
+		}
+		l := int(binary.LittleEndian.Uint32(buf[pos+1 : pos+5]))
+		pos += 5
+		return l, true
+	}
+	for {
+		keyLen, ok := readLen()
+		if !ok {
+			return values, nil
+		}
+		if pos+keyLen > len(buf) {
+			return nil, errCorrupted
+		}
+		key := buf[pos : pos+keyLen]
+		pos += keyLen
+		valLen, ok := readLen()
+		if !ok || pos >= len(buf) {
+			return nil, errCorrupted
+		}
+		free := int(buf[pos])
+		pos++
+		if pos+valLen+free > len(buf) {
+			return nil, errCorrupted
+		}
+		values = append(values, key, buf[pos:pos+valLen])
+		pos += valLen + free
+	}
+}
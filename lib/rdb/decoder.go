@@ -0,0 +1,431 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Decoder reads objects from rdb format, including compact encodings (ziplist, listpack, intset ...)
+// generated by real redis
+type Decoder struct {
+	r   *bufio.Reader
+	buf [8]byte
+}
+
+// NewDecoder creates a Decoder reading from r
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Parse reads rdb and passes every object to cb, stops if cb returns false
+func (dec *Decoder) Parse(cb func(obj *Object) bool) error {
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(dec.r, header); err != nil {
+		return err
+	}
+	if string(header[:5]) != magic {
+		return errors.New("invalid rdb file: wrong magic number")
+	}
+	if _, err := strconv.Atoi(string(header[5:])); err != nil {
+		return errors.New("invalid rdb file: wrong version")
+	}
+
+	dbIndex := 0
+	var expiration *time.Time
+	for {
+		opCode, err := dec.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch opCode {
+		case opCodeEOF:
+			return nil
+		case opCodeSelectDB:
+			index, _, err := dec.readLength()
+			if err != nil {
+				return err
+			}
+			dbIndex = int(index)
+		case opCodeResizeDB:
+			if _, _, err = dec.readLength(); err != nil {
+				return err
+			}
+			if _, _, err = dec.readLength(); err != nil {
+				return err
+			}
+		case opCodeAux:
+			if _, err = dec.readString(); err != nil {
+				return err
+			}
+			if _, err = dec.readString(); err != nil {
+				return err
+			}
+		case opCodeExpireTimeMs:
+			if _, err = io.ReadFull(dec.r, dec.buf[:8]); err != nil {
+				return err
+			}
+			t := time.UnixMilli(int64(binary.LittleEndian.Uint64(dec.buf[:8])))
+			expiration = &t
+		case opCodeExpireTime:
+			if _, err = io.ReadFull(dec.r, dec.buf[:4]); err != nil {
+				return err
+			}
+			t := time.Unix(int64(binary.LittleEndian.Uint32(dec.buf[:4])), 0)
+			expiration = &t
+		case opCodeFreq:
+			if _, err = dec.r.ReadByte(); err != nil {
+				return err
+			}
+		case opCodeIdle:
+			if _, _, err = dec.readLength(); err != nil {
+				return err
+			}
+		case opCodeFunction2:
+			if _, err = dec.readString(); err != nil {
+				return err
+			}
+		case opCodeModuleAux:
+			return errors.New("module aux data is not supported")
+		default:
+			key, err := dec.readString()
+			if err != nil {
+				return err
+			}
+			obj := &Object{
+				DB:         dbIndex,
+				Key:        string(key),
+				Expiration: expiration,
+			}
+			if err = dec.readObject(opCode, obj); err != nil {
+				return fmt.Errorf("read object %s failed: %v", key, err)
+			}
+			expiration = nil
+			if !cb(obj) {
+				return nil
+			}
+		}
+	}
+}
+
+func (dec *Decoder) readObject(typ byte, obj *Object) error {
+	var err error
+	switch typ {
+	case typeString:
+		obj.Type = StringType
+		obj.Value, err = dec.readString()
+	case typeList, typeSet:
+		obj.Type = ListType
+		if typ == typeSet {
+			obj.Type = SetType
+		}
+		obj.Value, err = dec.readList()
+	case typeHash:
+		obj.Type = HashType
+		obj.Value, err = dec.readHash()
+	case typeZSet, typeZSet2:
+		obj.Type = ZSetType
+		obj.Value, err = dec.readZSet(typ == typeZSet2)
+	case typeListZipList:
+		obj.Type = ListType
+		obj.Value, err = dec.readEncoded(parseZipList)
+	case typeListQuickList:
+		obj.Type = ListType
+		obj.Value, err = dec.readQuickList()
+	case typeListQuickList2:
+		obj.Type = ListType
+		obj.Value, err = dec.readQuickList2()
+	case typeSetIntSet:
+		obj.Type = SetType
+		obj.Value, err = dec.readEncoded(parseIntSet)
+	case typeSetListPack:
+		obj.Type = SetType
+		obj.Value, err = dec.readEncoded(parseListPack)
+	case typeHashZipMap:
+		obj.Type = HashType
+		var values [][]byte
+		if values, err = dec.readEncoded(parseZipMap); err == nil {
+			obj.Value, err = pairsToHash(values)
+		}
+	case typeHashZipList, typeHashListPack:
+		obj.Type = HashType
+		parse := parseZipList
+		if typ == typeHashListPack {
+			parse = parseListPack
+		}
+		var values [][]byte
+		if values, err = dec.readEncoded(parse); err == nil {
+			obj.Value, err = pairsToHash(values)
+		}
+	case typeZSetZipList, typeZSetListPack:
+		obj.Type = ZSetType
+		parse := parseZipList
+		if typ == typeZSetListPack {
+			parse = parseListPack
+		}
+		var values [][]byte
+		if values, err = dec.readEncoded(parse); err == nil {
+			obj.Value, err = pairsToZSet(values)
+		}
+	default:
+		return fmt.Errorf("unsupported object type %d", typ)
+	}
+	return err
+}
+
+// readLength returns length, and whether it's a special encoded string
+func (dec *Decoder) readLength() (uint64, bool, error) {
+	first, err := dec.r.ReadByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch first >> 6 {
+	case len6Bit:
+		return uint64(first & 0x3f), false, nil
+	case len14Bit:
+		next, err := dec.r.ReadByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(first&0x3f)<<8 | uint64(next), false, nil
+	case len32Or64Bit:
+		if first == len32Bit {
+			if _, err = io.ReadFull(dec.r, dec.buf[:4]); err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(dec.buf[:4])), false, nil
+		} else if first == len64Bit {
+			if _, err = io.ReadFull(dec.r, dec.buf[:8]); err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(dec.buf[:8]), false, nil
+		}
+		return 0, false, fmt.Errorf("illegal length encoding: %x", first)
+	default:
+		return uint64(first & 0x3f), true, nil
+	}
+}
+
+func (dec *Decoder) readString() ([]byte, error) {
+	length, special, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if !special {
+		s := make([]byte, length)
+		_, err = io.ReadFull(dec.r, s)
+		return s, err
+	}
+	switch length {
+	case encodeInt8:
+		b, err := dec.r.ReadByte()
+		return []byte(strconv.Itoa(int(int8(b)))), err
+	case encodeInt16:
+		if _, err = io.ReadFull(dec.r, dec.buf[:2]); err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int16(binary.LittleEndian.Uint16(dec.buf[:2]))))), nil
+	case encodeInt32:
+		if _, err = io.ReadFull(dec.r, dec.buf[:4]); err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int32(binary.LittleEndian.Uint32(dec.buf[:4]))))), nil
+	case encodeLZF:
+		return dec.readLZF()
+	}
+	return nil, fmt.Errorf("unknown string encoding: %d", length)
+}
+
+func (dec *Decoder) readLZF() ([]byte, error) {
+	compressedLen, _, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	rawLen, _, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	compressed := make([]byte, compressedLen)
+	if _, err = io.ReadFull(dec.r, compressed); err != nil {
+		return nil, err
+	}
+	return lzfDecompress(compressed, int(rawLen))
+}
+
+func (dec *Decoder) readList() ([][]byte, error) {
+	size, _, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	values := make([][]byte, 0, size)
+	for i := uint64(0); i < size; i++ {
+		v, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func (dec *Decoder) readHash() (map[string][]byte, error) {
+	size, _, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	hash := make(map[string][]byte, size)
+	for i := uint64(0); i < size; i++ {
+		field, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		value, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		hash[string(field)] = value
+	}
+	return hash, nil
+}
+
+func (dec *Decoder) readZSet(binaryScore bool) ([]*ZSetEntry, error) {
+	size, _, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*ZSetEntry, 0, size)
+	for i := uint64(0); i < size; i++ {
+		member, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		var score float64
+		if binaryScore {
+			score, err = dec.readBinaryDouble()
+		} else {
+			score, err = dec.readDouble()
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &ZSetEntry{
+			Member: string(member),
+			Score:  score,
+		})
+	}
+	return entries, nil
+}
+
+func (dec *Decoder) readBinaryDouble() (float64, error) {
+	if _, err := io.ReadFull(dec.r, dec.buf[:8]); err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(dec.buf[:8])), nil
+}
+
+// readDouble reads a double in string representation, used by legacy zset encoding
+func (dec *Decoder) readDouble() (float64, error) {
+	length, err := dec.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	switch length {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	s := make([]byte, length)
+	if _, err = io.ReadFull(dec.r, s); err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(s), 64)
+}
+
+func (dec *Decoder) readEncoded(parse func([]byte) ([][]byte, error)) ([][]byte, error) {
+	buf, err := dec.readString()
+	if err != nil {
+		return nil, err
+	}
+	return parse(buf)
+}
+
+func (dec *Decoder) readQuickList() ([][]byte, error) {
+	size, _, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	var values [][]byte
+	for i := uint64(0); i < size; i++ {
+		node, err := dec.readEncoded(parseZipList)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, node...)
+	}
+	return values, nil
+}
+
+func (dec *Decoder) readQuickList2() ([][]byte, error) {
+	size, _, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	var values [][]byte
+	for i := uint64(0); i < size; i++ {
+		container, _, err := dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+		buf, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		if container == quickListNodePlain {
+			values = append(values, buf)
+			continue
+		}
+		node, err := parseListPack(buf)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, node...)
+	}
+	return values, nil
+}
+
+func pairsToHash(values [][]byte) (map[string][]byte, error) {
+	if len(values)%2 != 0 {
+		return nil, errors.New("odd number of hash elements")
+	}
+	hash := make(map[string][]byte, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		hash[string(values[i])] = values[i+1]
+	}
+	return hash, nil
+}
+
+func pairsToZSet(values [][]byte) ([]*ZSetEntry, error) {
+	if len(values)%2 != 0 {
+		return nil, errors.New("odd number of zset elements")
+	}
+	entries := make([]*ZSetEntry, 0, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		score, err := strconv.ParseFloat(string(values[i+1]), 64)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &ZSetEntry{
+			Member: string(values[i]),
+			Score:  score,
+		})
+	}
+	return entries, nil
+}
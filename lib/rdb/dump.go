@@ -0,0 +1,73 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// maxDumpVersion is the latest rdb version whose encodings could be decoded, payload of newer redis is rejected
+const maxDumpVersion = 11
+
+// dumpFooterSize is size of rdb version and crc64 checksum at the end of DUMP payload
+const dumpFooterSize = 10
+
+// ErrInvalidDump is returned if version or checksum of DUMP payload is wrong
+var ErrInvalidDump = errors.New("DUMP payload version or checksum are wrong")
+
+// DumpValue serializes value of obj in the format of redis DUMP:
+// type code, value, rdb version and crc64 checksum of all above, key and expiration are not included
+func DumpValue(obj *Object) ([]byte, error) {
+	typ, err := objectType(obj)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	enc := NewEncoder(buf)
+	if err = enc.writeByte(typ); err != nil {
+		return nil, err
+	}
+	if err = enc.writeValue(obj); err != nil {
+		return nil, err
+	}
+	return SealDump(buf.Bytes()), nil
+}
+
+// SealDump appends rdb version and checksum to body of DUMP payload
+func SealDump(body []byte) []byte {
+	payload := make([]byte, len(body)+dumpFooterSize)
+	copy(payload, body)
+	binary.LittleEndian.PutUint16(payload[len(body):], version)
+	binary.LittleEndian.PutUint64(payload[len(body)+2:], crc64Update(0, payload[:len(body)+2]))
+	return payload
+}
+
+// OpenDump verifies version and checksum of DUMP payload, returns the body without footer
+func OpenDump(payload []byte) ([]byte, error) {
+	if len(payload) <= dumpFooterSize {
+		return nil, ErrInvalidDump
+	}
+	footer := payload[len(payload)-dumpFooterSize:]
+	if binary.LittleEndian.Uint16(footer[:2]) > maxDumpVersion {
+		return nil, ErrInvalidDump
+	}
+	if binary.LittleEndian.Uint64(footer[2:]) != crc64Update(0, payload[:len(payload)-8]) {
+		return nil, ErrInvalidDump
+	}
+	return payload[:len(payload)-dumpFooterSize], nil
+}
+
+// RestoreValue deserializes payload generated by DumpValue or redis DUMP,
+// key and expiration of the returned object are not set
+func RestoreValue(payload []byte) (*Object, error) {
+	body, err := OpenDump(payload)
+	if err != nil {
+		return nil, err
+	}
+	obj := &Object{}
+	dec := NewDecoder(bytes.NewReader(body[1:]))
+	if err = dec.readObject(body[0], obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
@@ -0,0 +1,265 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Encoder writes objects into rdb format
+type Encoder struct {
+	w   io.Writer
+	crc uint64
+	buf [9]byte
+}
+
+// NewEncoder creates an Encoder writing into w
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+func (enc *Encoder) write(p []byte) error {
+	enc.crc = crc64Update(enc.crc, p)
+	_, err := enc.w.Write(p)
+	return err
+}
+
+func (enc *Encoder) writeByte(b byte) error {
+	enc.buf[0] = b
+	return enc.write(enc.buf[:1])
+}
+
+// WriteHeader writes magic number, version and aux fields
+func (enc *Encoder) WriteHeader() error {
+	if err := enc.write([]byte(magic + "000" + strconv.Itoa(version))); err != nil {
+		return err
+	}
+	aux := [][2]string{
+		{"redis-bits", "64"},
+		{"ctime", strconv.FormatInt(time.Now().Unix(), 10)},
+	}
+	for _, kv := range aux {
+		if err := enc.WriteAux(kv[0], kv[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteAux writes an aux field
+func (enc *Encoder) WriteAux(key, value string) error {
+	if err := enc.writeByte(opCodeAux); err != nil {
+		return err
+	}
+	if err := enc.writeString([]byte(key)); err != nil {
+		return err
+	}
+	return enc.writeString([]byte(value))
+}
+
+// WriteDBHeader selects database and writes size hints of it
+func (enc *Encoder) WriteDBHeader(dbIndex int, keyCount, ttlCount int) error {
+	if err := enc.writeByte(opCodeSelectDB); err != nil {
+		return err
+	}
+	if err := enc.writeLength(uint64(dbIndex)); err != nil {
+		return err
+	}
+	if err := enc.writeByte(opCodeResizeDB); err != nil {
+		return err
+	}
+	if err := enc.writeLength(uint64(keyCount)); err != nil {
+		return err
+	}
+	return enc.writeLength(uint64(ttlCount))
+}
+
+// WriteObject writes a key-value pair with its expiration
+func (enc *Encoder) WriteObject(obj *Object) error {
+	if obj.Expiration != nil {
+		if err := enc.writeByte(opCodeExpireTimeMs); err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint64(enc.buf[:8], uint64(obj.Expiration.UnixMilli()))
+		if err := enc.write(enc.buf[:8]); err != nil {
+			return err
+		}
+	}
+	typ, err := objectType(obj)
+	if err != nil {
+		return err
+	}
+	if err = enc.writeObjectHeader(typ, obj.Key); err != nil {
+		return err
+	}
+	return enc.writeValue(obj)
+}
+
+// objectType returns type code of obj written before key
+func objectType(obj *Object) (byte, error) {
+	switch obj.Type {
+	case StringType:
+		return typeString, nil
+	case ListType:
+		return typeList, nil
+	case SetType:
+		return typeSet, nil
+	case HashType:
+		return typeHash, nil
+	case ZSetType:
+		return typeZSet2, nil
+	}
+	return 0, errors.New("unknown object type: " + obj.Type)
+}
+
+// writeValue writes value of obj without type code and key
+func (enc *Encoder) writeValue(obj *Object) error {
+	switch obj.Type {
+	case StringType:
+		return enc.writeStringValue(obj)
+	case ListType, SetType:
+		return enc.writeListValue(obj)
+	case HashType:
+		return enc.writeHashValue(obj)
+	case ZSetType:
+		return enc.writeZSetValue(obj)
+	}
+	return errors.New("unknown object type: " + obj.Type)
+}
+
+// WriteEnd writes EOF and checksum
+func (enc *Encoder) WriteEnd() error {
+	if err := enc.writeByte(opCodeEOF); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint64(enc.buf[:8], enc.crc)
+	_, err := enc.w.Write(enc.buf[:8])
+	return err
+}
+
+func (enc *Encoder) writeObjectHeader(typ byte, key string) error {
+	if err := enc.writeByte(typ); err != nil {
+		return err
+	}
+	return enc.writeString([]byte(key))
+}
+
+func (enc *Encoder) writeStringValue(obj *Object) error {
+	value, ok := obj.Value.([]byte)
+	if !ok {
+		return errors.New("string object requires []byte value")
+	}
+	return enc.writeString(value)
+}
+
+func (enc *Encoder) writeListValue(obj *Object) error {
+	values, ok := obj.Value.([][]byte)
+	if !ok {
+		return errors.New(obj.Type + " object requires [][]byte value")
+	}
+	if err := enc.writeLength(uint64(len(values))); err != nil {
+		return err
+	}
+	for _, v := range values {
+		if err := enc.writeString(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (enc *Encoder) writeHashValue(obj *Object) error {
+	hash, ok := obj.Value.(map[string][]byte)
+	if !ok {
+		return errors.New("hash object requires map[string][]byte value")
+	}
+	if err := enc.writeLength(uint64(len(hash))); err != nil {
+		return err
+	}
+	for field, value := range hash {
+		if err := enc.writeString([]byte(field)); err != nil {
+			return err
+		}
+		if err := enc.writeString(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (enc *Encoder) writeZSetValue(obj *Object) error {
+	entries, ok := obj.Value.([]*ZSetEntry)
+	if !ok {
+		return errors.New("zset object requires []*ZSetEntry value")
+	}
+	if err := enc.writeLength(uint64(len(entries))); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := enc.writeString([]byte(e.Member)); err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint64(enc.buf[:8], math.Float64bits(e.Score))
+		if err := enc.write(enc.buf[:8]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (enc *Encoder) writeLength(length uint64) error {
+	var n int
+	switch {
+	case length < 1<<6:
+		enc.buf[0] = byte(length)
+		n = 1
+	case length < 1<<14:
+		enc.buf[0] = byte(length>>8) | len14Bit<<6
+		enc.buf[1] = byte(length)
+		n = 2
+	case length <= math.MaxUint32:
+		enc.buf[0] = len32Bit
+		binary.BigEndian.PutUint32(enc.buf[1:5], uint32(length))
+		n = 5
+	default:
+		enc.buf[0] = len64Bit
+		binary.BigEndian.PutUint64(enc.buf[1:9], length)
+		n = 9
+	}
+	return enc.write(enc.buf[:n])
+}
+
+// writeString writes a string, using integer encoding if possible like redis does
+func (enc *Encoder) writeString(s []byte) error {
+	if len(s) > 0 && len(s) <= 11 {
+		if v, err := strconv.ParseInt(string(s), 10, 32); err == nil && strconv.FormatInt(v, 10) == string(s) {
+			return enc.writeInt(v)
+		}
+	}
+	if err := enc.writeLength(uint64(len(s))); err != nil {
+		return err
+	}
+	return enc.write(s)
+}
+
+func (enc *Encoder) writeInt(v int64) error {
+	var n int
+	switch {
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		enc.buf[0] = lenSpecial<<6 | encodeInt8
+		enc.buf[1] = byte(int8(v))
+		n = 2
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		enc.buf[0] = lenSpecial<<6 | encodeInt16
+		binary.LittleEndian.PutUint16(enc.buf[1:3], uint16(int16(v)))
+		n = 3
+	default:
+		enc.buf[0] = lenSpecial<<6 | encodeInt32
+		binary.LittleEndian.PutUint32(enc.buf[1:5], uint32(int32(v)))
+		n = 5
+	}
+	return enc.write(enc.buf[:n])
+}
@@ -0,0 +1,95 @@
+package rdb
+
+import (
+	"hash/crc64"
+	"time"
+)
+
+// value types of rdb objects
+const (
+	StringType = "string"
+	ListType   = "list"
+	SetType    = "set"
+	HashType   = "hash"
+	ZSetType   = "zset"
+)
+
+const (
+	magic   = "REDIS"
+	version = 9
+)
+
+// object type codes
+const (
+	typeString          = 0
+	typeList            = 1
+	typeSet             = 2
+	typeZSet            = 3
+	typeHash            = 4
+	typeZSet2           = 5
+	typeHashZipMap      = 9
+	typeListZipList     = 10
+	typeSetIntSet       = 11
+	typeZSetZipList     = 12
+	typeHashZipList     = 13
+	typeListQuickList   = 14
+	typeHashListPack    = 16
+	typeZSetListPack    = 17
+	typeListQuickList2  = 18
+	typeSetListPack     = 20
+	quickListNodePlain  = 1
+	quickListNodePacked = 2
+)
+
+// op codes
+const (
+	opCodeFunction2    = 245
+	opCodeModuleAux    = 247
+	opCodeIdle         = 248
+	opCodeFreq         = 249
+	opCodeAux          = 250
+	opCodeResizeDB     = 251
+	opCodeExpireTimeMs = 252
+	opCodeExpireTime   = 253
+	opCodeSelectDB     = 254
+	opCodeEOF          = 255
+)
+
+// length and string encodings
+const (
+	len6Bit      = 0
+	len14Bit     = 1
+	len32Or64Bit = 2
+	lenSpecial   = 3
+	len32Bit     = 0x80
+	len64Bit     = 0x81
+	encodeInt8   = 0
+	encodeInt16  = 1
+	encodeInt32  = 2
+	encodeLZF    = 3
+)
+
+// ZSetEntry is a member of sorted set with its score
+type ZSetEntry struct {
+	Member string
+	Score  float64
+}
+
+// Object is a key-value pair stored in rdb file
+// Value is []byte for string, [][]byte for list and set,
+// map[string][]byte for hash and []*ZSetEntry for zset
+type Object struct {
+	DB         int
+	Key        string
+	Type       string
+	Value      interface{}
+	Expiration *time.Time
+}
+
+// crcTable uses the Jones polynomial, same as redis
+var crcTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+// crc64Update computes redis style crc64, which has no initial or final inversion
+func crc64Update(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, crcTable, p)
+}
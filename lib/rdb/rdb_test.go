@@ -0,0 +1,113 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCrc64(t *testing.T) {
+	assert.Equal(t, uint64(0xe9c6d914c4b8d9ca), crc64Update(0, []byte("123456789")))
+}
+
+func TestEncodeAndDecode(t *testing.T) {
+	expireAt := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli())
+	objects := []*Object{
+		{DB: 0, Key: "str", Type: StringType, Value: []byte("ringodis")},
+		{DB: 0, Key: "int", Type: StringType, Value: []byte("-20000"), Expiration: &expireAt},
+		{DB: 0, Key: "empty", Type: StringType, Value: []byte{}},
+		{DB: 1, Key: "list", Type: ListType, Value: [][]byte{[]byte("a"), []byte("1")}},
+		{DB: 1, Key: "set", Type: SetType, Value: [][]byte{[]byte("a")}},
+		{DB: 1, Key: "hash", Type: HashType, Value: map[string][]byte{"f": []byte("v")}},
+		{DB: 2, Key: "zset", Type: ZSetType, Value: []*ZSetEntry{{Member: "m", Score: 1.5}}},
+	}
+	buf := &bytes.Buffer{}
+	enc := NewEncoder(buf)
+	assert.Nil(t, enc.WriteHeader())
+	lastDB := -1
+	for _, obj := range objects {
+		if obj.DB != lastDB {
+			assert.Nil(t, enc.WriteDBHeader(obj.DB, 1, 0))
+			lastDB = obj.DB
+		}
+		assert.Nil(t, enc.WriteObject(obj))
+	}
+	assert.Nil(t, enc.WriteEnd())
+
+	data := buf.Bytes()
+	checksum := binary.LittleEndian.Uint64(data[len(data)-8:])
+	assert.Equal(t, crc64Update(0, data[:len(data)-8]), checksum)
+
+	var decoded []*Object
+	err := NewDecoder(bytes.NewReader(data)).Parse(func(obj *Object) bool {
+		decoded = append(decoded, obj)
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, objects, decoded)
+}
+
+func TestCompactEncodings(t *testing.T) {
+	// ziplist of "ab", 12, 1000
+	zipList := []byte{
+		0, 0, 0, 0, 0, 0, 0, 0, 3, 0,
+		0, 0x02, 'a', 'b',
+		4, 0xfd,
+		2, 0xc0, 0xe8, 0x03,
+		0xff,
+	}
+	values, err := parseZipList(zipList)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("ab"), []byte("12"), []byte("1000")}, values)
+
+	// listpack of "ab", 5, -1
+	listPack := []byte{
+		0, 0, 0, 0, 3, 0,
+		0x82, 'a', 'b', 3,
+		0x05, 1,
+		0xdf, 0xff, 2,
+		0xff,
+	}
+	values, err = parseListPack(listPack)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("ab"), []byte("5"), []byte("-1")}, values)
+
+	// intset of 16 bit integers
+	intSet := []byte{2, 0, 0, 0, 2, 0, 0, 0, 0xff, 0xff, 0x10, 0x00}
+	values, err = parseIntSet(intSet)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("-1"), []byte("16")}, values)
+
+	out, err := lzfDecompress([]byte{0x00, 'a', 0xe0, 0x00, 0x00}, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, "aaaaaaaaaa", string(out))
+}
+
+func TestDumpAndRestore(t *testing.T) {
+	objects := []*Object{
+		{Type: StringType, Value: []byte("ringodis")},
+		{Type: ListType, Value: [][]byte{[]byte("a"), []byte("1")}},
+		{Type: SetType, Value: [][]byte{[]byte("a")}},
+		{Type: HashType, Value: map[string][]byte{"f": []byte("v")}},
+		{Type: ZSetType, Value: []*ZSetEntry{{Member: "m", Score: 1.5}}},
+	}
+	for _, obj := range objects {
+		payload, err := DumpValue(obj)
+		assert.Nil(t, err)
+		restored, err := RestoreValue(payload)
+		assert.Nil(t, err)
+		assert.Equal(t, obj, restored)
+	}
+	// example of DUMP in redis documents, value 10 is encoded as integer
+	restored, err := RestoreValue([]byte("\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("10"), restored.Value)
+
+	payload, _ := DumpValue(objects[0])
+	payload[1] ^= 1
+	_, err = RestoreValue(payload)
+	assert.Equal(t, ErrInvalidDump, err)
+}
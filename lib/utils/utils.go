@@ -62,9 +62,10 @@ func BytesEquals(a []byte, b []byte) bool {
 // -1 => size-1
 // both inclusive [0, 10] => left inclusive right exclusive [0, 9)
 // out of bound to max inbound [size, size+1] => [-1, -1]
+// start before the first element is treated as 0, like redis
 func ConvertRange(start int64, end int64, size int64) (int, int) {
 	if start < -size {
-		return -1, -1
+		start = 0
 	} else if start < 0 {
 		start = size + start
 	} else if start >= size {
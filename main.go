@@ -2,10 +2,12 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"ringodis/config"
 	"ringodis/lib/logger"
 	"ringodis/resp/server"
 	"ringodis/tcp"
+	"strconv"
 )
 
 const configFile string = "ringodis.conf"
@@ -20,13 +22,30 @@ func main() {
 
 	config.SetupConfig(configFile)
 
-	err := tcp.ListenAndServeWithSignal(
-		&tcp.Config{
-			Address: fmt.Sprintf("%s:%d", config.Properties.Bind,
-				config.Properties.Port),
-		},
-		server.MakeHandler(),
-	)
+	cfg := &tcp.Config{}
+	// port 0 disables plaintext connections, like redis
+	if config.Properties.Port != 0 {
+		cfg.Address = fmt.Sprintf("%s:%d", config.Properties.Bind, config.Properties.Port)
+	}
+	if config.Properties.UnixSocket != "" {
+		cfg.UnixSocket = config.Properties.UnixSocket
+		if perm := config.Properties.UnixSocketPerm; perm != "" {
+			mode, err := strconv.ParseUint(perm, 8, 32)
+			if err != nil {
+				logger.Fatal("invalid unixsocketperm: " + perm)
+			}
+			cfg.UnixSocketPerm = os.FileMode(mode)
+		}
+	}
+	if config.Properties.TLSPort != 0 {
+		tlsConfig, err := config.MakeServerTLSConfig()
+		if err != nil {
+			logger.Fatal(err)
+		}
+		cfg.TLSAddress = fmt.Sprintf("%s:%d", config.Properties.Bind, config.Properties.TLSPort)
+		cfg.TLSConfig = tlsConfig
+	}
+	err := tcp.ListenAndServeWithSignal(cfg, server.MakeHandler())
 	if err != nil {
 		logger.Error(err)
 	}
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"crypto/tls"
 	"errors"
 	"net"
 	"ringodis/interface/resp"
@@ -17,8 +18,13 @@ import (
 
 // Client is a pipeline mode redis client
 type Client struct {
-	conn        net.Conn
-	addr        string
+	conn net.Conn
+	addr string
+	// username and password are sent by AUTH after connected, empty password means no authentication
+	username string
+	password string
+	// tlsConfig is used to dial by TLS if it is not nil
+	tlsConfig   *tls.Config
 	pendingReqs chan *request // pending to send
 	waitingReqs chan *request // waiting for response
 	status      int32
@@ -45,13 +51,28 @@ const (
 )
 
 func MakeClient(addr string) (*Client, error) {
-	conn, err := net.Dial("tcp", addr)
+	return MakeAuthClient(addr, "", "")
+}
+
+// MakeAuthClient makes client authenticated by password, which is sent again after reconnected,
+// default user is used if username is empty
+func MakeAuthClient(addr string, username string, password string) (*Client, error) {
+	return MakeTLSClient(addr, username, password, nil)
+}
+
+// MakeTLSClient makes client connected by TLS if tlsConfig is not nil, server name is taken from addr
+// if it is not set in tlsConfig, client certificates in tlsConfig are presented for mutual TLS
+func MakeTLSClient(addr string, username string, password string, tlsConfig *tls.Config) (*Client, error) {
+	conn, err := dial(addr, username, password, tlsConfig)
 	if err != nil {
 		return nil, err
 	}
 	return &Client{
 		conn:        conn,
 		addr:        addr,
+		username:    username,
+		password:    password,
+		tlsConfig:   tlsConfig,
 		pendingReqs: make(chan *request, chanSize),
 		waitingReqs: make(chan *request, chanSize),
 		working:     &sync.WaitGroup{},
@@ -64,8 +85,11 @@ func (client *Client) Start() {
 	atomic.StoreInt32(&client.status, running)
 }
 
+// Close stops the client, it may be called more than once
 func (client *Client) Close() {
-	atomic.StoreInt32(&client.status, closed)
+	if atomic.SwapInt32(&client.status, closed) == closed {
+		return
+	}
 	close(client.pendingReqs)
 	client.working.Wait()
 	_ = client.conn.Close()
@@ -79,7 +103,7 @@ func (client *Client) reconnect() {
 	var conn net.Conn
 	for i := 0; i < 3; i++ {
 		var err error
-		conn, err = net.Dial("tcp", client.addr)
+		conn, err = dial(client.addr, client.username, client.password, client.tlsConfig)
 		if err != nil {
 			logger.Error("reconnect error: " + err.Error())
 			time.Sleep(time.Second)
@@ -102,6 +126,45 @@ func (client *Client) reconnect() {
 	go client.handleRead()
 }
 
+// dial connects to addr and sends AUTH before any other request
+func dial(addr string, username string, password string, tlsConfig *tls.Config) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if tlsConfig != nil {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: maxWait}, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = net.Dial("tcp", addr)
+	}
+	if err != nil || password == "" {
+		return conn, err
+	}
+	args := [][]byte{[]byte("AUTH"), []byte(password)}
+	if username != "" {
+		args = [][]byte{[]byte("AUTH"), []byte(username), []byte(password)}
+	}
+	if _, err = conn.Write(reply.MakeMultiBulkReply(args).ToBytes()); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	// reply of AUTH is a single line, read byte by byte so that nothing after it is consumed
+	_ = conn.SetReadDeadline(time.Now().Add(maxWait))
+	var line []byte
+	buf := make([]byte, 1)
+	for len(line) == 0 || line[len(line)-1] != '\n' {
+		if _, err = conn.Read(buf); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		line = append(line, buf[0])
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	if line[0] == '-' {
+		_ = conn.Close()
+		return nil, errors.New(strings.TrimSpace(string(line[1:])))
+	}
+	return conn, nil
+}
+
 func (client *Client) Send(args [][]byte) resp.Reply {
 	if atomic.LoadInt32(&client.status) != running {
 		return reply.MakeErrReply("client closed")
@@ -2,11 +2,17 @@ package conn
 
 import (
 	"net"
+	"ringodis/interface/resp"
+	"ringodis/lib/logger"
+	"ringodis/lib/sync/atomic"
 	"ringodis/lib/sync/wait"
 	"sync"
 	"time"
 )
 
+// maxPendingPushes is the max number of pushed messages not written yet, slower clients are disconnected
+const maxPendingPushes = 4096
+
 type Connection struct {
 	conn net.Conn
 
@@ -16,7 +22,32 @@ type Connection struct {
 	// lock while server sending response
 	mu sync.Mutex
 
+	// pushQueue holds messages of subscribed channels written by pushLoop, it's made by the first Push.
+	// pushMu guards pushQueue and pushDone, which is closed by Close to stop pushLoop
+	pushMu    sync.Mutex
+	pushQueue chan []byte
+	pushDone  chan struct{}
+
 	selectedDB int
+
+	closed atomic.Boolean
+
+	// authenticated by AUTH or HELLO
+	authenticated bool
+	// ACL user authenticated as
+	user string
+
+	// queued commands for `multi`
+	multiState bool
+	queue      [][][]byte
+	watching   map[resp.WatchedKey]uint32
+	txErrors   []error
+
+	// subscribed channels and patterns
+	subsMu        sync.Mutex
+	subs          map[string]struct{}
+	patterns      map[string]struct{}
+	shardChannels map[string]struct{}
 }
 
 func NewConn(conn net.Conn) *Connection {
@@ -25,31 +56,106 @@ func NewConn(conn net.Conn) *Connection {
 	}
 }
 
-// RemoteAddr returns the remote network address
+// RemoteAddr returns the remote network address, clients of unix socket are usually unnamed like "@",
+// so the path of socket is returned instead like redis
 func (c *Connection) RemoteAddr() net.Addr {
+	if addr, ok := c.conn.RemoteAddr().(*net.UnixAddr); ok && (addr.Name == "" || addr.Name == "@") {
+		return c.conn.LocalAddr()
+	}
 	return c.conn.RemoteAddr()
 }
 
 // Close disconnect with the client
 func (c *Connection) Close() error {
+	c.closed.Set(true)
+	c.pushMu.Lock()
+	if c.pushDone != nil {
+		select {
+		case <-c.pushDone:
+		default:
+			close(c.pushDone)
+		}
+	}
+	c.pushMu.Unlock()
 	c.sendingData.WaitWithTimeout(10 * time.Second)
 	_ = c.conn.Close()
 
 	return nil
 }
 
-// Write sends response to client over tcp conn
+// Write sends response to client over tcp conn, responses are queued behind pushed messages once Push is called
 func (c *Connection) Write(b []byte) (int, error) {
 	if len(b) == 0 {
 		return 0, nil
 	}
+	c.pushMu.Lock()
+	queue, done := c.pushQueue, c.pushDone
+	c.pushMu.Unlock()
+	if queue != nil {
+		select {
+		case queue <- b:
+			return len(b), nil
+		case <-done:
+			return 0, net.ErrClosed
+		}
+	}
+	return c.write(b)
+}
+
+func (c *Connection) write(b []byte) (int, error) {
 	c.sendingData.Add(1)
+	c.mu.Lock()
 	defer func() {
+		c.mu.Unlock()
 		c.sendingData.Done()
 	}()
 	return c.conn.Write(b)
 }
 
+// Push queues message of subscribed channels without blocking publisher,
+// client is disconnected if it doesn't read fast enough and maxPendingPushes messages are pending
+func (c *Connection) Push(b []byte) {
+	c.pushMu.Lock()
+	if c.pushQueue == nil && !c.closed.Get() {
+		c.pushQueue = make(chan []byte, maxPendingPushes)
+		c.pushDone = make(chan struct{})
+		go c.pushLoop(c.pushQueue, c.pushDone)
+	}
+	queue, done := c.pushQueue, c.pushDone
+	c.pushMu.Unlock()
+	if queue == nil {
+		return
+	}
+	select {
+	case queue <- b:
+	case <-done:
+	default:
+		logger.Warn("client " + c.conn.RemoteAddr().String() + " is disconnected as it has too many pending messages")
+		// closing net conn stops reading from client, then the client is closed by handler
+		_ = c.conn.Close()
+	}
+}
+
+// pushLoop writes queued messages and responses in order until connection is closed
+func (c *Connection) pushLoop(queue <-chan []byte, done <-chan struct{}) {
+	for {
+		select {
+		case b := <-queue:
+			if _, err := c.write(b); err != nil {
+				_ = c.conn.Close()
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// IsClosed tells whether Close has been called
+func (c *Connection) IsClosed() bool {
+	return c.closed.Get()
+}
+
 func (c *Connection) GetDBIndex() int {
 	return c.selectedDB
 }
@@ -57,3 +163,172 @@ func (c *Connection) GetDBIndex() int {
 func (c *Connection) SelectDB(db int) {
 	c.selectedDB = db
 }
+
+// SetAuthenticated marks whether the client has passed authentication
+func (c *Connection) SetAuthenticated(authenticated bool) {
+	c.authenticated = authenticated
+}
+
+// IsAuthenticated tells whether the client has passed authentication
+func (c *Connection) IsAuthenticated() bool {
+	return c.authenticated
+}
+
+// SetUser sets the ACL user of connection
+func (c *Connection) SetUser(user string) {
+	c.user = user
+}
+
+// GetUser returns the ACL user of connection
+func (c *Connection) GetUser() string {
+	return c.user
+}
+
+// InMultiState tells whether the connection is in a transaction
+func (c *Connection) InMultiState() bool {
+	return c.multiState
+}
+
+// SetMultiState enters or leaves transaction, leaving clears queued commands and watched keys
+func (c *Connection) SetMultiState(state bool) {
+	if !state {
+		c.watching = nil
+		c.queue = nil
+		c.txErrors = nil
+	}
+	c.multiState = state
+}
+
+// GetQueuedCmdLine returns queued commands of transaction
+func (c *Connection) GetQueuedCmdLine() [][][]byte {
+	return c.queue
+}
+
+// EnqueueCmd queues a command into transaction
+func (c *Connection) EnqueueCmd(cmdLine [][]byte) {
+	c.queue = append(c.queue, cmdLine)
+}
+
+// ClearQueuedCmds clears queued commands of transaction
+func (c *Connection) ClearQueuedCmds() {
+	c.queue = nil
+}
+
+// GetWatching returns watched keys and their versions when watched
+func (c *Connection) GetWatching() map[resp.WatchedKey]uint32 {
+	if c.watching == nil {
+		c.watching = make(map[resp.WatchedKey]uint32)
+	}
+	return c.watching
+}
+
+// AddTxError records a syntax error detected while queuing
+func (c *Connection) AddTxError(err error) {
+	c.txErrors = append(c.txErrors, err)
+}
+
+// GetTxErrors returns errors detected while queuing
+func (c *Connection) GetTxErrors() []error {
+	return c.txErrors
+}
+
+// Subscribe adds channel to subscribed channels
+func (c *Connection) Subscribe(channel string) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	if c.subs == nil {
+		c.subs = make(map[string]struct{})
+	}
+	c.subs[channel] = struct{}{}
+}
+
+// UnSubscribe removes channel from subscribed channels
+func (c *Connection) UnSubscribe(channel string) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	delete(c.subs, channel)
+}
+
+// PSubscribe adds pattern to subscribed patterns
+func (c *Connection) PSubscribe(pattern string) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	if c.patterns == nil {
+		c.patterns = make(map[string]struct{})
+	}
+	c.patterns[pattern] = struct{}{}
+}
+
+// PUnSubscribe removes pattern from subscribed patterns
+func (c *Connection) PUnSubscribe(pattern string) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	delete(c.patterns, pattern)
+}
+
+// SSubscribe adds channel to subscribed shard channels
+func (c *Connection) SSubscribe(channel string) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	if c.shardChannels == nil {
+		c.shardChannels = make(map[string]struct{})
+	}
+	c.shardChannels[channel] = struct{}{}
+}
+
+// SUnSubscribe removes channel from subscribed shard channels
+func (c *Connection) SUnSubscribe(channel string) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	delete(c.shardChannels, channel)
+}
+
+// SubsCount returns number of subscribed channels and patterns
+func (c *Connection) SubsCount() int {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	return len(c.subs) + len(c.patterns)
+}
+
+// ShardSubsCount returns number of subscribed shard channels
+func (c *Connection) ShardSubsCount() int {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	return len(c.shardChannels)
+}
+
+// InSubscribeMode tells whether the connection has subscribed any channel, pattern or shard channel
+func (c *Connection) InSubscribeMode() bool {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	return len(c.subs)+len(c.patterns)+len(c.shardChannels) > 0
+}
+
+// GetChannels returns all subscribed channels
+func (c *Connection) GetChannels() []string {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	return keysOf(c.subs)
+}
+
+// GetPatterns returns all subscribed patterns
+func (c *Connection) GetPatterns() []string {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	return keysOf(c.patterns)
+}
+
+// GetShardChannels returns all subscribed shard channels
+func (c *Connection) GetShardChannels() []string {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	return keysOf(c.shardChannels)
+}
+
+func keysOf(m map[string]struct{}) []string {
+	result := make([]string, 0, len(m))
+	for key := range m {
+		result = append(result, key)
+	}
+	return result
+}
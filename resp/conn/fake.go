@@ -0,0 +1,56 @@
+package conn
+
+import (
+	"bytes"
+	"net"
+)
+
+// FakeConn implements resp.Connection without a real network connection,
+// used for loading persistent data and testing
+type FakeConn struct {
+	Connection
+	buf bytes.Buffer
+}
+
+// NewFakeConn makes an authenticated connection, as it is used internally
+func NewFakeConn() *FakeConn {
+	c := &FakeConn{}
+	c.authenticated = true
+	return c
+}
+
+// Write stores data into buffer instead of sending it
+func (c *FakeConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.Write(b)
+}
+
+// Push writes message into buffer at once, so that tests needn't wait
+func (c *FakeConn) Push(b []byte) {
+	_, _ = c.Write(b)
+}
+
+// Clean resets the buffer
+func (c *FakeConn) Clean() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.buf.Reset()
+}
+
+// Bytes returns a copy of written data
+func (c *FakeConn) Bytes() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]byte(nil), c.buf.Bytes()...)
+}
+
+// RemoteAddr returns nil as there is no peer
+func (c *FakeConn) RemoteAddr() net.Addr {
+	return nil
+}
+
+// Close does nothing
+func (c *FakeConn) Close() error {
+	return nil
+}
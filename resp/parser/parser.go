@@ -2,6 +2,7 @@ package parser
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"io"
 	"ringodis/interface/resp"
@@ -26,6 +27,7 @@ type readState struct {
 	msgType           byte
 	args              [][]byte
 	bulkLen           int64
+	bulkBody          bool // next line is the body of a bulk string
 }
 
 func makeReadState() *readState {
@@ -38,6 +40,7 @@ func (s *readState) reset() {
 	s.msgType = 0
 	s.args = nil
 	s.bulkLen = 0
+	s.bulkBody = false
 }
 
 func (s *readState) parsed() bool {
@@ -51,6 +54,18 @@ func ParseStream(reader io.Reader) <-chan *Payload {
 	return ch
 }
 
+// ParseOne parses the first reply in data, e.g. a reply encoded by ToBytes
+func ParseOne(data []byte) (resp.Reply, error) {
+	ch := ParseStream(bytes.NewReader(data))
+	payload := <-ch
+	// parser goroutine exits after reporting EOF
+	go func() {
+		for range ch {
+		}
+	}()
+	return payload.Data, payload.Err
+}
+
 // parse0 is the main parser
 func parse0(reader io.Reader, ch chan<- *Payload) {
 	defer func() {
@@ -189,6 +204,7 @@ func parseBulkHeader(msg []byte, state *readState) error {
 		state.readingMultiLine = true
 		state.expectedArgsCount = 1
 		state.args = make([][]byte, 0, 1)
+		state.bulkBody = true
 		return nil
 	} else {
 		return errors.New(pErr + string(msg))
@@ -217,14 +233,23 @@ func parseSingleLineReply(msg []byte) (resp.Reply, error) {
 // parseBody read the non-first lines of multi bulk reply or bulk reply
 func parseBody(msg []byte, state *readState) error {
 	line := msg[0 : len(msg)-2]
+	if state.bulkBody {
+		// body may be empty or start with '$', never treat it as a header
+		state.args = append(state.args, line)
+		state.bulkBody = false
+		return nil
+	}
 	var err error
-	if line[0] == '$' {
+	if len(line) > 0 && line[0] == '$' {
 		if state.bulkLen, err = strconv.ParseInt(string(line[1:]), 10, 64); err != nil {
 			return errors.New(pErr + string(msg))
 		}
-		if state.bulkLen <= 0 {
-			state.args = append(state.args, []byte{})
+		if state.bulkLen < 0 {
+			// null bulk string in multi bulk reply, e.g. result of MGET
+			state.args = append(state.args, nil)
 			state.bulkLen = 0
+		} else {
+			state.bulkBody = true
 		}
 	} else {
 		state.args = append(state.args, line)
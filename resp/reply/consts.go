@@ -50,6 +50,19 @@ func MakeEmptyMultiBulkReply() *EmptyMultiBulkReply {
 	return &EmptyMultiBulkReply{}
 }
 
+// NullMultiBulkReply is null list "*-1", e.g. aborted transaction
+type NullMultiBulkReply struct{}
+
+var nullMultiBulkBytes = []byte("*-1\r\n")
+
+func (r *NullMultiBulkReply) ToBytes() []byte {
+	return nullMultiBulkBytes
+}
+
+func MakeNullMultiBulkReply() *NullMultiBulkReply {
+	return &NullMultiBulkReply{}
+}
+
 // NoReply respond nothing, for commands like subscribe
 type NoReply struct{}
 
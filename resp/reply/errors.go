@@ -68,6 +68,23 @@ func (r *WrongTypeErrReply) Error() string {
 	return "WRONG-TYPE Operation against a key holding the wrong kind of value"
 }
 
+// NoAuthErrReply represents commands from client not authenticated
+type NoAuthErrReply struct{}
+
+var noAuthErrBytes = []byte("-NOAUTH Authentication required.\r\n")
+
+func MakeNoAuthErrReply() *NoAuthErrReply {
+	return &NoAuthErrReply{}
+}
+
+func (r *NoAuthErrReply) ToBytes() []byte {
+	return noAuthErrBytes
+}
+
+func (r *NoAuthErrReply) Error() string {
+	return "NOAUTH Authentication required."
+}
+
 // ProtocolErrReply represents meeting unexpected byte during parse requests
 type ProtocolErrReply struct {
 	Msg string
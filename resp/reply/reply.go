@@ -58,6 +58,30 @@ func (r *MultiBulkReply) ToBytes() []byte {
 	return buf.Bytes()
 }
 
+/* ===== MultiRaw Reply ===== */
+
+// MultiRawReply stores a list of replies, e.g. results of transaction
+type MultiRawReply struct {
+	Replies []resp.Reply
+}
+
+// MakeMultiRawReply creates MultiRawReply
+func MakeMultiRawReply(replies []resp.Reply) *MultiRawReply {
+	return &MultiRawReply{
+		Replies: replies,
+	}
+}
+
+func (r *MultiRawReply) ToBytes() []byte {
+	argLen := len(r.Replies)
+	var buf bytes.Buffer
+	buf.WriteString("*" + strconv.Itoa(argLen) + CRLF)
+	for _, rep := range r.Replies {
+		buf.Write(rep.ToBytes())
+	}
+	return buf.Bytes()
+}
+
 /* ===== Status Reply ===== */
 
 // StatusReply stores a simple status string
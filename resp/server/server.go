@@ -13,6 +13,7 @@ import (
 	"ringodis/resp/conn"
 	"ringodis/resp/parser"
 	"ringodis/resp/reply"
+	"ringodis/sentinel"
 	"strings"
 	"sync"
 )
@@ -21,6 +22,9 @@ var (
 	unknownErrReplyBytes = []byte("-ERR unknown\r\n")
 )
 
+// max number of received but not executed commands of a client
+const pendingPayloadSize = 1024
+
 type Handler struct {
 	activeConn sync.Map
 	db         idb.DB
@@ -29,7 +33,9 @@ type Handler struct {
 
 func MakeHandler() *Handler {
 	var db idb.DB
-	if config.Properties.Self != "" && len(config.Properties.Peers) > 0 {
+	if config.Properties.Sentinel {
+		db = sentinel.MakeSentinel()
+	} else if config.Properties.ClusterEnabled || (config.Properties.Self != "" && len(config.Properties.Peers) > 0) {
 		db = cluster.MakeCluster()
 	} else {
 		db = database.NewStandaloneServer()
@@ -49,6 +55,12 @@ func (h *Handler) Handle(ctx context.Context, netConn net.Conn) {
 	client := conn.NewConn(netConn)
 	h.activeConn.Store(client, struct{}{})
 
+	// commands are executed in another goroutine, so that disconnection could be noticed
+	// while a command is blocking, e.g. BLPOP
+	payloads := make(chan *parser.Payload, pendingPayloadSize)
+	defer close(payloads)
+	go h.serve(client, payloads)
+
 	ch := parser.ParseStream(netConn)
 	for payload := range ch {
 		if err := payload.Err; err != nil {
@@ -58,12 +70,26 @@ func (h *Handler) Handle(ctx context.Context, netConn net.Conn) {
 				logger.Info("connection closed: " + client.RemoteAddr().String())
 				return
 			}
+		}
+		payloads <- payload
+	}
+	// stream also ends by other io errors, e.g. failed tls handshake
+	h.closeClient(client)
+}
+
+// serve executes commands and writes results back to client in order
+func (h *Handler) serve(client *conn.Connection, payloads <-chan *parser.Payload) {
+	for payload := range payloads {
+		if client.IsClosed() {
+			// drop pending commands of closed client
+			continue
+		}
+		if err := payload.Err; err != nil {
 			// protocol error
 			errReply := reply.MakeErrReply(err.Error())
 			if _, err = client.Write(errReply.ToBytes()); err != nil {
 				h.closeClient(client)
 				logger.Info("connection closed: " + client.RemoteAddr().String())
-				return
 			}
 			continue
 		}
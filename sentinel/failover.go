@@ -0,0 +1,386 @@
+package sentinel
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"ringodis/interface/resp"
+	"ringodis/lib/logger"
+	"ringodis/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// states of failover
+const (
+	failoverNone = iota
+	// waiting for votes of other sentinels
+	failoverWaitStart
+	failoverSelectReplica
+	// REPLICAOF NO ONE has been sent to the selected replica
+	failoverWaitPromotion
+)
+
+var failoverStateNames = []string{"none", "wait_start", "select_slave", "wait_promotion"}
+
+const (
+	// max time to wait for votes
+	maxElectionTimeout = 10 * time.Second
+	// replicas whose INFO is older than this are never promoted
+	replicaInfoValidity = 5 * infoPeriod
+	// failover starts after a random delay up to maxDesync, so that sentinels rarely ask for votes at the same time
+	maxDesync = time.Second
+	// replicas reporting wrong role are reconfigured only after the role is stable for this long,
+	// so that other sentinels have a chance to announce the result of a failover
+	roleFixDelay = 2 * helloPeriod
+)
+
+// master is a monitored master with states of failover
+type master struct {
+	name     string
+	quorum   int
+	inst     *instance
+	replicas map[string]*instance
+	// configEpoch is the epoch of the failover which made the current master
+	configEpoch int64
+
+	// reports is the latest reply of is-master-down-by-addr from every other sentinel
+	reports     map[string]*downReport
+	lastAskTime time.Time
+	odown       bool
+	// failover is not started before failoverDelay after master is objectively down
+	failoverDelay time.Time
+
+	// leader is the sentinel voted by this sentinel in leaderEpoch
+	leader      string
+	leaderEpoch int64
+
+	failoverState     int
+	failoverEpoch     int64
+	failoverStartTime time.Time
+	failoverStateTime time.Time
+	promoted          *instance
+}
+
+// downReport is the reply of is-master-down-by-addr
+type downReport struct {
+	down        bool
+	leader      string
+	leaderEpoch int64
+	time        time.Time
+}
+
+// isDown tells whether the instance is subjectively down, caller should hold mu
+func (sentinel *Sentinel) isDown(inst *instance, now time.Time) bool {
+	return inst.elapsed(now) > sentinel.settings.downAfter
+}
+
+// checkMaster monitors master and its replicas, starts or continues failover, caller should hold mu
+func (sentinel *Sentinel) checkMaster(m *master, now time.Time) {
+	sentinel.checkInstance(m.inst, now)
+	for _, r := range m.replicas {
+		sentinel.checkInstance(r, now)
+	}
+
+	sdown := sentinel.isDown(m.inst, now)
+	if sdown && m.failoverState == failoverNone && now.Sub(m.lastAskTime) >= time.Second {
+		sentinel.askPeers(m, now, "*")
+	}
+	odown := sdown && sentinel.countDownReports(m, now) >= m.quorum
+	if odown != m.odown {
+		m.odown = odown
+		if odown {
+			m.failoverDelay = now.Add(time.Duration(rand.Int63n(int64(maxDesync))))
+			logger.Warn(fmt.Sprintf("sentinel: +odown master %s %s", m.name, m.inst.addr))
+		} else {
+			logger.Info(fmt.Sprintf("sentinel: -odown master %s %s", m.name, m.inst.addr))
+		}
+	}
+
+	switch m.failoverState {
+	case failoverNone:
+		if m.odown && now.After(m.failoverDelay) && now.Sub(m.failoverStartTime) > 2*sentinel.settings.failoverTimeout {
+			sentinel.startFailover(m, now)
+		}
+	case failoverWaitStart:
+		sentinel.waitElection(m, now)
+	case failoverSelectReplica:
+		sentinel.promoteReplica(m, now)
+	case failoverWaitPromotion:
+		sentinel.waitPromotion(m, now)
+	}
+}
+
+// countDownReports counts sentinels agreeing that master is down, including this one
+func (sentinel *Sentinel) countDownReports(m *master, now time.Time) int {
+	count := 1
+	for _, report := range m.reports {
+		if report.down && now.Sub(report.time) < reportValidity {
+			count++
+		}
+	}
+	return count
+}
+
+// askPeers asks other sentinels whether master is down, asks for votes as well unless runID is `*`
+func (sentinel *Sentinel) askPeers(m *master, now time.Time, runID string) {
+	m.lastAskTime = now
+	host, port := m.inst.hostPort()
+	epoch := strconv.FormatInt(sentinel.currentEpoch, 10)
+	for _, peer := range sentinel.peers {
+		peer := peer
+		go func() {
+			res := peer.send("SENTINEL", "is-master-down-by-addr", host, port, epoch, runID)
+			report := parseDownReport(res)
+			if report == nil {
+				return
+			}
+			sentinel.mu.Lock()
+			defer sentinel.mu.Unlock()
+			m.reports[peer.addr] = report
+		}()
+	}
+}
+
+// parseDownReport parses reply like [down, leader, leader epoch]
+func parseDownReport(res resp.Reply) *downReport {
+	multi, ok := res.(*reply.MultiBulkReply)
+	if !ok || len(multi.Args) != 3 {
+		return nil
+	}
+	// integers nested in array are received as raw lines
+	down, err1 := strconv.Atoi(strings.TrimPrefix(string(multi.Args[0]), ":"))
+	epoch, err2 := strconv.ParseInt(strings.TrimPrefix(string(multi.Args[2]), ":"), 10, 64)
+	if err1 != nil || err2 != nil {
+		return nil
+	}
+	return &downReport{
+		down:        down == 1,
+		leader:      string(multi.Args[1]),
+		leaderEpoch: epoch,
+		time:        time.Now(),
+	}
+}
+
+// isMasterDownByAddr replies whether master is down by this sentinel,
+// and votes for the sentinel with runID as leader of failover if it hasn't voted in the epoch
+// SENTINEL is-master-down-by-addr ip port current-epoch runid
+func (sentinel *Sentinel) isMasterDownByAddr(args [][]byte) resp.Reply {
+	addr := net.JoinHostPort(string(args[0]), string(args[1]))
+	epoch, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	runID := string(args[3])
+	var m *master
+	for _, candidate := range sentinel.masters {
+		if candidate.inst.addr == addr {
+			m = candidate
+			break
+		}
+	}
+	down := 0
+	leader := "*"
+	var leaderEpoch int64
+	if m != nil {
+		now := time.Now()
+		if sentinel.isDown(m.inst, now) {
+			down = 1
+		}
+		if runID != "*" {
+			sentinel.vote(m, runID, epoch, now)
+			leader, leaderEpoch = m.leader, m.leaderEpoch
+		}
+	}
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeIntReply(int64(down)),
+		reply.MakeBulkReply([]byte(leader)),
+		reply.MakeIntReply(leaderEpoch),
+	})
+}
+
+// vote votes for the first sentinel asking in the epoch
+func (sentinel *Sentinel) vote(m *master, runID string, epoch int64, now time.Time) {
+	if epoch > sentinel.currentEpoch {
+		sentinel.currentEpoch = epoch
+	}
+	if m.leaderEpoch < epoch && sentinel.currentEpoch <= epoch {
+		m.leader = runID
+		m.leaderEpoch = sentinel.currentEpoch
+		logger.Info(fmt.Sprintf("sentinel: +vote-for-leader %s %d", runID, m.leaderEpoch))
+		// don't start another failover while the voted leader is working
+		if runID != sentinel.runID {
+			m.failoverStartTime = now
+		}
+	}
+}
+
+// startFailover increases epoch and asks other sentinels to vote this one as leader
+func (sentinel *Sentinel) startFailover(m *master, now time.Time) {
+	sentinel.currentEpoch++
+	m.failoverEpoch = sentinel.currentEpoch
+	m.failoverState = failoverWaitStart
+	m.failoverStartTime = now
+	m.failoverStateTime = now
+	logger.Warn(fmt.Sprintf("sentinel: +try-failover master %s %s epoch %d", m.name, m.inst.addr, m.failoverEpoch))
+	sentinel.vote(m, sentinel.runID, m.failoverEpoch, now)
+	sentinel.askPeers(m, now, sentinel.runID)
+}
+
+// forceFailover promotes a replica without agreement of other sentinels, used by SENTINEL FAILOVER
+func (sentinel *Sentinel) forceFailover(m *master, now time.Time) {
+	sentinel.currentEpoch++
+	m.failoverEpoch = sentinel.currentEpoch
+	m.failoverState = failoverSelectReplica
+	m.failoverStartTime = now
+	m.failoverStateTime = now
+	sentinel.vote(m, sentinel.runID, m.failoverEpoch, now)
+	logger.Warn(fmt.Sprintf("sentinel: +new-epoch %d, failover of %s requested", m.failoverEpoch, m.name))
+}
+
+// waitElection checks votes, leader needs majority of sentinels and no less than quorum
+func (sentinel *Sentinel) waitElection(m *master, now time.Time) {
+	votes := 0
+	if m.leader == sentinel.runID && m.leaderEpoch == m.failoverEpoch {
+		votes++
+	}
+	for _, report := range m.reports {
+		if report.leader == sentinel.runID && report.leaderEpoch == m.failoverEpoch {
+			votes++
+		}
+	}
+	needed := (len(sentinel.peers)+1)/2 + 1
+	if m.quorum > needed {
+		needed = m.quorum
+	}
+	if votes >= needed {
+		logger.Warn(fmt.Sprintf("sentinel: +elected-leader master %s epoch %d", m.name, m.failoverEpoch))
+		m.failoverState = failoverSelectReplica
+		m.failoverStateTime = now
+		return
+	}
+	timeout := sentinel.settings.failoverTimeout
+	if timeout > maxElectionTimeout {
+		timeout = maxElectionTimeout
+	}
+	if now.Sub(m.failoverStateTime) > timeout {
+		sentinel.abortFailover(m, "not elected")
+		return
+	}
+	if now.Sub(m.lastAskTime) >= time.Second {
+		sentinel.askPeers(m, now, sentinel.runID)
+	}
+}
+
+// selectReplica picks the reachable replica with the largest replication offset
+func (sentinel *Sentinel) selectReplica(m *master, now time.Time) *instance {
+	candidates := make([]*instance, 0, len(m.replicas))
+	for _, r := range m.replicas {
+		if sentinel.isDown(r, now) || r.role != roleReplica || now.Sub(r.infoTime) > replicaInfoValidity {
+			continue
+		}
+		candidates = append(candidates, r)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].replOffset != candidates[j].replOffset {
+			return candidates[i].replOffset > candidates[j].replOffset
+		}
+		return candidates[i].addr < candidates[j].addr
+	})
+	return candidates[0]
+}
+
+// promoteReplica sends REPLICAOF NO ONE to the selected replica
+func (sentinel *Sentinel) promoteReplica(m *master, now time.Time) {
+	r := sentinel.selectReplica(m, now)
+	if r == nil {
+		sentinel.abortFailover(m, "no good replica")
+		return
+	}
+	logger.Warn(fmt.Sprintf("sentinel: +selected-slave %s of %s", r.addr, m.name))
+	m.promoted = r
+	m.failoverState = failoverWaitPromotion
+	m.failoverStateTime = now
+	go func() {
+		res := r.send("REPLICAOF", "NO", "ONE")
+		if reply.IsErrorReply(res) {
+			logger.Warn("sentinel: promote " + r.addr + " failed: " + string(res.ToBytes()))
+		}
+	}()
+}
+
+// waitPromotion switches master when the promoted replica reports master role
+func (sentinel *Sentinel) waitPromotion(m *master, now time.Time) {
+	r := m.promoted
+	if r.role == roleMaster && r.infoTime.After(m.failoverStateTime) {
+		logger.Warn(fmt.Sprintf("sentinel: +promoted-slave %s of %s", r.addr, m.name))
+		m.configEpoch = m.failoverEpoch
+		sentinel.switchMaster(m, r.addr)
+		sentinel.resetFailover(m)
+		// announce the new config immediately
+		sentinel.lastHelloTime = now
+		sentinel.sendHello()
+		return
+	}
+	if now.Sub(m.failoverStateTime) > sentinel.settings.failoverTimeout {
+		sentinel.abortFailover(m, "promotion timeout")
+	}
+}
+
+// switchMaster makes the instance at addr master, the old master and other replicas are reconfigured as its replicas
+func (sentinel *Sentinel) switchMaster(m *master, addr string) {
+	old := m.inst
+	logger.Warn(fmt.Sprintf("sentinel: +switch-master %s %s %s", m.name, old.addr, addr))
+	newMaster, ok := m.replicas[addr]
+	if !ok {
+		newMaster = makeInstance(addr, m)
+	}
+	delete(m.replicas, addr)
+	m.replicas[old.addr] = old
+	m.inst = newMaster
+	m.odown = false
+	m.reports = make(map[string]*downReport)
+	host, port := newMaster.hostPort()
+	for _, r := range m.replicas {
+		if r == old {
+			// it will be reconfigured by fixRole once it's reachable
+			continue
+		}
+		r := r
+		go r.send("REPLICAOF", host, port)
+	}
+}
+
+func (sentinel *Sentinel) resetFailover(m *master) {
+	m.failoverState = failoverNone
+	m.promoted = nil
+}
+
+func (sentinel *Sentinel) abortFailover(m *master, reason string) {
+	logger.Warn(fmt.Sprintf("sentinel: -failover-abort-%s master %s", strings.ReplaceAll(reason, " ", "-"), m.name))
+	sentinel.resetFailover(m)
+}
+
+// fixRole reconfigures replicas which replicate from a wrong master, e.g. the old master restarted after failover
+func (sentinel *Sentinel) fixRole(m *master, inst *instance, now time.Time) {
+	if inst == m.inst || m.failoverState != failoverNone || sentinel.isDown(m.inst, now) ||
+		now.Sub(inst.roleSince) < roleFixDelay {
+		return
+	}
+	if inst.role == roleReplica && inst.masterAddr == m.inst.addr {
+		return
+	}
+	if inst.role != roleMaster && inst.role != roleReplica {
+		return
+	}
+	logger.Warn(fmt.Sprintf("sentinel: +fix-slave-config %s of %s", inst.addr, m.name))
+	// role will be changed, wait another delay before fixing again
+	inst.roleSince = now
+	host, port := m.inst.hostPort()
+	go inst.send("REPLICAOF", host, port)
+}
@@ -0,0 +1,181 @@
+package sentinel
+
+import (
+	"net"
+	"ringodis/interface/resp"
+	"ringodis/lib/utils"
+	"ringodis/resp/client"
+	"ringodis/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	roleMaster  = "master"
+	roleReplica = "slave"
+)
+
+// instance is a monitored master, replica or another sentinel
+type instance struct {
+	addr string
+	// owner is the master monitored with this instance, it's nil for sentinels
+	owner *master
+
+	clientMu sync.Mutex
+	client   *client.Client
+
+	// states below are guarded by mu of Sentinel
+	pinging      bool
+	lastPingTime time.Time
+	// pendingSince is the time when the oldest unanswered ping was sent, it's zero if no ping is pending
+	pendingSince time.Time
+	lastAvail    time.Time
+
+	refreshing   bool
+	lastInfoTime time.Time
+	infoTime     time.Time
+	// replication states reported by INFO
+	role       string
+	roleSince  time.Time
+	masterAddr string
+	linkUp     bool
+	replOffset int64
+}
+
+func makeInstance(addr string, owner *master) *instance {
+	now := time.Now()
+	return &instance{
+		addr:      addr,
+		owner:     owner,
+		lastAvail: now,
+	}
+}
+
+// send executes command on the instance, connection is established on demand
+func (inst *instance) send(args ...string) resp.Reply {
+	inst.clientMu.Lock()
+	c := inst.client
+	if c == nil {
+		var err error
+		c, err = client.MakeClient(inst.addr)
+		if err != nil {
+			inst.clientMu.Unlock()
+			return reply.MakeErrReply("ERR " + err.Error())
+		}
+		c.Start()
+		inst.client = c
+	}
+	inst.clientMu.Unlock()
+
+	res := c.Send(utils.ToCmdLine(args...))
+	if errReply, ok := res.(reply.ErrorReply); ok && errReply.Error() == "client closed" {
+		// client gave up reconnecting, dial again next time
+		inst.clientMu.Lock()
+		if inst.client == c {
+			inst.client = nil
+		}
+		inst.clientMu.Unlock()
+	}
+	if res == nil {
+		return reply.MakeUnknownErrReply()
+	}
+	return res
+}
+
+func (inst *instance) close() {
+	inst.clientMu.Lock()
+	defer inst.clientMu.Unlock()
+	if inst.client != nil {
+		inst.client.Close()
+		inst.client = nil
+	}
+}
+
+// elapsed returns how long the instance has not replied, caller should hold mu of Sentinel
+func (inst *instance) elapsed(now time.Time) time.Duration {
+	if !inst.pendingSince.IsZero() {
+		return now.Sub(inst.pendingSince)
+	}
+	return now.Sub(inst.lastAvail)
+}
+
+func (inst *instance) hostPort() (string, string) {
+	host, port, _ := net.SplitHostPort(inst.addr)
+	return host, port
+}
+
+// isValidPong tells whether the instance is alive by reply of PING, a loading server is alive too
+func isValidPong(res resp.Reply) bool {
+	switch r := res.(type) {
+	case *reply.StatusReply:
+		return r.Status == "PONG"
+	case reply.ErrorReply:
+		msg := r.Error()
+		return strings.HasPrefix(msg, "LOADING") || strings.HasPrefix(msg, "MASTERDOWN")
+	}
+	return false
+}
+
+// replicationInfo is the replication section of INFO
+type replicationInfo struct {
+	role       string
+	masterAddr string
+	linkUp     bool
+	offset     int64
+	// addresses of online replicas reported by master
+	replicas []string
+}
+
+// parseReplicationInfo parses lines like `role:master` and `slave0:ip=127.0.0.1,port=6380,state=online`
+func parseReplicationInfo(text string) *replicationInfo {
+	info := &replicationInfo{}
+	var masterHost, masterPort string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		pivot := strings.IndexByte(line, ':')
+		if pivot < 0 {
+			continue
+		}
+		key, value := line[:pivot], line[pivot+1:]
+		switch {
+		case key == "role":
+			info.role = value
+		case key == "master_host":
+			masterHost = value
+		case key == "master_port":
+			masterPort = value
+		case key == "master_link_status":
+			info.linkUp = value == "up"
+		case key == "slave_repl_offset":
+			info.offset, _ = strconv.ParseInt(value, 10, 64)
+		case strings.HasPrefix(key, "slave") && isDigits(key[len("slave"):]):
+			fields := make(map[string]string)
+			for _, field := range strings.Split(value, ",") {
+				if kv := strings.SplitN(field, "=", 2); len(kv) == 2 {
+					fields[kv[0]] = kv[1]
+				}
+			}
+			if fields["ip"] != "" && fields["port"] != "" && fields["state"] == "online" {
+				info.replicas = append(info.replicas, net.JoinHostPort(fields["ip"], fields["port"]))
+			}
+		}
+	}
+	if masterHost != "" {
+		info.masterAddr = net.JoinHostPort(masterHost, masterPort)
+	}
+	return info
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
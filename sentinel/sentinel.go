@@ -0,0 +1,436 @@
+package sentinel
+
+import (
+	"fmt"
+	"net"
+	"ringodis/config"
+	"ringodis/interface/resp"
+	"ringodis/lib/logger"
+	"ringodis/lib/utils"
+	"ringodis/resp/reply"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDownAfter       = 30 * time.Second
+	defaultFailoverTimeout = 180 * time.Second
+
+	cronPeriod = 100 * time.Millisecond
+	// instances are pinged every second, or more frequently if down-after is shorter
+	maxPingPeriod = time.Second
+	infoPeriod    = time.Second
+	// masters are announced to other sentinels periodically, so that they learn the result of failover
+	helloPeriod = time.Second
+	// reports from other sentinels expire after reportValidity
+	reportValidity = 5 * time.Second
+)
+
+// settings of Sentinel
+type settings struct {
+	// masters like "mymaster 127.0.0.1 6379 2"
+	monitors        []string
+	peers           []string
+	downAfter       time.Duration
+	failoverTimeout time.Duration
+}
+
+// Sentinel monitors masters and their replicas, promotes a replica when the master is agreed down by quorum
+type Sentinel struct {
+	mu           sync.Mutex
+	runID        string
+	currentEpoch int64
+	settings     *settings
+	masters      map[string]*master
+	// other sentinels
+	peers         []*instance
+	lastHelloTime time.Time
+
+	closeChan chan struct{}
+	closeOnce sync.Once
+}
+
+// MakeSentinel creates a sentinel by config
+func MakeSentinel() *Sentinel {
+	s := &settings{
+		monitors:        config.Properties.SentinelMonitor,
+		peers:           config.Properties.SentinelPeers,
+		downAfter:       time.Duration(config.Properties.SentinelDownAfter) * time.Millisecond,
+		failoverTimeout: time.Duration(config.Properties.SentinelFailoverTimeout) * time.Millisecond,
+	}
+	sentinel, err := makeSentinel(s)
+	if err != nil {
+		panic(err)
+	}
+	return sentinel
+}
+
+func makeSentinel(s *settings) (*Sentinel, error) {
+	if s.downAfter <= 0 {
+		s.downAfter = defaultDownAfter
+	}
+	if s.failoverTimeout <= 0 {
+		s.failoverTimeout = defaultFailoverTimeout
+	}
+	sentinel := &Sentinel{
+		runID:     utils.RandHexString(40),
+		settings:  s,
+		masters:   make(map[string]*master),
+		closeChan: make(chan struct{}),
+	}
+	for _, monitor := range s.monitors {
+		fields := strings.Fields(monitor)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("invalid sentinel monitor: %s", monitor)
+		}
+		quorum, err := strconv.Atoi(fields[3])
+		if err != nil || quorum <= 0 {
+			return nil, fmt.Errorf("invalid quorum: %s", monitor)
+		}
+		m := &master{
+			name:     fields[0],
+			quorum:   quorum,
+			replicas: make(map[string]*instance),
+			reports:  make(map[string]*downReport),
+		}
+		m.inst = makeInstance(net.JoinHostPort(fields[1], fields[2]), m)
+		sentinel.masters[m.name] = m
+	}
+	for _, peer := range s.peers {
+		if peer = strings.TrimSpace(peer); peer != "" {
+			sentinel.peers = append(sentinel.peers, makeInstance(peer, nil))
+		}
+	}
+	go sentinel.cron()
+	return sentinel, nil
+}
+
+func (sentinel *Sentinel) cron() {
+	ticker := time.NewTicker(cronPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sentinel.closeChan:
+			return
+		case <-ticker.C:
+		}
+		sentinel.mu.Lock()
+		now := time.Now()
+		for _, m := range sentinel.masters {
+			sentinel.checkMaster(m, now)
+		}
+		if now.Sub(sentinel.lastHelloTime) >= helloPeriod {
+			sentinel.lastHelloTime = now
+			sentinel.sendHello()
+		}
+		sentinel.mu.Unlock()
+	}
+}
+
+func (sentinel *Sentinel) pingPeriod() time.Duration {
+	if sentinel.settings.downAfter < maxPingPeriod {
+		return sentinel.settings.downAfter
+	}
+	return maxPingPeriod
+}
+
+// checkInstance pings the instance and refreshes its replication info, caller should hold mu
+func (sentinel *Sentinel) checkInstance(inst *instance, now time.Time) {
+	if !inst.pinging && now.Sub(inst.lastPingTime) >= sentinel.pingPeriod() {
+		inst.pinging = true
+		inst.lastPingTime = now
+		if inst.pendingSince.IsZero() {
+			inst.pendingSince = now
+		}
+		go sentinel.ping(inst)
+	}
+	if !inst.refreshing && now.Sub(inst.lastInfoTime) >= infoPeriod {
+		inst.refreshing = true
+		inst.lastInfoTime = now
+		go sentinel.refresh(inst)
+	}
+}
+
+func (sentinel *Sentinel) ping(inst *instance) {
+	res := inst.send("PING")
+	sentinel.mu.Lock()
+	defer sentinel.mu.Unlock()
+	inst.pinging = false
+	if isValidPong(res) {
+		inst.lastAvail = time.Now()
+		inst.pendingSince = time.Time{}
+	}
+}
+
+// refresh gets replication states by INFO, discovers replicas of master
+func (sentinel *Sentinel) refresh(inst *instance) {
+	res := inst.send("INFO", "replication")
+	sentinel.mu.Lock()
+	defer sentinel.mu.Unlock()
+	inst.refreshing = false
+	bulk, ok := res.(*reply.BulkReply)
+	if !ok {
+		return
+	}
+	info := parseReplicationInfo(string(bulk.Arg))
+	now := time.Now()
+	if info.role != inst.role {
+		inst.roleSince = now
+	}
+	inst.infoTime = now
+	inst.role = info.role
+	inst.masterAddr = info.masterAddr
+	inst.linkUp = info.linkUp
+	inst.replOffset = info.offset
+
+	m := inst.owner
+	if m == nil {
+		return
+	}
+	if inst == m.inst && info.role == roleMaster {
+		for _, addr := range info.replicas {
+			if _, ok := m.replicas[addr]; !ok && addr != m.inst.addr {
+				logger.Info(fmt.Sprintf("sentinel: +slave %s of %s", addr, m.name))
+				m.replicas[addr] = makeInstance(addr, m)
+			}
+		}
+	}
+	sentinel.fixRole(m, inst, now)
+}
+
+// sendHello announces config of masters to other sentinels, caller should hold mu
+func (sentinel *Sentinel) sendHello() {
+	for _, m := range sentinel.masters {
+		host, port := m.inst.hostPort()
+		hello := strings.Join([]string{
+			sentinel.runID,
+			strconv.FormatInt(sentinel.currentEpoch, 10),
+			m.name,
+			host,
+			port,
+			strconv.FormatInt(m.configEpoch, 10),
+		}, ",")
+		for _, peer := range sentinel.peers {
+			go peer.send("SENTINEL", "HELLO", hello)
+		}
+	}
+}
+
+// processHello adopts the newer config of master from another sentinel, caller should hold mu
+func (sentinel *Sentinel) processHello(hello string) resp.Reply {
+	fields := strings.Split(hello, ",")
+	if len(fields) != 6 {
+		return reply.MakeErrReply("ERR invalid hello message")
+	}
+	currentEpoch, err1 := strconv.ParseInt(fields[1], 10, 64)
+	configEpoch, err2 := strconv.ParseInt(fields[5], 10, 64)
+	if err1 != nil || err2 != nil {
+		return reply.MakeErrReply("ERR invalid hello message")
+	}
+	if fields[0] == sentinel.runID {
+		return reply.MakeOkReply()
+	}
+	if currentEpoch > sentinel.currentEpoch {
+		sentinel.currentEpoch = currentEpoch
+	}
+	m, ok := sentinel.masters[fields[2]]
+	if !ok || configEpoch <= m.configEpoch {
+		return reply.MakeOkReply()
+	}
+	addr := net.JoinHostPort(fields[3], fields[4])
+	m.configEpoch = configEpoch
+	if addr != m.inst.addr {
+		if m.failoverState != failoverNone {
+			sentinel.abortFailover(m, "newer config received")
+		}
+		sentinel.switchMaster(m, addr)
+	}
+	return reply.MakeOkReply()
+}
+
+// Exec executes commands of sentinel
+func (sentinel *Sentinel) Exec(c resp.Connection, cmdLine [][]byte) (result resp.Reply) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Warn(fmt.Sprintf("error occurs: %v\n%s", err, string(debug.Stack())))
+			result = reply.MakeUnknownErrReply()
+		}
+	}()
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	switch cmdName {
+	case "ping":
+		return reply.MakeStatusReply("PONG")
+	case "role":
+		sentinel.mu.Lock()
+		defer sentinel.mu.Unlock()
+		return reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte("sentinel")),
+			reply.MakeMultiBulkReply(utils.ToCmdLine(sentinel.masterNames()...)),
+		})
+	case "sentinel":
+		if len(cmdLine) < 2 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return sentinel.execSentinel(cmdLine[1:])
+	}
+	return reply.MakeErrReply("ERR unknown command '" + cmdName + "'")
+}
+
+// execSentinel executes SENTINEL subcommands
+func (sentinel *Sentinel) execSentinel(args [][]byte) resp.Reply {
+	sentinel.mu.Lock()
+	defer sentinel.mu.Unlock()
+	subCmd := strings.ToLower(string(args[0]))
+	args = args[1:]
+	switch subCmd {
+	case "myid":
+		return reply.MakeBulkReply([]byte(sentinel.runID))
+	case "masters":
+		result := make([]resp.Reply, 0, len(sentinel.masters))
+		for _, name := range sentinel.masterNames() {
+			result = append(result, sentinel.masterFields(sentinel.masters[name]))
+		}
+		return reply.MakeMultiRawReply(result)
+	case "is-master-down-by-addr":
+		if len(args) != 4 {
+			return reply.MakeArgNumErrReply("sentinel " + subCmd)
+		}
+		return sentinel.isMasterDownByAddr(args)
+	case "hello":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("sentinel " + subCmd)
+		}
+		return sentinel.processHello(string(args[0]))
+	}
+
+	// following subcommands need master name
+	if len(args) != 1 {
+		return reply.MakeErrReply("ERR Unknown sentinel subcommand or wrong number of arguments for '" + subCmd + "'")
+	}
+	m, ok := sentinel.masters[string(args[0])]
+	if !ok {
+		if subCmd == "get-master-addr-by-name" {
+			return reply.MakeNullMultiBulkReply()
+		}
+		return reply.MakeErrReply("ERR No such master with that name")
+	}
+	switch subCmd {
+	case "get-master-addr-by-name":
+		host, port := m.inst.hostPort()
+		return reply.MakeMultiBulkReply(utils.ToCmdLine(host, port))
+	case "master":
+		return sentinel.masterFields(m)
+	case "replicas", "slaves":
+		addrs := make([]string, 0, len(m.replicas))
+		for addr := range m.replicas {
+			addrs = append(addrs, addr)
+		}
+		sort.Strings(addrs)
+		result := make([]resp.Reply, 0, len(addrs))
+		for _, addr := range addrs {
+			result = append(result, sentinel.instanceFields(m.replicas[addr]))
+		}
+		return reply.MakeMultiRawReply(result)
+	case "sentinels":
+		result := make([]resp.Reply, 0, len(sentinel.peers))
+		for _, peer := range sentinel.peers {
+			result = append(result, sentinel.instanceFields(peer))
+		}
+		return reply.MakeMultiRawReply(result)
+	case "failover":
+		if m.failoverState != failoverNone {
+			return reply.MakeErrReply("INPROG Failover already in progress")
+		}
+		if sentinel.selectReplica(m, time.Now()) == nil {
+			return reply.MakeErrReply("NOGOODSLAVE No suitable replica to promote")
+		}
+		sentinel.forceFailover(m, time.Now())
+		return reply.MakeOkReply()
+	}
+	return reply.MakeErrReply("ERR Unknown sentinel subcommand or wrong number of arguments for '" + subCmd + "'")
+}
+
+func (sentinel *Sentinel) masterNames() []string {
+	names := make([]string, 0, len(sentinel.masters))
+	for name := range sentinel.masters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// instanceFields describes instance in field-value pairs, caller should hold mu
+func (sentinel *Sentinel) instanceFields(inst *instance) *reply.MultiBulkReply {
+	now := time.Now()
+	host, port := inst.hostPort()
+	flags := make([]string, 0, 3)
+	switch {
+	case inst.owner == nil:
+		flags = append(flags, "sentinel")
+	case inst == inst.owner.inst:
+		flags = append(flags, "master")
+	default:
+		flags = append(flags, "slave")
+	}
+	if sentinel.isDown(inst, now) {
+		flags = append(flags, "s_down")
+	}
+	if inst.owner != nil && inst == inst.owner.inst && inst.owner.odown {
+		flags = append(flags, "o_down")
+	}
+	fields := []string{
+		"ip", host,
+		"port", port,
+		"flags", strings.Join(flags, ","),
+		"last-ok-ping-reply", strconv.FormatInt(now.Sub(inst.lastAvail).Milliseconds(), 10),
+	}
+	if inst.owner != nil && inst != inst.owner.inst {
+		linkStatus := "err"
+		if inst.linkUp {
+			linkStatus = "ok"
+		}
+		fields = append(fields,
+			"master-link-status", linkStatus,
+			"slave-repl-offset", strconv.FormatInt(inst.replOffset, 10))
+	}
+	return reply.MakeMultiBulkReply(utils.ToCmdLine(fields...))
+}
+
+func (sentinel *Sentinel) masterFields(m *master) *reply.MultiBulkReply {
+	fields := sentinel.instanceFields(m.inst)
+	fields.Args = append(fields.Args, utils.ToCmdLine(
+		"name", m.name,
+		"num-slaves", strconv.Itoa(len(m.replicas)),
+		"num-other-sentinels", strconv.Itoa(len(sentinel.peers)),
+		"quorum", strconv.Itoa(m.quorum),
+		"config-epoch", strconv.FormatInt(m.configEpoch, 10),
+		"failover-state", failoverStateNames[m.failoverState],
+	)...)
+	return fields
+}
+
+// AfterClientClose does nothing, sentinel keeps no states of client
+func (sentinel *Sentinel) AfterClientClose(c resp.Connection) {
+}
+
+// Close stops monitoring
+func (sentinel *Sentinel) Close() {
+	sentinel.closeOnce.Do(func() {
+		close(sentinel.closeChan)
+		sentinel.mu.Lock()
+		defer sentinel.mu.Unlock()
+		for _, m := range sentinel.masters {
+			m.inst.close()
+			for _, r := range m.replicas {
+				r.close()
+			}
+		}
+		for _, peer := range sentinel.peers {
+			peer.close()
+		}
+	})
+}
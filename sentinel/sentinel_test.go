@@ -0,0 +1,186 @@
+package sentinel
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"ringodis/config"
+	"ringodis/database"
+	idb "ringodis/interface/database"
+	"ringodis/lib/utils"
+	"ringodis/resp/conn"
+	"ringodis/resp/parser"
+	"ringodis/resp/reply"
+	"ringodis/resp/reply/asserts"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer serves db through tcp, it could be killed to simulate crash
+type testServer struct {
+	listener net.Listener
+	db       idb.DB
+	mu       sync.Mutex
+	conns    []net.Conn
+}
+
+func listen(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return listener
+}
+
+func serve(t *testing.T, listener net.Listener, db idb.DB) *testServer {
+	server := &testServer{listener: listener, db: db}
+	t.Cleanup(server.kill)
+	go func() {
+		for {
+			netConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.mu.Lock()
+			server.conns = append(server.conns, netConn)
+			server.mu.Unlock()
+			go func() {
+				client := conn.NewConn(netConn)
+				for payload := range parser.ParseStream(netConn) {
+					if payload.Err != nil {
+						if payload.Err == io.EOF || strings.Contains(payload.Err.Error(), "closed") {
+							_ = client.Close()
+							db.AfterClientClose(client)
+							return
+						}
+						continue
+					}
+					if cmd, ok := payload.Data.(*reply.MultiBulkReply); ok {
+						_, _ = client.Write(db.Exec(client, cmd.Args).ToBytes())
+					}
+				}
+			}()
+		}
+	}()
+	return server
+}
+
+func (server *testServer) addr() string {
+	return server.listener.Addr().String()
+}
+
+func (server *testServer) kill() {
+	_ = server.listener.Close()
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for _, c := range server.conns {
+		_ = c.Close()
+	}
+	server.conns = nil
+	server.db.Close()
+}
+
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestParseReplicationInfo(t *testing.T) {
+	info := parseReplicationInfo("# Replication\r\nrole:master\r\nconnected_slaves:2\r\n" +
+		"slave0:ip=127.0.0.1,port=6380,state=online,offset=10,lag=0\r\n" +
+		"slave1:ip=127.0.0.1,port=6381,state=wait_bgsave,offset=0,lag=0\r\nmaster_repl_offset:10\r\n")
+	if info.role != roleMaster || len(info.replicas) != 1 || info.replicas[0] != "127.0.0.1:6380" {
+		t.Errorf("unexpected info: %+v", info)
+	}
+	info = parseReplicationInfo("role:slave\r\nmaster_host:127.0.0.1\r\nmaster_port:6379\r\n" +
+		"master_link_status:up\r\nslave_repl_offset:42\r\n")
+	if info.role != roleReplica || info.masterAddr != "127.0.0.1:6379" || !info.linkUp || info.offset != 42 {
+		t.Errorf("unexpected info: %+v", info)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	config.Properties.RDBFilename = filepath.Join(t.TempDir(), "dump.rdb")
+	master := serve(t, listen(t), database.NewStandaloneServer())
+	replicaListener := listen(t)
+	replicaDB := database.NewStandaloneServer()
+	replica := serve(t, replicaListener, replicaDB)
+	_, replicaPort, _ := net.SplitHostPort(replica.addr())
+	config.Properties.SlaveAnnouncePort, _ = strconv.Atoi(replicaPort)
+	defer func() {
+		config.Properties.SlaveAnnouncePort = 0
+	}()
+
+	c := conn.NewFakeConn()
+	master.db.Exec(c, utils.ToCmdLine("set", "k", "v"))
+	masterHost, masterPort, _ := net.SplitHostPort(master.addr())
+	asserts.AssertStatusReply(t, replicaDB.Exec(c, utils.ToCmdLine("replicaof", masterHost, masterPort)), "OK")
+
+	listeners := []net.Listener{listen(t), listen(t), listen(t)}
+	sentinels := make([]*Sentinel, len(listeners))
+	for i := range listeners {
+		var peers []string
+		for j, listener := range listeners {
+			if j != i {
+				peers = append(peers, listener.Addr().String())
+			}
+		}
+		s, err := makeSentinel(&settings{
+			monitors:        []string{"mymaster " + masterHost + " " + masterPort + " 2"},
+			peers:           peers,
+			downAfter:       300 * time.Millisecond,
+			failoverTimeout: 2 * time.Second,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sentinels[i] = s
+		serve(t, listeners[i], s)
+	}
+
+	getMasterAddr := func(s *Sentinel) string {
+		res, ok := s.Exec(c, utils.ToCmdLine("sentinel", "get-master-addr-by-name", "mymaster")).(*reply.MultiBulkReply)
+		if !ok || len(res.Args) != 2 {
+			return ""
+		}
+		return net.JoinHostPort(string(res.Args[0]), string(res.Args[1]))
+	}
+	for _, s := range sentinels {
+		if addr := getMasterAddr(s); addr != master.addr() {
+			t.Fatalf("expected master %s, actual %s", master.addr(), addr)
+		}
+		waitFor(t, 5*time.Second, func() bool {
+			res := s.Exec(c, utils.ToCmdLine("sentinel", "replicas", "mymaster"))
+			return len(res.(*reply.MultiRawReply).Replies) == 1
+		})
+	}
+	waitFor(t, 5*time.Second, func() bool {
+		return string(replicaDB.Exec(c, utils.ToCmdLine("get", "k")).ToBytes()) == "$1\r\nv\r\n"
+	})
+
+	master.kill()
+	for _, s := range sentinels {
+		waitFor(t, 15*time.Second, func() bool {
+			return getMasterAddr(s) == replica.addr()
+		})
+	}
+	// the promoted replica accepts writes
+	asserts.AssertStatusReply(t, replicaDB.Exec(c, utils.ToCmdLine("set", "k2", "v2")), "OK")
+	info := string(replicaDB.Exec(c, utils.ToCmdLine("info", "replication")).ToBytes())
+	if !strings.Contains(info, "role:master\r\n") {
+		t.Errorf("expected promoted replica to be master: %q", info)
+	}
+	res := sentinels[0].Exec(c, utils.ToCmdLine("sentinel", "get-master-addr-by-name", "other"))
+	if string(res.ToBytes()) != "*-1\r\n" {
+		t.Errorf("expected null reply for unknown master, actual %q", res.ToBytes())
+	}
+}
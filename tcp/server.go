@@ -2,6 +2,8 @@ package tcp
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"os"
@@ -13,13 +15,21 @@ import (
 )
 
 type Config struct {
+	// 明文监听地址，为空时不监听
 	Address string
+	// TLS 监听地址，为空时不监听
+	TLSAddress string
+	TLSConfig  *tls.Config
+	// unix socket 路径，为空时不监听
+	UnixSocket string
+	// unix socket 文件权限，为 0 时使用 umask 决定的权限
+	UnixSocketPerm os.FileMode
 }
 
 // 监听中断信号并通过 closeChan 通知服务器关闭
 func ListenAndServeWithSignal(cfg *Config, handler tcp.Handler) error {
 	closeChan := make(chan struct{})
-	sigChan := make(chan os.Signal)
+	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGHUP, syscall.SIGQUIT,
 		syscall.SIGTERM, syscall.SIGINT)
 	go func() {
@@ -31,48 +41,121 @@ func ListenAndServeWithSignal(cfg *Config, handler tcp.Handler) error {
 		}
 	}()
 
-	listener, err := net.Listen("tcp", cfg.Address)
-	if err != nil {
-		return err
+	var listeners []net.Listener
+	// 监听失败时关闭已打开的 listener
+	closeListeners := func() {
+		for _, listener := range listeners {
+			_ = listener.Close()
+		}
 	}
-	logger.Info(fmt.Sprintf("bind: %s, start listening...", cfg.Address))
-	ListenAndServe(listener, handler, closeChan)
+	if cfg.Address != "" {
+		listener, err := net.Listen("tcp", cfg.Address)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, listener)
+		logger.Info(fmt.Sprintf("bind: %s, start listening...", cfg.Address))
+	}
+	if cfg.TLSAddress != "" {
+		// TLS 握手在连接首次读写时进行，handler 无需区分
+		listener, err := tls.Listen("tcp", cfg.TLSAddress, cfg.TLSConfig)
+		if err != nil {
+			closeListeners()
+			return err
+		}
+		listeners = append(listeners, listener)
+		logger.Info(fmt.Sprintf("bind: %s, start listening tls...", cfg.TLSAddress))
+	}
+	if cfg.UnixSocket != "" {
+		listener, err := listenUnix(cfg.UnixSocket, cfg.UnixSocketPerm)
+		if err != nil {
+			closeListeners()
+			return err
+		}
+		listeners = append(listeners, listener)
+		logger.Info(fmt.Sprintf("bind: %s, start listening unix socket...", cfg.UnixSocket))
+	}
+	if len(listeners) == 0 {
+		return errors.New("no address to listen")
+	}
+	serve(listeners, handler, closeChan)
 	return nil
 }
 
+// 监听 unix socket，关闭 listener 时会删除 socket 文件
+func listenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	// 删除上次异常退出遗留的 socket 文件，其他类型的文件保留并由 Listen 报错
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err = os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if perm != 0 {
+		if err = os.Chmod(path, perm); err != nil {
+			_ = listener.Close()
+			return nil, err
+		}
+	}
+	return listener, nil
+}
+
 // 监听并提供服务，在收到 closeChan 发来的关闭通知后关闭
 func ListenAndServe(listener net.Listener, handler tcp.Handler,
 	closeChan <-chan struct{}) {
+	serve([]net.Listener{listener}, handler, closeChan)
+}
+
+// 在多个 listener 上使用同一个 handler 提供服务，任一 listener 出错时全部关闭
+func serve(listeners []net.Listener, handler tcp.Handler, closeChan <-chan struct{}) {
+	var closeOnce sync.Once
+	shutdown := func() {
+		closeOnce.Do(func() {
+			for _, listener := range listeners {
+				listener.Close() // 停止监听，listener.Accept()会立即返回io.EOF
+			}
+			handler.Close() // 关闭应用层服务器
+		})
+	}
 	// 监听关闭的通知
 	go func() {
 		<-closeChan
 		logger.Info("shutting down...")
-		listener.Close() // 停止监听，listener.Accept()会立即返回io.EOF
-		handler.Close()  // 关闭应用层服务器
+		shutdown()
 	}()
 
 	// 在异常退出后释放资源
-	defer func() {
-		listener.Close()
-		handler.Close()
-	}()
+	defer shutdown()
 	ctx := context.Background()
 	var waitDone sync.WaitGroup
-	for {
-		// 监听端口，阻塞直到收到新连接或者出现错误
-		conn, err := listener.Accept()
-		if err != nil {
-			break
-		}
-		// 开启一个新协程来处理新连接
-		logger.Info("accepted link")
-		waitDone.Add(1)
-		go func() {
-			defer func() {
-				waitDone.Done()
-			}()
-			handler.Handle(ctx, conn)
-		}()
+	var acceptDone sync.WaitGroup
+	for _, listener := range listeners {
+		acceptDone.Add(1)
+		go func(listener net.Listener) {
+			defer acceptDone.Done()
+			// 任一 listener 退出后关闭其他 listener
+			defer shutdown()
+			for {
+				// 监听端口，阻塞直到收到新连接或者出现错误
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				// 开启一个新协程来处理新连接
+				logger.Info("accepted link")
+				waitDone.Add(1)
+				go func() {
+					defer func() {
+						waitDone.Done()
+					}()
+					handler.Handle(ctx, conn)
+				}()
+			}
+		}(listener)
 	}
+	acceptDone.Wait()
 	waitDone.Wait()
 }
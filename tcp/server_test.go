@@ -0,0 +1,88 @@
+package tcp
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"ringodis/resp/conn"
+	"testing"
+	"time"
+)
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ringodis.sock")
+	// stale socket left by crash is replaced
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	listener, err := listenUnix(path, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0700 {
+		t.Fatalf("unexpected socket file %v %v", info, err)
+	}
+	closeChan := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		ListenAndServe(listener, MakeHandler(), closeChan)
+		close(done)
+	}()
+
+	client, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err = client.Write([]byte("ping\n")); err != nil {
+		t.Fatal(err)
+	}
+	_ = client.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if line, err := bufio.NewReader(client).ReadString('\n'); err != nil || line != "ping\n" {
+		t.Errorf("unexpected echo %q %v", line, err)
+	}
+
+	// unnamed clients of unix socket are reported by path of socket
+	rawPath := filepath.Join(t.TempDir(), "raw.sock")
+	raw, err := net.Listen("unix", rawPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	peer, err := net.Dial("unix", rawPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	accepted, err := raw.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer accepted.Close()
+	if addr := conn.NewConn(accepted).RemoteAddr(); addr == nil || addr.String() != rawPath {
+		t.Errorf("unexpected remote addr %v", addr)
+	}
+
+	closeChan <- struct{}{}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server is not closed")
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket file is not removed: %v", err)
+	}
+
+	// other files are never removed
+	if err = os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = listenUnix(path, 0); err == nil {
+		t.Error("expected error listening on regular file")
+	}
+}